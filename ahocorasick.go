@@ -0,0 +1,156 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "unsafe"
+
+// minStartLiterals は、マッチの開始位置の候補を Aho-Corasick 法で探す、先頭のリテラルの最小数です。
+// リテラルが1つだけなら、各位置での照合も最初の文字ですぐに失敗するため、前処理の効果がありません。
+const minStartLiterals = 2
+
+// acAutomaton は、複数のリテラルを入力の1回の走査で探す Aho-Corasick 法のオートマトンです。
+// 状態0が根で、各状態は、いずれかのリテラルの接頭辞に対応します。
+type acAutomaton struct {
+	next   []map[rune]int // 各状態から文字で進む状態（トライの辺）
+	fail   []int          // 各状態で次の文字の辺がない場合に戻る状態（最長の真の接尾辞の状態）
+	out    [][]int        // 各状態で終わるリテラルの番号（fail でたどれる状態のものも含む）
+	lens   []int          // 各リテラルの長さ
+	maxLen int            // 最も長いリテラルの長さ
+}
+
+// newACAutomaton は、空でないリテラルのリストからオートマトンを構築します。
+func newACAutomaton(literals [][]rune) *acAutomaton {
+	a := &acAutomaton{next: []map[rune]int{nil}, fail: []int{0}, out: [][]int{nil}}
+	for id, lit := range literals {
+		state := 0
+		for _, r := range lit {
+			child, ok := a.next[state][r]
+			if !ok {
+				if a.next[state] == nil {
+					a.next[state] = make(map[rune]int)
+				}
+				child = len(a.next)
+				a.next[state][r] = child
+				a.next = append(a.next, nil)
+				a.fail = append(a.fail, 0)
+				a.out = append(a.out, nil)
+			}
+			state = child
+		}
+		a.out[state] = append(a.out[state], id)
+		a.lens = append(a.lens, len(lit))
+		a.maxLen = max(a.maxLen, len(lit))
+	}
+
+	// 根に近い状態から順に、戻り先と、戻り先で終わるリテラルを求める
+	queue := []int{0}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for r, child := range a.next[state] {
+			if state != 0 {
+				a.fail[child] = a.step(a.fail[state], r)
+				a.out[child] = append(a.out[child], a.out[a.fail[child]]...)
+			}
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// step は、状態 state で文字 r を読んだ後の状態を返します。
+func (a *acAutomaton) step(state int, r rune) int {
+	for {
+		if child, ok := a.next[state][r]; ok {
+			return child
+		}
+		if state == 0 {
+			return 0
+		}
+		state = a.fail[state]
+	}
+}
+
+// nextStart は、input[from:] の中でいずれかのリテラルが始まる最初の位置を返します。なければ-1を返します。
+func (a *acAutomaton) nextStart(input []rune, from int) int {
+	state, best := 0, -1
+	for i := from; i < len(input); i++ {
+		// best より前に始まるリテラルは、best+maxLen より前で終わる
+		if best >= 0 && i >= best+a.maxLen {
+			break
+		}
+		state = a.step(state, input[i])
+		for _, id := range a.out[state] {
+			if start := i + 1 - a.lens[id]; best < 0 || start < best {
+				best = start
+			}
+		}
+	}
+	return best
+}
+
+// scan は、input の中に現れるリテラルごとに、その番号で f を呼び出します（同じリテラルで複数回呼ぶことがあります）。
+func (a *acAutomaton) scan(input []rune, f func(id int)) {
+	state := 0
+	for _, r := range input {
+		state = a.step(state, r)
+		for _, id := range a.out[state] {
+			f(id)
+		}
+	}
+}
+
+// size は、オートマトンのおおよその大きさを返します。
+func (a *acAutomaton) size() int {
+	n := int(unsafe.Sizeof(*a))
+	n += len(a.next) * int(unsafe.Sizeof(map[rune]int(nil))+unsafe.Sizeof(0)+unsafe.Sizeof([]int(nil)))
+	for state := range a.next {
+		n += len(a.next[state])*int(unsafe.Sizeof(rune(0))+unsafe.Sizeof(0)) + mapEntryOverhead
+		n += cap(a.out[state]) * int(unsafe.Sizeof(0))
+	}
+	return n + cap(a.lens)*int(unsafe.Sizeof(0))
+}
+
+// literalRuns は、パターンのマッチに必ず現れるリテラルの集合を求めます。
+// required は、どのマッチもそのいずれかを含むリテラルの集合で、最も絞り込みの効くもの（最短のリテラルが最も長いもの）です。
+// start は、どのマッチもそのいずれかで始まるリテラルの集合です。見つからなければ nil を返します。
+// 大小文字を区別しない文字はリテラルとして扱いません。
+func literalRuns(ast Node) (required, start [][]rune) {
+	seq := sequenceOf(ast)
+	bestLen := 0
+	for i := 0; i < len(seq); {
+		// リテラルの集合にマッチする要素が連続する範囲を、1つの集合にまとめる
+		var run []Node
+		for ; i < len(seq); i++ {
+			node := seq[i]
+			if capture, ok := node.(*CaptureNode); ok {
+				node = capture.node
+			}
+			if _, ok := literalSet(node); !ok {
+				break
+			}
+			run = append(run, node)
+		}
+		if len(run) == 0 {
+			i++
+			continue
+		}
+		set, ok := literalSet(&ConcatNode{nodes: run})
+		if !ok {
+			continue
+		}
+		shortest := len(set[0])
+		for _, lit := range set {
+			shortest = min(shortest, len(lit))
+		}
+		if shortest == 0 {
+			continue
+		}
+		if i == len(run) && len(set) >= minStartLiterals {
+			start = set
+		}
+		if shortest > bestLen || (shortest == bestLen && len(set) < len(required)) {
+			required, bestLen = set, shortest
+		}
+	}
+	return required, start
+}
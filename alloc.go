@@ -0,0 +1,135 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+// ErrMemoryLimit は、Allocator が照合の作業領域を確保できなかったため、照合を打ち切った場合のエラーです。
+var ErrMemoryLimit = errors.New("照合の作業領域が上限を超えました")
+
+// Allocator は、照合の作業領域であるバックトラックスタックと、キャプチャなどの取り消し記録のメモリを供給します。
+// 作業領域を広げる必要があるときだけ呼び出され、それまでの内容は新しい領域に複製します。
+// 設定は Options.Allocator か Matcher.SetAllocator で行います。このパッケージの Arena も Allocator です。
+type Allocator interface {
+	// AllocBacktrack は、長さが0で容量が n 以上のバックトラックスタック用のスライスを返します。
+	// 上限を超えるため確保できない場合は nil を返します。
+	AllocBacktrack(n int) []BacktrackPoint
+
+	// AllocTrail は、長さが0で容量が n 以上の取り消し記録用のスライスを返します。
+	// 上限を超えるため確保できない場合は nil を返します。
+	AllocTrail(n int) []TrailEntry
+}
+
+// minAllocSize は、Allocator に最初に要求する作業領域の要素数です。
+const minAllocSize = 64
+
+// growStack は、Allocator からバックトラックスタックの領域を確保し直します。確保できなければ ErrMemoryLimit を記録して false を返します。
+func (m *Matcher) growStack() bool {
+	stack := m.alloc.AllocBacktrack(max(2*cap(m.stack), minAllocSize))
+	if stack == nil {
+		m.err = ErrMemoryLimit
+		return false
+	}
+	m.stack = append(stack[:0], m.stack...)
+	return true
+}
+
+// growTrail は、Allocator から取り消し記録の領域を確保し直します。確保できなければ ErrMemoryLimit を記録して false を返します。
+func (m *Matcher) growTrail() bool {
+	trail := m.alloc.AllocTrail(max(2*cap(m.trail), minAllocSize))
+	if trail == nil {
+		m.err = ErrMemoryLimit
+		return false
+	}
+	m.trail = append(trail[:0], m.trail...)
+	return true
+}
+
+// SetAllocator は、この Matcher の照合の作業領域を a から確保するよう設定します。a が nil なら通常どおり確保します。
+// NewMatcher で作成した Matcher を、リクエストごとの Arena で使う場合などに呼び出します。
+// それまでの作業領域は手放すため、照合の途中で呼び出してはいけません。
+func (m *Matcher) SetAllocator(a Allocator) {
+	m.stopStepping()
+	m.alloc = a
+	m.stack, m.trail = nil, nil
+}
+
+// Arena は、上限のバイト数まで作業領域を確保し、Reset でまとめて回収して再利用する Allocator です。
+// リクエストごとに Arena を用意するか、1つの Arena をリクエストの終わりに Reset して使います。
+// 複数のゴルーチンから同時に使えます。
+type Arena struct {
+	mu     sync.Mutex
+	limit  int
+	used   int
+	stacks [][]BacktrackPoint // 確保した領域（Reset で free に移す）
+	trails [][]TrailEntry
+	free   struct {
+		stacks [][]BacktrackPoint
+		trails [][]TrailEntry
+	}
+}
+
+// NewArena は、合計 limit バイトまで作業領域を確保する Arena を返します。limit が0以下なら上限はありません。
+func NewArena(limit int) *Arena {
+	return &Arena{limit: limit}
+}
+
+// AllocBacktrack は、Allocator の AllocBacktrack を実装します。
+func (a *Arena) AllocBacktrack(n int) []BacktrackPoint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := arenaAlloc(a, &a.free.stacks, n)
+	if ok {
+		a.stacks = append(a.stacks, s)
+	}
+	return s
+}
+
+// AllocTrail は、Allocator の AllocTrail を実装します。
+func (a *Arena) AllocTrail(n int) []TrailEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := arenaAlloc(a, &a.free.trails, n)
+	if ok {
+		a.trails = append(a.trails, s)
+	}
+	return s
+}
+
+// arenaAlloc は、回収済みの領域から容量が n 以上のものを探し、なければ上限の範囲で新しく確保します。
+func arenaAlloc[T any](a *Arena, free *[][]T, n int) ([]T, bool) {
+	for i, s := range *free {
+		if cap(s) >= n {
+			(*free)[i] = (*free)[len(*free)-1]
+			*free = (*free)[:len(*free)-1]
+			return s[:0], true
+		}
+	}
+	var zero T
+	size := n * int(unsafe.Sizeof(zero))
+	if a.limit > 0 && a.used+size > a.limit {
+		return nil, false
+	}
+	a.used += size
+	return make([]T, 0, n), true
+}
+
+// Used は、これまでに確保した作業領域のバイト数を返します。Reset で回収した領域も、再利用のため数えたままです。
+func (a *Arena) Used() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.used
+}
+
+// Reset は、確保したすべての領域を回収し、以降の確保で再利用します。
+// Arena を使う照合がすべて終わってから呼び出してください。
+func (a *Arena) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.free.stacks = append(a.free.stacks, a.stacks...)
+	a.free.trails = append(a.free.trails, a.trails...)
+	a.stacks, a.trails = nil, nil
+}
@@ -0,0 +1,132 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// unbounded は、長さに上限がないことを表します。
+const unbounded = -1
+
+// maxAnalyzedLen は、長さ解析で扱う最大値です。これを超える長さは上限なしとみなします。
+const maxAnalyzedLen = 1 << 30
+
+// nodeLength は、ノードがマッチする文字列の最小長と最大長（ルーン数）を返します。
+// 最大長に上限がない場合は unbounded（-1）を返します。
+func nodeLength(node Node) (min, max int) {
+	switch n := node.(type) {
+	case *CharNode, *AnyCharNode, *CharClassNode:
+		// 1文字にマッチ
+		return 1, 1
+
+	case *ConcatNode:
+		// 連接は各要素の長さの和
+		for _, child := range n.nodes {
+			cmin, cmax := nodeLength(child)
+			min = capLength(addLength(min, cmin))
+			max = addLength(max, cmax)
+		}
+		return min, max
+
+	case *AltNode:
+		// 選択は両辺の最小の最小値と最大の最大値
+		lmin, lmax := nodeLength(n.left)
+		rmin, rmax := nodeLength(n.right)
+		min = lmin
+		if rmin < min {
+			min = rmin
+		}
+		if lmax == unbounded || rmax == unbounded {
+			return min, unbounded
+		}
+		max = lmax
+		if rmax > max {
+			max = rmax
+		}
+		return min, max
+
+	case *RepeatNode:
+		// 繰り返しは本体の長さと回数の積
+		cmin, cmax := nodeLength(n.node)
+		min = capLength(mulLength(cmin, n.min))
+		switch {
+		case cmax == 0 || n.max == 0:
+			max = 0
+		case cmax == unbounded || n.max == -1:
+			max = unbounded
+		default:
+			max = mulLength(cmax, n.max)
+		}
+		return min, max
+
+	case *CaptureNode:
+		return nodeLength(n.node)
+
+	case *GroupNode:
+		return nodeLength(n.node)
+
+	case *BackrefNode:
+		// 参照先の長さは実行時まで分からない
+		return 0, unbounded
+
+	case *BoundaryNode:
+		// 境界は文字を消費しない
+		return 0, 0
+
+	default:
+		return 0, unbounded
+	}
+}
+
+// addLength は、長さを加算します。maxAnalyzedLen を超える場合は上限なしとします。
+func addLength(a, b int) int {
+	if a == unbounded || b == unbounded || a+b > maxAnalyzedLen {
+		return unbounded
+	}
+	return a + b
+}
+
+// mulLength は、長さを乗算します。maxAnalyzedLen を超える場合は上限なしとします。
+func mulLength(a, n int) int {
+	if a == unbounded || n < 0 {
+		return unbounded
+	}
+	if a != 0 && n > maxAnalyzedLen/a {
+		return unbounded
+	}
+	return a * n
+}
+
+// capLength は、最小長として使うために上限なしを maxAnalyzedLen に丸めます。
+func capLength(n int) int {
+	if n == unbounded {
+		return maxAnalyzedLen
+	}
+	return n
+}
+
+// endAnchor は、パターンが必ずテキスト末尾（\z、またはマルチラインでない $）で終わるかどうかと、
+// その直前に必ず現れるリテラルの接尾辞を返します。
+func endAnchor(node Node) (suffix []rune, anchored bool) {
+	seq := sequenceOf(node)
+	if len(seq) == 0 {
+		return nil, false
+	}
+	if b, ok := seq[len(seq)-1].(*BoundaryNode); !ok || !isEndOfText(b) {
+		return nil, false
+	}
+
+	// \z の直前にあるリテラル文字を後ろから集める（大小文字を区別しない文字は除く）
+	i := len(seq) - 1
+	for i > 0 {
+		if ch, ok := seq[i-1].(*CharNode); !ok || ch.fold {
+			break
+		}
+		i--
+	}
+	for _, n := range seq[i : len(seq)-1] {
+		suffix = append(suffix, n.(*CharNode).r)
+	}
+	return suffix, true
+}
+
+// isEndOfText は、境界がテキスト末尾にだけマッチするかどうかを返します。
+func isEndOfText(b *BoundaryNode) bool {
+	return b.nodeType == NodeEndText || (b.nodeType == NodeEndLine && !b.multiline)
+}
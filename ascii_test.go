@@ -0,0 +1,51 @@
+//go:build btregexp_ascii
+
+package btregexp
+
+import "testing"
+
+// ASCII専用ビルドのテストは、btregexp_ascii タグ付きでビルドします。
+//
+//	go test -tags btregexp_ascii -run TestASCIIBuild
+
+// TestASCIIBuild は、Unicode の表を使わないビルドでの制限と動作をテストします。
+func TestASCIIBuild(t *testing.T) {
+	rejected := []struct {
+		pattern string
+		opts    Options
+	}{
+		{`\p{L}`, Options{}},
+		{`a\P{Greek}`, Options{}},
+		{`abc`, Options{Flags: Flags{Normalization: NFC}}},
+	}
+	for _, tc := range rejected {
+		if _, err := CompileWithOptions(tc.pattern, tc.opts); err == nil {
+			t.Errorf("CompileWithOptions(%q) はエラーになるべきです", tc.pattern)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		opts    Options
+		input   string
+		want    bool
+	}{
+		{`^\s+$`, Options{}, " \t\r\n", true},
+		{`^\s$`, Options{}, "　", false},
+		{`^\w+$`, Options{}, "abc_123", true},
+		{`(?i)^hello$`, Options{}, "HeLLo", true},
+		{`(?i)^k$`, Options{}, "\u212a", false},
+		{`(?i)^é$`, Options{}, "É", false},
+		{`^a+b$`, Options{LinearFallback: true}, "aaab", true},
+	}
+	for _, tc := range tests {
+		re, err := CompileWithOptions(tc.pattern, tc.opts)
+		if err != nil {
+			t.Errorf("CompileWithOptions(%q) エラー: %v", tc.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tc.input); got != tc.want {
+			t.Errorf("%q.MatchString(%q) = %v, 期待値 %v", tc.pattern, tc.input, got, tc.want)
+		}
+	}
+}
@@ -24,6 +24,10 @@ const (
 	NodeEndText                  // テキスト末尾（\z）
 	NodeWordBoundary             // 単語境界（\b）
 	NodeNonWordBoundary          // 非単語境界（\B）
+	NodeMatchStart               // マッチの開始位置の指定（Vim の \zs）
+	NodeMatchEnd                 // マッチの終了位置の指定（Vim の \ze）
+	NodeNotAfterWord             // 直前が単語文字でない（Options.WholeWord）
+	NodeNotBeforeWord            // 直後が単語文字でない（Options.WholeWord）
 )
 
 // RepeatType は、繰り返しの種類を表します。
@@ -54,17 +58,25 @@ type runeRange struct {
 // Node は、正規表現の抽象構文木のノードを表すインターフェースです。
 type Node interface {
 	Type() NodeType
+
+	// String は、ノードを正規形のパターンの構文で書き表した文字列を返します（Format を参照）。
+	String() string
 }
 
 // CharNode は、単一の文字にマッチするノードです。
 type CharNode struct {
-	r rune // マッチする文字
+	r    rune // マッチする文字
+	fold bool // 大小文字を区別しないかどうか（(?i) の範囲内）
 }
 
 func (n *CharNode) Type() NodeType {
 	return NodeChar
 }
 
+func (n *CharNode) String() string {
+	return nodeString(n)
+}
+
 // ConcatNode は、複数のノードの連接を表します。
 type ConcatNode struct {
 	nodes []Node // 連接されるノードのリスト
@@ -74,6 +86,10 @@ func (n *ConcatNode) Type() NodeType {
 	return NodeConcat
 }
 
+func (n *ConcatNode) String() string {
+	return nodeString(n)
+}
+
 // AltNode は、選択（|）を表します。
 type AltNode struct {
 	left  Node // 左辺
@@ -84,6 +100,10 @@ func (n *AltNode) Type() NodeType {
 	return NodeAlt
 }
 
+func (n *AltNode) String() string {
+	return nodeString(n)
+}
+
 // RepeatNode は、繰り返し（*, +, ?, {n,m}）を表します。
 type RepeatNode struct {
 	node       Node       // 繰り返される部分
@@ -105,6 +125,10 @@ func (n *RepeatNode) Type() NodeType {
 	}
 }
 
+func (n *RepeatNode) String() string {
+	return nodeString(n)
+}
+
 // CaptureNode は、キャプチャグループを表します。
 type CaptureNode struct {
 	index int    // キャプチャグループのインデックス
@@ -116,6 +140,10 @@ func (n *CaptureNode) Type() NodeType {
 	return NodeCapture
 }
 
+func (n *CaptureNode) String() string {
+	return nodeString(n)
+}
+
 // GroupNode は、非キャプチャグループを表します。
 type GroupNode struct {
 	node Node // グループの内容
@@ -125,6 +153,10 @@ func (n *GroupNode) Type() NodeType {
 	return NodeGroup
 }
 
+func (n *GroupNode) String() string {
+	return nodeString(n)
+}
+
 // BackrefNode は、バックリファレンスを表します。
 type BackrefNode struct {
 	index int    // 参照するキャプチャグループのインデックス
@@ -135,6 +167,10 @@ func (n *BackrefNode) Type() NodeType {
 	return NodeBackref
 }
 
+func (n *BackrefNode) String() string {
+	return nodeString(n)
+}
+
 // AnyCharNode は、任意の1文字（.）にマッチするノードです。
 type AnyCharNode struct {
 	dotMatchesNewline bool // 改行にもマッチするかどうか
@@ -144,23 +180,40 @@ func (n *AnyCharNode) Type() NodeType {
 	return NodeAnyChar
 }
 
+func (n *AnyCharNode) String() string {
+	return nodeString(n)
+}
+
 // CharClassNode は、文字クラス（[...]）を表します。
 type CharClassNode struct {
 	classType  CharClassType // 文字クラスの種類
 	negate     bool          // 否定クラスかどうか（[^...]）
 	ranges     []runeRange   // 文字範囲のリスト（カスタムクラスの場合）
 	unicodeKey string        // Unicodeプロパティ（\p{...}の場合）
+	fold       bool          // 大小文字を区別しないかどうか（(?i) の範囲内）
 }
 
 func (n *CharClassNode) Type() NodeType {
 	return NodeCharClass
 }
 
-// BoundaryNode は、各種境界条件（^, $, \b, \B, \A, \z）を表します。
+func (n *CharClassNode) String() string {
+	return nodeString(n)
+}
+
+// BoundaryNode は、各種境界条件（^, $, \b, \B, \A, \z）と、文字を消費しない位置の指定（\zs, \ze）を表します。
 type BoundaryNode struct {
-	nodeType NodeType // 境界の種類
+	nodeType  NodeType // 境界の種類
+	multiline bool     // ^ と $ の場合、マルチラインモード（(?m) の範囲内）かどうか
+
+	// lineTerminators は、マルチラインの ^ と $ が \n に加えて \r、U+2028、U+2029 も行の区切りとみなすかどうかです（JavaScript）。
+	lineTerminators bool
 }
 
 func (n *BoundaryNode) Type() NodeType {
 	return n.nodeType
 }
+
+func (n *BoundaryNode) String() string {
+	return nodeString(n)
+}
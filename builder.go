@@ -0,0 +1,194 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// Expr は、Literal や Concat などの組み合わせ子で組み立てる正規表現の部品です。
+// パターンを文字列の連結で組み立てる場合と異なり、リテラルのエスケープや、選択や繰り返しに必要な括弧を気にする必要がありません。
+//
+// 組み合わせ子は構文木を直接組み立て、Compile は構文木を正規形のパターン（String を参照）に書き出してコンパイルします。
+// Expr は値として扱い、メソッドは元の Expr を変更せずに新しい Expr を返します。ゼロ値は空文字列にマッチします。
+type Expr struct {
+	node Node
+}
+
+// ExprOf は、Parse で得た構文木などのノードを Expr として返します。
+func ExprOf(node Node) Expr {
+	return Expr{node: node}
+}
+
+// Node は、Expr の構文木を返します。
+func (e Expr) Node() Node {
+	return e.node
+}
+
+// Literal は、文字列 s そのものにマッチする Expr を返します。s の中の文字はエスケープする必要はありません。
+func Literal(s string) Expr {
+	var nodes []Node
+	for _, r := range s {
+		nodes = append(nodes, &CharNode{r: r})
+	}
+	if len(nodes) == 1 {
+		return Expr{node: nodes[0]}
+	}
+	return Expr{node: &ConcatNode{nodes: nodes}}
+}
+
+// Class は、chars の中のいずれか1文字にマッチする Expr（[...]）を返します。chars が空なら、どの文字にもマッチしません。
+func Class(chars string) Expr {
+	n := &CharClassNode{classType: ClassCustom}
+	for _, r := range chars {
+		n.ranges = append(n.ranges, runeRange{min: r, max: r})
+	}
+	return Expr{node: n}
+}
+
+// NotClass は、chars のどの文字でもない1文字にマッチする Expr（[^...]）を返します。
+func NotClass(chars string) Expr {
+	e := Class(chars)
+	e.node.(*CharClassNode).negate = true
+	return e
+}
+
+// CharRange は、lo から hi までのいずれか1文字にマッチする Expr（[lo-hi]）を返します。
+func CharRange(lo, hi rune) Expr {
+	return Expr{node: &CharClassNode{classType: ClassCustom, ranges: []runeRange{{min: lo, max: hi}}}}
+}
+
+// Digit は、数字1文字にマッチする Expr（\d）を返します。
+func Digit() Expr {
+	return Expr{node: &CharClassNode{classType: ClassDigit}}
+}
+
+// Word は、単語文字1文字にマッチする Expr（\w）を返します。
+func Word() Expr {
+	return Expr{node: &CharClassNode{classType: ClassWord}}
+}
+
+// Space は、空白文字1文字にマッチする Expr（\s）を返します。
+func Space() Expr {
+	return Expr{node: &CharClassNode{classType: ClassSpace}}
+}
+
+// Any は、改行を含む任意の1文字にマッチする Expr（(?s:.)）を返します。
+func Any() Expr {
+	return Expr{node: &AnyCharNode{dotMatchesNewline: true}}
+}
+
+// BeginText は、テキストの先頭にマッチする Expr（\A）を返します。
+func BeginText() Expr {
+	return Expr{node: &BoundaryNode{nodeType: NodeBeginText}}
+}
+
+// EndText は、テキストの末尾にマッチする Expr（\z）を返します。
+func EndText() Expr {
+	return Expr{node: &BoundaryNode{nodeType: NodeEndText}}
+}
+
+// WordBoundary は、単語境界にマッチする Expr（\b）を返します。
+func WordBoundary() Expr {
+	return Expr{node: &BoundaryNode{nodeType: NodeWordBoundary}}
+}
+
+// Concat は、exprs を順に連接した Expr を返します。
+func Concat(exprs ...Expr) Expr {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	nodes := make([]Node, len(exprs))
+	for i, e := range exprs {
+		nodes[i] = e.node
+	}
+	return Expr{node: &ConcatNode{nodes: nodes}}
+}
+
+// Alt は、exprs のいずれかにマッチする Expr（|）を返します。先に渡したものを優先します。
+func Alt(exprs ...Expr) Expr {
+	if len(exprs) == 0 {
+		return Expr{}
+	}
+	node := exprs[len(exprs)-1].node
+	for i := len(exprs) - 2; i >= 0; i-- {
+		node = &AltNode{left: exprs[i].node, right: node}
+	}
+	return Expr{node: node}
+}
+
+// Group は、e をキャプチャグループで囲んだ Expr を返します。name が空でなければ名前付きグループ（(?P<name>...)）にします。
+// グループの番号は、パターンの中の開き括弧の順に、コンパイル時に決まります。
+func Group(name string, e Expr) Expr {
+	return Expr{node: &CaptureNode{name: name, node: e.node}}
+}
+
+// Repeat は、e を lo 回以上 hi 回以下繰り返す貪欲な Expr を返します。hi が負なら上限はありません。
+func Repeat(e Expr, lo, hi int) Expr {
+	return Expr{node: &RepeatNode{node: e.node, min: lo, max: max(hi, -1)}}
+}
+
+// Then は、e の後に exprs を連接した Expr を返します（Concat を参照）。
+func (e Expr) Then(exprs ...Expr) Expr {
+	return Concat(append([]Expr{e}, exprs...)...)
+}
+
+// Or は、e か exprs のいずれかにマッチする Expr を返します（Alt を参照）。
+func (e Expr) Or(exprs ...Expr) Expr {
+	return Alt(append([]Expr{e}, exprs...)...)
+}
+
+// Star は、e の0回以上の繰り返し（*）を返します。
+func (e Expr) Star() Expr {
+	return Repeat(e, 0, -1)
+}
+
+// Plus は、e の1回以上の繰り返し（+）を返します。
+func (e Expr) Plus() Expr {
+	return Repeat(e, 1, -1)
+}
+
+// Optional は、e の0回か1回の繰り返し（?）を返します。
+func (e Expr) Optional() Expr {
+	return Repeat(e, 0, 1)
+}
+
+// Repeat は、e を lo 回以上 hi 回以下繰り返す Expr を返します（関数の Repeat を参照）。
+func (e Expr) Repeat(lo, hi int) Expr {
+	return Repeat(e, lo, hi)
+}
+
+// Lazy は、e が繰り返しなら、それを非貪欲にした Expr を返します。繰り返しでなければ e をそのまま返します。
+func (e Expr) Lazy() Expr {
+	if n, ok := e.node.(*RepeatNode); ok {
+		lazy := *n
+		lazy.repeatType = RepeatNonGreedy
+		return Expr{node: &lazy}
+	}
+	return e
+}
+
+// Capture は、e をキャプチャグループで囲んだ Expr を返します（Group を参照）。
+func (e Expr) Capture(name string) Expr {
+	return Group(name, e)
+}
+
+// String は、Expr を正規形のパターン（Format と同じ形式）で返します。
+func (e Expr) String() string {
+	return nodeString(e.node)
+}
+
+// Compile は、Expr をコンパイルします。
+func (e Expr) Compile() (*Regexp, error) {
+	return e.CompileWithOptions(Options{})
+}
+
+// CompileWithOptions は、設定を指定して Expr をコンパイルします。
+// Options.Flags は、パターンの文字列に書いた場合と同じく全体に効きます。設定の扱いは CompileNode と同じです。
+func (e Expr) CompileWithOptions(opts Options) (*Regexp, error) {
+	return CompileNode(e.node, opts)
+}
+
+// MustCompile は Compile と同様ですが、コンパイルに失敗した場合はパニックします。
+func (e Expr) MustCompile() *Regexp {
+	re, err := e.Compile()
+	if err != nil {
+		panic("regexp: Expr.Compile(" + quote(e.String()) + "): " + err.Error())
+	}
+	return re
+}
@@ -0,0 +1,270 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Bundle は、CompileBundle でまとめてコンパイルしたパターンの組です。
+// 同じ内容の文字クラスやリテラルの表は、パターンの間で1つを共有します。
+// Bundle は作成後に変更しないため、複数のゴルーチンから同時に使えます。
+type Bundle struct {
+	regexps []*Regexp // 各パターン（コンパイルに失敗したものは nil）
+	lines   []int     // 各パターンの行番号（CompileList で読み込んだ場合のみ）
+
+	// 各パターンのマッチに必ず現れるリテラルを探すオートマトン（前処理できない場合は nil）
+	prefilter *acAutomaton
+	owners    [][]int // 各リテラルを必要とするパターンの番号
+	always    []int   // 必ず現れるリテラルがなく、常に照合するパターンの番号
+	latin1    bool    // 入力の各バイトを1文字として扱うかどうか
+}
+
+// BundleError は、CompileBundle でコンパイルに失敗したパターンのエラーです。
+type BundleError struct {
+	Index   int    // パターンの番号（CompileBundle に渡したスライスでの位置）
+	Pattern string // パターン
+	Err     error  // コンパイルのエラー
+	Line    int    // パターンの行番号（CompileList で読み込んだ場合のみ。それ以外は0）
+}
+
+func (e *BundleError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%d 行目のパターン %s: %v", e.Line, quote(e.Pattern), e.Err)
+	}
+	return fmt.Sprintf("パターン %d %s: %v", e.Index, quote(e.Pattern), e.Err)
+}
+
+func (e *BundleError) Unwrap() error {
+	return e.Err
+}
+
+// BundleErrors は、CompileBundle でコンパイルに失敗したすべてのパターンのエラーを、番号の順に並べたものです。
+type BundleErrors []*BundleError
+
+func (errs BundleErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// CompileBundle は、多数のパターンを同じ設定でまとめてコンパイルします。
+// パターンは複数のゴルーチンで並行してコンパイルし、同じ内容の文字クラスやリテラルの表（トライ）は
+// パターンの間で1つを共有するため、1つずつ CompileWithOptions するより速く、メモリも少なくて済みます。
+// 同じ文字列のパターンは1度だけコンパイルし、同じ Regexp を共有します
+// （Profile や Coverage を指定した場合は、記録を分けるため共有しません）。
+//
+// コンパイルに失敗したパターンがあっても、残りのパターンはコンパイルした Bundle を返します。
+// その場合、エラーは失敗したパターンの番号を含む BundleErrors で、Bundle の該当する位置は nil になります。
+func CompileBundle(patterns []string, opts Options) (*Bundle, error) {
+	regexps := make([]*Regexp, len(patterns))
+	errs := make([]error, len(patterns))
+
+	// 同じ文字列のパターンは、最初のものだけをコンパイルする
+	source := make([]int, len(patterns))
+	first := make(map[string]int, len(patterns))
+	var jobs []int
+	for i, pattern := range patterns {
+		if j, ok := first[pattern]; ok && !opts.Profile && !opts.Coverage {
+			source[i] = j
+			continue
+		}
+		first[pattern] = i
+		source[i] = i
+		jobs = append(jobs, i)
+	}
+
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range min(runtime.GOMAXPROCS(0), len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				regexps[i], errs[i] = CompileWithOptions(patterns[i], opts)
+			}
+		}()
+	}
+	for _, i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	interner := newBundleInterner()
+	var bundleErrs BundleErrors
+	for i, pattern := range patterns {
+		if j := source[i]; j != i {
+			regexps[i], errs[i] = regexps[j], errs[j]
+		}
+		if errs[i] != nil {
+			bundleErrs = append(bundleErrs, &BundleError{Index: i, Pattern: pattern, Err: errs[i]})
+		} else if source[i] == i {
+			interner.intern(regexps[i].prog)
+		}
+	}
+
+	b := &Bundle{regexps: regexps, latin1: opts.Latin1}
+	b.buildPrefilter()
+	if len(bundleErrs) > 0 {
+		return b, bundleErrs
+	}
+	return b, nil
+}
+
+// bundleInterner は、プログラムの間で同じ内容の文字クラスとトライを1つにまとめます。
+type bundleInterner struct {
+	classes map[string]*charClass
+	tries   map[string]*literalTrie
+}
+
+func newBundleInterner() *bundleInterner {
+	return &bundleInterner{
+		classes: make(map[string]*charClass),
+		tries:   make(map[string]*literalTrie),
+	}
+}
+
+// intern は、プログラムの命令が参照する文字クラスとトライを、これまでに見た同じ内容のものに置き換えます。
+func (in *bundleInterner) intern(p *program) {
+	for pc := range p.instrs {
+		instr := &p.instrs[pc]
+		if c := instr.CharClass; c != nil {
+			// マップはキーの順に書式化されるので、内容が同じなら同じ文字列になる
+			key := fmt.Sprint(c.anyOf, c.ranges, c.classType, c.negate, c.unicode)
+			if shared, ok := in.classes[key]; ok {
+				instr.CharClass = shared
+			} else {
+				in.classes[key] = c
+			}
+		}
+		if t := instr.Trie; t != nil {
+			var sb strings.Builder
+			t.root.writeKey(&sb)
+			key := sb.String()
+			if shared, ok := in.tries[key]; ok {
+				instr.Trie = shared
+			} else {
+				in.tries[key] = t
+			}
+		}
+	}
+}
+
+// writeKey は、トライのノードとその子孫の内容を、子の文字の順に書き出します。
+func (t *trieNode) writeKey(sb *strings.Builder) {
+	fmt.Fprintf(sb, "%d(", t.accept)
+	runes := make([]rune, 0, len(t.children))
+	for r := range t.children {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	for _, r := range runes {
+		fmt.Fprintf(sb, "%d ", r)
+		t.children[r].writeKey(sb)
+	}
+	sb.WriteByte(')')
+}
+
+// Len は、パターンの数を返します。
+func (b *Bundle) Len() int {
+	return len(b.regexps)
+}
+
+// Regexp は、番号 i のパターンの Regexp を返します。コンパイルに失敗したパターンでは nil を返します。
+func (b *Bundle) Regexp(i int) *Regexp {
+	return b.regexps[i]
+}
+
+// Line は、番号 i のパターンを読み込んだ行番号（1始まり）を返します。CompileList で作成した Bundle でなければ0を返します。
+func (b *Bundle) Line(i int) int {
+	if b.lines == nil {
+		return 0
+	}
+	return b.lines[i]
+}
+
+// buildPrefilter は、各パターンのマッチに必ず現れるリテラルをまとめた Aho-Corasick 法のオートマトンを構築します。
+// 入力を正規化する設定では、入力の文字列と直接比べられないため構築しません。
+func (b *Bundle) buildPrefilter() {
+	var literals [][]rune
+	index := make(map[string]int)
+	for i, re := range b.regexps {
+		if re == nil {
+			continue
+		}
+		if re.prog.normalization != NoNormalization {
+			return
+		}
+		if len(re.prog.requiredLiterals) == 0 {
+			b.always = append(b.always, i)
+			continue
+		}
+		for _, lit := range re.prog.requiredLiterals {
+			id, ok := index[string(lit)]
+			if !ok {
+				id = len(literals)
+				index[string(lit)] = id
+				literals = append(literals, lit)
+				b.owners = append(b.owners, nil)
+			}
+			b.owners[id] = append(b.owners[id], i)
+		}
+	}
+	if len(literals) > 0 {
+		b.prefilter = newACAutomaton(literals)
+	}
+}
+
+// MatchString は、s にマッチするパターンの番号を、番号の順に返します。
+//
+// 各パターンのマッチに必ず現れるリテラルを、すべてのパターンについて Aho-Corasick 法で1度に探し、
+// そのリテラルが s に現れたパターンだけを照合します。
+// 侵入検知のシグネチャのように、数千のパターンの大半がキーワードを含む場合に、照合するパターンを大きく絞り込めます。
+func (b *Bundle) MatchString(s string) []int {
+	candidates := b.candidates(s)
+	var matched []int
+	for i, re := range b.regexps {
+		if re != nil && (candidates == nil || candidates[i]) && re.MatchString(s) {
+			matched = append(matched, i)
+		}
+	}
+	return matched
+}
+
+// candidates は、s にマッチし得るパターンの表を返します。前処理できない場合は nil を返します。
+func (b *Bundle) candidates(s string) []bool {
+	if b.prefilter == nil {
+		return nil
+	}
+	var input []rune
+	if b.latin1 {
+		input = make([]rune, len(s))
+		for i := 0; i < len(s); i++ {
+			input[i] = rune(s[i])
+		}
+	} else {
+		input = []rune(s)
+	}
+
+	candidates := make([]bool, len(b.regexps))
+	for _, i := range b.always {
+		candidates[i] = true
+	}
+	seen := make([]bool, len(b.owners))
+	b.prefilter.scan(input, func(id int) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		for _, i := range b.owners[id] {
+			candidates[i] = true
+		}
+	})
+	return candidates
+}
@@ -0,0 +1,176 @@
+// btregexp-debug は、パターンの構文木とコンパイルされた命令列を表示し、
+// 入力を与えた場合はマッチングの結果と、必要なら1命令ごとの実行の記録（トレース）を表示するデバッグ用のコマンドです。
+// 出力は入力だけで決まるため、再現可能なトレースとしてバグ報告に添付できます。
+// -record を指定すると照合のトレースをファイルに記録し、-replay でその記録を再生します。
+//
+// 使い方:
+//
+//	btregexp-debug [-i] [-m] [-s] [-dialect default|js|vim] [-trace] [-profile] [-record file] pattern [input]
+//	btregexp-debug -replay file
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	btregexp "github.com/user/go-btregexp"
+)
+
+// dialects は、-dialect に指定できる方言の名前です。
+var dialects = map[string]btregexp.Dialect{
+	"default": btregexp.DialectDefault,
+	"js":      btregexp.DialectJavaScript,
+	"vim":     btregexp.DialectVim,
+}
+
+func main() {
+	caseInsensitive := flag.Bool("i", false, "大小文字を区別しない")
+	multiline := flag.Bool("m", false, "マルチラインモード")
+	dotMatchesNL := flag.Bool("s", false, ". を改行にもマッチさせる")
+	dialect := flag.String("dialect", "default", "パターンの構文の方言（default、js、vim）")
+	trace := flag.Bool("trace", false, "入力とのマッチングを1命令ずつ表示する")
+	profile := flag.Bool("profile", false, "入力とのマッチングで実行した命令の回数をパターンの部分ごとに表示する")
+	record := flag.String("record", "", "入力とのマッチングのトレースを記録するファイル")
+	replay := flag.String("replay", "", "-record で記録したトレースを再生する")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "使い方: btregexp-debug [flags] pattern [input]")
+		fmt.Fprintln(flag.CommandLine.Output(), "        btregexp-debug -replay file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *replay != "" {
+		if flag.NArg() != 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		replayTrace(*replay)
+		return
+	}
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	d, ok := dialects[*dialect]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知の方言です: %s\n", *dialect)
+		os.Exit(2)
+	}
+
+	re, err := btregexp.CompileWithOptions(flag.Arg(0), btregexp.Options{
+		Flags: btregexp.Flags{
+			CaseInsensitive: *caseInsensitive,
+			Multiline:       *multiline,
+			DotMatchesNL:    *dotMatchesNL,
+		},
+		Dialect: d,
+		Profile: *profile,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "コンパイルエラー: %v\n", err)
+		os.Exit(1)
+	}
+
+	printProgram(re)
+	if flag.NArg() < 2 {
+		return
+	}
+	input := flag.Arg(1)
+	fmt.Printf("\n入力: %q\n", input)
+
+	if *record != "" {
+		if err := os.WriteFile(*record, re.RecordTrace(input), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "トレースを記録できません: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var loc []int
+	if *trace {
+		var steps []btregexp.TraceStep
+		loc = re.Trace(input, func(s btregexp.TraceStep) { steps = append(steps, s) })
+		printTrace(steps)
+	} else {
+		loc = re.FindStringSubmatchIndex(input)
+	}
+
+	if *profile {
+		fmt.Printf("\nプロファイル:\n%s\n", re.Profile())
+	}
+	printResult(re, input, loc)
+}
+
+// replayTrace は、-record で記録したトレースを再生して表示します。
+func replayTrace(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "トレースを読み込めません: %v\n", err)
+		os.Exit(1)
+	}
+	r, err := btregexp.ReplayTrace(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "トレースを再生できません: %v\n", err)
+		os.Exit(1)
+	}
+	re, err := btregexp.CompileWithOptions(r.Pattern, r.Options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "コンパイルエラー: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("パターン: %q\n", r.Pattern)
+	printProgram(re)
+	fmt.Printf("\n入力: %q\n", r.Input)
+	printTrace(r.Steps)
+	if r.Diverged >= 0 {
+		fmt.Printf("このビルドの照合はステップ %d から記録と異なります\n\n", r.Diverged+1)
+	}
+	printResult(re, r.Input, r.Result)
+}
+
+// printProgram は、パターンの構文木と命令列を表示します。
+func printProgram(re *btregexp.Regexp) {
+	ast, err := re.DumpAST()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "解析エラー: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("構文木:\n%s\n", ast)
+	fmt.Printf("命令列:\n%s", re.ProgramListing())
+}
+
+// printTrace は、トレースの各ステップを表示します。バックトラックして再開したステップには * を付けます。
+func printTrace(steps []btregexp.TraceStep) {
+	fmt.Printf("\nトレース:\n%6s %6s %6s %6s %6s   %s\n", "step", "start", "pc", "pos", "depth", "instr")
+	for i, s := range steps {
+		mark := " "
+		if s.Backtrack {
+			mark = "*"
+		}
+		fmt.Printf("%6d %6d %6d %6d %6d %s %s\n", i+1, s.Start, s.PC, s.Pos, s.Depth, mark, s.Instr)
+	}
+	fmt.Println()
+}
+
+// printResult は、マッチングの結果を表示します。
+func printResult(re *btregexp.Regexp, input string, loc []int) {
+	if loc == nil {
+		fmt.Println("マッチしません")
+		return
+	}
+	names := re.SubexpNames()
+	for i := 0; i+1 < len(loc); i += 2 {
+		label := fmt.Sprintf("グループ %d", i/2)
+		if i == 0 {
+			label = "マッチ"
+		} else if names[i/2] != "" {
+			label += " (" + names[i/2] + ")"
+		}
+		if loc[i] < 0 {
+			fmt.Printf("%s: なし\n", label)
+			continue
+		}
+		fmt.Printf("%s: [%d, %d] %q\n", label, loc[i], loc[i+1], input[loc[i]:loc[i+1]])
+	}
+}
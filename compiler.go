@@ -3,7 +3,6 @@ package btregexp
 
 import (
 	"fmt"
-	"unicode"
 )
 
 // InstrType は、正規表現命令のタイプを表します。
@@ -25,6 +24,17 @@ const (
 	InstrEndLine                          // 行末
 	InstrBeginText                        // テキスト先頭
 	InstrEndText                          // テキスト末尾
+	InstrRepeatInit                       // 繰り返しカウンタの初期化（{n,m}）
+	InstrRepeatCheck                      // 繰り返しカウンタの更新と継続判定（{n,m}）
+	InstrTrie                             // リテラルのみからなる選択（トライで照合）
+	InstrRun                              // 1文字にマッチする要素の繰り返し（まとめて消費）
+	InstrAtomicBegin                      // アトミックなグループの開始（バックトラックスタックの深さを記録）
+	InstrAtomicEnd                        // アトミックなグループの終了（グループ内のバックトラックポイントを破棄）
+	InstrProgressMark                     // 繰り返し本体の開始位置を記録
+	InstrProgressCheck                    // 繰り返し本体が入力を消費したかを確認（消費していなければ繰り返しを抜ける）
+	InstrCoverage                         // 選択の分岐や省略可能なグループを通ったことを記録（Options.Coverage）
+	InstrNotAfterWord                     // 直前が単語文字でない（Options.WholeWord）
+	InstrNotBeforeWord                    // 直後が単語文字でない（Options.WholeWord）
 )
 
 // SaveType は、InstrSaveのタイプを表します。
@@ -37,31 +47,35 @@ const (
 
 // Instr は、正規表現プログラムの命令を表します。
 type Instr struct {
-	Op         InstrType  // 命令の種類
-	Next       int        // 次の命令インデックス（-1は終了、分岐命令では第2分岐先）
-	Arg        int        // 命令の引数（文字、ジャンプ先、保存位置など）
-	SaveType   SaveType   // InstrSaveの場合、開始位置か終了位置か
-	Char       rune       // InstrCharの場合の文字
-	CharClass  *charClass // InstrCharClassの場合の文字クラス
-	Greedy     bool       // InstrSplitの場合、貪欲マッチか非貪欲マッチか
-	Possessive bool       // 所有的量指定子か
+	Op         InstrType    // 命令の種類
+	Next       int          // 次の命令インデックス（-1は終了、分岐命令では第2分岐先）
+	Arg        int          // 命令の引数（文字、ジャンプ先、保存位置など）
+	SaveType   SaveType     // InstrSaveの場合、開始位置か終了位置か
+	Char       rune         // InstrCharの場合の文字
+	CharClass  *charClass   // InstrCharClassの場合の文字クラス
+	Greedy     bool         // InstrSplitの場合、貪欲マッチか非貪欲マッチか
+	Possessive bool         // 所有的量指定子か
+	Counter    int          // InstrRepeatInit/InstrRepeatCheck/InstrAtomic*/InstrProgress*/InstrCoverageの場合のカウンタ番号
+	Min        int          // InstrRepeatCheckの場合の最小繰り返し回数
+	Max        int          // InstrRepeatCheckの場合の最大繰り返し回数（-1は無限大）
+	Trie       *literalTrie // InstrTrieの場合のトライ
+	RunOp      InstrType    // InstrRunの場合、繰り返す1文字命令の種類
 }
 
 // charClass は、文字クラスの内部表現です。
 type charClass struct {
-	anyOf           []rune          // 含まれる個別の文字
-	ranges          []runeRange     // 含まれる文字範囲
-	classType       CharClassType   // 組み込み文字クラス（\d, \s, \w など）
-	negate          bool            // 否定文字クラスかどうか（[^...] など）
-	unicode         map[string]bool // Unicodeプロパティ
-	caseInsensitive bool            // 大小文字を区別しないかどうか
+	anyOf     []rune          // 含まれる個別の文字
+	ranges    []runeRange     // 含まれる文字範囲
+	classType CharClassType   // 組み込み文字クラス（\d, \s, \w など）
+	negate    bool            // 否定文字クラスかどうか（[^...] など）
+	unicode   map[string]bool // Unicodeプロパティ
 }
 
 // matches は、文字 r が文字クラスにマッチするかどうかを判定します。
 func (c *charClass) matches(r rune) bool {
 	// 個別の文字をチェック
 	for _, ch := range c.anyOf {
-		if r == ch || (c.caseInsensitive && unicode.ToLower(r) == unicode.ToLower(ch)) {
+		if r == ch {
 			return !c.negate
 		}
 	}
@@ -71,13 +85,6 @@ func (c *charClass) matches(r rune) bool {
 		if r >= rng.min && r <= rng.max {
 			return !c.negate
 		}
-		// 大小文字を区別しない場合、小文字変換してから再チェック
-		if c.caseInsensitive {
-			lowerR := unicode.ToLower(r)
-			if lowerR >= unicode.ToLower(rng.min) && lowerR <= unicode.ToLower(rng.max) {
-				return !c.negate
-			}
-		}
 	}
 
 	// 組み込み文字クラスをチェック
@@ -91,14 +98,12 @@ func (c *charClass) matches(r rune) bool {
 			return !c.negate
 		}
 	case ClassSpace:
-		if unicode.IsSpace(r) {
+		if isSpace(r) {
 			return !c.negate
 		}
 	case ClassUnicode:
-		// TODO: Unicodeプロパティの実装
-		// （現在はダミー実装です）
 		for prop := range c.unicode {
-			if prop == "L" && unicode.IsLetter(r) {
+			if hasUnicodeProperty(prop, r) {
 				return !c.negate
 			}
 		}
@@ -118,7 +123,21 @@ type Compiler struct {
 	instrs      []Instr  // 生成される命令列
 	numCaptures int      // キャプチャグループの数
 	subexpNames []string // サブマッチの名前のリスト
+	backrefs    []bool   // バックリファレンスから参照されているグループ
+	numCounters int      // 繰り返しカウンタの数
+	maxInstrs   int      // 命令数の上限（0は無制限）
 	flags       Flags    // コンパイル時のフラグ
+	matchBounds bool     // \zs または \ze を含むかどうか
+
+	recordOwners bool   // 各命令を生成したノードを記録するかどうか（Options.Profile の場合）
+	owners       []Node // 各命令を生成したノード（子ノードの命令は子ノードのもの）
+
+	// coverageCounters は、通ったことを記録するノード（選択の分岐と省略可能なグループ）と、記録するカウンタの番号です
+	// （Options.Coverage の場合のみ）。
+	coverageCounters map[Node]int
+
+	// singleRunes は、InstrRun や InstrTrie を使わず、1文字ずつ照合する命令だけを生成するかどうかです（FindFuzzy で使う）。
+	singleRunes bool
 }
 
 // newCompiler は、新しいコンパイラを作成します。
@@ -136,92 +155,186 @@ func (c *Compiler) emit(instr Instr) int {
 	return pos
 }
 
-// patch は、指定された位置の命令のNext（またはArg）を更新します。
-func (c *Compiler) patch(pos int, next int) {
-	c.instrs[pos].Next = next
+// checkSize は、生成済みの命令数が上限を超えていればエラーを返します。
+func (c *Compiler) checkSize() error {
+	if c.maxInstrs > 0 && len(c.instrs) > c.maxInstrs {
+		return fmt.Errorf("%w: 命令数が上限 %d を超えました", ErrProgramTooLarge, c.maxInstrs)
+	}
+	return nil
 }
 
-// patchArg は、指定された位置の命令のArgを更新します。
-func (c *Compiler) patchArg(pos int, arg int) {
-	c.instrs[pos].Arg = arg
+// frag は、コンパイルされたノードに対応する命令列の断片を表します。
+// start は断片の入口となる命令の位置、out は断片の出口として
+// 後で接続先を埋める必要がある命令のフィールドのリストです。
+type frag struct {
+	start int    // 入口の命令の位置
+	out   []hole // 接続先が未定の出口
+}
+
+// hole は、接続先が未定の命令のフィールドを表します。
+type hole struct {
+	pc  int  // 命令の位置
+	arg bool // true なら Arg、false なら Next が未定
+}
+
+// emitFrag は、出口が1つ（Next）の命令を追加し、その命令だけからなる断片を返します。
+func (c *Compiler) emitFrag(instr Instr) frag {
+	pc := c.emit(instr)
+	return frag{start: pc, out: []hole{{pc: pc}}}
+}
+
+// fill は、断片の出口の接続先をすべて target に設定します。
+func (c *Compiler) fill(out []hole, target int) {
+	for _, h := range out {
+		if h.arg {
+			c.instrs[h.pc].Arg = target
+		} else {
+			c.instrs[h.pc].Next = target
+		}
+	}
 }
 
 // compile は、ASTノードをコンパイルして命令列を生成します。
 func (c *Compiler) compile(node Node) (*program, error) {
 	// ルートノードからコンパイル開始
-	start, err := c.compileNode(node)
+	f, err := c.compileNode(node)
 	if err != nil {
 		return nil, err
 	}
 
-	// マッチング成功命令を追加
-	c.emit(Instr{Op: InstrMatch})
+	// マッチング成功命令を追加し、パターン全体の出口をつなぐ
+	c.fill(f.out, c.emit(Instr{Op: InstrMatch}))
+	if err := c.checkSize(); err != nil {
+		return nil, err
+	}
 
-	// 命令列の先頭を調整
-	if start != 0 {
-		// 命令列の先頭が0以外の場合（通常起こりえない）
-		newInstrs := make([]Instr, len(c.instrs))
-		copy(newInstrs, c.instrs)
-		// 先頭を0番目に移動
-		for i := range newInstrs {
-			if newInstrs[i].Op == InstrJump || newInstrs[i].Op == InstrSplit {
-				if newInstrs[i].Next >= start {
-					newInstrs[i].Next -= start
-				}
-				if newInstrs[i].Arg >= 0 && newInstrs[i].Arg >= start {
-					newInstrs[i].Arg -= start
-				}
-			}
+	// マッチに必要な長さを解析（短すぎる入力を早期に棄却するため）
+	minLen, maxLen := nodeLength(node)
+
+	// テキスト末尾に固定されたリテラル接尾辞を解析（マッチ前に直接比較するため）
+	endSuffix, endAnchored := endAnchor(node)
+
+	// マッチに必ず現れるリテラルを解析（開始位置の候補を Aho-Corasick 法で探すため）
+	requiredLiterals, startLiterals := literalRuns(node)
+	var startAutomaton *acAutomaton
+	if startLiterals != nil {
+		startAutomaton = newACAutomaton(startLiterals)
+	}
+
+	// 参照されているグループの表をキャプチャ数に合わせる
+	backrefs := make([]bool, c.numCaptures+1)
+	hasBackrefs := false
+	for i, ref := range c.backrefs {
+		if ref && i < len(backrefs) {
+			backrefs[i] = true
+			hasBackrefs = true
 		}
-		c.instrs = newInstrs[:len(c.instrs)-start]
 	}
 
 	// 完成したプログラムを返す
 	return &program{
 		instrs:      c.instrs,
+		start:       f.start,
+		flags:       c.flags,
 		numCaptures: c.numCaptures,
 		subexpNames: c.subexpNames,
+		backrefs:    backrefs,
+		hasBackrefs: hasBackrefs,
+		numCounters: c.numCounters,
+		minLen:      minLen,
+		maxLen:      maxLen,
+		endSuffix:   endSuffix,
+		endAnchored: endAnchored,
+		matchBounds: c.matchBounds,
+
+		requiredLiterals: requiredLiterals,
+		startLiterals:    startAutomaton,
 	}, nil
 }
 
-// compileNode は、指定されたノードとその子ノードをコンパイルします。
-func (c *Compiler) compileNode(node Node) (int, error) {
+// markOwners は、位置 first 以降に生成された命令のうち、子ノードのものでない命令を node が生成したものとして記録します。
+func (c *Compiler) markOwners(node Node, first int) {
+	for len(c.owners) < len(c.instrs) {
+		c.owners = append(c.owners, nil)
+	}
+	for pc := first; pc < len(c.instrs); pc++ {
+		if c.owners[pc] == nil {
+			c.owners[pc] = node
+		}
+	}
+}
+
+// compileNode は、指定されたノードとその子ノードをコンパイルし、命令列の断片を返します。
+// 通ったことを記録するノードは、記録する命令に続けてコンパイルします。
+func (c *Compiler) compileNode(node Node) (frag, error) {
+	counter, ok := c.coverageCounters[node]
+	if !ok {
+		return c.compileNodeInstrs(node)
+	}
+	mark := c.emitFrag(Instr{Op: InstrCoverage, Counter: counter})
+	body, err := c.compileNodeInstrs(node)
+	if err != nil {
+		return frag{}, err
+	}
+	c.fill(mark.out, body.start)
+	return frag{start: mark.start, out: body.out}, nil
+}
+
+// compileNodeInstrs は、ノードとその子ノードを命令列の断片にコンパイルします。
+func (c *Compiler) compileNodeInstrs(node Node) (frag, error) {
 	if node == nil {
-		return -1, fmt.Errorf("ノードがnilです")
+		return frag{}, fmt.Errorf("ノードがnilです")
+	}
+
+	// 命令数の上限を超えていないか確認
+	if err := c.checkSize(); err != nil {
+		return frag{}, err
+	}
+
+	if c.recordOwners {
+		defer c.markOwners(node, len(c.instrs))
 	}
 
 	switch n := node.(type) {
 	case *CharNode:
-		// 1文字にマッチする命令を生成
-		char := n.r
-		if c.flags.CaseInsensitive {
-			char = unicode.ToLower(char)
+		// 大小文字を区別しない文字は、コンパイル時に同一視される文字の集合に展開する
+		// （実行時は単純な比較だけで済む）
+		if n.fold {
+			if orbit := foldOrbit(n.r); len(orbit) > 1 {
+				return c.emitFrag(Instr{
+					Op:        InstrCharClass,
+					CharClass: &charClass{anyOf: orbit},
+				}), nil
+			}
 		}
-		start := c.emit(Instr{Op: InstrChar, Char: char, Next: len(c.instrs) + 1})
-		return start, nil
+
+		// 1文字にマッチする命令を生成
+		return c.emitFrag(Instr{Op: InstrChar, Char: n.r}), nil
 
 	case *AnyCharNode:
 		// 任意の1文字にマッチする命令を生成
-		dotMatchesNL := n.dotMatchesNewline || c.flags.DotMatchesNL
-		start := c.emit(Instr{
-			Op:   InstrAnyChar,
-			Arg:  boolToInt(dotMatchesNL), // 1なら改行にもマッチ、0ならマッチしない
-			Next: len(c.instrs) + 1,
-		})
-		return start, nil
+		// （(?s) の範囲はパーサーがノードごとに記録している）
+		return c.emitFrag(Instr{
+			Op:  InstrAnyChar,
+			Arg: boolToInt(n.dotMatchesNewline), // 1なら改行にもマッチ、0ならマッチしない
+		}), nil
 
 	case *CharClassNode:
 		// 文字クラスにマッチする命令を生成
 		class := &charClass{
-			classType:       n.classType,
-			negate:          n.negate,
-			caseInsensitive: c.flags.CaseInsensitive,
+			classType: n.classType,
+			negate:    n.negate,
 		}
 
 		// カスタム文字クラスの場合、範囲をコピー
+		// （大小文字を区別しない場合は、同一視される文字を含む範囲に展開する）
 		if n.classType == ClassCustom {
-			for _, r := range n.ranges {
-				class.ranges = append(class.ranges, r)
+			if n.fold {
+				class.ranges = foldRanges(n.ranges)
+			} else {
+				for _, r := range n.ranges {
+					class.ranges = append(class.ranges, r)
+				}
 			}
 		} else if n.classType == ClassUnicode {
 			// Unicodeプロパティの場合
@@ -229,109 +342,92 @@ func (c *Compiler) compileNode(node Node) (int, error) {
 			class.unicode[n.unicodeKey] = true
 		}
 
-		start := c.emit(Instr{
-			Op:        InstrCharClass,
-			CharClass: class,
-			Next:      len(c.instrs) + 1,
-		})
-		return start, nil
+		return c.emitFrag(Instr{Op: InstrCharClass, CharClass: class}), nil
 
 	case *ConcatNode:
-		// 連接は、各ノードを順番にコンパイル
+		// 連接は、各ノードを順番にコンパイルし、前のノードの出口を次のノードの入口につなぐ
 		if len(n.nodes) == 0 {
 			// 空の連接は空文字にマッチ（ジャンプだけ）
-			return c.emit(Instr{Op: InstrJump, Next: len(c.instrs) + 1}), nil
+			return c.emitFrag(Instr{Op: InstrJump}), nil
 		}
 
-		var start int
-		var err error
-		var lastNext int
-
-		for i, child := range n.nodes {
-			if i == 0 {
-				start, err = c.compileNode(child)
-				if err != nil {
-					return -1, err
-				}
-				lastNext = len(c.instrs)
-			} else {
-				curr, err := c.compileNode(child)
-				if err != nil {
-					return -1, err
-				}
-				c.patch(lastNext-1, curr)
-				lastNext = len(c.instrs)
+		f, err := c.compileNode(n.nodes[0])
+		if err != nil {
+			return frag{}, err
+		}
+		for _, child := range n.nodes[1:] {
+			next, err := c.compileNode(child)
+			if err != nil {
+				return frag{}, err
 			}
+			c.fill(f.out, next.start)
+			f.out = next.out
 		}
-
-		return start, nil
+		return f, nil
 
 	case *AltNode:
-		// 選択（|）は、分岐命令を使用
-		// 左辺と右辺をコンパイル
+		// リテラルのみの選択は、分岐命令の連鎖ではなくトライにまとめる
+		// （分岐ごとに通ったことを記録する場合と、1文字ずつ照合する場合を除く）
+		if literals, ok := literalAlternatives(n); ok && c.coverageCounters == nil && !c.singleRunes {
+			return c.emitFrag(Instr{Op: InstrTrie, Trie: newLiteralTrie(literals)}), nil
+		}
+
+		// 選択（|）は、左辺を先に試す分岐命令を使用
+		split := c.emit(Instr{Op: InstrSplit, Greedy: true})
 		left, err := c.compileNode(n.left)
 		if err != nil {
-			return -1, err
+			return frag{}, err
 		}
-
-		// 左辺が終了したら終点にジャンプする命令を追加
-		jumpPos := c.emit(Instr{Op: InstrJump, Next: -1}) // 後でパッチ
-
-		_, err = c.compileNode(n.right)
+		right, err := c.compileNode(n.right)
 		if err != nil {
-			return -1, err
+			return frag{}, err
 		}
-		rightEnd := len(c.instrs)
-
-		// 左辺のジャンプ先を、右辺の終了後に設定
-		c.patch(jumpPos, rightEnd)
+		c.instrs[split].Next = left.start
+		c.instrs[split].Arg = right.start
 
-		// 分岐命令を先頭に挿入し、左辺か右辺にジャンプするようにする
-		start := c.emit(Instr{Op: InstrSplit, Next: left, Arg: jumpPos + 1})
-
-		return start, nil
+		// どちらの辺の出口も選択全体の出口になる
+		return frag{start: split, out: append(left.out, right.out...)}, nil
 
 	case *RepeatNode:
-		// 繰り返しノードは複雑なので、タイプ別に処理
-		switch n.Type() {
-		case NodeStar: // 0回以上の繰り返し(*)
-			return c.compileStar(n.node, n.repeatType == RepeatNonGreedy, n.possessive)
-		case NodePlus: // 1回以上の繰り返し(+)
-			return c.compilePlus(n.node, n.repeatType == RepeatNonGreedy, n.possessive)
-		case NodeQuest: // 0または1回(?)
-			return c.compileQuest(n.node, n.repeatType == RepeatNonGreedy, n.possessive)
-		case NodeRepeat: // 範囲指定({n,m})
-			return c.compileRepeat(n.node, n.min, n.max, n.repeatType == RepeatNonGreedy, n.possessive)
+		// 1文字にマッチする要素の繰り返しは、1命令のループにまとめる
+		// （本体を通ったことを記録する場合と、1文字ずつ照合する場合を除く）
+		if _, covered := c.coverageCounters[n.node]; isSingleRune(n.node) && !covered && !c.singleRunes {
+			return c.compileRun(n)
 		}
-		return -1, fmt.Errorf("未知の繰り返しタイプ: %v", n.Type())
+
+		// 所有的量指定子は、貪欲な繰り返し全体をアトミックなグループとしてコンパイル
+		if n.possessive {
+			return c.compileAtomic(func() (frag, error) {
+				return c.compileRepeatNode(n.node, n.min, n.max, false)
+			})
+		}
+		return c.compileRepeatNode(n.node, n.min, n.max, n.repeatType == RepeatNonGreedy)
 
 	case *CaptureNode:
 		// キャプチャグループ
 		// 開始位置を保存する命令
 		captureIndex := n.index * 2 // 開始と終了で2つの位置を保存
-		saveBegin := c.emit(Instr{
+		f := c.emitFrag(Instr{
 			Op:       InstrSave,
 			Arg:      captureIndex,
 			SaveType: SaveBegin,
-			Next:     len(c.instrs) + 1,
 		})
 
 		// グループの内容をコンパイル
 		body, err := c.compileNode(n.node)
 		if err != nil {
-			return -1, err
+			return frag{}, err
 		}
-
-		// 最初の保存命令の次の命令を内容の先頭に設定
-		c.patch(saveBegin, body)
+		c.fill(f.out, body.start)
 
 		// 終了位置を保存する命令
-		c.emit(Instr{
+		end := c.emitFrag(Instr{
 			Op:       InstrSave,
 			Arg:      captureIndex + 1,
 			SaveType: SaveEnd,
-			Next:     len(c.instrs) + 1,
 		})
+		c.fill(body.out, end.start)
+		f.out = end.out
 
 		// 必要なら、キャプチャ情報を更新
 		if n.index > c.numCaptures {
@@ -351,32 +447,36 @@ func (c *Compiler) compileNode(node Node) (int, error) {
 			c.subexpNames[n.index] = n.name
 		}
 
-		return saveBegin, nil
+		return f, nil
 
 	case *GroupNode:
 		// 非キャプチャグループは単純に内容をコンパイル
 		return c.compileNode(n.node)
 
 	case *BackrefNode:
-		// バックリファレンス
-		var refIndex int
-		if n.name != "" {
-			// 名前付きバックリファレンスの場合、番号を検索する必要がある
-			// （ここでは単純化のため、インデックスが直接指定されたと仮定）
-			refIndex = n.index
-		} else {
-			refIndex = n.index
+		// バックリファレンス（名前付きの参照も、パーサーが番号に解決済み）
+		refIndex := n.index
+
+		// 参照されたグループを記録（マッチ時にキャプチャ保存を省略できるかの判定用）
+		for len(c.backrefs) <= refIndex {
+			c.backrefs = append(c.backrefs, false)
 		}
+		c.backrefs[refIndex] = true
 
 		// バックリファレンス命令を生成
-		start := c.emit(Instr{
-			Op:   InstrBackref,
-			Arg:  refIndex,
-			Next: len(c.instrs) + 1,
-		})
-		return start, nil
+		return c.emitFrag(Instr{Op: InstrBackref, Arg: refIndex}), nil
 
 	case *BoundaryNode:
+		// \zs と \ze は、マッチ全体の開始位置・終了位置のスロットを現在位置で上書きする
+		switch n.nodeType {
+		case NodeMatchStart:
+			c.matchBounds = true
+			return c.emitFrag(Instr{Op: InstrSave, Arg: 0, SaveType: SaveBegin}), nil
+		case NodeMatchEnd:
+			c.matchBounds = true
+			return c.emitFrag(Instr{Op: InstrSave, Arg: 1, SaveType: SaveEnd}), nil
+		}
+
 		// 境界条件
 		var op InstrType
 		switch n.nodeType {
@@ -392,306 +492,234 @@ func (c *Compiler) compileNode(node Node) (int, error) {
 			op = InstrBeginText
 		case NodeEndText:
 			op = InstrEndText
+		case NodeNotAfterWord:
+			op = InstrNotAfterWord
+		case NodeNotBeforeWord:
+			op = InstrNotBeforeWord
 		default:
-			return -1, fmt.Errorf("未知の境界タイプ: %v", n.nodeType)
+			return frag{}, fmt.Errorf("未知の境界タイプ: %v", n.nodeType)
 		}
 
-		start := c.emit(Instr{
-			Op:   op,
-			Next: len(c.instrs) + 1,
-		})
-		return start, nil
+		// ^ と $ は、マルチラインモードかどうかを Arg に持つ
+		// （1ならマルチライン、2なら \r、U+2028、U+2029 も行の区切りとみなすマルチライン）
+		arg := boolToInt(n.multiline)
+		if n.multiline && n.lineTerminators {
+			arg = 2
+		}
+		return c.emitFrag(Instr{Op: op, Arg: arg}), nil
 
 	default:
-		return -1, fmt.Errorf("未知のノードタイプ: %T", node)
+		return frag{}, fmt.Errorf("未知のノードタイプ: %T", node)
 	}
 }
 
-// compileStar は、0回以上の繰り返し（*）をコンパイルします。
-func (c *Compiler) compileStar(node Node, nonGreedy, possessive bool) (int, error) {
-	// 先に分岐命令を挿入（後で本体の先頭を設定）
-	var splitPos int
-
-	if possessive {
-		// 所有的量指定子の場合、単体にマッチした後は戻らない
-		body, err := c.compileNode(node)
-		if err != nil {
-			return -1, err
-		}
-
-		// 先頭に分岐を置き、マッチするか、スキップするか
-		splitPos = c.emit(Instr{
-			Op:         InstrSplit,
-			Next:       body, // マッチを試行
-			Arg:        -1,   // スキップ（後でパッチ）
-			Greedy:     !nonGreedy,
-			Possessive: true,
-		})
-
-		// 本体の最後に、本体の先頭に戻るジャンプを追加（バックトラックなし）
-		c.emit(Instr{
-			Op:   InstrJump,
-			Next: body,
-		})
-
-		// 分岐命令のスキップ先をここに設定
-		c.patchArg(splitPos, len(c.instrs))
-	} else {
-		// 通常の*演算子
-		splitPos = c.emit(Instr{
-			Op:     InstrSplit,
-			Next:   -1, // 後でパッチ
-			Arg:    -1, // 後でパッチ
-			Greedy: !nonGreedy,
-		})
-
-		// 本体をコンパイル
-		body, err := c.compileNode(node)
-		if err != nil {
-			return -1, err
-		}
-
-		// 分岐命令の分岐先を本体に設定
-		if nonGreedy {
-			// 非貪欲の場合、先にスキップを試す
-			c.patch(splitPos, len(c.instrs)+1) // スキップ
-			c.patchArg(splitPos, body)         // マッチ
-		} else {
-			// 貪欲の場合、先にマッチを試す
-			c.patch(splitPos, body)               // マッチ
-			c.patchArg(splitPos, len(c.instrs)+1) // スキップ
-		}
+// compileRepeatNode は、繰り返しを回数の指定に応じた形でコンパイルします。
+func (c *Compiler) compileRepeatNode(node Node, min, max int, nonGreedy bool) (frag, error) {
+	switch {
+	case min == 0 && max == -1: // 0回以上の繰り返し(*)
+		return c.compileStar(node, nonGreedy)
+	case min == 1 && max == -1: // 1回以上の繰り返し(+)
+		return c.compilePlus(node, nonGreedy)
+	case min == 0 && max == 1: // 0または1回(?)
+		return c.compileQuest(node, nonGreedy)
+	default: // 範囲指定({n,m})
+		return c.compileRepeat(node, min, max, nonGreedy)
+	}
+}
 
-		// 本体の後に、繰り返し先頭に戻るジャンプを追加
-		c.emit(Instr{
-			Op:   InstrJump,
-			Next: splitPos,
-		})
+// compileStar は、0回以上の繰り返し（*）をコンパイルします。
+//
+//	split: InstrSplit  body か出口へ（貪欲なら body を先に試す）
+//	body:  ...         本体（終了後は split へ）
+func (c *Compiler) compileStar(node Node, nonGreedy bool) (frag, error) {
+	split := c.emit(Instr{Op: InstrSplit, Greedy: !nonGreedy})
+
+	// 本体をコンパイルし、終了後は分岐に戻る
+	body, empty, err := c.compileLoopBody(node)
+	if err != nil {
+		return frag{}, err
 	}
+	c.fill(body.out, split)
+	c.instrs[split].Next = body.start
 
-	return splitPos, nil
+	// 分岐のもう一方（Arg）が繰り返しの出口
+	return frag{start: split, out: append(empty, hole{pc: split, arg: true})}, nil
 }
 
 // compilePlus は、1回以上の繰り返し（+）をコンパイルします。
-func (c *Compiler) compilePlus(node Node, nonGreedy, possessive bool) (int, error) {
-	// まず、本体をコンパイル（これは最低1回実行）
-	start, err := c.compileNode(node)
+//
+//	body:  ...         本体（最低1回実行）
+//	split: InstrSplit  body に戻るか出口へ（貪欲なら body を先に試す）
+func (c *Compiler) compilePlus(node Node, nonGreedy bool) (frag, error) {
+	body, empty, err := c.compileLoopBody(node)
 	if err != nil {
-		return -1, err
-	}
-
-	if possessive {
-		// 所有的量指定子の場合、最初にマッチした後は繰り返すがバックトラックはしない
-		c.emit(Instr{
-			Op:   InstrJump,
-			Next: start,
-		})
-		return start, nil
-	}
-
-	// 次に分岐命令を挿入（本体に戻るか、次に進むか）
-	var splitInstr Instr
-	if nonGreedy {
-		// 非貪欲の場合、先にスキップ
-		splitInstr = Instr{
-			Op:     InstrSplit,
-			Next:   len(c.instrs) + 2, // スキップ
-			Arg:    start,             // 繰り返し
-			Greedy: false,
-		}
-	} else {
-		// 貪欲の場合、先に繰り返し
-		splitInstr = Instr{
-			Op:     InstrSplit,
-			Next:   start,             // 繰り返し
-			Arg:    len(c.instrs) + 2, // スキップ
-			Greedy: true,
-		}
+		return frag{}, err
 	}
 
-	c.emit(splitInstr)
+	// 本体の後に、本体に戻るか次に進むかの分岐を置く
+	split := c.emit(Instr{Op: InstrSplit, Next: body.start, Greedy: !nonGreedy})
+	c.fill(body.out, split)
 
-	return start, nil
+	return frag{start: body.start, out: append(empty, hole{pc: split, arg: true})}, nil
 }
 
 // compileQuest は、0または1回（?）をコンパイルします。
-func (c *Compiler) compileQuest(node Node, nonGreedy, possessive bool) (int, error) {
-	// 分岐命令を挿入（本体を実行するか、スキップするか）
-	splitPos := c.emit(Instr{
-		Op:         InstrSplit,
-		Next:       -1, // 後でパッチ
-		Arg:        -1, // 後でパッチ
-		Greedy:     !nonGreedy,
-		Possessive: possessive,
-	})
+//
+//	split: InstrSplit  body か出口へ（貪欲なら body を先に試す）
+//	body:  ...         本体（終了後は出口へ）
+func (c *Compiler) compileQuest(node Node, nonGreedy bool) (frag, error) {
+	split := c.emit(Instr{Op: InstrSplit, Greedy: !nonGreedy})
 
-	// 本体をコンパイル
 	body, err := c.compileNode(node)
 	if err != nil {
-		return -1, err
+		return frag{}, err
 	}
+	c.instrs[split].Next = body.start
 
-	// 所有的量指定子の場合、バックトラック情報は破棄される
+	// 本体の出口と、本体を飛ばす分岐の両方が出口になる
+	return frag{start: split, out: append(body.out, hole{pc: split, arg: true})}, nil
+}
 
-	// 分岐命令の分岐先を設定
-	if nonGreedy {
-		// 非貪欲の場合、先にスキップを試す
-		c.patch(splitPos, len(c.instrs)+1) // スキップ
-		c.patchArg(splitPos, body)         // マッチ
-	} else {
-		// 貪欲の場合、先にマッチを試す
-		c.patch(splitPos, body)               // マッチ
-		c.patchArg(splitPos, len(c.instrs)+1) // スキップ
+// compileAtomic は、compileBody がコンパイルする部分をアトミックなグループとしてコンパイルします。
+// グループを抜けた時点で、グループ内で作られたバックトラックポイントはすべて破棄されます。
+//
+//	begin: InstrAtomicBegin  バックトラックスタックの深さを記録
+//	body:  ...               本体
+//	end:   InstrAtomicEnd    記録した深さまでスタックを戻す
+func (c *Compiler) compileAtomic(compileBody func() (frag, error)) (frag, error) {
+	// スタックの深さを記録するスロットは、繰り返しカウンタと同じ領域に割り当てる
+	counter := c.numCounters
+	c.numCounters++
+
+	f := c.emitFrag(Instr{Op: InstrAtomicBegin, Counter: counter})
+	body, err := compileBody()
+	if err != nil {
+		return frag{}, err
 	}
+	c.fill(f.out, body.start)
 
-	return splitPos, nil
+	end := c.emitFrag(Instr{Op: InstrAtomicEnd, Counter: counter})
+	c.fill(body.out, end.start)
+	f.out = end.out
+	return f, nil
 }
 
-// compileRepeat は、範囲指定繰り返し（{n,m}）をコンパイルします。
-func (c *Compiler) compileRepeat(node Node, min, max int, nonGreedy, possessive bool) (int, error) {
-	// まず、最小回数分、本体を繰り返す
-	var start, prev, current int
-	var err error
-
-	// 最小回数の繰り返し部分（固定実行）
-	if min > 0 {
-		// 最初の一回
-		start, err = c.compileNode(node)
-		if err != nil {
-			return -1, err
-		}
-
-		prev = start
-
-		// 残りの min-1 回
-		for i := 1; i < min; i++ {
-			current, err = c.compileNode(node)
-			if err != nil {
-				return -1, err
-			}
+// compileRun は、1文字にマッチする要素の繰り返し（a*, \d+, [^"]* など）を
+// 1つの InstrRun 命令にコンパイルします。InstrRun はマッチする文字をまとめて消費し、
+// 文字ごとにバックトラックポイントを作る代わりに、範囲を1つのポイントとして記録します。
+func (c *Compiler) compileRun(n *RepeatNode) (frag, error) {
+	// まず要素を通常どおり1命令にコンパイルし、それを繰り返し命令に置き換える
+	f, err := c.compileNode(n.node)
+	if err != nil {
+		return frag{}, err
+	}
+	item := c.instrs[f.start]
+
+	c.instrs[f.start] = Instr{
+		Op:         InstrRun,
+		RunOp:      item.Op,
+		Char:       item.Char,
+		CharClass:  item.CharClass,
+		Arg:        item.Arg,
+		Min:        n.min,
+		Max:        n.max,
+		Greedy:     n.repeatType != RepeatNonGreedy,
+		Possessive: n.possessive,
+	}
+	if c.recordOwners {
+		// 置き換えた命令は、要素ではなく繰り返しのもの
+		c.owners[f.start] = n
+	}
+	return f, nil
+}
 
-			// 前のイテレーションと連結
-			last := prev
-			for c.instrs[last].Next != -1 && last < len(c.instrs)-1 {
-				last++
-			}
-			if last < len(c.instrs) {
-				c.patch(last, current)
-			}
+// isSingleRune は、ノードが常にちょうど1文字にマッチする単純な要素かどうかを返します。
+func isSingleRune(node Node) bool {
+	switch node.(type) {
+	case *CharNode, *AnyCharNode, *CharClassNode:
+		return true
+	}
+	return false
+}
 
-			prev = current
-		}
-	} else {
-		// min == 0 の場合は、空のノードから始める
-		start = len(c.instrs)
-	}
-
-	// 最大回数まで（オプショナルな追加実行）
-	if max == -1 {
-		// 上限なしの場合は * と同様
-		// 最小回数を実行した後の位置
-		if possessive {
-			// 所有的量指定子の場合、マッチするがバックトラックしない
-			repeatBody, err := c.compileNode(node)
-			if err != nil {
-				return -1, err
-			}
+// compileRepeat は、範囲指定繰り返し（{n,m}）をコンパイルします。
+// 本体を複製する代わりにループカウンタを使用するため、
+// 生成される命令数は繰り返し回数に依存しません。
+//
+//	init:  InstrRepeatInit  カウンタを初期化して check へ
+//	body:  ...              本体（終了後は check へ）
+//	check: InstrRepeatCheck カウンタを進め、body に戻るか Next（出口）へ抜けるかを判定
+//	gate:  InstrRepeatCheck 本体が入力を消費しなかった場合の判定（最小回数に達するまで body に戻る）
+func (c *Compiler) compileRepeat(node Node, min, max int, nonGreedy bool) (frag, error) {
+	// この繰り返し用のカウンタを割り当てる
+	counter := c.numCounters
+	c.numCounters++
+
+	// カウンタ初期化命令（ジャンプ先は後でパッチ）
+	init := c.emit(Instr{Op: InstrRepeatInit, Counter: counter})
 
-			// 分岐：マッチするか終了するか
-			splitPos := c.emit(Instr{
-				Op:         InstrSplit,
-				Next:       repeatBody,
-				Arg:        len(c.instrs) + 2, // 終了位置（後でパッチ）
-				Greedy:     !nonGreedy,
-				Possessive: true,
-			})
+	// 本体をコンパイル
+	body, empty, err := c.compileLoopBody(node)
+	if err != nil {
+		return frag{}, err
+	}
 
-			// 繰り返しジャンプ
-			c.emit(Instr{
-				Op:   InstrJump,
-				Next: splitPos,
-			})
-		} else {
-			// 通常の繰り返し
-			repeatBody, err := c.compileNode(node)
-			if err != nil {
-				return -1, err
-			}
+	// 繰り返し判定命令（本体の最後はここに到達する）
+	check := c.emit(Instr{
+		Op:      InstrRepeatCheck,
+		Counter: counter,
+		Arg:     body.start, // 本体の先頭
+		Min:     min,
+		Max:     max,
+		Greedy:  !nonGreedy,
+	})
+	c.fill(body.out, check)
+
+	// 本体が入力を消費しなかった場合も、最小回数に達するまでは繰り返しを続ける（Perl と同じ）。
+	// 最大回数を最小回数にした判定を通し、最小回数に達していれば抜ける
+	if min > 0 && len(empty) > 0 {
+		gate := c.emit(Instr{Op: InstrRepeatCheck, Counter: counter, Arg: body.start, Min: min, Max: min, Greedy: !nonGreedy})
+		c.fill(empty, gate)
+		empty = []hole{{pc: gate}}
+	}
 
-			// 分岐：マッチするか終了するか
-			var splitOp Instr
-			if nonGreedy {
-				splitOp = Instr{
-					Op:     InstrSplit,
-					Next:   len(c.instrs) + 2, // 終了位置
-					Arg:    repeatBody,        // マッチ
-					Greedy: false,
-				}
-			} else {
-				splitOp = Instr{
-					Op:     InstrSplit,
-					Next:   repeatBody,        // マッチ
-					Arg:    len(c.instrs) + 2, // 終了位置
-					Greedy: true,
-				}
-			}
-			splitPos := c.emit(splitOp)
+	// 初期化後は最初の判定へ
+	c.instrs[init].Next = check
 
-			// 繰り返しジャンプ
-			c.emit(Instr{
-				Op:   InstrJump,
-				Next: splitPos,
-			})
-		}
-	} else if max > min {
-		// 有限の追加繰り返し
-		var repeatStarts []int
-
-		// 各追加の繰り返しで、実行するかスキップするかの分岐を追加
-		for i := 0; i < max-min; i++ {
-			// ここから繰り返し部分が始まる
-			repeatPos := len(c.instrs)
-			repeatStarts = append(repeatStarts, repeatPos)
-
-			// 分岐命令：実行するかスキップするか
-			splitPos := c.emit(Instr{
-				Op:         InstrSplit,
-				Next:       -1, // 後でパッチ
-				Arg:        -1, // 後でパッチ
-				Greedy:     !nonGreedy,
-				Possessive: possessive,
-			})
+	return frag{start: init, out: append(empty, hole{pc: check})}, nil
+}
 
-			// 本体をコンパイル
-			body, err := c.compileNode(node)
-			if err != nil {
-				return -1, err
-			}
+// compileLoopBody は、繰り返しの本体をコンパイルします。
+// 本体が空文字列にマッチし得る場合は、入力を消費しない繰り返しが際限なく続かないよう、
+// 本体の前後に開始位置の記録と確認を挟みます。
+//
+//	mark:  InstrProgressMark   本体の開始位置を記録
+//	body:  ...                 本体
+//	check: InstrProgressCheck  位置が進んでいれば Next（繰り返しの継続）へ、進んでいなければ Arg へ
+//
+// 戻り値の empty は、入力を消費しなかったために繰り返しを抜ける出口（check の Arg）です。
+func (c *Compiler) compileLoopBody(node Node) (body frag, empty []hole, err error) {
+	if min, _ := nodeLength(node); min > 0 {
+		// 必ず入力を消費する本体には確認は不要
+		body, err = c.compileNode(node)
+		return body, nil, err
+	}
 
-			// 分岐命令の分岐先を設定
-			if nonGreedy {
-				// 非貪欲の場合、先にスキップを試す
-				c.patch(splitPos, len(c.instrs)) // スキップ（次の分岐またはマッチ終了）
-				c.patchArg(splitPos, body)       // マッチ
-			} else {
-				// 貪欲の場合、先にマッチを試す
-				c.patch(splitPos, body)             // マッチ
-				c.patchArg(splitPos, len(c.instrs)) // スキップ（次の分岐またはマッチ終了）
-			}
+	// 開始位置を記録するスロットは、繰り返しカウンタと同じ領域に割り当てる
+	counter := c.numCounters
+	c.numCounters++
 
-			// 所有的量指定子の場合、バックトラック状態を破棄
-			if possessive && i < max-min-1 {
-				// 最後以外の繰り返しでは、次の繰り返しに無条件ジャンプ
-				c.emit(Instr{
-					Op:   InstrJump,
-					Next: len(c.instrs),
-				})
-			}
-		}
+	body = c.emitFrag(Instr{Op: InstrProgressMark, Counter: counter})
+	inner, err := c.compileNode(node)
+	if err != nil {
+		return frag{}, nil, err
 	}
+	c.fill(body.out, inner.start)
 
-	// 最終的には min 回目の先頭か、min == 0 の場合は最初の分岐を返す
-	return start, nil
+	check := c.emit(Instr{Op: InstrProgressCheck, Counter: counter})
+	c.fill(inner.out, check)
+	body.out = []hole{{pc: check}}
+	return body, []hole{{pc: check, arg: true}}, nil
 }
 
 // boolToInt は、論理値を整数に変換します。
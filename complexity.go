@@ -0,0 +1,406 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotLinear は、Options.RequireLinear を指定したパターンが、線形時間での照合を保証できない構文を含む場合のエラーです。
+// 返すエラーはこれをラップし、原因となる部分式を含みます。
+var ErrNotLinear = errors.New("線形時間での照合を保証できないパターンです")
+
+// ErrSuperlinear は、Options.RejectSuperlinear を指定したパターンが、指数的なバックトラックを起こし得る場合のエラーです。
+// 返すエラーはこれをラップし、原因となる繰り返しの部分式を含みます。
+var ErrSuperlinear = errors.New("破滅的なバックトラックを起こし得るパターンです")
+
+// Complexity は、1つの開始位置からのマッチングにかかる最悪の計算量の分類です。
+// 入力全体を走査する Find などでは、これに開始位置の数（入力長）が掛かります。
+type Complexity int
+
+const (
+	ComplexityLinear      Complexity = iota // 入力長に比例する
+	ComplexityPolynomial                    // 入力長の多項式（2乗以上）になり得る
+	ComplexityExponential                   // 入力長の指数になり得る（破滅的なバックトラック）
+)
+
+// String は、計算量の分類の名前を返します。
+func (c Complexity) String() string {
+	switch c {
+	case ComplexityLinear:
+		return "linear"
+	case ComplexityPolynomial:
+		return "polynomial"
+	case ComplexityExponential:
+		return "exponential"
+	}
+	return "unknown"
+}
+
+// ComplexityReport は、コンパイルされたパターンの最悪計算量の見積もりです。
+type ComplexityReport struct {
+	// Class は、計算量の分類です。
+	Class Complexity
+
+	// Degree は、Class が ComplexityPolynomial の場合の次数です（O(n^2) なら2）。
+	Degree int
+
+	// Culprits は、計算量の原因となる部分式です。
+	// 指数的な場合は曖昧な繰り返し、多項式の場合は重なり合う繰り返しの並びを、パターンの構文で表します。
+	Culprits []string
+}
+
+// Complexity は、このバックトラック型エンジンでパターンを実行した場合の最悪計算量の見積もりを返します。
+// ステップ数の上限の決定や、書き換えるべきパターンの優先順位付けに使用できます。
+//
+// 見積もりは構文に基づく保守的なもので、実際には起こらない最悪ケースを報告することがあります。
+// 上限のない繰り返しだけを対象とし、所有的量指定子の繰り返しはバックトラックしないものとして扱います。
+func (re *Regexp) Complexity() ComplexityReport {
+	report := re.complexity
+	report.Culprits = append([]string(nil), report.Culprits...)
+	return report
+}
+
+// complexityAnalyzer は、ASTをたどって計算量を見積もります。
+type complexityAnalyzer struct {
+	exponential []Node // 本体が曖昧な繰り返し
+	polynomial  []Node // 重なり合う繰り返しの並びを含むノード
+}
+
+// analyzeComplexity は、AST全体の計算量を見積もります。
+func analyzeComplexity(node Node) ComplexityReport {
+	a := &complexityAnalyzer{}
+	degree := a.degree(node)
+
+	var report ComplexityReport
+	switch {
+	case len(a.exponential) > 0:
+		report.Class = ComplexityExponential
+		for _, n := range a.exponential {
+			report.Culprits = append(report.Culprits, nodeString(n))
+		}
+	case degree >= 2:
+		report.Class = ComplexityPolynomial
+		report.Degree = degree
+		for _, n := range a.polynomial {
+			report.Culprits = append(report.Culprits, nodeString(n))
+		}
+	default:
+		report.Class = ComplexityLinear
+	}
+	return report
+}
+
+// degree は、1つの開始位置からノードを照合する最悪計算量の次数を返します（定数や線形なら1）。
+// 指数的になり得る繰り返しを見つけた場合は、a.exponential に記録します。
+func (a *complexityAnalyzer) degree(node Node) int {
+	switch n := node.(type) {
+	case *ConcatNode:
+		d := 1
+		for _, child := range n.nodes {
+			d = max(d, a.degree(child))
+		}
+		// 同じ文字を奪い合う繰り返しが並ぶと、分割の仕方の数だけ試行が増える
+		if chain := overlappingLoops(n.nodes); chain >= 2 {
+			a.polynomial = append(a.polynomial, n)
+			d = max(d, chain)
+		}
+		return d
+
+	case *AltNode:
+		return max(a.degree(n.left), a.degree(n.right))
+
+	case *RepeatNode:
+		d := a.degree(n.node)
+		if isBacktrackingLoop(n) && ambiguousBody(n.node, d) {
+			a.exponential = append(a.exponential, n)
+		}
+		return d
+
+	case *CaptureNode:
+		return a.degree(n.node)
+
+	case *GroupNode:
+		return a.degree(n.node)
+
+	default:
+		return 1
+	}
+}
+
+// isBacktrackingLoop は、ノードがバックトラックし得る上限のない繰り返しかどうかを返します。
+func isBacktrackingLoop(node Node) bool {
+	n, ok := node.(*RepeatNode)
+	return ok && n.max == -1 && !n.possessive
+}
+
+// overlappingLoops は、連接の要素のうち、同じ文字を消費し得る繰り返しが
+// 最大でいくつ連続して並ぶかを返します。
+// 間にある要素も前の繰り返しが消費し得る文字だけで構成される場合に、連続しているとみなします（例: .*x.*）。
+func overlappingLoops(nodes []Node) int {
+	best, chain := 0, 0
+	var prev charSet
+	for _, node := range nodes {
+		chars := nodeChars(node)
+		switch {
+		case isBacktrackingLoop(node):
+			if chain > 0 && prev.overlaps(chars) {
+				chain++
+			} else {
+				chain = 1
+			}
+			prev = chars
+		case chain > 0 && (chars.empty() || prev.overlaps(chars)):
+			// 前の繰り返しが消費し得る要素（または文字を消費しない要素）は連続を途切れさせない
+		default:
+			chain = 0
+		}
+		best = max(best, chain)
+	}
+	return best
+}
+
+// ambiguousBody は、繰り返しの本体が同じ文字列に複数の方法でマッチし得るかどうかを返します。
+// そのような本体を繰り返すと、試行する組み合わせが指数的に増えます（例: (a+)+、(a|a)*、(x+x+)+）。
+func ambiguousBody(body Node, degree int) bool {
+	// 本体だけで多項式の試行がある
+	if degree >= 2 {
+		return true
+	}
+	// 先頭の文字が重なる選択肢がある
+	if overlappingAlternation(body) {
+		return true
+	}
+	// 本体の末尾の繰り返しが、次の繰り返しの先頭の文字も消費し得る
+	seq := sequenceOf(unwrapGroup(body))
+	first := firstChars(body)
+	for i := len(seq) - 1; i >= 0; i-- {
+		inner := unwrapGroup(seq[i])
+		if isBacktrackingLoop(inner) && nodeChars(inner).overlaps(first) {
+			return true
+		}
+		if min, _ := nodeLength(seq[i]); min > 0 {
+			break
+		}
+	}
+	return false
+}
+
+// overlappingAlternation は、ノード内に、先頭の文字が重なる選択肢を持つ選択があるかどうかを返します。
+// 所有的な繰り返しの内側はバックトラックしないため対象外です。
+func overlappingAlternation(node Node) bool {
+	switch n := node.(type) {
+	case *AltNode:
+		branches := flattenAlt(n)
+		for i := range branches {
+			for j := i + 1; j < len(branches); j++ {
+				if firstChars(branches[i]).overlaps(firstChars(branches[j])) {
+					return true
+				}
+			}
+		}
+		for _, b := range branches {
+			if overlappingAlternation(b) {
+				return true
+			}
+		}
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if overlappingAlternation(child) {
+				return true
+			}
+		}
+	case *RepeatNode:
+		return !n.possessive && overlappingAlternation(n.node)
+	case *CaptureNode:
+		return overlappingAlternation(n.node)
+	case *GroupNode:
+		return overlappingAlternation(n.node)
+	}
+	return false
+}
+
+// unwrapGroup は、キャプチャグループや非キャプチャグループの内側のノードを返します。
+func unwrapGroup(node Node) Node {
+	for {
+		switch n := node.(type) {
+		case *CaptureNode:
+			node = n.node
+		case *GroupNode:
+			node = n.node
+		default:
+			return node
+		}
+	}
+}
+
+// charSet は、ノードが消費し得る文字の集合を近似したものです。
+type charSet struct {
+	any    bool        // 任意の文字を含み得る
+	ranges []runeRange // any でない場合に含まれる文字範囲
+}
+
+// empty は、集合が空かどうかを返します。
+func (s charSet) empty() bool {
+	return !s.any && len(s.ranges) == 0
+}
+
+// union は、2つの集合の和を返します。
+func (s charSet) union(t charSet) charSet {
+	if s.any || t.any {
+		return charSet{any: true}
+	}
+	ranges := append(append([]runeRange(nil), s.ranges...), t.ranges...)
+	return charSet{ranges: normalizeRanges(ranges)}
+}
+
+// overlaps は、2つの集合に共通の文字があり得るかどうかを返します。
+func (s charSet) overlaps(t charSet) bool {
+	if s.empty() || t.empty() {
+		return false
+	}
+	if s.any || t.any {
+		return true
+	}
+	for _, a := range s.ranges {
+		for _, b := range t.ranges {
+			if a.min <= b.max && b.min <= a.max {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeChars は、ノードが消費し得るすべての文字の集合を返します。
+func nodeChars(node Node) charSet {
+	switch n := node.(type) {
+	case *ConcatNode:
+		var s charSet
+		for _, child := range n.nodes {
+			s = s.union(nodeChars(child))
+		}
+		return s
+	case *AltNode:
+		return nodeChars(n.left).union(nodeChars(n.right))
+	case *RepeatNode:
+		return nodeChars(n.node)
+	case *CaptureNode:
+		return nodeChars(n.node)
+	case *GroupNode:
+		return nodeChars(n.node)
+	case *BoundaryNode:
+		return charSet{}
+	default:
+		return singleChars(node)
+	}
+}
+
+// firstChars は、ノードのマッチの先頭になり得る文字の集合を返します。
+func firstChars(node Node) charSet {
+	switch n := node.(type) {
+	case *ConcatNode:
+		var s charSet
+		for _, child := range n.nodes {
+			s = s.union(firstChars(child))
+			if min, _ := nodeLength(child); min > 0 {
+				break
+			}
+		}
+		return s
+	case *AltNode:
+		return firstChars(n.left).union(firstChars(n.right))
+	case *RepeatNode:
+		return firstChars(n.node)
+	case *CaptureNode:
+		return firstChars(n.node)
+	case *GroupNode:
+		return firstChars(n.node)
+	case *BoundaryNode:
+		return charSet{}
+	default:
+		return singleChars(node)
+	}
+}
+
+// singleChars は、1文字にマッチするノード（やバックリファレンス）が消費し得る文字の集合を返します。
+func singleChars(node Node) charSet {
+	switch n := node.(type) {
+	case *CharNode:
+		if n.fold {
+			var ranges []runeRange
+			for _, r := range foldOrbit(n.r) {
+				ranges = append(ranges, runeRange{min: r, max: r})
+			}
+			return charSet{ranges: normalizeRanges(ranges)}
+		}
+		return charSet{ranges: []runeRange{{min: n.r, max: n.r}}}
+	case *CharClassNode:
+		if n.negate {
+			return charSet{any: true}
+		}
+		switch n.classType {
+		case ClassCustom:
+			if n.fold {
+				return charSet{ranges: foldRanges(n.ranges)}
+			}
+			return charSet{ranges: normalizeRanges(append([]runeRange(nil), n.ranges...))}
+		case ClassDigit:
+			return charSet{ranges: []runeRange{{'0', '9'}}}
+		case ClassWord:
+			return charSet{ranges: []runeRange{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}}}
+		}
+	}
+	// 任意の文字、空白類、Unicodeプロパティ、バックリファレンスは、何でも含み得るとみなす
+	return charSet{any: true}
+}
+
+// checkExponential は、Options.RejectSuperlinear のために、計算量の見積もりが指数的でないことを確かめます。
+// 指数的な場合は、原因となる繰り返しを示す ErrSuperlinear をラップしたエラーを返します。
+func checkExponential(report ComplexityReport) error {
+	if report.Class == ComplexityExponential {
+		return fmt.Errorf("%w: 曖昧な繰り返し %s", ErrSuperlinear, strings.Join(report.Culprits, ", "))
+	}
+	return nil
+}
+
+// checkLinear は、Options.RequireLinear のために、1つの開始位置からの照合が入力長に比例することを確かめます。
+// バックリファレンスを含む場合や、計算量の見積もりが線形でない場合は ErrNotLinear をラップしたエラーを返します。
+func checkLinear(ast Node, report ComplexityReport) error {
+	if ref := findBackref(ast); ref != nil {
+		return fmt.Errorf("%w: バックリファレンス %s", ErrNotLinear, nodeString(ref))
+	}
+	if report.Class != ComplexityLinear {
+		return fmt.Errorf("%w: 計算量が %s になり得る部分式 %s", ErrNotLinear, report.Class, strings.Join(report.Culprits, ", "))
+	}
+	return nil
+}
+
+// findBackref は、ノード内の最初のバックリファレンスを返します。なければ nil を返します。
+func findBackref(node Node) Node {
+	switch n := node.(type) {
+	case *BackrefNode:
+		return n
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if ref := findBackref(child); ref != nil {
+				return ref
+			}
+		}
+	case *AltNode:
+		if ref := findBackref(n.left); ref != nil {
+			return ref
+		}
+		return findBackref(n.right)
+	case *RepeatNode:
+		return findBackref(n.node)
+	case *CaptureNode:
+		return findBackref(n.node)
+	case *GroupNode:
+		if n.node != nil {
+			return findBackref(n.node)
+		}
+	}
+	return nil
+}
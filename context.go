@@ -0,0 +1,73 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "context"
+
+// contextCheckInterval は、照合中にコンテキストの取り消しを確かめる間隔（命令数）です。
+const contextCheckInterval = 1024
+
+// PartialMatches は、FindAllContext が返す、途中で打ち切られたかもしれない検索の結果です。
+type PartialMatches struct {
+	// Matches は、見つけたマッチと各グループの位置です（FindAllStringSubmatchIndex と同じ形式）。
+	Matches [][]int
+
+	// Offset は、検索を打ち切った位置（バイト単位）です。最後まで検索した場合は-1です。
+	// 検索の進み具合を表示するのに使えます。続きは Resume で検索します。
+	Offset int
+
+	re      *Regexp
+	s       string
+	pos     int // 続きを試行する開始位置（ルーン単位）
+	prevEnd int // 直前のマッチの終了位置（ルーン単位、なければ-1）
+}
+
+// FindAllContext は、FindAllStringSubmatchIndex と同じく s の中の重ならないマッチを最大 n 個（n が負なら全て）探しますが、
+// ctx が取り消されるか期限を過ぎると検索を打ち切り、それまでに見つけたマッチを返します。
+//
+// 打ち切った場合は、ctx.Err() のエラーとともに、Offset が打ち切った位置の結果を返します。
+// 結果の Resume で、同じ文字列の続きから検索を再開できます。
+// ステップ数の上限に達した場合も ErrStepLimitExceeded とともに同じように返しますが、続きから再開しても同じエラーになります。
+// LinearFallback で標準ライブラリに照合を任せる場合は、線形時間で終わるため途中で打ち切りません。
+func (re *Regexp) FindAllContext(ctx context.Context, s string, n int) (*PartialMatches, error) {
+	return re.findAllContext(ctx, s, 0, -1, n)
+}
+
+// Resume は、打ち切られた検索の続きを、新しい ctx で検索します。
+// 返す結果の Matches は、続きで見つけたマッチだけです。n は FindAllContext と同じく、この呼び出しで探すマッチの最大数です。
+// 最後まで検索した結果に対しては、空の結果を返します。
+func (p *PartialMatches) Resume(ctx context.Context, n int) (*PartialMatches, error) {
+	if p.Offset < 0 {
+		return &PartialMatches{Offset: -1, re: p.re, s: p.s}, nil
+	}
+	return p.re.findAllContext(ctx, p.s, p.pos, p.prevEnd, n)
+}
+
+// findAllContext は、開始位置 pos（ルーン単位）から検索し、打ち切った場合は続きの位置を結果に記録します。
+func (re *Regexp) findAllContext(ctx context.Context, s string, pos, prevEnd, n int) (*PartialMatches, error) {
+	p := &PartialMatches{Offset: -1, re: re, s: s}
+	prog := re.prog
+	if prog.linear != nil {
+		if err := ctx.Err(); err != nil {
+			p.Offset = 0
+			return p, err
+		}
+		p.Matches = prog.linear.FindAllStringSubmatchIndex(s, n)
+		return p, nil
+	}
+
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+	m.resetString(s)
+	if ctx.Done() != nil {
+		m.ctx = ctx
+	}
+	_, prevEnd = m.scan(pos, len(m.input)+1, prevEnd, n, func() {
+		p.Matches = append(p.Matches, m.submatchIndex())
+	})
+	if m.err != nil {
+		p.Offset = m.offsets[m.startPos]
+		p.pos, p.prevEnd = m.startPos, prevEnd
+		return p, m.err
+	}
+	return p, nil
+}
@@ -0,0 +1,132 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "sync/atomic"
+
+// CoverageKind は、CoverageItem の種類です。
+type CoverageKind string
+
+const (
+	CoverageBranch   CoverageKind = "branch"   // 選択（|）の分岐
+	CoverageOptional CoverageKind = "optional" // 省略可能なグループ（(...)?、(?:...)*、(...){0,n} など）
+)
+
+// CoverageItem は、パターンの選択の分岐または省略可能なグループと、それを通ったマッチの数です。
+type CoverageItem struct {
+	Kind CoverageKind
+
+	// Pattern は、分岐または省略可能なグループ（量指定子を含む）のパターンです（Format と同じ表記）。
+	Pattern string
+
+	// Alternation と Index は、CoverageBranch の場合の選択全体のパターンと、その中での分岐の番号（0始まり）です。
+	Alternation string
+	Index       int
+
+	// Count は、この分岐や部分を通ったマッチの数です。省略可能なグループでは、1回以上繰り返したマッチの数です。
+	Count uint64
+}
+
+// CoverageReport は、Regexp.Coverage が返す、マッチに使われた分岐と省略可能なグループの記録です。
+type CoverageReport struct {
+	Matches uint64         // 記録したマッチの数
+	Items   []CoverageItem // パターンに現れる順の各分岐と省略可能なグループ
+}
+
+// Uncovered は、一度もマッチに使われなかった分岐と省略可能なグループを返します。
+func (r *CoverageReport) Uncovered() []CoverageItem {
+	var items []CoverageItem
+	for _, item := range r.Items {
+		if item.Count == 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// coverage は、Options.Coverage を指定したプログラムの、分岐や省略可能なグループを通ったマッチの数です。
+// 各項目を通ったかどうかは、マッチの試行中にカウンタのスロットに記録し、バックトラックで取り消します。
+type coverage struct {
+	items   []CoverageItem  // 各項目（Count は使わない）
+	counts  []atomic.Uint64 // 各項目を通ったマッチの数
+	matches atomic.Uint64   // 記録したマッチの数
+}
+
+// newCoverage は、ASTの分岐と省略可能なグループを集めた coverage と、
+// 通ったことを記録するノードからカウンタ番号（項目の番号と同じ）への対応を返します。
+func newCoverage(ast Node) (*coverage, map[Node]int) {
+	cov := &coverage{}
+	counters := make(map[Node]int)
+	add := func(node Node, item CoverageItem) {
+		counters[node] = len(cov.items)
+		cov.items = append(cov.items, item)
+	}
+
+	var walk func(node Node)
+	walk = func(node Node) {
+		switch n := node.(type) {
+		case *AltNode:
+			pattern := nodeString(n)
+			for i, branch := range flattenAlt(n) {
+				add(branch, CoverageItem{Kind: CoverageBranch, Pattern: nodeString(branch), Alternation: pattern, Index: i})
+				walk(branch)
+			}
+		case *RepeatNode:
+			if n.min == 0 && n.max != 0 && isGroup(n.node) {
+				add(n.node, CoverageItem{Kind: CoverageOptional, Pattern: nodeString(n)})
+			}
+			walk(n.node)
+		default:
+			for _, child := range childNodes(node) {
+				walk(child)
+			}
+		}
+	}
+	walk(ast)
+	cov.counts = make([]atomic.Uint64, len(cov.items))
+	return cov, counters
+}
+
+// isGroup は、ノードがキャプチャグループまたは非キャプチャグループかどうかを返します。
+func isGroup(node Node) bool {
+	switch node.(type) {
+	case *CaptureNode, *GroupNode:
+		return true
+	}
+	return false
+}
+
+// record は、マッチャー m のマッチが通った項目を記録します。
+func (c *coverage) record(m *Matcher) {
+	c.matches.Add(1)
+	base := m.prog.counterBase()
+	for i := range c.items {
+		if m.saved[base+i] >= 0 {
+			c.counts[i].Add(1)
+		}
+	}
+}
+
+// Coverage は、Options.Coverage を指定してコンパイルした Regexp について、これまでのマッチに使われた
+// 選択の分岐と省略可能なグループを返します。Coverage を指定していない場合は nil を返します。
+// マッチは、照合で見つけたマッチをすべて数えます（FindAll などでは、見つけたマッチごとに数えます）。
+func (re *Regexp) Coverage() *CoverageReport {
+	c := re.prog.coverage
+	if c == nil {
+		return nil
+	}
+	report := &CoverageReport{Matches: c.matches.Load(), Items: append([]CoverageItem(nil), c.items...)}
+	for i := range report.Items {
+		report.Items[i].Count = c.counts[i].Load()
+	}
+	return report
+}
+
+// ResetCoverage は、Options.Coverage で記録したマッチの数を0に戻します。
+func (re *Regexp) ResetCoverage() {
+	if c := re.prog.coverage; c != nil {
+		c.matches.Store(0)
+		for i := range c.counts {
+			c.counts[i].Store(0)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"reflect"
+	"regexp"
+)
+
+// Discrepancy は、クロスチェックで見つかった、このエンジンと標準ライブラリの結果の違いです。
+type Discrepancy struct {
+	Pattern string // パターン
+	Method  string // 呼び出されたメソッドの名前
+	Input   string // メソッドに渡された入力
+	Got     any    // このエンジンの結果
+	Want    any    // 標準ライブラリの結果
+}
+
+// crossChecker は、操作ごとに標準ライブラリでも同じ操作を実行し、結果を比較します。
+type crossChecker struct {
+	std    *regexp.Regexp    // 同じ意味の標準ライブラリの正規表現
+	report func(Discrepancy) // 結果が異なる場合に呼び出す関数
+}
+
+// newCrossChecker は、パターン expr の AST を標準ライブラリの正規表現に変換してクロスチェックを準備します。
+// 標準ライブラリで表せないパターンでは nil を返し、クロスチェックは行いません。
+func newCrossChecker(expr string, ast Node, opts Options) *crossChecker {
+	// 結果の上限で打ち切った結果は、標準ライブラリの結果と比べられない
+	if opts.CrossCheck == nil || opts.limitsResults() {
+		return nil
+	}
+	std := linearRegexp(ast, opts)
+	if std == nil {
+		return nil
+	}
+	if expr == "" {
+		// Split は式が空かどうかで空文字列の結果を変えるため、比べる正規表現も空の式にする。
+		// Anchored などで AST が空でなくなった場合は、空の式では表せない
+		if std.String() != "(?:)" {
+			return nil
+		}
+		std = regexp.MustCompile("")
+	}
+	return &crossChecker{std: std, report: opts.CrossCheck}
+}
+
+// compare は、このエンジンの結果 *got と、標準ライブラリで同じ操作をした結果 want(std) を比較し、
+// 異なっていれば報告します。メソッドの戻り値が確定した後に呼び出せるよう、defer で使います。
+func (c *crossChecker) compare(method, input string, got any, want func(std *regexp.Regexp) any) {
+	g := reflect.ValueOf(got).Elem().Interface()
+	if w := want(c.std); !reflect.DeepEqual(g, w) {
+		c.report(Discrepancy{Pattern: c.std.String(), Method: method, Input: input, Got: g, Want: w})
+	}
+}
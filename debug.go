@@ -0,0 +1,254 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// instrNames は、各命令の種類の表示名です。
+var instrNames = map[InstrType]string{
+	InstrChar:            "char",
+	InstrAnyChar:         "any",
+	InstrCharClass:       "class",
+	InstrMatch:           "match",
+	InstrJump:            "jump",
+	InstrSplit:           "split",
+	InstrSave:            "save",
+	InstrBackref:         "backref",
+	InstrWordBoundary:    "wordb",
+	InstrNonWordBoundary: "nwordb",
+	InstrBeginLine:       "bol",
+	InstrEndLine:         "eol",
+	InstrBeginText:       "bot",
+	InstrEndText:         "eot",
+	InstrRepeatInit:      "rep-init",
+	InstrRepeatCheck:     "rep-check",
+	InstrTrie:            "trie",
+	InstrRun:             "run",
+	InstrAtomicBegin:     "atomic-begin",
+	InstrAtomicEnd:       "atomic-end",
+	InstrProgressMark:    "prog-mark",
+	InstrProgressCheck:   "prog-check",
+	InstrCoverage:        "cover",
+	InstrNotAfterWord:    "not-after-word",
+	InstrNotBeforeWord:   "not-before-word",
+}
+
+// String は、命令の種類の表示名を返します。
+func (t InstrType) String() string {
+	if name, ok := instrNames[t]; ok {
+		return name
+	}
+	return "InstrType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// String は、命令を、種類と引数、次に実行する命令の位置を並べた1行の文字列で返します。
+func (instr Instr) String() string {
+	var sb strings.Builder
+	sb.WriteString(instr.Op.String())
+	switch instr.Op {
+	case InstrChar:
+		sb.WriteString(" " + strconv.QuoteRune(instr.Char))
+	case InstrCharClass:
+		sb.WriteString(" " + instr.CharClass.String())
+	case InstrSplit:
+		// 先に試す分岐先を先に書く
+		first, second := instr.Next, instr.Arg
+		if !instr.Greedy {
+			first, second = second, first
+		}
+		fmt.Fprintf(&sb, " -> %d, %d", first, second)
+		return sb.String()
+	case InstrSave:
+		fmt.Fprintf(&sb, " slot %d", instr.Arg)
+	case InstrBackref:
+		fmt.Fprintf(&sb, " \\%d", instr.Arg)
+	case InstrBeginLine, InstrEndLine:
+		if instr.Arg != 0 {
+			sb.WriteString(" multiline")
+		}
+	case InstrRepeatInit, InstrAtomicBegin, InstrAtomicEnd, InstrProgressMark, InstrCoverage:
+		fmt.Fprintf(&sb, " counter %d", instr.Counter)
+	case InstrRepeatCheck:
+		fmt.Fprintf(&sb, " counter %d {%d,%d}%s body %d", instr.Counter, instr.Min, instr.Max, greedySuffix(instr), instr.Arg)
+	case InstrProgressCheck:
+		fmt.Fprintf(&sb, " counter %d exit %d", instr.Counter, instr.Arg)
+	case InstrRun:
+		var elem string
+		switch instr.RunOp {
+		case InstrChar:
+			elem = strconv.QuoteRune(instr.Char)
+		case InstrCharClass:
+			elem = instr.CharClass.String()
+		default:
+			elem = instr.RunOp.String()
+		}
+		fmt.Fprintf(&sb, " %s {%d,%d}%s", elem, instr.Min, instr.Max, greedySuffix(instr))
+	case InstrMatch:
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, " -> %d", instr.Next)
+	return sb.String()
+}
+
+// greedySuffix は、繰り返しの命令が非貪欲なら "?"、所有的なら "+"、貪欲なら空文字列を返します。
+func greedySuffix(instr Instr) string {
+	switch {
+	case instr.Possessive:
+		return "+"
+	case !instr.Greedy:
+		return "?"
+	}
+	return ""
+}
+
+// String は、文字クラスをパターンの構文に近い形で返します。
+func (c *charClass) String() string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	if c.negate {
+		sb.WriteString("^")
+	}
+	for _, r := range c.anyOf {
+		writeClassRune(&sb, r)
+	}
+	for _, rng := range c.ranges {
+		writeClassRune(&sb, rng.min)
+		if rng.max != rng.min {
+			sb.WriteString("-")
+			writeClassRune(&sb, rng.max)
+		}
+	}
+	switch c.classType {
+	case ClassDigit:
+		sb.WriteString(`\d`)
+	case ClassWord:
+		sb.WriteString(`\w`)
+	case ClassSpace:
+		sb.WriteString(`\s`)
+	case ClassUnicode:
+		for key := range c.unicode {
+			sb.WriteString(`\p{` + key + "}")
+		}
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// ProgramListing は、コンパイルされた命令列を、1行に1命令ずつ位置を付けて返します。
+// 実行を開始する命令には * を付けます。
+func (re *Regexp) ProgramListing() string {
+	var sb strings.Builder
+	for pc, instr := range re.prog.instrs {
+		mark := " "
+		if pc == re.prog.start {
+			mark = "*"
+		}
+		fmt.Fprintf(&sb, "%s%4d  %s\n", mark, pc, instr)
+	}
+	return sb.String()
+}
+
+// DumpAST は、パターンを解析した構文木（Optimize などで書き換える前のもの）を、入れ子を字下げで表した文字列で返します。
+func (re *Regexp) DumpAST() (string, error) {
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	dumpNode(&sb, ast, 0)
+	return sb.String(), nil
+}
+
+// dumpNode は、ノードとその子を、depth の深さの字下げで sb に書き込みます。
+func dumpNode(sb *strings.Builder, node Node, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	switch n := node.(type) {
+	case *ConcatNode:
+		sb.WriteString("concat\n")
+		for _, child := range n.nodes {
+			dumpNode(sb, child, depth+1)
+		}
+	case *AltNode:
+		sb.WriteString("alt\n")
+		for _, branch := range flattenAlt(n) {
+			dumpNode(sb, branch, depth+1)
+		}
+	case *RepeatNode:
+		fmt.Fprintf(sb, "repeat {%d,%d}", n.min, n.max)
+		switch {
+		case n.possessive:
+			sb.WriteString(" possessive")
+		case n.repeatType == RepeatNonGreedy:
+			sb.WriteString(" non-greedy")
+		}
+		sb.WriteString("\n")
+		dumpNode(sb, n.node, depth+1)
+	case *CaptureNode:
+		fmt.Fprintf(sb, "capture %d", n.index)
+		if n.name != "" {
+			sb.WriteString(" " + n.name)
+		}
+		sb.WriteString("\n")
+		dumpNode(sb, n.node, depth+1)
+	case *GroupNode:
+		sb.WriteString("group\n")
+		if n.node != nil {
+			dumpNode(sb, n.node, depth+1)
+		}
+	case *CharNode:
+		sb.WriteString("char " + nodeString(n) + "\n")
+	case *AnyCharNode, *CharClassNode:
+		sb.WriteString("class " + nodeString(n) + "\n")
+	case *BackrefNode:
+		sb.WriteString("backref " + nodeString(n) + "\n")
+	case *BoundaryNode:
+		sb.WriteString("assert " + nodeString(n) + "\n")
+	default:
+		sb.WriteString("empty\n")
+	}
+}
+
+// TraceStep は、Trace が記録する、マッチングの実行の1ステップです。
+type TraceStep struct {
+	Start int    // 試行しているマッチの開始位置（バイト単位）
+	PC    int    // 実行する命令の位置
+	Instr string // 実行する命令（ProgramListing と同じ表記）
+	Pos   int    // 入力の現在位置（バイト単位）
+	Depth int    // バックトラックスタックの深さ
+
+	// Backtrack は、このステップがバックトラックして再開したものかどうかです。
+	Backtrack bool
+}
+
+// Trace は、文字列 s の最初のマッチを探し、命令を1つ実行するたびに f を呼び出します。
+// 戻り値は FindStringSubmatchIndex と同じです。
+// LinearFallback の設定にかかわらず、常にこのエンジンで照合します。
+func (re *Regexp) Trace(s string, f func(TraceStep)) []int {
+	m := re.prog.getMatcher(true)
+	defer re.prog.putMatcher(m)
+	m.resetString(s)
+	m.trace = func(pc int) { f(m.traceStep(pc)) }
+	defer func() { m.trace = nil }()
+
+	if !m.search(0) {
+		return nil
+	}
+	return m.submatchIndex()
+}
+
+// traceStep は、命令 pc を実行する直前のマッチャーの状態を TraceStep で返します。
+func (m *Matcher) traceStep(pc int) TraceStep {
+	step := TraceStep{
+		Start:     m.offsets[m.startPos],
+		PC:        pc,
+		Instr:     m.prog.instrs[pc].String(),
+		Pos:       m.offsets[m.pos],
+		Depth:     len(m.stack),
+		Backtrack: m.resumed,
+	}
+	m.resumed = false
+	return step
+}
@@ -0,0 +1,121 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// DiagramKind は、DiagramNode の種類です。
+type DiagramKind string
+
+const (
+	DiagramSequence  DiagramKind = "sequence"  // 要素の並び（Children を順に通る）
+	DiagramChoice    DiagramKind = "choice"    // 選択（Children のいずれかを通る。先頭ほど優先）
+	DiagramRepeat    DiagramKind = "repeat"    // 繰り返し（Children[0] を Repeat の回数だけ通る）
+	DiagramCapture   DiagramKind = "capture"   // キャプチャグループ（Children[0] を囲む）
+	DiagramLiteral   DiagramKind = "literal"   // 文字列そのもの（Text）
+	DiagramClass     DiagramKind = "class"     // 1文字にマッチする文字クラスや .（Text はパターンの構文）
+	DiagramBackref   DiagramKind = "backref"   // バックリファレンス（Index のグループ）
+	DiagramAssertion DiagramKind = "assertion" // 文字を消費しない境界や位置の指定（Text はパターンの構文）
+)
+
+// DiagramNode は、パターンの構文木を、鉄道図（railroad diagram）の描画に向けて表したものです。
+// encoding/json でそのまま JSON に変換でき、Web の UI などでこのエンジンが解釈したとおりのパターンの構造を表示できます。
+//
+// 非キャプチャグループやフラグのグループは取り除き、連続する文字は1つの DiagramLiteral にまとめます。
+type DiagramNode struct {
+	Kind DiagramKind `json:"kind"`
+
+	// Text は、DiagramLiteral では文字列、DiagramClass と DiagramAssertion ではパターンの構文です。
+	Text string `json:"text,omitempty"`
+
+	// CaseInsensitive は、DiagramLiteral の文字列を大小文字を区別せずに照合するかどうかです。
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+
+	// Index と Name は、DiagramCapture と DiagramBackref のグループの番号と名前です。
+	Index int    `json:"index,omitempty"`
+	Name  string `json:"name,omitempty"`
+
+	// Repeat は、DiagramRepeat の繰り返し回数です。
+	Repeat *DiagramRepeatRange `json:"repeat,omitempty"`
+
+	Children []*DiagramNode `json:"children,omitempty"`
+}
+
+// DiagramRepeatRange は、DiagramRepeat の繰り返し回数と種類です。
+type DiagramRepeatRange struct {
+	Min        int  `json:"min"`
+	Max        int  `json:"max"` // -1は上限なし
+	NonGreedy  bool `json:"nonGreedy,omitempty"`
+	Possessive bool `json:"possessive,omitempty"`
+}
+
+// Diagram は、パターンの構文木を、鉄道図の描画に向けた DiagramNode として返します。
+func (re *Regexp) Diagram() (*DiagramNode, error) {
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return nil, err
+	}
+	return diagramOf(ast), nil
+}
+
+// diagramOf は、ASTのノードを DiagramNode に変換します。
+func diagramOf(node Node) *DiagramNode {
+	switch n := node.(type) {
+	case *CharNode:
+		return &DiagramNode{Kind: DiagramLiteral, Text: string(n.r), CaseInsensitive: n.fold}
+
+	case *ConcatNode:
+		d := &DiagramNode{Kind: DiagramSequence}
+		for _, child := range n.nodes {
+			c := diagramOf(child)
+			// 連続する文字は1つの文字列にまとめる
+			if len(d.Children) > 0 && c.Kind == DiagramLiteral {
+				last := d.Children[len(d.Children)-1]
+				if last.Kind == DiagramLiteral && last.CaseInsensitive == c.CaseInsensitive {
+					last.Text += c.Text
+					continue
+				}
+			}
+			d.Children = append(d.Children, c)
+		}
+		if len(d.Children) == 1 {
+			return d.Children[0]
+		}
+		return d
+
+	case *AltNode:
+		d := &DiagramNode{Kind: DiagramChoice}
+		for _, branch := range flattenAlt(n) {
+			d.Children = append(d.Children, diagramOf(branch))
+		}
+		return d
+
+	case *RepeatNode:
+		return &DiagramNode{
+			Kind: DiagramRepeat,
+			Repeat: &DiagramRepeatRange{
+				Min:        n.min,
+				Max:        n.max,
+				NonGreedy:  n.repeatType == RepeatNonGreedy,
+				Possessive: n.possessive,
+			},
+			Children: []*DiagramNode{diagramOf(n.node)},
+		}
+
+	case *CaptureNode:
+		return &DiagramNode{Kind: DiagramCapture, Index: n.index, Name: n.name, Children: []*DiagramNode{diagramOf(n.node)}}
+
+	case *GroupNode:
+		return diagramOf(n.node)
+
+	case *BackrefNode:
+		return &DiagramNode{Kind: DiagramBackref, Index: n.index, Name: n.name}
+
+	case *AnyCharNode, *CharClassNode:
+		return &DiagramNode{Kind: DiagramClass, Text: nodeString(n)}
+
+	case *BoundaryNode:
+		return &DiagramNode{Kind: DiagramAssertion, Text: nodeString(n)}
+
+	default:
+		// 空のパターン
+		return &DiagramNode{Kind: DiagramSequence}
+	}
+}
@@ -0,0 +1,208 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Dialect は、パターンの構文の方言を表します。
+type Dialect int
+
+const (
+	// DialectDefault は、Go の regexp と Perl に準じたこのパッケージの構文です。
+	DialectDefault Dialect = iota
+
+	// DialectJavaScript は、ECMAScript（JavaScript）の正規表現の構文と意味論です。
+	// \uXXXX・\u{...}・\xXX・\cX のエスケープ、(?<name>...) の名前付きグループ、
+	// Annex B の寛容な構文（量指定子にならない { や }、存在しないグループへの \N を8進エスケープとみなすなど）を受け付けます。
+	// 所有的量指定子は使えず、マッチしていないグループへのバックリファレンスは空文字列にマッチします。
+	// . と、マルチラインモードの ^ と $ は、\n に加えて \r、U+2028、U+2029 も行の区切りとみなします。
+	DialectJavaScript
+
+	// DialectVim は、Vim の検索パターンの構文です。
+	// \v、\m、\M、\V による「魔法」の度合いの切り替え、\zs と \ze によるマッチの範囲の指定、
+	// \%[...] による省略可能な並び、\{-n,m} の非貪欲な繰り返し、\c と \C による大小文字の区別の指定などを受け付けます。
+	// Vim と同様に、^ と $ は行頭と行末を表し、マッチしていないグループへのバックリファレンスは空文字列にマッチします。
+	// 先読み・後読み（\@）、\&、~ など、エディタの状態に依存する要素や対応する機能のない要素はエラーになります。
+	DialectVim
+
+	// DialectRE2 は、RE2（Go の regexp）の構文です。Translate の変換先として使います。
+	// パターンの構文として指定した場合は DialectDefault と同じに扱います。
+	DialectRE2
+
+	// DialectPCRE は、PCRE の構文です。Translate の変換先として使います。
+	// パターンの構文として指定した場合は DialectDefault と同じに扱います。
+	DialectPCRE
+)
+
+// isLineTerminator は、JavaScript で行の区切りとみなす文字かどうかを返します。
+func isLineTerminator(r rune) bool {
+	return r == '\n' || r == '\r' || r == '\u2028' || r == '\u2029'
+}
+
+// jsLineTerminators は、JavaScript の行の区切り文字の範囲です。
+var jsLineTerminators = []runeRange{{'\n', '\n'}, {'\r', '\r'}, {'\u2028', '\u2029'}}
+
+// quantifierAhead は、現在の位置から {n}、{n,}、{n,m} のいずれかの量指定子が始まるかどうかを返します。
+func (p *Parser) quantifierAhead() bool {
+	return isQuantifierAt(p.input, p.pos)
+}
+
+// isQuantifierAt は、expr の位置 i から {n}、{n,}、{n,m} のいずれかの量指定子が始まるかどうかを返します。
+func isQuantifierAt(expr string, i int) bool {
+	rest := expr[i:]
+	if len(rest) < 3 || rest[0] != '{' || !isDigit(rune(rest[1])) {
+		return false
+	}
+	j := 1
+	for j < len(rest) && isDigit(rune(rest[j])) {
+		j++
+	}
+	if j < len(rest) && rest[j] == ',' {
+		j++
+		for j < len(rest) && isDigit(rune(rest[j])) {
+			j++
+		}
+	}
+	return j < len(rest) && rest[j] == '}'
+}
+
+// parseJSEscape は、JavaScript の方言に固有のエスケープシーケンス（'\\' と r は読み取り済み）を解析します。
+// 固有のエスケープでなければ ok に false を返します。
+func (p *Parser) parseJSEscape(r rune) (node Node, ok bool, err error) {
+	switch r {
+	case 'u', 'x', 'c', '0':
+		c, err := p.parseJSCharEscape(r)
+		if err != nil {
+			return nil, true, err
+		}
+		return &CharNode{r: c, fold: p.flags.caseInsensitive}, true, nil
+
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		// 続く数字も含めてグループ番号とみなせればバックリファレンス
+		start := p.pos - 1
+		end := p.pos
+		for end < len(p.input) && isDigit(rune(p.input[end])) {
+			end++
+		}
+		for ; end > start; end-- {
+			if n, _ := strconv.Atoi(p.input[start:end]); n <= p.totalCaptures {
+				p.pos = end
+				ref := &BackrefNode{index: n}
+				p.backrefs = append(p.backrefs, ref)
+				return ref, true, nil
+			}
+		}
+
+		// Annex B: 存在しないグループへの参照は8進エスケープ（\8 と \9 は数字そのもの）
+		if r >= '8' {
+			return &CharNode{r: r}, true, nil
+		}
+		value := r - '0'
+		for i := 0; i < 2 && p.peek() >= '0' && p.peek() <= '7' && value*8+(p.peek()-'0') <= 0377; i++ {
+			value = value*8 + (p.next() - '0')
+		}
+		return &CharNode{r: value, fold: p.flags.caseInsensitive}, true, nil
+
+	case 'A', 'z':
+		// JavaScript には \A と \z がなく、文字そのものを表す
+		return &CharNode{r: r, fold: p.flags.caseInsensitive}, true, nil
+	}
+	return nil, false, nil
+}
+
+// parseJSCharEscape は、1文字を表す JavaScript のエスケープシーケンス（\u, \x, \c, \0）の値を返します。
+// Annex B に従い、形式が正しくない場合は文字そのもの（\c の場合は '\\'）として扱います。
+func (p *Parser) parseJSCharEscape(r rune) (rune, error) {
+	switch r {
+	case 'u':
+		if p.peek() == '{' {
+			// \u{...}
+			end := p.pos + 1
+			for end < len(p.input) && isHexDigit(p.input[end]) {
+				end++
+			}
+			if end > p.pos+1 && end < len(p.input) && p.input[end] == '}' {
+				v, err := strconv.ParseUint(p.input[p.pos+1:end], 16, 32)
+				if err != nil || v > utf8.MaxRune {
+					return 0, fmt.Errorf("無効なコードポイント: \\u%s", p.input[p.pos:end+1])
+				}
+				p.pos = end + 1
+				return rune(v), nil
+			}
+			return r, nil
+		}
+		if v, ok := p.hexDigits(4); ok {
+			return v, nil
+		}
+		return r, nil
+
+	case 'x':
+		if v, ok := p.hexDigits(2); ok {
+			return v, nil
+		}
+		return r, nil
+
+	case 'c':
+		c := p.peek()
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+			p.next()
+			return c % 32, nil
+		}
+		// \c の後に英字がなければ、'\' そのもの（c は次の文字として読む）
+		p.pos--
+		return '\\', nil
+
+	default:
+		// \0（後に数字が続く場合は8進エスケープとみなす）
+		value := rune(0)
+		for i := 0; i < 2 && p.peek() >= '0' && p.peek() <= '7'; i++ {
+			value = value*8 + (p.next() - '0')
+		}
+		return value, nil
+	}
+}
+
+// hexDigits は、現在の位置から n 桁の16進数を読み取ります。n 桁そろっていなければ位置を進めずに false を返します。
+func (p *Parser) hexDigits(n int) (rune, bool) {
+	if p.pos+n > len(p.input) {
+		return 0, false
+	}
+	for i := 0; i < n; i++ {
+		if !isHexDigit(p.input[p.pos+i]) {
+			return 0, false
+		}
+	}
+	v, _ := strconv.ParseUint(p.input[p.pos:p.pos+n], 16, 32)
+	p.pos += n
+	return rune(v), true
+}
+
+// isHexDigit は、c が16進数の数字かどうかを返します。
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// countCaptures は、パターン中のキャプチャグループの総数を数えます。
+// JavaScript では、\N がバックリファレンスか8進エスケープかをパターン全体のグループ数で決めるため、解析の前に数えます。
+func countCaptures(expr string) int {
+	count := 0
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			i++
+		case '[':
+			i = skipCharClass(expr, i) - 1
+		case '(':
+			rest := expr[i+1:]
+			if len(rest) == 0 || rest[0] != '?' ||
+				(len(rest) > 2 && rest[1] == '<' && rest[2] != '=' && rest[2] != '!') ||
+				(len(rest) > 2 && rest[1] == 'P' && rest[2] == '<') {
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,107 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Position は、Document の中の位置です。
+type Position struct {
+	Offset int // テキストの先頭からのバイト位置
+	Line   int // 行番号（1始まり）
+	Column int // 桁（1始まり、行頭からの文字数に1を足したもの）
+}
+
+// Range は、Document の中の範囲です。
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// DocumentMatch は、Document.FindAll が返すマッチです。
+type DocumentMatch struct {
+	Range          // マッチ全体の範囲
+	Text   string  // マッチした文字列
+	Groups []Range // 各グループの範囲（Groups[0] はマッチ全体。マッチしなかったグループは Offset が-1）
+}
+
+// Document は、大きなテキストの各行の開始位置をあらかじめ索引しておき、
+// 複数のパターンによる検索の結果を行と桁で返せるようにしたものです。
+// バイト位置から行への変換は索引の二分探索で行うため、検索のたびにテキストを先頭から数え直す必要がありません。
+// 行は '\n' で区切ります。Document は作成後に変更しないため、複数のゴルーチンから同時に使えます。
+type Document struct {
+	text       string
+	lineStarts []int // 各行の先頭のバイト位置
+}
+
+// NewDocument は、テキストの各行の開始位置を索引した Document を返します。
+func NewDocument(text string) *Document {
+	lineStarts := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &Document{text: text, lineStarts: lineStarts}
+}
+
+// Text は、Document のテキストを返します。
+func (d *Document) Text() string {
+	return d.text
+}
+
+// LineCount は、行数を返します。テキストが '\n' で終わる場合は、その後の空の行も数えます。
+func (d *Document) LineCount() int {
+	return len(d.lineStarts)
+}
+
+// Line は、行番号 n（1始まり）の行を、末尾の '\n' を除いて返します。範囲外の場合は空文字列を返します。
+func (d *Document) Line(n int) string {
+	if n < 1 || n > len(d.lineStarts) {
+		return ""
+	}
+	start := d.lineStarts[n-1]
+	end := len(d.text)
+	if n < len(d.lineStarts) {
+		end = d.lineStarts[n] - 1
+	}
+	return d.text[start:end]
+}
+
+// Position は、バイト位置 offset の行と桁を返します。
+// offset は0以上テキストの長さ以下でなければなりません。
+func (d *Document) Position(offset int) Position {
+	// offset 以下で最も後ろの行の先頭
+	line := sort.SearchInts(d.lineStarts, offset+1)
+	start := d.lineStarts[line-1]
+	return Position{
+		Offset: offset,
+		Line:   line,
+		Column: utf8.RuneCountInString(d.text[start:offset]) + 1,
+	}
+}
+
+// FindAll は、テキストの中の重ならないマッチを最大 n 個（n が負なら全て）、行と桁を付けて返します。
+func (d *Document) FindAll(re *Regexp, n int) []DocumentMatch {
+	var result []DocumentMatch
+	for _, loc := range re.FindAllStringSubmatchIndex(d.text, n) {
+		m := DocumentMatch{Text: d.text[loc[0]:loc[1]], Groups: make([]Range, len(loc)/2)}
+		for i := range m.Groups {
+			if loc[2*i] < 0 {
+				m.Groups[i] = Range{Start: Position{Offset: -1}, End: Position{Offset: -1}}
+				continue
+			}
+			m.Groups[i] = Range{Start: d.Position(loc[2*i]), End: d.Position(loc[2*i+1])}
+		}
+		m.Range = m.Groups[0]
+		result = append(result, m)
+	}
+	return result
+}
+
+// String は、位置を "行:桁" の形式で返します。
+func (p Position) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
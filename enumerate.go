@@ -0,0 +1,336 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"iter"
+	"sort"
+	"unicode"
+)
+
+// maxEnumeratedClass は、Enumerate で列挙できる文字クラスの文字数の上限です。
+// . や否定の文字クラスのように、これを超える文字にマッチする要素を含むパターンは列挙できません。
+const maxEnumeratedClass = 1024
+
+// Enumerate は、パターンが文字列全体としてマッチする、長さ maxLen 文字（ルーン数）以下の文字列をすべて列挙します。
+// 文字列は短いものから順に、同じ長さの中では辞書順に、重複なく返します。
+//
+// 各文字列は、パターンを \A(?:...)\z で囲んだ場合と同様に、文字列全体にマッチするものだけを返します。
+// 上限のない繰り返しも、maxLen を超えない範囲で列挙します。
+// 所有的量指定子、\zs、\ze を含むパターンや、maxEnumeratedClass を超える文字にマッチする要素（. や [^a] など）を
+// 含むパターンはエラーを返します。
+func (re *Regexp) Enumerate(maxLen int) (iter.Seq[string], error) {
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEnumerable(ast); err != nil {
+		return nil, err
+	}
+
+	return func(yield func(string) bool) {
+		for length := 0; length <= maxLen; length++ {
+			e := &enumerator{
+				limit:      length,
+				unsetEmpty: re.opts.UnsetBackrefMatchesEmpty,
+			}
+			e.reset(re.numSubexp)
+			e.walk(ast, e.accept)
+
+			found := make([]string, 0, len(e.found))
+			for s := range e.found {
+				found = append(found, s)
+			}
+			sort.Strings(found)
+			for _, s := range found {
+				if re.opts.Latin1 {
+					s = latin1String(s)
+				}
+				if !yield(s) {
+					return
+				}
+			}
+		}
+	}, nil
+}
+
+// checkEnumerable は、ノードが Enumerate で列挙できる要素だけからなるかどうかを確認します。
+func checkEnumerable(node Node) error {
+	switch n := node.(type) {
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if err := checkEnumerable(child); err != nil {
+				return err
+			}
+		}
+	case *AltNode:
+		if err := checkEnumerable(n.left); err != nil {
+			return err
+		}
+		return checkEnumerable(n.right)
+	case *RepeatNode:
+		if n.possessive {
+			return fmt.Errorf("列挙できません: 所有的量指定子")
+		}
+		return checkEnumerable(n.node)
+	case *CaptureNode:
+		return checkEnumerable(n.node)
+	case *GroupNode:
+		return checkEnumerable(n.node)
+	case *AnyCharNode, *CharClassNode:
+		ranges, err := enumeratedRanges(n)
+		if err != nil {
+			return err
+		}
+		size := 0
+		for _, rng := range ranges {
+			size += int(rng.max-rng.min) + 1
+		}
+		if size > maxEnumeratedClass {
+			return fmt.Errorf("列挙できません: %d 文字を超える文字にマッチする要素", maxEnumeratedClass)
+		}
+	case *BoundaryNode:
+		if n.nodeType == NodeMatchStart || n.nodeType == NodeMatchEnd {
+			return fmt.Errorf("列挙できません: \\zs と \\ze")
+		}
+	}
+	return nil
+}
+
+// enumeratedRanges は、. または文字クラスのノードがマッチする文字範囲を、サロゲートを除いて返します。
+func enumeratedRanges(node Node) ([]runeRange, error) {
+	var ranges []runeRange
+	switch n := node.(type) {
+	case *AnyCharNode:
+		ranges = []runeRange{{0, '\t'}, {'\v', '\f'}, {'\r' + 1, unicode.MaxRune}}
+		if n.dotMatchesNewline {
+			ranges = []runeRange{{0, unicode.MaxRune}}
+		}
+	case *CharClassNode:
+		var err error
+		if ranges, err = classRanges(n); err != nil {
+			return nil, err
+		}
+		if n.negate {
+			ranges = complementRanges(ranges)
+		}
+	}
+	return removeRange(ranges, runeRange{0xd800, 0xdfff}), nil
+}
+
+// enumeratedAssertion は、列挙中の文字列の位置 pos で確認する境界条件です。
+type enumeratedAssertion struct {
+	node *BoundaryNode
+	pos  int
+}
+
+// enumerator は、構文木をたどって、ちょうど limit 文字の文字列を列挙します。
+// 走査は継続（k）を渡す形で行い、選択や繰り返しのすべての選び方を試します。
+type enumerator struct {
+	limit      int
+	unsetEmpty bool // まだマッチしていないグループへのバックリファレンスが空文字列にマッチするかどうか
+
+	// alphabet は、. や文字クラスから生成する文字の候補です。nil の場合は、マッチするすべての文字を生成します。
+	alphabet []rune
+
+	buf        []rune                // 生成中の文字列
+	captures   [][2]int              // キャプチャグループの位置（未マッチは -1）
+	assertions []enumeratedAssertion // 文字列の完成後に確認する境界条件
+
+	classes map[Node][]runeRange // . や文字クラスがマッチする文字範囲（計算済みのもの）
+	found   map[string]bool
+}
+
+// reset は、numSubexp 個のキャプチャグループを持つパターンを列挙できるよう、状態を初期化します。
+func (e *enumerator) reset(numSubexp int) {
+	e.buf = e.buf[:0]
+	e.captures = make([][2]int, numSubexp+1)
+	for i := range e.captures {
+		e.captures[i] = [2]int{-1, -1}
+	}
+	e.assertions = nil
+	e.classes = make(map[Node][]runeRange)
+	e.found = make(map[string]bool)
+}
+
+// accept は、パターン全体をたどり終えたときに、条件を満たす文字列を記録します。
+func (e *enumerator) accept() {
+	if len(e.buf) != e.limit {
+		return
+	}
+	for _, a := range e.assertions {
+		if !e.holds(a) {
+			return
+		}
+	}
+	e.found[string(e.buf)] = true
+}
+
+// holds は、完成した文字列で境界条件が成り立つかどうかを、Matcher と同じ規則で判定します。
+func (e *enumerator) holds(a enumeratedAssertion) bool {
+	arg := boolToInt(a.node.multiline)
+	if a.node.multiline && a.node.lineTerminators {
+		arg = 2
+	}
+	switch a.node.nodeType {
+	case NodeWordBoundary:
+		return isAtWordBoundary(e.buf, a.pos)
+	case NodeNonWordBoundary:
+		return !isAtWordBoundary(e.buf, a.pos)
+	case NodeNotAfterWord:
+		return a.pos == 0 || !isWordChar(e.buf[a.pos-1])
+	case NodeNotBeforeWord:
+		return a.pos == len(e.buf) || !isWordChar(e.buf[a.pos])
+	case NodeBeginLine:
+		return a.pos == 0 || (arg != 0 && isLineBreak(e.buf[a.pos-1], arg))
+	case NodeEndLine:
+		return a.pos == len(e.buf) || (arg != 0 && isLineBreak(e.buf[a.pos], arg))
+	case NodeBeginText:
+		return a.pos == 0
+	case NodeEndText:
+		return a.pos == len(e.buf)
+	case NodeMatchStart, NodeMatchEnd:
+		// マッチの範囲を変えるだけで、文字列全体がマッチするかどうかには影響しない
+		return true
+	}
+	return false
+}
+
+// walk は、ノードがマッチし得る文字列を生成中の文字列に加えるたびに、継続 k を呼び出します。
+func (e *enumerator) walk(node Node, k func()) {
+	switch n := node.(type) {
+	case *CharNode:
+		runes := []rune{n.r}
+		if n.fold {
+			runes = foldOrbit(n.r)
+		}
+		for _, r := range runes {
+			e.emit(r, k)
+		}
+
+	case *ConcatNode:
+		e.walkConcat(n.nodes, k)
+
+	case *AltNode:
+		for _, branch := range flattenAlt(n) {
+			e.walk(branch, k)
+		}
+
+	case *RepeatNode:
+		e.walkRepeat(n, 0, k)
+
+	case *CaptureNode:
+		start := len(e.buf)
+		e.walk(n.node, func() {
+			saved := e.captures[n.index]
+			e.captures[n.index] = [2]int{start, len(e.buf)}
+			k()
+			e.captures[n.index] = saved
+		})
+
+	case *GroupNode:
+		e.walk(n.node, k)
+
+	case *BackrefNode:
+		capture := e.captures[n.index]
+		if capture[0] < 0 {
+			if e.unsetEmpty {
+				k()
+			}
+			return
+		}
+		if len(e.buf)+capture[1]-capture[0] > e.limit {
+			return
+		}
+		before := len(e.buf)
+		e.buf = append(e.buf, e.buf[capture[0]:capture[1]]...)
+		k()
+		e.buf = e.buf[:before]
+
+	case *AnyCharNode, *CharClassNode:
+		ranges, ok := e.classes[n]
+		if !ok {
+			ranges, _ = enumeratedRanges(n)
+			e.classes[n] = ranges
+		}
+		if e.alphabet != nil {
+			for _, r := range e.alphabet {
+				if inRanges(ranges, r) {
+					e.emit(r, k)
+				}
+			}
+			return
+		}
+		for _, rng := range ranges {
+			for r := rng.min; r <= rng.max && len(e.buf) < e.limit; r++ {
+				e.emit(r, k)
+			}
+		}
+
+	case *BoundaryNode:
+		e.assertions = append(e.assertions, enumeratedAssertion{n, len(e.buf)})
+		k()
+		e.assertions = e.assertions[:len(e.assertions)-1]
+
+	case nil:
+		k()
+	}
+}
+
+// walkConcat は、連接の各要素を順にたどります。
+func (e *enumerator) walkConcat(nodes []Node, k func()) {
+	if len(nodes) == 0 {
+		k()
+		return
+	}
+	e.walk(nodes[0], func() {
+		e.walkConcat(nodes[1:], k)
+	})
+}
+
+// walkRepeat は、繰り返しの count 回目以降をたどります。
+// 最小回数を超える繰り返しは、空文字列にマッチするものを除きます（同じ文字列を無限に生成しないため）。
+func (e *enumerator) walkRepeat(n *RepeatNode, count int, k func()) {
+	if count >= n.min {
+		k()
+	}
+	if n.max != -1 && count >= n.max {
+		return
+	}
+	// 残りの最小回数の繰り返しが収まらなければ打ち切る
+	if bodyMin, _ := nodeLength(n.node); count < n.min && len(e.buf)+(n.min-count)*bodyMin > e.limit {
+		return
+	}
+	start := len(e.buf)
+	e.walk(n.node, func() {
+		if count >= n.min && len(e.buf) == start {
+			return
+		}
+		e.walkRepeat(n, count+1, k)
+	})
+}
+
+// emit は、文字 r を生成中の文字列に加えて継続 k を呼び出します。文字列が limit 文字に達している場合は何もしません。
+func (e *enumerator) emit(r rune, k func()) {
+	if len(e.buf) >= e.limit {
+		return
+	}
+	e.buf = append(e.buf, r)
+	k()
+	e.buf = e.buf[:len(e.buf)-1]
+}
+
+// inRanges は、文字 r が整列済みの文字範囲のリストに含まれるかどうかを返します。
+func inRanges(ranges []runeRange, r rune) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].max >= r })
+	return i < len(ranges) && ranges[i].min <= r
+}
+
+// latin1String は、U+0000 から U+00FF の文字からなる文字列を、各文字を1バイトとするバイト列の文字列に変換します。
+func latin1String(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
@@ -0,0 +1,76 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// Equal は、2つの Regexp のコンパイル結果が同じかどうかを返します。
+// パターンの文字列ではなく、コンパイルした命令列と、照合の結果に影響する設定（Latin1、Normalization、
+// UnsetBackrefMatchesEmpty、MaxMatches、MaxCaptureBytes、MaxStreamHistory）と、グループの数と名前を比べます。
+// そのため、(?:a)b と ab、[abc] と [a-c] のように書き方だけが異なるパターンは等しくなります。
+//
+// 照合の結果に影響しない設定（LinearFallback、Profile、Coverage、SlowMatch、Allocator など）は比べません。
+// 等しくないと判定したパターンも、同じ文字列にマッチすることはあります（すべての文字列で同じかは Equivalent で調べます）。
+func Equal(a, b *Regexp) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	return a.programKey() == b.programKey()
+}
+
+// Hash は、Equal で等しい Regexp どうしで同じになるハッシュ値を返します。キャッシュや重複の検出のキーに使います。
+// 値は同じバージョンのこのパッケージであれば、プロセスや実行環境をまたいでも同じです。
+func (re *Regexp) Hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(re.programKey()))
+	return h.Sum64()
+}
+
+// programKey は、Equal で比べるコンパイル結果と設定を、一意に書き表した文字列を返します。
+func (re *Regexp) programKey() string {
+	p := re.prog
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d %q %v %v %v %d %d %d %d %d\n", p.numCaptures, p.subexpNames, p.latin1, p.normalization,
+		p.unsetBackrefMatchesEmpty, p.maxMatches, p.maxCaptureBytes, p.maxStreamHistory, p.editSlot, p.start)
+	for _, instr := range p.instrs {
+		fmt.Fprintf(&sb, "%d %d %d %d %d %v %v %d %d %d %d", instr.Op, instr.Next, instr.Arg, instr.SaveType,
+			instr.Char, instr.Greedy, instr.Possessive, instr.Counter, instr.Min, instr.Max, instr.RunOp)
+		if c := instr.CharClass; c != nil {
+			sb.WriteString(" class ")
+			c.writeKey(&sb)
+		}
+		if t := instr.Trie; t != nil {
+			sb.WriteString(" trie ")
+			t.root.writeKey(&sb)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// writeKey は、文字クラスの内容を、同じ文字の集合なら書き方によらず同じになるように書き出します。
+// 個別の文字と範囲は、重なりや隣接をまとめた範囲の並びにします。
+func (c *charClass) writeKey(sb *strings.Builder) {
+	ranges := slices.Clone(c.ranges)
+	for _, r := range c.anyOf {
+		ranges = append(ranges, runeRange{min: r, max: r})
+	}
+	slices.SortFunc(ranges, func(a, b runeRange) int { return int(a.min - b.min) })
+	var merged []runeRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.min <= merged[n-1].max+1 {
+			merged[n-1].max = max(merged[n-1].max, r.max)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	// マップはキーの順に書式化される
+	fmt.Fprint(sb, merged, c.classType, c.negate, c.unicode)
+}
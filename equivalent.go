@@ -0,0 +1,155 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"sort"
+	"unicode"
+)
+
+// Equivalent は、2つのパターンが、長さ maxLen 文字（ルーン数）以下のすべての文字列について、
+// 文字列全体として同じようにマッチするかどうかを確認します。
+// 異なる場合は false と、一方だけが文字列全体にマッチする文字列（反例）を返します。反例は、最も短いもののうち辞書順で最初のものです。
+//
+// 確認は、各パターンがマッチし得る文字列を列挙し、両方のパターンで照合して行います。
+// 列挙する文字は、2つのパターンの文字や文字クラスが区別する文字の範囲ごとに、代表となる2文字に絞ります。
+// そのため、同じ範囲の3種類以上の文字の異同をバックリファレンスで区別するパターンでは、違いを見落とすことがあります。
+// 列挙する文字列の数は maxLen に対して指数的に増えるため、maxLen は小さな値にしてください。
+func Equivalent(a, b *Regexp, maxLen int) (bool, string) {
+	astA, errA := parse(a.expr, a.opts)
+	astB, errB := parse(b.expr, b.opts)
+	wholeA, errWholeA := wholeMatchProgram(a)
+	wholeB, errWholeB := wholeMatchProgram(b)
+	if errA != nil || errB != nil || errWholeA != nil || errWholeB != nil {
+		// コンパイル済みのパターンは、同じ設定で必ず解析とコンパイルができる
+		return false, ""
+	}
+
+	alphabet := representativeRunes(astA, astB)
+	for length := 0; length <= maxLen; length++ {
+		candidates := make(map[string]bool)
+		for _, p := range []struct {
+			re  *Regexp
+			ast Node
+		}{{a, astA}, {b, astB}} {
+			e := &enumerator{limit: length, unsetEmpty: p.re.opts.UnsetBackrefMatchesEmpty, alphabet: alphabet}
+			e.reset(p.re.numSubexp)
+			e.walk(p.ast, e.accept)
+			for s := range e.found {
+				candidates[s] = true
+			}
+		}
+
+		sorted := make([]string, 0, len(candidates))
+		for s := range candidates {
+			sorted = append(sorted, s)
+		}
+		sort.Strings(sorted)
+		for _, s := range sorted {
+			inA := matchString(wholeA, inputFor(s, a.opts))
+			inB := matchString(wholeB, inputFor(s, b.opts))
+			if inA != inB {
+				return false, s
+			}
+		}
+	}
+	return true, ""
+}
+
+// wholeMatchProgram は、パターンを \A(?:...)\z で囲み、文字列全体にマッチする場合だけマッチするプログラムにコンパイルします。
+func wholeMatchProgram(re *Regexp) (*program, error) {
+	// コンパイルはASTを書き換えるため、列挙に使うASTとは別に解析する
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return nil, err
+	}
+	opts := re.opts
+	opts.MaxProgramSize = -1
+	whole := &ConcatNode{nodes: []Node{
+		&BoundaryNode{nodeType: NodeBeginText},
+		&GroupNode{node: ast},
+		&BoundaryNode{nodeType: NodeEndText},
+	}}
+	return compileProgram(whole, opts)
+}
+
+// inputFor は、列挙した文字列を、設定に従って照合する入力に変換します。
+func inputFor(s string, opts Options) string {
+	if opts.Latin1 {
+		return latin1String(s)
+	}
+	return s
+}
+
+// representativeRunes は、パターンの文字や文字クラスが区別する文字の範囲ごとに、代表となる文字を返します。
+// 同じ範囲の文字は、どちらのパターンの文字、文字クラス、単語境界、行の区切りにとっても区別がつきません。
+// バックリファレンスで文字の異同を区別できるよう、各範囲から（範囲に2文字以上あれば）2文字を選びます。
+func representativeRunes(asts ...Node) []rune {
+	bounds := map[rune]bool{0: true, 0xd800: true, 0xe000: true}
+	addRange := func(rng runeRange) {
+		bounds[rng.min] = true
+		bounds[rng.max+1] = true
+	}
+	// 単語境界と行の区切りで区別される文字
+	for _, rng := range []runeRange{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}, {'\n', '\n'}, {'\r', '\r'}, {0x2028, 0x2029}} {
+		addRange(rng)
+	}
+
+	var walk func(node Node)
+	walk = func(node Node) {
+		switch n := node.(type) {
+		case *CharNode:
+			runes := []rune{n.r}
+			if n.fold {
+				runes = foldOrbit(n.r)
+			}
+			for _, r := range runes {
+				addRange(runeRange{r, r})
+			}
+		case *AnyCharNode, *CharClassNode:
+			ranges, _ := enumeratedRanges(n)
+			for _, rng := range ranges {
+				addRange(rng)
+			}
+		case *ConcatNode:
+			for _, child := range n.nodes {
+				walk(child)
+			}
+		case *AltNode:
+			walk(n.left)
+			walk(n.right)
+		case *RepeatNode:
+			walk(n.node)
+		case *CaptureNode:
+			walk(n.node)
+		case *GroupNode:
+			walk(n.node)
+		}
+	}
+	for _, ast := range asts {
+		walk(ast)
+	}
+
+	sorted := make([]rune, 0, len(bounds))
+	for r := range bounds {
+		if r <= unicode.MaxRune {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var alphabet []rune
+	for i, lo := range sorted {
+		hi := rune(unicode.MaxRune)
+		if i+1 < len(sorted) {
+			hi = sorted[i+1] - 1
+		}
+		if 0xd800 <= lo && lo <= 0xdfff {
+			continue
+		}
+		alphabet = append(alphabet, lo)
+		if lo < hi {
+			alphabet = append(alphabet, lo+1)
+		}
+	}
+	return alphabet
+}
@@ -0,0 +1,147 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"sort"
+)
+
+// minFold と maxFold は、大小文字の同一視の対象となる文字の範囲です。
+// この範囲外の文字は、大小文字を区別しなくても自分自身にしかマッチしません。
+const (
+	minFold = 0x0041
+	maxFold = 0x1e943
+)
+
+// foldOrbit は、大小文字を区別しない場合に r と同一視される文字を、r 自身を含めてすべて返します。
+// 例えば 'k' に対しては 'k', 'K' とケルビン記号（U+212A）を返します。
+func foldOrbit(r rune) []rune {
+	orbit := []rune{r}
+	for f := simpleFold(r); f != r; f = simpleFold(f) {
+		orbit = append(orbit, f)
+	}
+	return orbit
+}
+
+// foldRanges は、文字範囲のリストを、大小文字を区別せずにマッチする範囲のリストに展開します。
+func foldRanges(ranges []runeRange) []runeRange {
+	var folded []runeRange
+	for _, rng := range ranges {
+		folded = append(folded, rng)
+
+		// 同一視の対象範囲をすべて含む範囲は、展開しても変わらない
+		lo, hi := rng.min, rng.max
+		if lo <= minFold && hi >= maxFold {
+			continue
+		}
+		if lo < minFold {
+			lo = minFold
+		}
+		if hi > maxFold {
+			hi = maxFold
+		}
+		for r := lo; r <= hi; r++ {
+			for f := simpleFold(r); f != r; f = simpleFold(f) {
+				folded = append(folded, runeRange{min: f, max: f})
+			}
+		}
+	}
+	return normalizeRanges(folded)
+}
+
+// normalizeRanges は、文字範囲のリストを整列し、重なる範囲や隣接する範囲を統合します。
+func normalizeRanges(ranges []runeRange) []runeRange {
+	if len(ranges) == 0 {
+		return ranges
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].min < ranges[j].min
+	})
+
+	merged := ranges[:1]
+	for _, rng := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if rng.min <= last.max+1 {
+			if rng.max > last.max {
+				last.max = rng.max
+			}
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	return merged
+}
+
+// matchRuneFold は、matchRune と同様に1文字の命令が文字 ch にマッチするかどうかを判定しますが、
+// ch と同一視される文字のいずれかにマッチすればマッチとします。
+// 否定の文字クラスは、同一視される文字のいずれも否定する前のクラスに含まれない場合にマッチします
+// （(?i)[^a] と同様に、[^a] は A にもマッチしません）。
+// (?i) の場合と同様に、\w や \p{...} などの組み込みの文字クラスは同一視しません。
+func matchRuneFold(op InstrType, instr *Instr, ch rune) bool {
+	switch op {
+	case InstrChar:
+		for f := ch; ; {
+			if f == instr.Char {
+				return true
+			}
+			if f = simpleFold(f); f == ch {
+				return false
+			}
+		}
+	case InstrAnyChar:
+		return instr.Arg == 1 || (ch != '\n' && ch != '\r')
+	case InstrCharClass:
+		c := instr.CharClass
+		if c.classType != ClassCustom {
+			return c.matches(ch)
+		}
+		for f := ch; ; {
+			// 否定する前のクラスに含まれるかどうか
+			if c.matches(f) != c.negate {
+				return !c.negate
+			}
+			if f = simpleFold(f); f == ch {
+				return c.negate
+			}
+		}
+	}
+	return false
+}
+
+// foldMatcher は、大小文字を区別せずに照合するマッチャーを、文字列 s を入力にして取り出します。
+// 標準ライブラリは照合の途中で大小文字の扱いを変えられないため、LinearFallback の設定にかかわらずこのエンジンで照合します。
+func (re *Regexp) foldMatcher(s string, needSubmatch bool) *Matcher {
+	m := re.prog.getMatcher(needSubmatch)
+	m.fold = true
+	m.resetString(s)
+	return m
+}
+
+// MatchStringFold は、MatchString と同様ですが、パターンに (?i) を付けた場合と同じように大小文字を区別せずに照合します。
+// 同じパターンを大小文字を区別する場合としない場合の両方で使うときに、2つの Regexp をコンパイルせずに済みます。
+// バックリファレンスは、(?i) の場合と同様に大小文字を区別して比較します。
+func (re *Regexp) MatchStringFold(s string) bool {
+	m := re.foldMatcher(s, false)
+	defer re.prog.putMatcher(m)
+	return m.Match()
+}
+
+// FindStringFold は、FindString と同様ですが、MatchStringFold と同じように大小文字を区別せずに照合します。
+func (re *Regexp) FindStringFold(s string) string {
+	m := re.foldMatcher(s, false)
+	defer re.prog.putMatcher(m)
+	if !m.search(0) {
+		return ""
+	}
+	loc := m.matchIndex()
+	return s[loc[0]:loc[1]]
+}
+
+// FindStringSubmatchFold は、FindStringSubmatch と同様ですが、MatchStringFold と同じように大小文字を区別せずに照合します。
+func (re *Regexp) FindStringSubmatchFold(s string) []string {
+	m := re.foldMatcher(s, true)
+	defer re.prog.putMatcher(m)
+	if !m.search(0) {
+		return nil
+	}
+	return submatchStrings(s, m.submatchIndex())
+}
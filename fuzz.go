@@ -0,0 +1,279 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fuzzAlphabet は、ファズテストで生成するパターンと入力に使う文字です。
+// 少ない種類の文字に絞ることで、パターンと入力がマッチしやすくなります。
+const fuzzAlphabet = "ab-_ \n1é"
+
+// FuzzCase は、ファズテストの1つの入力（パターンと、照合する文字列）です。
+type FuzzCase struct {
+	Pattern string
+	Input   string
+}
+
+// RandomPattern は、ファズテスト用のランダムなパターンを生成します。
+// depth は、グループや選択を入れ子にする深さの上限です。
+// 生成するパターンの多くは標準ライブラリでもコンパイルでき、CompareWithStdlib で結果を比較できます。
+// まれにバックリファレンスや所有的量指定子など、このエンジンに固有の構文も含めます。
+func RandomPattern(rng *rand.Rand, depth int) string {
+	var sb strings.Builder
+	writeRandomAlt(&sb, rng, depth)
+	return sb.String()
+}
+
+// writeRandomAlt は、ランダムな選択（または連接）を sb に書き込みます。
+func writeRandomAlt(sb *strings.Builder, rng *rand.Rand, depth int) {
+	writeRandomConcat(sb, rng, depth)
+	for depth > 0 && rng.Intn(4) == 0 {
+		sb.WriteString("|")
+		writeRandomConcat(sb, rng, depth)
+	}
+}
+
+// writeRandomConcat は、ランダムな要素の連接を sb に書き込みます。
+func writeRandomConcat(sb *strings.Builder, rng *rand.Rand, depth int) {
+	for n := rng.Intn(4); n >= 0; n-- {
+		writeRandomAtom(sb, rng, depth)
+		writeRandomQuantifier(sb, rng)
+	}
+}
+
+// writeRandomAtom は、ランダムな1つの要素を sb に書き込みます。
+func writeRandomAtom(sb *strings.Builder, rng *rand.Rand, depth int) {
+	switch k := rng.Intn(12); {
+	case k < 5:
+		writeLiteral(sb, randomFuzzRune(rng))
+	case k == 5:
+		sb.WriteString(".")
+	case k == 6:
+		sb.WriteString([]string{`[ab]`, `[^a]`, `[a-e1]`, `\d`, `\w`, `\s`, `\W`}[rng.Intn(7)])
+	case k == 7:
+		sb.WriteString([]string{`^`, `$`, `\b`, `\B`, `\A`, `\z`, `(?m:^)`, `(?m:$)`}[rng.Intn(8)])
+	case k == 8 && depth > 0:
+		sb.WriteString([]string{"(", "(?:", "(?i:", "(?s:"}[rng.Intn(4)])
+		writeRandomAlt(sb, rng, depth-1)
+		sb.WriteString(")")
+	case k == 9 && rng.Intn(8) == 0:
+		// このエンジンに固有のバックリファレンス
+		sb.WriteString(`(a)\1`)
+	default:
+		writeLiteral(sb, randomFuzzRune(rng))
+	}
+}
+
+// writeRandomQuantifier は、ランダムな量指定子（またはなし）を sb に書き込みます。
+func writeRandomQuantifier(sb *strings.Builder, rng *rand.Rand) {
+	switch rng.Intn(10) {
+	case 0:
+		sb.WriteString("*")
+	case 1:
+		sb.WriteString("+")
+	case 2:
+		sb.WriteString("?")
+	case 3:
+		min := rng.Intn(3)
+		sb.WriteString("{" + strconv.Itoa(min) + "," + strconv.Itoa(min+rng.Intn(3)) + "}")
+	default:
+		return
+	}
+	switch rng.Intn(6) {
+	case 0:
+		sb.WriteString("?") // 非貪欲
+	case 1:
+		if rng.Intn(4) == 0 {
+			sb.WriteString("+") // このエンジンに固有の所有的量指定子
+		}
+	}
+}
+
+// randomFuzzRune は、fuzzAlphabet からランダムに1文字を選びます。
+func randomFuzzRune(rng *rand.Rand) rune {
+	runes := []rune(fuzzAlphabet)
+	return runes[rng.Intn(len(runes))]
+}
+
+// RandomInput は、ファズテスト用のランダムな入力を、最大 maxLen 文字で生成します。
+func RandomInput(rng *rand.Rand, maxLen int) string {
+	var sb strings.Builder
+	for n := rng.Intn(maxLen + 1); n > 0; n-- {
+		sb.WriteRune(randomFuzzRune(rng))
+	}
+	return sb.String()
+}
+
+// MutateInput は、入力の1か所をランダムに変更（文字の挿入、削除、置換、範囲の複製）した文字列を返します。
+func MutateInput(rng *rand.Rand, input string) string {
+	runes := []rune(input)
+	if len(runes) == 0 {
+		return string(randomFuzzRune(rng))
+	}
+	i := rng.Intn(len(runes))
+	switch rng.Intn(4) {
+	case 0:
+		runes = append(runes[:i], append([]rune{randomFuzzRune(rng)}, runes[i:]...)...)
+	case 1:
+		runes = append(runes[:i], runes[i+1:]...)
+	case 2:
+		runes[i] = randomFuzzRune(rng)
+	default:
+		j := i + rng.Intn(len(runes)-i) + 1
+		runes = append(runes[:j], append(append([]rune(nil), runes[i:j]...), runes[j:]...)...)
+	}
+	return string(runes)
+}
+
+// CompareWithStdlib は、パターンと入力をこのエンジンと標準ライブラリの regexp の両方で照合し、
+// 結果の違いやパニックがあればエラーとして返します。
+// このエンジンでコンパイルできないパターンや、標準ライブラリで表せないパターンでは、パニックの有無だけを確認します。
+// 空文字列にマッチし得る部分の繰り返しを含むパターンでは、意味の違いがあるためマッチするかどうかだけを比較します。
+// Translate で RE2 向けに書き換えられるパターンでは、書き換えたパターンの標準ライブラリでのマッチの位置も比較します。
+func CompareWithStdlib(c FuzzCase) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("パニック: %v（パターン %q、入力 %q）", r, c.Pattern, c.Input)
+		}
+	}()
+
+	var found []Discrepancy
+	re, err := CompileWithOptions(c.Pattern, Options{CrossCheck: func(d Discrepancy) {
+		found = append(found, d)
+	}})
+	if err != nil {
+		return nil
+	}
+
+	re.MatchString(c.Input)
+	if !emptyRepeat(re.expr) {
+		re.FindAllStringIndex(c.Input, -1)
+		re.ReplaceAllLiteralString(c.Input, "x")
+		re.Split(c.Input, -1)
+		re.FindStringSubmatchIndex(c.Input)
+		re.FindAllStringSubmatchIndex(c.Input, -1)
+	}
+	if len(found) > 0 {
+		d := found[0]
+		return fmt.Errorf("%s の結果が標準ライブラリと異なります: %v、標準ライブラリは %v（パターン %q、入力 %q）",
+			d.Method, d.Got, d.Want, c.Pattern, c.Input)
+	}
+
+	// Translate で RE2 向けに書き換えたパターンも、標準ライブラリで同じ位置にマッチする
+	if translated, err := Translate(c.Pattern, DialectRE2); err == nil {
+		if std, err := regexp.Compile(translated); err == nil {
+			got, want := re.FindAllStringIndex(c.Input, -1), std.FindAllStringIndex(c.Input, -1)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				return fmt.Errorf("Translate の結果 %q の標準ライブラリでのマッチが異なります: %v、このエンジンは %v（パターン %q、入力 %q）",
+					translated, want, got, c.Pattern, c.Input)
+			}
+		}
+	}
+	return nil
+}
+
+// emptyRepeat は、パターンが、空文字列にマッチし得る部分の繰り返しを含むかどうかを返します。
+// そのような繰り返しでは、Perl と同様に空の繰り返しを扱うこのエンジンと、標準ライブラリとで、
+// マッチやサブマッチの位置が異なることがあります（例: (a|)* と "a"、(?:n?|_{0}.)*é と "é"）。
+func emptyRepeat(pattern string) bool {
+	ast, err := parse(pattern, Options{})
+	if err != nil {
+		return false
+	}
+	return hasEmptyRepeat(ast)
+}
+
+// hasEmptyRepeat は、構文木が、空文字列にマッチし得る部分を2回以上繰り返し得る繰り返しを含むかどうかを返します。
+func hasEmptyRepeat(node Node) bool {
+	switch n := node.(type) {
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if hasEmptyRepeat(child) {
+				return true
+			}
+		}
+	case *AltNode:
+		return hasEmptyRepeat(n.left) || hasEmptyRepeat(n.right)
+	case *RepeatNode:
+		min, _ := nodeLength(n.node)
+		return (min == 0 && n.max != 0 && n.max != 1) || hasEmptyRepeat(n.node)
+	case *CaptureNode:
+		return hasEmptyRepeat(n.node)
+	case *GroupNode:
+		return hasEmptyRepeat(n.node)
+	}
+	return false
+}
+
+// MinimizeFuzzCase は、fails が true を返し続ける範囲で、パターンと入力から文字を取り除いて最小化したケースを返します。
+// 失敗の判定には、例えば CompareWithStdlib がエラーを返すかどうかを使います。
+func MinimizeFuzzCase(c FuzzCase, fails func(FuzzCase) bool) FuzzCase {
+	for changed := true; changed; {
+		changed = false
+		for _, field := range []*string{&c.Pattern, &c.Input} {
+			for i := 0; i < len(*field); {
+				_, size := utf8.DecodeRuneInString((*field)[i:])
+				orig := *field
+				*field = orig[:i] + orig[i+size:]
+				if fails(c) {
+					changed = true
+					continue
+				}
+				*field = orig
+				i += size
+			}
+		}
+	}
+	return c
+}
+
+// FuzzTrace は、ファズテストで実行したケースを記録し、失敗したケースを最小化して報告します。
+// ケースごとに Run を呼び、失敗があれば Failure で最小化した再現例を取り出します。
+type FuzzTrace struct {
+	// Check は、ケースを確認する関数です。nil の場合は CompareWithStdlib を使います。
+	Check func(FuzzCase) error
+
+	// Cases は、これまでに実行したケースです（最後が最新）。
+	Cases []FuzzCase
+
+	failure *FuzzCase
+	err     error
+}
+
+// Run は、ケースを記録して確認し、失敗した場合はそのエラーを返します。
+// 最初の失敗は、Failure で取り出せるよう保持します。
+func (t *FuzzTrace) Run(c FuzzCase) error {
+	t.Cases = append(t.Cases, c)
+	err := t.check(c)
+	if err != nil && t.failure == nil {
+		t.failure = &c
+		t.err = err
+	}
+	return err
+}
+
+// Failure は、最初に失敗したケースを最小化した再現例と、元のエラーを返します。
+// 失敗がなければ nil のエラーを返します。
+func (t *FuzzTrace) Failure() (FuzzCase, error) {
+	if t.failure == nil {
+		return FuzzCase{}, nil
+	}
+	minimized := MinimizeFuzzCase(*t.failure, func(c FuzzCase) bool {
+		return t.check(c) != nil
+	})
+	return minimized, t.err
+}
+
+// check は、設定された関数でケースを確認します。
+func (t *FuzzTrace) check(c FuzzCase) error {
+	if t.Check != nil {
+		return t.Check(c)
+	}
+	return CompareWithStdlib(c)
+}
@@ -0,0 +1,48 @@
+//go:build fuzz
+
+package btregexp
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// ファズテストは、通常のテストでは実行しないよう fuzz タグ付きでビルドします。
+//
+//	go test -tags fuzz -fuzz FuzzCompareWithStdlib
+
+// FuzzCompareWithStdlib は、パターンと入力の組を、標準ライブラリの regexp と比較します。
+func FuzzCompareWithStdlib(f *testing.F) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 64; i++ {
+		f.Add(RandomPattern(rng, 2), RandomInput(rng, 8))
+	}
+
+	f.Fuzz(func(t *testing.T, pattern, input string) {
+		trace := &FuzzTrace{}
+		trace.Run(FuzzCase{Pattern: pattern, Input: input})
+		if c, err := trace.Failure(); err != nil {
+			t.Fatalf("%v\n最小化した再現例: パターン %q、入力 %q", err, c.Pattern, c.Input)
+		}
+	})
+}
+
+// FuzzGeneratedPattern は、シードから生成したパターンと、入力を変更した文字列とを照合します。
+func FuzzGeneratedPattern(f *testing.F) {
+	for seed := int64(0); seed < 16; seed++ {
+		f.Add(seed, "ab-_ 1")
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64, input string) {
+		rng := rand.New(rand.NewSource(seed))
+		pattern := RandomPattern(rng, 3)
+		trace := &FuzzTrace{}
+		for i := 0; i < 8; i++ {
+			trace.Run(FuzzCase{Pattern: pattern, Input: input})
+			input = MutateInput(rng, input)
+		}
+		if c, err := trace.Failure(); err != nil {
+			t.Fatalf("%v\n最小化した再現例: パターン %q、入力 %q", err, c.Pattern, c.Input)
+		}
+	})
+}
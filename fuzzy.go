@@ -0,0 +1,136 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "sync"
+
+// 近似照合で1文字の命令に加える編集の種類（試す順）
+const (
+	editSubstitute = iota // 入力の1文字を、命令がマッチする文字の代わりに消費する
+	editDelete            // 命令を、入力を消費せずに飛ばす（入力から文字が欠けている）
+	editInsert            // 入力の1文字を、命令を進めずに飛ばす（入力に余分な文字がある）
+)
+
+// fuzzyProgram は、FindFuzzy で使う近似照合用のプログラムです。
+type fuzzyProgram struct {
+	once sync.Once
+	prog *program
+	err  error
+}
+
+// FuzzyMatch は、FindFuzzy が返す近似マッチです。
+type FuzzyMatch struct {
+	Index []int  // マッチとサブマッチの位置（FindStringSubmatchIndex と同じ形式）
+	Text  string // マッチした文字列
+	Edits int    // パターンに一致させるために必要だった編集（1文字の置換・削除・挿入）の回数
+}
+
+// FindFuzzy は、TRE や agrep のように、最大 maxEdits 回の編集を許して s の中のマッチを探します。
+// 編集は、パターンの1文字の要素（文字、.、文字クラス）に対する、入力の1文字の置換、欠落、余分な1文字のいずれかで、
+// それぞれ1回と数えます。
+//
+// 編集の回数が最も少ないマッチのうち、最も左のものを返します。マッチがなければ nil を返します。
+// バックリファレンスやアンカーなどの幅のない要素は編集しませんが、その直前の余分な文字は飛ばします（"abc$" は "abcd" にマッチします）。
+// 編集の組み合わせを試すため、maxEdits が大きいと照合は急速に遅くなります（ステップ数の上限に達した場合は nil を返します）。
+func (re *Regexp) FindFuzzy(s string, maxEdits int) *FuzzyMatch {
+	prog, err := re.fuzzyProgram()
+	if err != nil {
+		return nil
+	}
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+	m.resetString(s)
+
+	// 編集の回数の少ない順に探す（k-1 回ではどこにもマッチしなければ、見つかるマッチは k 回の編集を要する）
+	for k := 0; k <= max(maxEdits, 0); k++ {
+		m.maxEdits = k
+		if m.search(0) {
+			loc := m.submatchIndex()
+			return &FuzzyMatch{Index: loc, Text: s[loc[0]:loc[1]], Edits: m.saved[prog.editSlot]}
+		}
+		if m.err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// fuzzyProgram は、近似照合用のプログラムを、初めて呼ばれたときにコンパイルして返します。
+func (re *Regexp) fuzzyProgram() (*program, error) {
+	re.fuzzy.once.Do(func() {
+		ast, err := parse(re.expr, re.opts)
+		if err != nil {
+			re.fuzzy.err = err
+			return
+		}
+		re.fuzzy.prog, re.fuzzy.err = compileFuzzyProgram(ast, re.opts)
+	})
+	return re.fuzzy.prog, re.fuzzy.err
+}
+
+// compileFuzzyProgram は、AST を近似照合用のプログラムにコンパイルします。
+// 編集できるよう1文字ずつ照合する命令だけを使い、編集の回数を記録するスロットを追加します。
+// 入力の長さやリテラルによる前処理は、編集によって成り立たなくなるため使いません。
+func compileFuzzyProgram(ast Node, opts Options) (*program, error) {
+	compiler := newCompiler()
+	compiler.flags = opts.Flags
+	compiler.singleRunes = true
+	prog, err := compiler.compile(factorAlternations(Optimize(ast)))
+	if err != nil {
+		return nil, err
+	}
+
+	prog.unsetBackrefMatchesEmpty = opts.UnsetBackrefMatchesEmpty
+	prog.latin1 = opts.Latin1
+	prog.normalization = normalizationOf(opts)
+	prog.numCounters++
+	prog.editSlot = prog.spanBase() - 1
+	prog.minLen, prog.maxLen = 0, unbounded
+	prog.endSuffix, prog.rawSuffix = nil, ""
+	prog.startLiterals = nil
+	return prog, nil
+}
+
+// isZeroWidth は、命令が入力を消費しない位置の判定か、マッチの成功かどうかを返します。
+// 近似照合では、これらの命令の前では余分な文字の挿入だけを試します。
+func isZeroWidth(op InstrType) bool {
+	switch op {
+	case InstrMatch, InstrWordBoundary, InstrNonWordBoundary, InstrBeginLine, InstrEndLine,
+		InstrBeginText, InstrEndText, InstrNotAfterWord, InstrNotBeforeWord:
+		return true
+	}
+	return false
+}
+
+// resumeFuzzy は、近似照合のバックトラックポイント bp から、まだ試していない編集を加えて再開します。
+// 試せる編集がなければ、ポイントを取り除いて false を返します。
+func (m *Matcher) resumeFuzzy(bp *BacktrackPoint, pc *int) bool {
+	instr := &m.prog.instrs[bp.pc]
+	for bp.limit <= editInsert {
+		edit := bp.limit
+		bp.limit++
+		switch edit {
+		case editSubstitute:
+			// 一致する文字を置換しても編集が増えるだけ
+			if bp.pos >= len(m.input) || m.matchRune(instr.Op, instr, m.input[bp.pos]) {
+				continue
+			}
+			*pc, m.pos = instr.Next, bp.pos+1
+		case editDelete:
+			*pc, m.pos = instr.Next, bp.pos
+		case editInsert:
+			if bp.pos >= len(m.input) {
+				continue
+			}
+			*pc, m.pos = bp.pc, bp.pos+1
+		}
+
+		edits := m.saved[m.prog.editSlot]
+		if bp.limit > editInsert {
+			m.stack = m.stack[:len(m.stack)-1]
+		}
+		m.setSlot(m.prog.editSlot, edits+1)
+		return true
+	}
+	m.stack = m.stack[:len(m.stack)-1]
+	return false
+}
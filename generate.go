@@ -0,0 +1,169 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"math/rand"
+	"strings"
+	"unicode"
+)
+
+// maxGenerateAttempts は、GenerateExample がパターンにマッチする文字列を生成し直す最大回数です。
+const maxGenerateAttempts = 100
+
+// GenerateExample は、全体がパターンにマッチする文字列を、構文木をたどってランダムに1つ生成します。
+//
+// 上限のない繰り返し（*、+、{n,}）は、最小回数に最大 maxRepeat 回を加えた回数までに制限します。
+// 文字クラスからは、含まれていれば表示可能な ASCII 文字を優先して選びます。
+// 境界（^、\b など）や所有的量指定子は生成時には考慮せず、生成した文字列がマッチしなければ生成し直します。
+// 何度生成してもマッチしない場合（例えば a\bb のように決してマッチしないパターン）は、最後に生成した文字列を返します。
+func (re *Regexp) GenerateExample(rng *rand.Rand, maxRepeat int) string {
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return ""
+	}
+	// 一部だけがマッチする文字列を受け入れないよう、文字列全体にマッチするかどうかで確かめる
+	whole, err := wholeMatchProgram(re)
+	if err != nil {
+		return ""
+	}
+	if maxRepeat < 0 {
+		maxRepeat = 0
+	}
+	var example string
+	for i := 0; i < maxGenerateAttempts; i++ {
+		g := &exampleGenerator{rng: rng, maxRepeat: maxRepeat, captures: make(map[int]string)}
+		g.generate(ast)
+		example = g.sb.String()
+		if matchString(whole, inputFor(example, re.opts)) {
+			break
+		}
+	}
+	return example
+}
+
+// exampleGenerator は、構文木をたどってパターンにマッチする文字列を生成します。
+type exampleGenerator struct {
+	rng       *rand.Rand
+	maxRepeat int
+	sb        strings.Builder
+	captures  map[int]string // キャプチャグループのインデックスと、そのグループに生成した文字列（バックリファレンス用）
+}
+
+// generate は、ノードにマッチする文字列を生成して書き込みます。
+func (g *exampleGenerator) generate(node Node) {
+	switch n := node.(type) {
+	case *CharNode:
+		if n.fold {
+			orbit := foldOrbit(n.r)
+			g.sb.WriteRune(orbit[g.rng.Intn(len(orbit))])
+			return
+		}
+		g.sb.WriteRune(n.r)
+
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			g.generate(child)
+		}
+
+	case *AltNode:
+		branches := flattenAlt(n)
+		g.generate(branches[g.rng.Intn(len(branches))])
+
+	case *RepeatNode:
+		hi := n.max
+		if hi < 0 || hi > n.min+g.maxRepeat {
+			hi = n.min + g.maxRepeat
+		}
+		for count := n.min + g.rng.Intn(hi-n.min+1); count > 0; count-- {
+			g.generate(n.node)
+		}
+
+	case *CaptureNode:
+		start := g.sb.Len()
+		g.generate(n.node)
+		g.captures[n.index] = g.sb.String()[start:]
+
+	case *GroupNode:
+		g.generate(n.node)
+
+	case *BackrefNode:
+		// 参照先のグループに生成した文字列を繰り返す（まだ生成していなければ空文字列）
+		g.sb.WriteString(g.captures[n.index])
+
+	case *AnyCharNode:
+		ranges := []runeRange{{0, '\t'}, {'\v', '\f'}, {'\r' + 1, unicode.MaxRune}}
+		if n.dotMatchesNewline {
+			ranges = []runeRange{{0, unicode.MaxRune}}
+		}
+		g.sb.WriteRune(g.pickRune(ranges))
+
+	case *CharClassNode:
+		ranges, err := classRanges(n)
+		if err != nil {
+			return
+		}
+		if n.negate {
+			ranges = complementRanges(ranges)
+		}
+		if len(ranges) > 0 {
+			g.sb.WriteRune(g.pickRune(ranges))
+		}
+	}
+}
+
+// printableASCII は、文字クラスから優先して選ぶ、表示可能な ASCII 文字の範囲です。
+var printableASCII = runeRange{' ', '~'}
+
+// pickRune は、整列済みの文字範囲のリストからランダムに1文字を選びます。
+// 範囲に表示可能な ASCII 文字が含まれていれば、多くの場合はその中から選びます。
+// サロゲートの範囲の文字は、文字列に書き込めないため選びません。
+func (g *exampleGenerator) pickRune(ranges []runeRange) rune {
+	if g.rng.Intn(8) != 0 {
+		var printable []runeRange
+		for _, rng := range ranges {
+			lo, hi := max(rng.min, printableASCII.min), min(rng.max, printableASCII.max)
+			if lo <= hi {
+				printable = append(printable, runeRange{lo, hi})
+			}
+		}
+		if len(printable) > 0 {
+			ranges = printable
+		}
+	}
+	ranges = removeRange(ranges, runeRange{0xd800, 0xdfff})
+	if len(ranges) == 0 {
+		return unicode.ReplacementChar
+	}
+
+	total := 0
+	for _, rng := range ranges {
+		total += int(rng.max-rng.min) + 1
+	}
+	k := g.rng.Intn(total)
+	for _, rng := range ranges {
+		size := int(rng.max-rng.min) + 1
+		if k < size {
+			return rng.min + rune(k)
+		}
+		k -= size
+	}
+	return ranges[len(ranges)-1].max
+}
+
+// removeRange は、整列済みの文字範囲のリストから、範囲 r に含まれる文字を取り除きます。
+func removeRange(ranges []runeRange, r runeRange) []runeRange {
+	var result []runeRange
+	for _, rng := range ranges {
+		if rng.max < r.min || r.max < rng.min {
+			result = append(result, rng)
+			continue
+		}
+		if rng.min < r.min {
+			result = append(result, runeRange{rng.min, r.min - 1})
+		}
+		if r.max < rng.max {
+			result = append(result, runeRange{r.max + 1, rng.max})
+		}
+	}
+	return result
+}
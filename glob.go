@@ -0,0 +1,207 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// GlobOptions は、FromGlob でグロブパターンを変換する際の設定です。
+type GlobOptions struct {
+	// Separator は、パスの区切り文字です。0の場合は '/' を使います。
+	// * や ? などは区切り文字にマッチせず、** だけが区切り文字をまたいでマッチします。
+	Separator rune
+
+	// CaseInsensitive は、大小文字を区別せずに照合するかどうかです。
+	CaseInsensitive bool
+}
+
+// FromGlob は、グロブパターンを、パス全体にマッチする正規表現に変換してコンパイルします。
+//
+// 次の構文に対応しています。それ以外の文字は、正規表現のメタ文字も含めて文字そのものとして扱います。
+//
+//   - 区切り文字以外の0文字以上
+//     ?       区切り文字以外の1文字
+//     [...]   文字クラス（[!...] または [^...] は否定。どちらも区切り文字にはマッチしない）
+//     **      区切り文字を含む0文字以上（パスの要素全体が ** の場合、**/ は0個以上のディレクトリ）
+//     {a,b}   いずれかのパターン（入れ子にできる）
+//     \x      文字 x そのもの
+func FromGlob(pattern string, opts GlobOptions) (*Regexp, error) {
+	sep := opts.Separator
+	if sep == 0 {
+		sep = '/'
+	}
+	expr, err := globToRegexp(pattern, sep)
+	if err != nil {
+		return nil, err
+	}
+	return CompileWithOptions(expr, Options{Flags: Flags{CaseInsensitive: opts.CaseInsensitive}})
+}
+
+// globConverter は、グロブパターンを正規表現の構文に書き換えます。
+type globConverter struct {
+	pattern string
+	pos     int
+	sep     rune
+	sb      strings.Builder
+	braces  int // 開いている { の数
+}
+
+// globToRegexp は、グロブパターンを、パス全体にマッチする正規表現の構文に書き換えます。
+func globToRegexp(pattern string, sep rune) (string, error) {
+	g := &globConverter{pattern: pattern, sep: sep}
+	g.sb.WriteString(`\A`)
+	for g.pos < len(g.pattern) {
+		if err := g.convert(); err != nil {
+			return "", err
+		}
+	}
+	if g.braces > 0 {
+		return "", fmt.Errorf("グロブの { が閉じられていません: %s", pattern)
+	}
+	g.sb.WriteString(`\z`)
+	return g.sb.String(), nil
+}
+
+// convert は、グロブパターンの1つの要素を書き換えます。
+func (g *globConverter) convert() error {
+	start := g.pos
+	r, size := utf8.DecodeRuneInString(g.pattern[g.pos:])
+	g.pos += size
+
+	switch {
+	case r == '*':
+		if g.pos < len(g.pattern) && g.pattern[g.pos] == '*' {
+			g.pos++
+			g.doubleStar(start)
+			return nil
+		}
+		g.sb.WriteString(g.notSep() + "*")
+
+	case r == '?':
+		g.sb.WriteString(g.notSep())
+
+	case r == '[':
+		return g.charClass()
+
+	case r == '{':
+		g.sb.WriteString("(?:")
+		g.braces++
+
+	case r == ',' && g.braces > 0:
+		g.sb.WriteString("|")
+
+	case r == '}' && g.braces > 0:
+		g.sb.WriteString(")")
+		g.braces--
+
+	case r == '\\':
+		if g.pos >= len(g.pattern) {
+			return fmt.Errorf("グロブのエスケープが終了していません: %s", g.pattern)
+		}
+		r, size = utf8.DecodeRuneInString(g.pattern[g.pos:])
+		g.pos += size
+		writeLiteral(&g.sb, r)
+
+	default:
+		writeLiteral(&g.sb, r)
+	}
+	return nil
+}
+
+// doubleStar は、位置 start から始まる ** を書き換えます。
+// パスの要素全体が ** の場合は区切り文字をまたいでマッチし、続く区切り文字も含めて0個以上のディレクトリを表します。
+// 要素の一部にある ** は * と同じ意味です。
+func (g *globConverter) doubleStar(start int) {
+	before, _ := utf8.DecodeLastRuneInString(g.pattern[:start])
+	after, size := utf8.DecodeRuneInString(g.pattern[g.pos:])
+	segmentStart := start == 0 || before == g.sep || before == '{' || before == ','
+	switch {
+	case !segmentStart:
+		g.sb.WriteString(g.notSep() + "*")
+	case g.pos < len(g.pattern) && after == g.sep:
+		// **/ は空文字列か、区切り文字で終わる任意の文字列
+		g.pos += size
+		var sep strings.Builder
+		writeLiteral(&sep, g.sep)
+		g.sb.WriteString("(?s:.*" + sep.String() + ")?")
+	default:
+		g.sb.WriteString("(?s:.*)")
+	}
+}
+
+// charClass は、[...] の文字クラスを書き換えます（'[' は読み取り済み）。
+// 否定であってもなくても、文字クラスは区切り文字にはマッチしません。
+func (g *globConverter) charClass() error {
+	negate := false
+	if g.pos < len(g.pattern) && (g.pattern[g.pos] == '!' || g.pattern[g.pos] == '^') {
+		negate = true
+		g.pos++
+	}
+
+	var ranges []runeRange
+	for first := true; ; first = false {
+		if g.pos >= len(g.pattern) {
+			return fmt.Errorf("グロブの [ が閉じられていません: %s", g.pattern)
+		}
+		if g.pattern[g.pos] == ']' && !first {
+			g.pos++
+			break
+		}
+		lo := g.classRune()
+		hi := lo
+		if g.pos+1 < len(g.pattern) && g.pattern[g.pos] == '-' && g.pattern[g.pos+1] != ']' {
+			g.pos++
+			if hi = g.classRune(); hi < lo {
+				return fmt.Errorf("グロブの文字クラスの範囲が逆です: %c-%c", lo, hi)
+			}
+		}
+		ranges = append(ranges, runeRange{lo, hi})
+	}
+
+	if negate {
+		ranges = append(ranges, runeRange{g.sep, g.sep})
+	} else {
+		ranges = removeRune(normalizeRanges(ranges), g.sep)
+	}
+
+	g.sb.WriteString("[")
+	if negate {
+		g.sb.WriteString("^")
+	}
+	for _, rng := range ranges {
+		writeClassRune(&g.sb, rng.min)
+		if rng.max != rng.min {
+			g.sb.WriteString("-")
+			writeClassRune(&g.sb, rng.max)
+		}
+	}
+	g.sb.WriteString("]")
+	return nil
+}
+
+// classRune は、文字クラスの中の1文字を読み取ります。\ に続く文字はそのまま読み取ります。
+func (g *globConverter) classRune() rune {
+	r, size := utf8.DecodeRuneInString(g.pattern[g.pos:])
+	g.pos += size
+	if r == '\\' && g.pos < len(g.pattern) {
+		r, size = utf8.DecodeRuneInString(g.pattern[g.pos:])
+		g.pos += size
+	}
+	return r
+}
+
+// removeRune は、整列済みの文字範囲のリストから文字 r を取り除きます。
+func removeRune(ranges []runeRange, r rune) []runeRange {
+	return removeRange(ranges, runeRange{r, r})
+}
+
+// notSep は、区切り文字以外の1文字にマッチする文字クラスを返します。
+func (g *globConverter) notSep() string {
+	var sb strings.Builder
+	sb.WriteString("[^")
+	writeClassRune(&sb, g.sep)
+	sb.WriteString("]")
+	return sb.String()
+}
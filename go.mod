@@ -1,3 +1,5 @@
 module github.com/user/go-btregexp
 
 go 1.24.0
+
+require golang.org/x/text v0.30.0
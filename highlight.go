@@ -0,0 +1,92 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"sort"
+	"strings"
+)
+
+// HighlightMarkers は、強調する部分の前と後ろに挿入する文字列です。
+type HighlightMarkers struct {
+	Open  string
+	Close string
+}
+
+// Highlight は、s の中の重ならない各マッチを open と close で囲んだ文字列を返します。
+// 例えば re.Highlight(s, "<b>", "</b>") は、検索結果の一致箇所を太字にする HTML を作ります。
+// 空文字列へのマッチは囲みません。s の文字列はエスケープせずにそのまま使います。
+func (re *Regexp) Highlight(s, open, close string) string {
+	return re.HighlightGroups(s, HighlightMarkers{Open: open, Close: close}, nil)
+}
+
+// highlightSpan は、マーカーで囲む範囲です。
+type highlightSpan struct {
+	start, end int // 範囲（バイト単位）
+	order      int // 同じ範囲の中での順序（小さいほど外側）
+	markers    HighlightMarkers
+}
+
+// HighlightGroups は、Highlight と同様に各マッチを match のマーカーで囲み、さらにマッチ内の名前付きグループを、
+// groups にその名前で指定したマーカーで囲んだ文字列を返します。
+// match のマーカーが両方とも空文字列の場合は、名前付きグループだけを囲みます。
+//
+// マーカーは、マッチの中にグループ、グループの中に入れ子のグループがくるように、正しく入れ子にして挿入します。
+// 空文字列にマッチしたマッチやグループは囲みません。
+// \zs や \ze によってグループがマッチや他のグループと部分的に重なる場合は、入れ子になるよう外側の範囲で切り詰めます。
+func (re *Regexp) HighlightGroups(s string, match HighlightMarkers, groups map[string]HighlightMarkers) string {
+	var spans []highlightSpan
+	forEachStringMatch(re.prog, s, -1, len(groups) > 0, func(loc []int) {
+		if match != (HighlightMarkers{}) {
+			spans = append(spans, highlightSpan{loc[0], loc[1], len(spans), match})
+		}
+		for i := 2; i+1 < len(loc); i += 2 {
+			name := re.subexpNames[i/2]
+			if markers, ok := groups[name]; ok && name != "" && loc[i] >= 0 {
+				spans = append(spans, highlightSpan{loc[i], loc[i+1], len(spans), markers})
+			}
+		}
+	})
+
+	// 開始位置の順に、同じ開始位置なら長い（外側の）範囲から
+	sort.Slice(spans, func(i, j int) bool {
+		a, b := spans[i], spans[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		if a.end != b.end {
+			return a.end > b.end
+		}
+		return a.order < b.order
+	})
+
+	var sb strings.Builder
+	last := 0
+	var open []highlightSpan // 開いている範囲（外側から順に）
+	closeUntil := func(pos int) {
+		for len(open) > 0 && open[len(open)-1].end <= pos {
+			top := open[len(open)-1]
+			sb.WriteString(s[last:top.end])
+			sb.WriteString(top.markers.Close)
+			last = top.end
+			open = open[:len(open)-1]
+		}
+	}
+	for _, sp := range spans {
+		closeUntil(sp.start)
+		// 閉じた範囲や開いている範囲と部分的に重なる場合は、入れ子になるよう切り詰める
+		sp.start = max(sp.start, last)
+		if len(open) > 0 {
+			sp.end = min(sp.end, open[len(open)-1].end)
+		}
+		if sp.start >= sp.end {
+			continue
+		}
+		sb.WriteString(s[last:sp.start])
+		sb.WriteString(sp.markers.Open)
+		last = sp.start
+		open = append(open, sp)
+	}
+	closeUntil(len(s))
+	sb.WriteString(s[last:])
+	return sb.String()
+}
@@ -0,0 +1,116 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// ErrNoMatch は、FindInto で s が正規表現にマッチしなかった場合のエラーです。
+var ErrNoMatch = errors.New("正規表現にマッチしません")
+
+// FindInto は、s の最初のマッチの名前付きグループの文字列を、構造体 dst の対応するフィールドに代入します。
+// dst は構造体へのポインタでなければなりません。フィールドとグループは regexp:"name" のタグで対応付け、
+// タグのないフィールドは変更しません。
+//
+// フィールドの型は、string、整数、符号なし整数、浮動小数点数、bool のいずれかで、
+// 文字列は strconv の ParseInt、ParseUint、ParseFloat、ParseBool で変換します。
+// マッチに参加しなかったグループに対応するフィールドは変更しません。
+//
+// マッチしない場合は ErrNoMatch を、ステップ数の上限に達した場合は ErrStepLimitExceeded を返します。
+// タグがパターンにないグループの名前を指す場合や、文字列をフィールドの型に変換できない場合もエラーを返します。
+// 変換に失敗した場合、それより前のフィールドには代入済みです。
+func (re *Regexp) FindInto(s string, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("FindInto の代入先は構造体へのポインタでなければなりません: %T", dst)
+	}
+	v = v.Elem()
+
+	loc, err := re.FindStringSubmatchIndexErr(s)
+	if err != nil {
+		return err
+	}
+	if loc == nil {
+		return ErrNoMatch
+	}
+	m := Submatch{re: re, s: s, loc: loc}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("regexp")
+		if !ok {
+			continue
+		}
+		if !field.IsExported() {
+			return fmt.Errorf("フィールド %s は公開されていないため代入できません", field.Name)
+		}
+		index := re.namedIndex(m, name)
+		if index < 0 {
+			return fmt.Errorf("フィールド %s のタグが、存在しない名前付きグループ %q を参照しています", field.Name, name)
+		}
+		if !m.Matched(index) {
+			continue
+		}
+		if err := setField(v.Field(i), m.Group(index)); err != nil {
+			return fmt.Errorf("フィールド %s にグループ %q の値 %q を代入できません: %w", field.Name, name, m.Group(index), err)
+		}
+	}
+	return nil
+}
+
+// namedIndex は、名前付きグループ name の番号を返します。同じ名前のグループが複数あれば、
+// マッチ m に参加した最初のもの（どれも参加していなければ最初のもの）を返します。名前がなければ-1を返します。
+func (re *Regexp) namedIndex(m Submatch, name string) int {
+	index := -1
+	for i, n := range re.subexpNames {
+		if i == 0 || n != name {
+			continue
+		}
+		if m.Matched(i) {
+			return i
+		}
+		if index < 0 {
+			index = i
+		}
+	}
+	return index
+}
+
+// setField は、文字列 s をフィールドの型に変換して代入します。
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("対応していない型です: %s", f.Type())
+	}
+	return nil
+}
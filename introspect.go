@@ -0,0 +1,212 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "strings"
+
+// LiteralPrefix は、すべてのマッチが必ずその文字列で始まるリテラルの接頭辞を返します。
+// complete は、パターンがその文字列そのもの（大小文字の同一視やキャプチャグループを含まない）かどうかです。
+// 入力を正規化する設定（Flags.Normalization）では、入力の文字列と直接比べられないため、常に "", false を返します。
+func (re *Regexp) LiteralPrefix() (prefix string, complete bool) {
+	if re.prog.normalization != NoNormalization {
+		return "", false
+	}
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return "", false
+	}
+	seq := sequenceOf(Optimize(ast))
+
+	var sb strings.Builder
+	i := 0
+	for ; i < len(seq); i++ {
+		ch, ok := seq[i].(*CharNode)
+		if !ok || ch.fold {
+			break
+		}
+		if re.prog.latin1 {
+			sb.WriteByte(byte(ch.r))
+		} else {
+			sb.WriteRune(ch.r)
+		}
+	}
+	return sb.String(), i == len(seq)
+}
+
+// IsLiteral は、パターンが1つの文字列そのものかどうかを返します。
+// true の場合、照合は strings.Index などの文字列の検索に置き換えられます（文字列は LiteralPrefix で得られます）。
+func (re *Regexp) IsLiteral() bool {
+	_, complete := re.LiteralPrefix()
+	return complete
+}
+
+// IsAnchored は、すべてのマッチが必ずテキストの先頭から始まるかどうか（start）と、
+// 必ずテキストの末尾で終わるかどうか（end）を返します。
+// \A、\z や、マルチラインモードでない ^ と $ による固定、および Options.Anchored を考慮します。
+func (re *Regexp) IsAnchored() (start, end bool) {
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return false, false
+	}
+	return startAnchored(ast), re.prog.endAnchored
+}
+
+// startAnchored は、ノードのマッチが必ずテキストの先頭（\A、またはマルチラインでない ^）から始まるかどうかを返します。
+func startAnchored(node Node) bool {
+	switch n := node.(type) {
+	case *BoundaryNode:
+		return n.nodeType == NodeBeginText || (n.nodeType == NodeBeginLine && !n.multiline)
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if startAnchored(child) {
+				return true
+			}
+			// 幅のない要素の後ろなら、まだ先頭にいる
+			if _, max := nodeLength(child); max != 0 {
+				return false
+			}
+		}
+		return false
+	case *AltNode:
+		return startAnchored(n.left) && startAnchored(n.right)
+	case *CaptureNode:
+		return startAnchored(n.node)
+	case *GroupNode:
+		return n.node != nil && startAnchored(n.node)
+	}
+	return false
+}
+
+// IsOnePass は、パターンが one-pass（テキストの先頭に固定され、選択や繰り返しのどの分岐に進むかを
+// 次の1文字だけで決められる）かどうかを返します。
+// one-pass のパターンはバックトラックせずに入力を1回走査するだけで照合できるため、
+// RE2（標準ライブラリの regexp）の one-pass や DFA による高速な照合の対象になります。
+// 判定は保守的で、false でも実際には one-pass である場合があります。
+func (re *Regexp) IsOnePass() bool {
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return false
+	}
+	// RE2 で表せない（バックリファレンスや所有的量指定子などを含む）パターンは対象外
+	if _, err := toSyntax(ast); err != nil {
+		return false
+	}
+	ast = factorAlternations(Optimize(ast))
+	if !startAnchored(ast) {
+		return false
+	}
+	_, ok := onePass(ast, nil)
+	return ok
+}
+
+// onePass は、後ろに follow の文字が続き得るノードについて、どの分岐に進むかを次の1文字で決められるかどうかを判定し、
+// ノードの先頭に来得る文字（ノードが空文字列にマッチし得る場合は follow を含む）を返します。
+func onePass(node Node, follow []runeRange) ([]runeRange, bool) {
+	switch n := node.(type) {
+	case *ConcatNode:
+		// 後ろの要素から順に、各要素の後ろに続き得る文字を求める
+		ok := true
+		for i := len(n.nodes) - 1; i >= 0 && ok; i-- {
+			follow, ok = onePass(n.nodes[i], follow)
+		}
+		return follow, ok
+
+	case *AltNode:
+		var union []runeRange
+		nullable := 0
+		for _, branch := range flattenAlt(n) {
+			first, ok := onePass(branch, follow)
+			if !ok || rangesOverlap(union, first) {
+				return nil, false
+			}
+			if min, _ := nodeLength(branch); min == 0 {
+				nullable++
+			}
+			union = normalizeRanges(append(union, first...))
+		}
+		// 空文字列にマッチする分岐が2つ以上あると、どちらに進むかを決められない
+		return union, nullable <= 1
+
+	case *RepeatNode:
+		// 本体の後ろには、本体の先頭か、繰り返しの後ろの文字が続く
+		first, _ := onePass(n.node, nil)
+		bodyFollow := normalizeRanges(append(append([]runeRange(nil), first...), follow...))
+		if _, ok := onePass(n.node, bodyFollow); !ok {
+			return nil, false
+		}
+		if n.min != n.max {
+			// 繰り返すか抜けるかを次の1文字で決める
+			if min, _ := nodeLength(n.node); min == 0 || rangesOverlap(first, follow) {
+				return nil, false
+			}
+		}
+		if n.min == 0 {
+			return bodyFollow, true
+		}
+		return first, true
+
+	case *CaptureNode:
+		return onePass(n.node, follow)
+
+	case *GroupNode:
+		if n.node == nil {
+			return follow, true
+		}
+		return onePass(n.node, follow)
+
+	case *CharNode:
+		runes := []rune{n.r}
+		if n.fold {
+			runes = foldOrbit(n.r)
+		}
+		var ranges []runeRange
+		for _, r := range runes {
+			ranges = append(ranges, runeRange{r, r})
+		}
+		return normalizeRanges(ranges), true
+
+	case *AnyCharNode, *CharClassNode:
+		ranges, err := enumeratedRanges(n)
+		return ranges, err == nil
+
+	case *BoundaryNode:
+		return follow, true
+
+	case *BackrefNode:
+		return nil, false
+	}
+	// 空のパターン
+	return follow, true
+}
+
+// rangesOverlap は、整列した2つの文字範囲のリストに共通する文字があるかどうかを返します。
+func rangesOverlap(a, b []runeRange) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].max < b[j].min:
+			i++
+		case b[j].max < a[i].min:
+			j++
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// MinInputLen は、マッチするために入力に必要な最小の長さ（文字数。Latin-1 モードではバイト数）を返します。
+// これより短い入力には、どの位置からもマッチしません。
+func (re *Regexp) MinInputLen() int {
+	return re.prog.minLen
+}
+
+// MaxInputLen は、1つのマッチが消費し得る最大の長さ（文字数。Latin-1 モードではバイト数）を返します。
+// 繰り返しの上限がない場合やバックリファレンスを含む場合など、長さに上限がなければ bounded は false です。
+// バイト数で扱う場合、UTF-8 の入力では最大で n*utf8.UTFMax バイトになります。
+// 入力を正規化する設定（Flags.Normalization）では、長さは正規化した後の文字数です。
+func (re *Regexp) MaxInputLen() (n int, bounded bool) {
+	if re.prog.maxLen == unbounded {
+		return 0, false
+	}
+	return re.prog.maxLen, true
+}
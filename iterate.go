@@ -0,0 +1,126 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"iter"
+	"sort"
+)
+
+// NewMatcher は、入力 input の中のマッチを先頭から順に探す Matcher を返します。
+// Next でマッチを1つずつ探し、Result でその位置を取り出します。
+// 同じ Matcher は Reset で別の入力に使い回せます。
+//
+// 返す Matcher はプールに戻さないため、照合の作業領域は Matcher を手放すまで保持されます。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+// Matcher は複数のゴルーチンから同時に使えません。
+func (re *Regexp) NewMatcher(input []byte) *Matcher {
+	m := newMatcher(re.prog, nil)
+	m.Reset(input)
+	return m
+}
+
+// Reset は、入力を input に替えて、先頭から検索をやり直します。
+func (m *Matcher) Reset(input []byte) {
+	m.stopStepping()
+	m.resetBytes(input)
+	m.err = nil
+	m.steps, m.totalSteps, m.attempts, m.matches = 0, 0, 0, 0
+	m.results, m.resultBytes = 0, 0
+	m.nextPos, m.lastEnd, m.found = 0, -1, false
+}
+
+// Next は、次のマッチを探し、見つかれば true を返します。マッチの位置は Result で取り出します。
+// マッチは FindAllSubmatchIndex と同じ規則で探します。つまり前のマッチと重ならず、
+// 空マッチの後は1文字進めて検索を続け、前のマッチの直後に隣接する空マッチは無視します。
+// 入力の最後まで探し終えた場合や、ステップ数の上限に達した場合は false を返します（後者は Err で判別します）。
+func (m *Matcher) Next() bool {
+	if m.stepNext != nil {
+		// Step で実行中の検索があれば、それを最後まで実行する
+		for {
+			if _, ok := m.Step(); !ok {
+				return m.found
+			}
+		}
+	}
+	return m.next()
+}
+
+// next は、Next の本体です。
+func (m *Matcher) next() bool {
+	m.found = false
+	if m.nextPos > len(m.input) {
+		return false
+	}
+	m.nextPos, m.lastEnd = m.scan(m.nextPos, len(m.input)+1, m.lastEnd, 1, func() { m.found = true })
+	return m.found
+}
+
+// Result は、直前の Next で見つけたマッチ全体と各グループの位置を返します（FindSubmatchIndex と同じ形式のバイト位置）。
+// マッチしなかったグループの位置は-1です。直前の Next がマッチを見つけなかった場合は nil を返します。
+func (m *Matcher) Result() []int {
+	if !m.found {
+		return nil
+	}
+	return m.submatchIndex()
+}
+
+// Pos は、次の Next が検索を始める位置（バイト位置）を返します。入力の最後まで探し終えた場合は-1を返します。
+func (m *Matcher) Pos() int {
+	if m.nextPos > len(m.input) {
+		return -1
+	}
+	return m.offsets[m.nextPos]
+}
+
+// SetPos は、次の Next が検索を始める位置をバイト位置 pos に設定します。pos が文字の途中なら、次の文字の先頭から探します。
+// 前のマッチの記録は消すため、pos で終わるマッチの直後でも、pos の空マッチを返します。
+// 空マッチの後に同じ位置から進めずに探し直す場合など、FindAll と異なる進め方が必要な場合に使います。
+func (m *Matcher) SetPos(pos int) {
+	m.stopStepping()
+	m.nextPos = sort.SearchInts(m.offsets, max(pos, 0))
+	m.lastEnd, m.found = -1, false
+}
+
+// stepAborted は、Step や ResumableMatch で中断している照合を中止するときに、照合を抜け出すために使うパニックの値です。
+type stepAborted struct{}
+
+// Step は、次のマッチの検索を命令1つ分だけ実行し、実行した命令とその時点の入力の位置、バックトラックスタックの深さを返します。
+// 返す値は Trace と同じ TraceStep です。
+//
+// Step を繰り返し呼び出すと、1回の Next と同じ検索を少しずつ進めます。検索が終わると、命令を実行せずに false を返し、
+// 見つけたマッチは Next の後と同じく Result で、エラーは Err で取り出せます。その次の Step は、続きのマッチの検索を始めます。
+// 検索の途中で Next を呼び出すとその検索を最後まで実行し、Reset や SetPos を呼び出すとその検索を中止します。
+func (m *Matcher) Step() (TraceStep, bool) {
+	if m.stepNext == nil {
+		m.stepNext, m.stepStop = iter.Pull(func(yield func(TraceStep) bool) {
+			defer func() {
+				m.trace = nil
+				if r := recover(); r != nil {
+					if _, ok := r.(stepAborted); !ok {
+						panic(r)
+					}
+				}
+			}()
+			m.trace = func(pc int) {
+				if !yield(m.traceStep(pc)) {
+					panic(stepAborted{})
+				}
+			}
+			m.next()
+		})
+	}
+	step, ok := m.stepNext()
+	if !ok {
+		m.stepNext, m.stepStop = nil, nil
+	}
+	return step, ok
+}
+
+// stopStepping は、Step で実行中の検索があれば中止します。
+func (m *Matcher) stopStepping() {
+	if m.stepStop != nil {
+		m.stepStop()
+		m.stepNext, m.stepStop = nil, nil
+		m.found = false
+	}
+}
@@ -0,0 +1,71 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "fmt"
+
+// ResultLimitError は、1回の操作の結果が Options.MaxMatches または Options.MaxCaptureBytes の上限を超えたため、
+// 操作を打ち切った場合のエラーです。
+type ResultLimitError struct {
+	Option string // 超えた上限の設定の名前（"MaxMatches" または "MaxCaptureBytes"）
+	Limit  int    // 上限の値
+}
+
+func (e *ResultLimitError) Error() string {
+	return fmt.Sprintf("操作の結果が上限（%s = %d）を超えました", e.Option, e.Limit)
+}
+
+// limitsResults は、1回の操作の結果に上限があるかどうかを返します。
+func (opts Options) limitsResults() bool {
+	return opts.MaxMatches > 0 || opts.MaxCaptureBytes > 0
+}
+
+// countResult は、直前のマッチを操作の結果に加えます。結果が上限を超える場合は *ResultLimitError を返します。
+// サブマッチの位置を記録しないマッチャーでは、マッチ全体の文字列だけを数えます。
+func (m *Matcher) countResult() error {
+	groups := 1
+	if m.needSubmatch {
+		groups = m.prog.numCaptures + 1
+	}
+	size := 0
+	for i := 0; i < groups; i++ {
+		if start, end := m.saved[2*i], m.saved[2*i+1]; start >= 0 && end >= start {
+			size += m.offsets[end] - m.offsets[start]
+		}
+	}
+
+	if max := m.prog.maxMatches; max > 0 && m.results+1 > max {
+		return &ResultLimitError{Option: "MaxMatches", Limit: max}
+	}
+	if max := m.prog.maxCaptureBytes; max > 0 && m.resultBytes+size > max {
+		return &ResultLimitError{Option: "MaxCaptureBytes", Limit: max}
+	}
+	m.results++
+	m.resultBytes += size
+	return nil
+}
+
+// FindAllStringErr は FindAllString と同様ですが、ステップ数の上限や結果の上限（Options.MaxMatches など）に達して
+// 照合を打ち切った場合は、それまでに見つけたマッチとエラーを返します。
+func (re *Regexp) FindAllStringErr(s string, n int) ([]string, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	var result []string
+	err := forEachStringMatch(re.prog, s, n, false, func(loc []int) {
+		result = append(result, s[loc[0]:loc[1]])
+	})
+	return result, err
+}
+
+// FindAllStringSubmatchIndexErr は FindAllStringSubmatchIndex と同様ですが、ステップ数の上限や結果の上限に達して
+// 照合を打ち切った場合は、それまでに見つけたマッチとエラーを返します。
+func (re *Regexp) FindAllStringSubmatchIndexErr(s string, n int) ([][]int, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	var result [][]int
+	err := forEachStringMatch(re.prog, s, n, true, func(loc []int) {
+		result = append(result, loc)
+	})
+	return result, err
+}
@@ -0,0 +1,23 @@
+//go:build !btregexp_ascii
+
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "regexp"
+
+// linearRegexp は、ASTを標準ライブラリの正規表現に変換して返します。
+// RE2 の構文で表せない場合や、バイト単位・正規化した入力で照合する必要がある場合は nil を返します。
+func linearRegexp(ast Node, opts Options) *regexp.Regexp {
+	if opts.Latin1 || normalizationOf(opts) != NoNormalization {
+		return nil
+	}
+	s, err := toSyntax(ast)
+	if err != nil {
+		return nil
+	}
+	re, err := regexp.Compile(s.String())
+	if err != nil {
+		return nil
+	}
+	return re
+}
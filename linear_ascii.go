@@ -0,0 +1,12 @@
+//go:build btregexp_ascii
+
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "regexp"
+
+// linearRegexp は、btregexp_ascii のビルドでは常に nil を返します。
+// 標準ライブラリのパターンの解析は Unicode の表を使うため、LinearFallback と CrossCheck は働きません。
+func linearRegexp(ast Node, opts Options) *regexp.Regexp {
+	return nil
+}
@@ -0,0 +1,235 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"strings"
+)
+
+// LineMatch は、行ごとに照合する FindLines、LineMatcher、ScanLines などが返す、1行の中のマッチです。
+type LineMatch struct {
+	Line   int64  // 行番号（1始まり）
+	Offset int64  // 行の先頭の、テキスト全体でのバイト位置
+	Text   string // 行（末尾の '\n' を除く）
+
+	// Matches は、行の中の各マッチと各グループの位置です（FindAllSubmatchIndex と同じ形式）。
+	// 位置は行の先頭からのバイト位置です。
+	Matches [][]int
+}
+
+// lineSplitter は、チャンクに分けて届くテキストを '\n' で区切り、改行を除いた各行を順に渡します。
+// 行の途中までのデータは、次のチャンクが届くまで保持します。テキストが '\n' で終わる場合、その後の空の行は渡しません。
+type lineSplitter struct {
+	partial []byte // 改行がまだ届いていない行の途中までのデータ
+	line    int64  // 次の行の番号
+	offset  int64  // 次の行の先頭の、テキスト全体でのバイト位置
+}
+
+// write は、テキストの続きのチャンクを受け取り、改行がそろった行ごとに f を呼び出します。
+// f に渡す行は、f から戻るまでだけ有効です。f が false を返した場合は、そこで止めて false を返します。
+func (ls *lineSplitter) write(chunk []byte, f func(line, offset int64, text []byte) bool) bool {
+	for len(chunk) > 0 {
+		i := bytes.IndexByte(chunk, '\n')
+		if i < 0 {
+			ls.partial = append(ls.partial, chunk...)
+			return true
+		}
+		text := chunk[:i]
+		if len(ls.partial) > 0 {
+			text = append(ls.partial, text...)
+		}
+		ls.partial = ls.partial[:0]
+		if !ls.emit(text, 1, f) {
+			return false
+		}
+		chunk = chunk[i+1:]
+	}
+	return true
+}
+
+// flush は、改行で終わっていない最後の行があれば f を呼び出します。テキストが終わったときに呼び出します。
+func (ls *lineSplitter) flush(f func(line, offset int64, text []byte) bool) bool {
+	if len(ls.partial) == 0 {
+		return true
+	}
+	text := ls.partial
+	ls.partial = ls.partial[:0]
+	return ls.emit(text, 0, f)
+}
+
+// emit は、区切りの改行 newline バイトを除いた1行を f に渡し、行番号と位置を次の行に進めます。
+func (ls *lineSplitter) emit(text []byte, newline int64, f func(line, offset int64, text []byte) bool) bool {
+	line, offset := ls.line, ls.offset
+	ls.line++
+	ls.offset += int64(len(text)) + newline
+	return f(line, offset, text)
+}
+
+// readLines は、r から読み取ったテキストを lineSplitter で行に分け、行ごとに f を呼び出します。
+// r が io.EOF を返すまで読み続け、読み取りのエラーがあれば、読み取れた最後の行を渡してからそのエラーを返します（io.EOF の場合は nil）。
+// f が false を返した場合は、読み取りを止めて nil を返します。
+func readLines(r io.Reader, f func(line, offset int64, text []byte) bool) error {
+	br := bufio.NewReader(r)
+	ls := lineSplitter{line: 1}
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if !ls.write(chunk, f) {
+			return nil
+		}
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			ls.flush(f)
+			return nil
+		default:
+			ls.flush(f)
+			return err
+		}
+	}
+}
+
+// FindLines は、テキストを '\n' で区切った各行を別々の入力として照合し、マッチした行ごとに最初のマッチを返します。
+// マッチしなかった行は含みません。テキストが '\n' で終わる場合、その後の空の行は照合しません。
+//
+// 各行を1つの入力として照合するため、Multiline の設定にかかわらず ^ と $ は行の先頭と末尾にマッチし、
+// マッチが行をまたぐことはありません。
+func (re *Regexp) FindLines(s string) []LineMatch {
+	return re.FindAllLines(s, 1)
+}
+
+// FindAllLines は、FindLines と同じく各行を別々の入力として照合し、マッチした行ごとに、
+// 行の中の重ならないマッチを最大 n 個（n が負なら全て）返します。n が0なら nil を返します。
+func (re *Regexp) FindAllLines(s string, n int) []LineMatch {
+	if n == 0 {
+		return nil
+	}
+	var result []LineMatch
+	readLines(strings.NewReader(s), func(line, offset int64, text []byte) bool {
+		if m, ok := re.findLine(line, offset, text, n); ok {
+			result = append(result, m)
+		}
+		return true
+	})
+	return result
+}
+
+// FindAllLinesReader は、r から読み取ったテキストを FindAllLines と同じく1行ずつ照合し、
+// マッチした行を順に返すイテレータを返します。テキスト全体は保持しません。
+// 読み取りでエラーが発生した場合は、読み取れた最後の行を照合してから、そのエラーを返して終了します（io.EOF はエラーとしません）。
+func (re *Regexp) FindAllLinesReader(r io.Reader, n int) iter.Seq2[LineMatch, error] {
+	return func(yield func(LineMatch, error) bool) {
+		if n == 0 {
+			return
+		}
+		err := readLines(r, func(line, offset int64, text []byte) bool {
+			m, ok := re.findLine(line, offset, text, n)
+			return !ok || yield(m, nil)
+		})
+		if err != nil {
+			yield(LineMatch{}, err)
+		}
+	}
+}
+
+// findLine は、1行を照合し、行の中の重ならないマッチを最大 n 個（n が負なら全て）含む LineMatch を返します。
+// マッチしなければ false を返します。
+func (re *Regexp) findLine(line, offset int64, text []byte, n int) (LineMatch, bool) {
+	matches := re.FindAllSubmatchIndex(text, n)
+	if matches == nil {
+		return LineMatch{}, false
+	}
+	return LineMatch{Line: line, Offset: offset, Text: string(text), Matches: matches}, true
+}
+
+// LineRegexp は、CompileLine でコンパイルした、各行の先頭に固定して照合する正規表現です。
+//
+// ScanLines などは、各行を別々の入力として行の先頭から1回だけ照合します。
+// (?m)^ でテキスト全体を走査する場合と異なり、行の中の開始位置をずらして試行したり、
+// 改行を探しながら行頭の判定をしたりしないため、マッチしない行をすぐに読み飛ばせます。
+type LineRegexp struct {
+	re *Regexp
+}
+
+// CompileLine は、パターンを各行の先頭に固定する LineRegexp にコンパイルします。
+// パターンは行の先頭から始まる部分にだけマッチし、\A と ^ は行の先頭に、\z と $ は行の末尾にマッチします。
+func CompileLine(expr string) (*LineRegexp, error) {
+	return CompileLineWithOptions(expr, Options{})
+}
+
+// CompileLineWithOptions は、設定を指定して CompileLine と同じくコンパイルします。
+// Options.Anchored に AnchorEnd か AnchorBoth を指定すると、行全体に固定します。それ以外の指定は行の先頭に固定します。
+func CompileLineWithOptions(expr string, opts Options) (*LineRegexp, error) {
+	if opts.Anchored == AnchorEnd || opts.Anchored == AnchorBoth {
+		opts.Anchored = AnchorBoth
+	} else {
+		opts.Anchored = AnchorStart
+	}
+	re, err := CompileWithOptions(expr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &LineRegexp{re: re}, nil
+}
+
+// MustCompileLine は CompileLine と同様ですが、コンパイルに失敗した場合はパニックします。
+func MustCompileLine(expr string) *LineRegexp {
+	lr, err := CompileLine(expr)
+	if err != nil {
+		panic("regexp: CompileLine(" + quote(expr) + "): " + err.Error())
+	}
+	return lr
+}
+
+// Regexp は、行の先頭に固定してコンパイルした Regexp を返します。1行を照合する場合などに使います。
+func (lr *LineRegexp) Regexp() *Regexp {
+	return lr.re
+}
+
+// String は、コンパイル元のパターンを返します。
+func (lr *LineRegexp) String() string {
+	return lr.re.expr
+}
+
+// ScanLines は、テキストを '\n' で区切った各行を行の先頭から照合し、マッチした行を順に返すイテレータを返します。
+// LineMatch.Matches は、マッチと各グループの位置の1つだけです。行の区切り方は FindLines と同じです。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+// 照合を打ち切った場合はその行で終了します。エラーを確かめる場合は ScanLinesReader を使います。
+func (lr *LineRegexp) ScanLines(s string) iter.Seq[LineMatch] {
+	return func(yield func(LineMatch) bool) {
+		for m, err := range lr.ScanLinesReader(strings.NewReader(s)) {
+			if err != nil || !yield(m) {
+				return
+			}
+		}
+	}
+}
+
+// ScanLinesReader は、r から読み取ったテキストを ScanLines と同じく1行ずつ照合し、マッチした行を順に返すイテレータを返します。
+// マッチしなかった行は文字列に変換せずに読み飛ばします。
+// 読み取りでエラーが発生した場合は、読み取れた最後の行を照合してから、そのエラーを返して終了します（io.EOF はエラーとしません）。
+// ステップ数の上限などで行の照合を打ち切った場合は、その行でそのエラー（ErrStepLimitExceeded など）を返して終了します。
+func (lr *LineRegexp) ScanLinesReader(r io.Reader) iter.Seq2[LineMatch, error] {
+	return func(yield func(LineMatch, error) bool) {
+		prog := lr.re.prog
+		m := prog.getMatcher(true)
+		defer prog.putMatcher(m)
+		var matchErr error
+		err := readLines(r, func(line, offset int64, text []byte) bool {
+			m.resetBytes(text)
+			if !m.MatchStart(0) {
+				matchErr = m.err
+				return matchErr == nil
+			}
+			return yield(LineMatch{Line: line, Offset: offset, Text: string(text), Matches: [][]int{m.submatchIndex()}}, nil)
+		})
+		if matchErr != nil {
+			err = matchErr
+		}
+		if err != nil {
+			yield(LineMatch{}, err)
+		}
+	}
+}
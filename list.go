@@ -0,0 +1,46 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// CompileList は、r から1行に1つずつパターンを読み込み、CompileBundle で同じ設定でまとめてコンパイルします。
+//
+// 空白だけの行と、空白を除いた先頭が '#' の行はコメントとして読み飛ばします（'#' で始まるパターンは \# と書きます）。
+// 行末の "\r" は取り除きますが、それ以外の空白はパターンの一部です。
+// パターンごとの設定は、(?i) のようなインラインのフラグを先頭に付けて指定できます。
+// 戻り値の Bundle のパターンの番号は、コメントを除いて読み込んだ順で、Bundle.Line で行番号が分かります。
+//
+// コンパイルに失敗したパターンがあれば、CompileBundle と同じく残りのパターンの Bundle と BundleErrors を返します。
+// BundleErrors の各エラーの Line は、パターンの行番号です。読み取りでエラーが発生した場合は、そのエラーだけを返します。
+func CompileList(r io.Reader, opts Options) (*Bundle, error) {
+	var patterns []string
+	var lines []int
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		if trimmed := strings.TrimSpace(text); trimmed != "" && trimmed[0] != '#' {
+			patterns = append(patterns, text)
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	b, err := CompileBundle(patterns, opts)
+	b.lines = lines
+	if errs, ok := err.(BundleErrors); ok {
+		for _, e := range errs {
+			e.Line = lines[e.Index]
+		}
+	}
+	return b, err
+}
@@ -2,140 +2,459 @@
 package btregexp
 
 import (
+	"context"
 	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Matcher は、正規表現マッチングエンジンを表します。
 type Matcher struct {
-	prog            *program // コンパイルされた正規表現プログラム
-	input           []rune   // 入力文字列（Unicodeルーン配列）
-	pos             int      // 現在の入力位置
-	multiline       bool     // マルチラインモード
-	caseInsensitive bool     // 大文字小文字を区別しない
-	dotMatchesNL    bool     // ドットが改行にマッチする
-	startPos        int      // マッチ開始位置
-	captures        [][]int  // キャプチャグループの位置
-	saved           []int    // 保存された位置
-	maxSteps        int      // 最大実行ステップ数（無限ループ防止）
-	steps           int      // 現在の実行ステップ数
+	prog         *program                 // コンパイルされた正規表現プログラム
+	input        []rune                   // 入力文字列（Unicodeルーン配列）
+	pos          int                      // 現在の入力位置
+	startPos     int                      // マッチ開始位置
+	captures     [][]int                  // キャプチャグループの位置
+	saved        []int                    // 保存された位置
+	maxSteps     int                      // 最大実行ステップ数（無限ループ防止）
+	steps        int                      // 現在の実行ステップ数
+	err          error                    // 最後の実行で発生したエラー（ステップ数の超過など）
+	needSubmatch bool                     // サブマッチの位置が必要かどうか（falseならマッチ全体の位置のみ）
+	stack        []BacktrackPoint         // バックトラックスタック
+	trail        []TrailEntry             // スロット変更の取り消し記録
+	accepts      []trieAccept             // トライ照合用の作業領域
+	runes        []rune                   // 文字列入力をルーンに変換するための作業領域
+	offsets      []int                    // 各ルーンの入力内での開始バイト位置（末尾に入力のバイト長が続く）
+	inSegment    []bool                   // 正規化した入力で、各ルーンがセグメントの途中の文字かどうか（Flags.Normalization の場合のみ）
+	trace        func(pc int)             // 命令を実行するたびに呼び出す関数（Trace で使う。通常は nil）
+	hitEnd       bool                     // 直前の MatchStart が入力の末尾を調べたかどうか（入力が続けば結果が変わり得る）
+	resumed      bool                     // 次の命令がバックトラックして再開したものかどうか（Trace で使う）
+	totalSteps   int                      // この操作でこれまでの MatchStart が実行したステップ数の合計（SlowMatchHook で使う）
+	attempts     int                      // この操作で MatchStart を試行した回数（SlowMatchHook で使う）
+	matches      int                      // この操作で MatchStart が成功した回数（ObserveHook で使う）
+	began        time.Time                // この操作の開始時刻（SlowMatchHook で時間を判定する場合のみ）
+	maxEdits     int                      // 近似照合で許す編集の回数（FindFuzzy で使う。通常は0）
+	fold         bool                     // 大小文字を区別せずに照合するかどうか（MatchStringFold などで使う）
+	results      int                      // この操作で返したマッチの数（Options.MaxMatches で使う）
+	resultBytes  int                      // この操作で返したマッチと各グループの文字列の合計バイト数（Options.MaxCaptureBytes で使う）
+	ctx          context.Context          // 取り消されたら照合を打ち切るコンテキスト（FindAllContext で使う。通常は nil）
+	nextCtxCheck int                      // 次にコンテキストを確かめるステップ数（この操作での合計）
+	nextPos      int                      // Next が次に検索を始める位置（NewMatcher で作成した場合のみ使う）
+	lastEnd      int                      // Next が直前に返したマッチの終了位置（なければ-1）
+	found        bool                     // 直前の Next がマッチを見つけたかどうか
+	stepNext     func() (TraceStep, bool) // Step で実行中の検索の次の命令を取り出す関数（実行中でなければ nil）
+	stepStop     func()                   // Step で実行中の検索を中止する関数
+	more         func() bool              // 入力の末尾で続きの入力を待つ関数（ResumableMatch で使う。通常は nil）
+	alloc        Allocator                // バックトラックスタックと取り消し記録の領域を供給する Allocator（通常は nil）
+	keepTrail    bool                     // バックトラックポイントがなくても取り消し記録を残すかどうか（CaptureTree で使う）
 }
 
 // BacktrackPoint は、バックトラックするポイントを表します。
 type BacktrackPoint struct {
-	pc       int   // プログラムカウンタ
-	pos      int   // 入力位置
-	captures []int // キャプチャ状態
+	pc    int // プログラムカウンタ
+	pos   int // 入力位置
+	trail int // このポイントを作成した時点での取り消し記録の長さ
+	kind  backtrackKind
+	limit int // backtrackRunGreedy では最小位置、backtrackRunLazy では最大位置（-1は上限なし）
+	run   int // backtrackRunLazy の場合、文字を判定する InstrRun 命令の位置
+}
+
+// backtrackKind は、バックトラックポイントの種類を表します。
+type backtrackKind int
+
+const (
+	backtrackSingle    backtrackKind = iota // 1つの位置から再開
+	backtrackRunGreedy                      // 貪欲な InstrRun：位置を1つずつ戻しながら再開
+	backtrackRunLazy                        // 非貪欲な InstrRun：1文字ずつ追加で消費しながら再開
+	backtrackFuzzy                          // 近似照合：1文字の命令を編集（置換・削除・挿入）して再開
+)
+
+// TrailEntry は、キャプチャなどのスロットの変更前の値の記録（取り消し記録の1項目）です。
+// バックトラック時には記録を逆順にたどって値を元に戻します。Allocator が記録の領域を供給する場合に使います。
+type TrailEntry struct {
+	slot int // 変更されたスロット
+	old  int // 変更前の値
 }
 
 // newMatcher は、新しいマッチャーを作成します。
 func newMatcher(prog *program, input []rune) *Matcher {
 	// キャプチャグループ用の配列を初期化
-	// 各グループにつき2つの位置（開始と終了）が必要
-	numSlots := (prog.numCaptures + 1) * 2
-	saved := make([]int, numSlots)
+	// 各グループにつき2つの位置（開始と終了）が必要で、その後に繰り返しカウンタが続く
+	saved := make([]int, prog.numSlots())
 	for i := range saved {
 		saved[i] = -1 // 未初期化の位置は-1
 	}
 
-	// プログラムから情報を取得して設定
-	var multiline, caseInsensitive, dotMatchesNL bool
+	// マルチラインモードなどのフラグは、コンパイル時に各命令の引数に反映済み
+	return &Matcher{
+		prog:         prog,
+		input:        input,
+		pos:          0,
+		startPos:     0,
+		saved:        saved,
+		maxSteps:     1000000, // 最大実行ステップ数（適宜調整）
+		needSubmatch: true,
+		alloc:        prog.alloc,
+	}
+}
 
-	// プログラム内のフラグを確認
-	for _, instr := range prog.instrs {
-		// マルチラインモードを検出
-		if instr.Op == InstrBeginLine || instr.Op == InstrEndLine {
-			multiline = true
+// maxPooledInput は、プールに戻すマッチャーが保持し続ける入力用作業領域の最大ルーン数です。
+// これより大きな作業領域は、巨大な入力を一度処理しただけでメモリを占有し続けないよう手放します。
+const maxPooledInput = 64 << 10
+
+// getMatcher は、プログラムのプールからマッチャーを取り出します。
+// プールが空の場合は新しく作成します。使い終わったら putMatcher で戻します。
+//
+// needSubmatch が false の場合、マッチャーはサブマッチの位置を記録しません。
+// Match や FindIndex のようにマッチ全体の位置しか必要としない場合に使用し、
+// バックリファレンスから参照されないグループの保存処理とその取り消し記録を省略します。
+func (p *program) getMatcher(needSubmatch bool) *Matcher {
+	m, ok := p.matchers.Get().(*Matcher)
+	if !ok {
+		m = newMatcher(p, nil)
+		if mt := metrics.Load(); mt != nil {
+			count(mt.MatcherPoolMisses, 1)
 		}
+	}
+	m.needSubmatch = needSubmatch
+	m.err = nil
+	m.steps, m.totalSteps, m.attempts, m.matches = 0, 0, 0, 0
+	m.maxEdits = 0
+	m.fold = false
+	m.results, m.resultBytes = 0, 0
+	m.ctx, m.nextCtxCheck = nil, 0
+	if p.slowMatch != nil {
+		p.slowMatch.begin(m)
+	}
+	return m
+}
 
-		// 大文字小文字を区別しないモードを検出
-		if instr.Op == InstrCharClass && instr.CharClass != nil && instr.CharClass.caseInsensitive {
-			caseInsensitive = true
+// putMatcher は、マッチャーをプログラムのプールに戻します。
+func (p *program) putMatcher(m *Matcher) {
+	stats := m.operationStats()
+	if p.slowMatch != nil {
+		p.slowMatch.end(m, stats)
+	}
+	if p.observer != nil {
+		p.observer.end(stats)
+	}
+	countOperation(stats)
+	m.input = nil
+	if m.alloc != nil {
+		// Allocator が供給した領域は、その Allocator が回収できるよう手放す
+		m.stack, m.trail = nil, nil
+	}
+	if cap(m.runes) > maxPooledInput {
+		m.runes = nil
+		m.offsets = nil
+		m.inSegment = nil
+	}
+	p.matchers.Put(m)
+}
+
+// operationStats は、マッチャーを使った1回の操作の統計です。
+// SlowMatchHook と ObserveHook に渡す統計と、メトリクスのカウンタは、これをもとに作ります。
+type operationStats struct {
+	inputLen int   // 入力の長さ（文字列やバイト列の入力ならバイト数、それ以外はルーン数）
+	steps    int   // 実行した命令数
+	attempts int   // マッチを試行した開始位置の数
+	matches  int   // マッチに成功した試行の数
+	err      error // 照合を打ち切った場合のエラー
+}
+
+// operationStats は、この操作でこれまでにマッチャーが記録した統計を返します。
+func (m *Matcher) operationStats() operationStats {
+	stats := operationStats{
+		inputLen: len(m.input),
+		steps:    m.totalSteps + m.steps,
+		attempts: m.attempts,
+		matches:  m.matches,
+		err:      m.err,
+	}
+	if len(m.offsets) == len(m.input)+1 {
+		// 文字列やバイト列の入力なら、バイト数
+		stats.inputLen = m.offsets[len(m.input)]
+	}
+	return stats
+}
+
+// resetString は、文字列 s をルーンに変換してマッチャーの入力に設定し、
+// 各ルーンの開始バイト位置を記録します。
+// 変換先の領域はマッチャーに保持され、次回の呼び出しで再利用されます。
+func (m *Matcher) resetString(s string) {
+	if m.prog.normalization != NoNormalization {
+		m.resetNormalized(s, nil)
+		return
+	}
+	m.runes = m.runes[:0]
+	m.offsets = m.offsets[:0]
+	if m.prog.latin1 {
+		// Latin-1 モードでは、各バイトを1文字とする
+		for i := 0; i < len(s); i++ {
+			m.runes = append(m.runes, rune(s[i]))
+			m.offsets = append(m.offsets, i)
 		}
+		m.offsets = append(m.offsets, len(s))
+		m.input = m.runes
+		return
+	}
+	for i, r := range s {
+		m.runes = append(m.runes, r)
+		m.offsets = append(m.offsets, i)
+	}
+	m.offsets = append(m.offsets, len(s))
+	m.input = m.runes
+}
 
-		// ドットが改行にマッチするモードを検出
-		if instr.Op == InstrAnyChar && instr.Arg == 1 {
-			dotMatchesNL = true
+// resetBytes は、バイト列 b をルーンに変換してマッチャーの入力に設定し、
+// 各ルーンの開始バイト位置を記録します。
+// 不正なUTF-8のバイトは、文字列に対する range と同様に1バイトずつ utf8.RuneError として扱います。
+// Latin-1 モードでは、各バイトをそのまま1文字として扱います。
+func (m *Matcher) resetBytes(b []byte) {
+	if m.prog.normalization != NoNormalization {
+		m.resetNormalized("", b)
+		return
+	}
+	m.runes = m.runes[:0]
+	m.offsets = m.offsets[:0]
+	for i := 0; i < len(b); {
+		r, size := rune(b[i]), 1
+		if !m.prog.latin1 {
+			r, size = utf8.DecodeRune(b[i:])
 		}
+		m.runes = append(m.runes, r)
+		m.offsets = append(m.offsets, i)
+		i += size
 	}
+	m.offsets = append(m.offsets, len(b))
+	m.input = m.runes
+}
 
-	return &Matcher{
-		prog:            prog,
-		input:           input,
-		pos:             0,
-		multiline:       multiline,
-		caseInsensitive: caseInsensitive,
-		dotMatchesNL:    dotMatchesNL,
-		startPos:        0,
-		saved:           saved,
-		maxSteps:        1000000, // 最大実行ステップ数（適宜調整）
+// tooShort は、start 以降の残りの入力がマッチに必要な最小長に満たないかどうかを返します。
+func (m *Matcher) tooShort(start int) bool {
+	return len(m.input)-start < m.prog.minLen
+}
+
+// setSlot は、スロットに値を設定します。
+// バックトラックポイントが存在する場合は、元に戻せるよう変更前の値を記録します。
+func (m *Matcher) setSlot(slot, value int) {
+	if len(m.stack) > 0 || m.keepTrail {
+		if len(m.trail) == cap(m.trail) && m.alloc != nil && !m.growTrail() {
+			return
+		}
+		m.trail = append(m.trail, TrailEntry{slot: slot, old: m.saved[slot]})
+	}
+	m.saved[slot] = value
+}
+
+// pushBacktrack は、バックトラックポイントをスタックに追加します。
+func (m *Matcher) pushBacktrack(pc, pos int) {
+	if len(m.stack) == cap(m.stack) && m.alloc != nil && !m.growStack() {
+		return
+	}
+	m.stack = append(m.stack, BacktrackPoint{pc: pc, pos: pos, trail: len(m.trail)})
+}
+
+// undoTrail は、取り消し記録を指定された長さまで巻き戻し、スロットを元の値に戻します。
+func (m *Matcher) undoTrail(length int) {
+	for i := len(m.trail) - 1; i >= length; i-- {
+		e := m.trail[i]
+		m.saved[e.slot] = e.old
+	}
+	m.trail = m.trail[:length]
+}
+
+// skipSave は、指定されたスロットへの保存を省略できるかどうかを返します。
+func (m *Matcher) skipSave(slot int) bool {
+	if m.needSubmatch {
+		return false
 	}
+	// マッチ全体のスロットへの保存は \zs と \ze によるもので、省略できない
+	group := slot / 2
+	return group != 0 && (group >= len(m.prog.backrefs) || !m.prog.backrefs[group])
 }
 
 // Match は、入力文字列のどこかで正規表現がマッチするかどうかを確認します。
 func (m *Matcher) Match() bool {
-	// マルチラインモードが設定されているかどうかを確認
-	if m.prog != nil && len(m.prog.instrs) > 0 {
-		// プログラムの最初の命令にマルチラインフラグが設定されているか確認
-		for _, instr := range m.prog.instrs {
-			if instr.Op == InstrBeginLine || instr.Op == InstrEndLine {
-				m.multiline = true
+	// 入力の各位置からマッチングを試行
+	return m.search(0)
+}
+
+// search は、from 以降の各位置から順にマッチを試行し、最初に見つかったマッチを記録します。
+func (m *Matcher) search(from int) bool {
+	return m.searchRange(from, len(m.input)+1)
+}
+
+// searchRange は、開始位置が [from, to) の範囲にあるマッチを先頭から順に試行し、
+// 最初に見つかったマッチを記録します。マッチ自体は to を越えて続いてもかまいません。
+func (m *Matcher) searchRange(from, to int) bool {
+	// 末尾に必要なリテラルがなければ、どの位置からもマッチしない
+	if m.cannotMatch() {
+		return false
+	}
+
+	// 末尾に固定されたパターンは、末尾に届かない位置から試行しても無駄
+	if first := m.firstStart(); from < first {
+		from = first
+	}
+
+	if to > len(m.input)+1 {
+		to = len(m.input) + 1
+	}
+	for start := from; start < to; start++ {
+		// 残りの入力が最小長に満たなければ、以降の位置でもマッチしない
+		if m.tooShort(start) {
+			break
+		}
+		// 先頭のリテラルのいずれかが始まる位置まで飛ばす
+		if ac := m.prog.startLiterals; ac != nil && !m.fold {
+			if start = ac.nextStart(m.input, start); start < 0 || start >= to {
 				break
 			}
 		}
-	}
-
-	// 入力の各位置からマッチングを試行
-	for start := 0; start <= len(m.input); start++ {
-		m.startPos = start
-		m.pos = start
-		// キャプチャ状態をリセット
-		for i := range m.saved {
-			m.saved[i] = -1
+		if m.MatchStart(start) {
+			return true
 		}
-		m.steps = 0
-
-		// 最初のキャプチャグループ（全体マッチ）の開始位置を設定
-		m.saved[0] = start
+		// ステップ数の上限に達した場合、それ以降の位置は試行しない
+		if m.err != nil {
+			return false
+		}
+	}
+	return false
+}
 
-		// 命令列を実行
-		if m.execute(0) {
-			// マッチした場合、最初のキャプチャグループの終了位置を設定
-			m.saved[1] = m.pos
+// cannotMatch は、入力がテキスト末尾に必要なリテラル接尾辞で終わっていないかどうかを返します。
+func (m *Matcher) cannotMatch() bool {
+	suffix := m.prog.endSuffix
+	if len(suffix) == 0 || m.fold {
+		return false
+	}
+	if len(m.input) < len(suffix) {
+		return true
+	}
+	tail := m.input[len(m.input)-len(suffix):]
+	for i, r := range suffix {
+		if tail[i] != r {
 			return true
 		}
 	}
 	return false
 }
 
+// encodeEndSuffix は、endSuffix を入力と同じ符号化（Latin-1 モードではバイト、それ以外では UTF-8）のバイト列にして返します。
+// 入力を正規化する場合や、不正なバイトと区別できない utf8.RuneError を含む場合は、入力と直接比べられないため空文字列を返します。
+func (p *program) encodeEndSuffix() string {
+	if p.normalization != NoNormalization {
+		return ""
+	}
+	buf := make([]byte, 0, len(p.endSuffix))
+	for _, r := range p.endSuffix {
+		switch {
+		case p.latin1 && r <= 0xff:
+			buf = append(buf, byte(r))
+		case !p.latin1 && r != utf8.RuneError:
+			buf = utf8.AppendRune(buf, r)
+		default:
+			return ""
+		}
+	}
+	return string(buf)
+}
+
+// rejectString は、文字列 s がテキスト末尾に必要なリテラル接尾辞で終わっていなければ、
+// s をルーンに変換せずに入力を空にして true を返します。統計のため、入力の長さだけは記録します。
+func (m *Matcher) rejectString(s string) bool {
+	suffix := m.prog.rawSuffix
+	if suffix == "" || strings.HasSuffix(s, suffix) {
+		return false
+	}
+	m.rejectInput(len(s))
+	return true
+}
+
+// rejectBytes は、rejectString のバイト列版です。
+func (m *Matcher) rejectBytes(b []byte) bool {
+	suffix := m.prog.rawSuffix
+	if suffix == "" || len(b) >= len(suffix) && string(b[len(b)-len(suffix):]) == suffix {
+		return false
+	}
+	m.rejectInput(len(b))
+	return true
+}
+
+// rejectInput は、マッチャーの入力を、長さ size バイトの入力を変換せずに棄却した状態にします。
+func (m *Matcher) rejectInput(size int) {
+	m.runes = m.runes[:0]
+	m.offsets = append(m.offsets[:0], size)
+	m.input = m.runes
+}
+
+// firstStart は、マッチが始まり得る最初の位置を返します。
+// テキスト末尾に固定され、最大長が有限のパターンでは、末尾から最大長より前の位置は試行不要です。
+func (m *Matcher) firstStart() int {
+	if !m.prog.endAnchored || m.prog.maxLen == unbounded || m.prog.maxLen >= len(m.input) {
+		return 0
+	}
+	return len(m.input) - m.prog.maxLen
+}
+
 // MatchStart は、入力文字列の指定位置から始まるマッチを確認します。
 func (m *Matcher) MatchStart(start int) bool {
-	if start < 0 || start > len(m.input) {
+	if start < 0 || start > len(m.input) || m.tooShort(start) || m.splitsSegment(start) {
 		return false
 	}
 
 	m.startPos = start
 	m.pos = start
+	m.err = nil
 	// キャプチャ状態をリセット
 	for i := range m.saved {
 		m.saved[i] = -1
 	}
+	m.totalSteps += m.steps
 	m.steps = 0
+	m.attempts++
+	m.hitEnd = false
 
 	// 最初のキャプチャグループ（全体マッチ）の開始位置を設定
 	m.saved[0] = start
+	if m.prog.editSlot > 0 {
+		m.saved[m.prog.editSlot] = 0
+	}
 
 	// 命令列を実行
-	if m.execute(0) {
-		// マッチした場合、最初のキャプチャグループの終了位置を設定
-		m.saved[1] = m.pos
+	if m.execute(m.prog.start) {
+		// マッチした場合、最初のキャプチャグループの終了位置を設定（\ze で設定済みならそのまま）
+		if m.saved[1] < 0 {
+			m.saved[1] = m.pos
+		}
+		// \ze が \zs より前にある場合は、空のマッチとする
+		if m.saved[1] < m.saved[0] {
+			m.saved[1] = m.saved[0]
+		}
+		m.matches++
 		return true
 	}
 	return false
 }
 
+// splitsSegment は、位置 pos が正規化した入力のセグメントの途中かどうかを返します。
+func (m *Matcher) splitsSegment(pos int) bool {
+	return pos < len(m.inSegment) && m.inSegment[pos]
+}
+
+// Err は、最後のマッチングで発生したエラーを返します。
+// ステップ数の上限に達してマッチングを打ち切った場合は ErrStepLimitExceeded を返します。
+// Allocator が作業領域を確保できずに打ち切った場合は ErrMemoryLimit を返します。
+// 単にマッチしなかった場合は nil を返します。
+func (m *Matcher) Err() error {
+	return m.err
+}
+
 // Captures は、最後のマッチで捕捉されたグループの位置を返します。
 func (m *Matcher) Captures() [][]int {
-	result := make([][]int, (len(m.saved)+1)/2)
+	result := make([][]int, m.prog.numCaptures+1)
 	for i := 0; i < len(result); i++ {
 		start := m.saved[i*2]
 		end := m.saved[i*2+1]
@@ -164,15 +483,28 @@ func (m *Matcher) CaptureTexts() []string {
 
 // execute は、命令列を実行します。
 func (m *Matcher) execute(pc int) bool {
-	// バックトラックスタック
-	var stack []BacktrackPoint
+	// バックトラックスタックと取り消し記録を初期化
+	m.stack = m.stack[:0]
+	m.trail = m.trail[:0]
 
 	for {
 		// 無限ループ防止
 		m.steps++
 		if m.steps > m.maxSteps {
+			m.err = ErrStepLimitExceeded
+			return false
+		}
+		if m.err != nil {
+			// Allocator が作業領域を確保できなかった
 			return false
 		}
+		if m.ctx != nil && m.totalSteps+m.steps >= m.nextCtxCheck {
+			m.nextCtxCheck = m.totalSteps + m.steps + contextCheckInterval
+			if err := m.ctx.Err(); err != nil {
+				m.err = err
+				return false
+			}
+		}
 
 		// プログラムの終了チェック
 		if pc >= len(m.prog.instrs) {
@@ -180,66 +512,104 @@ func (m *Matcher) execute(pc int) bool {
 		}
 
 		instr := m.prog.instrs[pc]
+		if m.trace != nil {
+			m.trace(pc)
+		}
+		if m.prog.profile != nil {
+			m.prog.profile.counts[pc].Add(1)
+		}
+
+		// 近似照合では、幅のない命令やマッチの前でも、入力の余分な1文字を飛ばせるようにする
+		if m.prog.editSlot > 0 && isZeroWidth(instr.Op) && m.pos < len(m.input) && m.saved[m.prog.editSlot] < m.maxEdits {
+			m.pushRun(backtrackFuzzy, pc, m.pos, editInsert, -1)
+		}
 
 		switch instr.Op {
 		case InstrMatch:
+			// 正規化した入力では、セグメントの途中で終わるマッチは認めない
+			if m.splitsSegment(m.pos) {
+				goto Backtrack
+			}
 			// マッチ成功
+			if m.prog.coverage != nil {
+				m.prog.coverage.record(m)
+			}
 			return true
 
-		case InstrChar:
+		case InstrChar, InstrAnyChar, InstrCharClass:
+			// 入力の末尾では、続きの入力が届けば同じ命令をやり直す
+			if m.pos >= len(m.input) && m.moreInput() {
+				continue
+			}
+
+			// 近似照合では、文字が一致しなかった場合に命令を編集して再開できるようにする
+			if m.prog.editSlot > 0 && m.saved[m.prog.editSlot] < m.maxEdits {
+				m.pushRun(backtrackFuzzy, pc, m.pos, editSubstitute, -1)
+			}
+
 			// 1文字マッチ
 			if m.pos >= len(m.input) {
 				// 入力終了
+				m.hitEnd = true
 				goto Backtrack
 			}
 
-			ch := m.input[m.pos]
-
-			matched := false
-			if m.caseInsensitive {
-				// 大文字小文字を無視して比較
-				matched = equalFoldRune(ch, instr.Char)
-			} else {
-				// 通常の比較
-				matched = (ch == instr.Char)
-			}
-
-			if !matched {
+			if !m.matchRune(instr.Op, &instr, m.input[m.pos]) {
 				goto Backtrack
 			}
 
 			m.pos++
 			pc = instr.Next
 
-		case InstrAnyChar:
-			// 任意の1文字マッチ
-			if m.pos >= len(m.input) {
-				// 入力終了
-				goto Backtrack
+		case InstrRun:
+			// 1文字要素の繰り返し：マッチする文字をまとめて消費
+			start := m.pos
+			limit := len(m.input)
+			if instr.Max >= 0 && start+instr.Max < limit {
+				limit = start + instr.Max
 			}
 
-			ch := m.input[m.pos]
-			// 改行にマッチするかどうか
-			if !m.dotMatchesNL && (ch == '\n' || ch == '\r') {
-				goto Backtrack
+			// 貪欲・所有的なら最大まで、非貪欲なら最小回数だけ消費
+			want := limit
+			if !instr.Greedy && !instr.Possessive {
+				want = min(start+instr.Min, limit)
+			}
+			end := start
+			for end < want && m.matchRune(instr.RunOp, &instr, m.input[end]) {
+				end++
+			}
+			m.steps += end - start
+			if end == len(m.input) && end == want && (instr.Max < 0 || end-start < instr.Max) {
+				// 入力が続けば、さらに消費できた（今すぐ続きが必要なら、届くのを待って同じ命令をやり直す）
+				if (instr.Greedy || instr.Possessive || end-start < instr.Min) && m.moreInput() {
+					continue
+				}
+				m.hitEnd = true
 			}
 
-			m.pos++
-			pc = instr.Next
-
-		case InstrCharClass:
-			// 文字クラスマッチ
-			if m.pos >= len(m.input) {
-				// 入力終了
+			if end-start < instr.Min {
+				// 最小回数に満たない
 				goto Backtrack
 			}
 
-			ch := m.input[m.pos]
-			if !instr.CharClass.matches(ch) {
-				goto Backtrack
+			switch {
+			case instr.Possessive:
+				// 所有的量指定子はバックトラックしない
+			case instr.Greedy:
+				// 1文字ずつ戻せる範囲を1つのポイントとして記録
+				if end > start+instr.Min {
+					m.pushRun(backtrackRunGreedy, instr.Next, end-1, start+instr.Min, pc)
+				}
+			default:
+				// 1文字ずつ追加で消費できるよう記録
+				max := -1
+				if instr.Max >= 0 {
+					max = start + instr.Max
+				}
+				m.pushRun(backtrackRunLazy, instr.Next, end, max, pc)
 			}
 
-			m.pos++
+			m.pos = end
 			pc = instr.Next
 
 		case InstrJump:
@@ -248,58 +618,139 @@ func (m *Matcher) execute(pc int) bool {
 
 		case InstrSplit:
 			// 条件分岐（バックトラックポイント）
-			if instr.Possessive {
-				// 所有的量指定子の場合、バックトラックしない
-				if instr.Greedy {
-					// 貪欲モード：最初の分岐を先に試す
-					pc = instr.Next
-				} else {
-					// 非貪欲モード：2番目の分岐を先に試す
-					pc = instr.Arg
-				}
+			var nextPC, altPC int
+			if instr.Greedy {
+				// 貪欲モード：最初の分岐を先に試す
+				nextPC = instr.Next
+				altPC = instr.Arg
 			} else {
-				// 通常の分岐
-				// バックトラックポイントをスタックに追加
-				savepoint := make([]int, len(m.saved))
-				copy(savepoint, m.saved)
-
-				var nextPC, altPC int
-				if instr.Greedy {
-					// 貪欲モード：最初の分岐を先に試す
-					nextPC = instr.Next
-					altPC = instr.Arg
-				} else {
-					// 非貪欲モード：2番目の分岐を先に試す
-					nextPC = instr.Arg
-					altPC = instr.Next
-				}
+				// 非貪欲モード：2番目の分岐を先に試す
+				nextPC = instr.Arg
+				altPC = instr.Next
+			}
 
-				// バックトラックポイントを保存
-				stack = append(stack, BacktrackPoint{
-					pc:       altPC,
-					pos:      m.pos,
-					captures: savepoint,
-				})
+			// バックトラックポイントを保存
+			m.pushBacktrack(altPC, m.pos)
 
-				pc = nextPC
-			}
+			pc = nextPC
 
 		case InstrSave:
 			// キャプチャグループの位置を保存
 			slot := instr.Arg
-			// 現在の位置を保存
-			m.saved[slot] = m.pos
+			// 参照されないグループの保存は省略できる
+			if !m.skipSave(slot) {
+				// 現在の位置を保存
+				m.setSlot(slot, m.pos)
+				// バックリファレンスから参照されるグループは、閉じた時点の範囲を別に記録する
+				if group := slot / 2; instr.SaveType == SaveEnd && m.prog.hasBackrefs && m.prog.backrefs[group] {
+					span := m.prog.spanBase() + group*2
+					m.setSlot(span, m.saved[slot-1])
+					m.setSlot(span+1, m.pos)
+				}
+			}
+			pc = instr.Next
+
+		case InstrRepeatInit:
+			// 繰り返しカウンタを初期化（最初の InstrRepeatCheck で0になる）
+			m.setSlot(m.prog.counterBase()+instr.Counter, -1)
+			pc = instr.Next
+
+		case InstrRepeatCheck:
+			// 繰り返しカウンタを進めて、続行するかどうかを判定
+			slot := m.prog.counterBase() + instr.Counter
+			count := m.saved[slot] + 1
+			m.setSlot(slot, count)
+
+			if count < instr.Min {
+				// 最小回数に達していなければ本体を必ず実行
+				pc = instr.Arg
+				break
+			}
+			if instr.Max != -1 && count >= instr.Max {
+				// 最大回数に達したら終了
+				pc = instr.Next
+				break
+			}
+
+			// 本体をもう一度実行するか、終了するかの分岐
+			nextPC, altPC := instr.Arg, instr.Next
+			if !instr.Greedy {
+				// 非貪欲モード：先に終了を試す
+				nextPC, altPC = altPC, nextPC
+			}
+			// バックトラックポイントを保存
+			m.pushBacktrack(altPC, m.pos)
+			pc = nextPC
+
+		case InstrAtomicBegin:
+			// 現在のバックトラックスタックの深さを記録
+			m.setSlot(m.prog.counterBase()+instr.Counter, len(m.stack))
+			pc = instr.Next
+
+		case InstrProgressMark:
+			// 繰り返し本体の開始位置を記録
+			m.setSlot(m.prog.counterBase()+instr.Counter, m.pos)
+			pc = instr.Next
+
+		case InstrCoverage:
+			// 通ったことを記録（バックトラックで取り消される）
+			m.setSlot(m.prog.counterBase()+instr.Counter, m.pos)
+			pc = instr.Next
+
+		case InstrProgressCheck:
+			// 本体が入力を消費していなければ、同じ繰り返しを続けても進まないので抜ける
+			if m.pos == m.saved[m.prog.counterBase()+instr.Counter] {
+				pc = instr.Arg
+			} else {
+				pc = instr.Next
+			}
+
+		case InstrAtomicEnd:
+			// グループ内で作られたバックトラックポイントを破棄し、以降はグループ内に戻らない
+			m.stack = m.stack[:m.saved[m.prog.counterBase()+instr.Counter]]
+			pc = instr.Next
+
+		case InstrTrie:
+			// リテラルの選択をトライで照合
+			var atEnd bool
+			if m.fold {
+				m.accepts, atEnd = instr.Trie.lookupFold(m.input, m.pos, m.accepts)
+			} else {
+				m.accepts, atEnd = instr.Trie.lookup(m.input, m.pos, m.accepts)
+			}
+			if atEnd {
+				if m.moreInput() {
+					continue
+				}
+				m.hitEnd = true
+			}
+			if len(m.accepts) == 0 {
+				goto Backtrack
+			}
+
+			// 優先度の低い選択肢から順にバックトラックポイントとして積む
+			for i := len(m.accepts) - 1; i > 0; i-- {
+				m.pushBacktrack(instr.Next, m.pos+m.accepts[i].length)
+			}
+
+			// 最も優先度の高い選択肢で続行
+			m.pos += m.accepts[0].length
 			pc = instr.Next
 
 		case InstrBackref:
 			// バックリファレンス
+			// （繰り返しの途中で開き直したグループは、最後に閉じた時点の範囲を参照する）
 			groupIdx := instr.Arg
-			startSlot := groupIdx * 2
+			startSlot := m.prog.spanBase() + groupIdx*2
 			endSlot := startSlot + 1
 
-			// 参照するグループがまだマッチしていない場合は失敗
-			if startSlot >= len(m.saved) || m.saved[startSlot] < 0 || m.saved[endSlot] < 0 {
-				goto Backtrack
+			// 参照するグループがまだマッチしていない場合は、設定に応じて失敗するか空文字列にマッチ
+			if groupIdx >= len(m.prog.backrefs) || m.saved[startSlot] < 0 || m.saved[endSlot] < 0 {
+				if !m.prog.unsetBackrefMatchesEmpty {
+					goto Backtrack
+				}
+				pc = instr.Next
+				continue
 			}
 
 			startPos := m.saved[startSlot]
@@ -308,6 +759,10 @@ func (m *Matcher) execute(pc int) bool {
 
 			// 入力の残りが短い場合は失敗
 			if m.pos+refLen > len(m.input) {
+				if m.moreInput() {
+					continue
+				}
+				m.hitEnd = true
 				goto Backtrack
 			}
 
@@ -323,6 +778,10 @@ func (m *Matcher) execute(pc int) bool {
 
 		case InstrWordBoundary:
 			// 単語境界
+			if m.pos == len(m.input) && m.moreInput() {
+				continue
+			}
+			m.hitEnd = m.hitEnd || m.pos == len(m.input)
 			atBoundary := isAtWordBoundary(m.input, m.pos)
 			if !atBoundary {
 				goto Backtrack
@@ -331,22 +790,48 @@ func (m *Matcher) execute(pc int) bool {
 
 		case InstrNonWordBoundary:
 			// 非単語境界
+			if m.pos == len(m.input) && m.moreInput() {
+				continue
+			}
+			m.hitEnd = m.hitEnd || m.pos == len(m.input)
 			atBoundary := isAtWordBoundary(m.input, m.pos)
 			if atBoundary {
 				goto Backtrack
 			}
 			pc = instr.Next
 
+		case InstrNotAfterWord:
+			// 直前が単語文字でない
+			if m.pos > 0 && isWordChar(m.input[m.pos-1]) {
+				goto Backtrack
+			}
+			pc = instr.Next
+
+		case InstrNotBeforeWord:
+			// 直後が単語文字でない
+			if m.pos == len(m.input) && m.moreInput() {
+				continue
+			}
+			m.hitEnd = m.hitEnd || m.pos == len(m.input)
+			if m.pos < len(m.input) && isWordChar(m.input[m.pos]) {
+				goto Backtrack
+			}
+			pc = instr.Next
+
 		case InstrBeginLine:
-			// 行頭
-			if m.pos > 0 && m.input[m.pos-1] != '\n' && m.input[m.pos-1] != '\r' && (m.pos != m.startPos || !m.multiline) {
+			// 行頭（マルチラインでなければテキスト先頭のみ）
+			if m.pos > 0 && (instr.Arg == 0 || !isLineBreak(m.input[m.pos-1], instr.Arg)) {
 				goto Backtrack
 			}
 			pc = instr.Next
 
 		case InstrEndLine:
-			// 行末
-			if m.pos < len(m.input) && m.input[m.pos] != '\n' && m.input[m.pos] != '\r' {
+			// 行末（マルチラインでなければテキスト末尾のみ）
+			if m.pos == len(m.input) && m.moreInput() {
+				continue
+			}
+			m.hitEnd = m.hitEnd || m.pos == len(m.input)
+			if m.pos < len(m.input) && (instr.Arg == 0 || !isLineBreak(m.input[m.pos], instr.Arg)) {
 				goto Backtrack
 			}
 			pc = instr.Next
@@ -360,6 +845,10 @@ func (m *Matcher) execute(pc int) bool {
 
 		case InstrEndText:
 			// テキスト末尾
+			if m.pos == len(m.input) && m.moreInput() {
+				continue
+			}
+			m.hitEnd = m.hitEnd || m.pos == len(m.input)
 			if m.pos != len(m.input) {
 				goto Backtrack
 			}
@@ -374,20 +863,112 @@ func (m *Matcher) execute(pc int) bool {
 
 	Backtrack:
 		// バックトラックポイントがあれば、そこから再開
-		if len(stack) > 0 {
-			bp := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-
-			pc = bp.pc
-			m.pos = bp.pos
-			copy(m.saved, bp.captures)
-		} else {
+		if !m.backtrack(&pc) {
 			// バックトラックポイントがなければ失敗
 			return false
 		}
+		m.resumed = m.trace != nil
 	}
 }
 
+// moreInput は、入力の末尾に達したときに続きの入力を待ち、入力が増えた場合は true を返します。
+// ResumableMatch 以外では、続きの入力はないため常に false を返します。
+func (m *Matcher) moreInput() bool {
+	return m.more != nil && m.more()
+}
+
+// backtrack は、スタック上のバックトラックポイントから実行を再開できる状態に戻します。
+// 再開できるポイントがなければ false を返します。
+func (m *Matcher) backtrack(pc *int) bool {
+	for len(m.stack) > 0 {
+		bp := &m.stack[len(m.stack)-1]
+		m.undoTrail(bp.trail)
+
+		switch bp.kind {
+		case backtrackRunGreedy:
+			// 1文字戻した位置から再開し、まだ戻せるならポイントを残す
+			*pc = bp.pc
+			m.pos = bp.pos
+			if bp.pos > bp.limit {
+				bp.pos--
+			} else {
+				m.stack = m.stack[:len(m.stack)-1]
+			}
+			return true
+
+		case backtrackRunLazy:
+			// もう1文字消費できれば、その位置から再開
+			run := &m.prog.instrs[bp.run]
+			if bp.pos < len(m.input) && (bp.limit < 0 || bp.pos < bp.limit) &&
+				m.matchRune(run.RunOp, run, m.input[bp.pos]) {
+				bp.pos++
+				*pc = bp.pc
+				m.pos = bp.pos
+				return true
+			}
+			if bp.pos == len(m.input) && (bp.limit < 0 || bp.pos < bp.limit) {
+				if m.moreInput() {
+					continue
+				}
+				m.hitEnd = true
+			}
+			m.stack = m.stack[:len(m.stack)-1]
+
+		case backtrackFuzzy:
+			if m.resumeFuzzy(bp, pc) {
+				return true
+			}
+
+		default:
+			*pc = bp.pc
+			m.pos = bp.pos
+			m.stack = m.stack[:len(m.stack)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// pushRun は、InstrRun 用の範囲を表すバックトラックポイントをスタックに追加します。
+func (m *Matcher) pushRun(kind backtrackKind, pc, pos, limit, run int) {
+	if len(m.stack) == cap(m.stack) && m.alloc != nil && !m.growStack() {
+		return
+	}
+	m.stack = append(m.stack, BacktrackPoint{
+		pc:    pc,
+		pos:   pos,
+		trail: len(m.trail),
+		kind:  kind,
+		limit: limit,
+		run:   run,
+	})
+}
+
+// matchRune は、1文字にマッチする命令（種類は op）が文字 ch にマッチするかどうかを判定します。
+func (m *Matcher) matchRune(op InstrType, instr *Instr, ch rune) bool {
+	if m.fold {
+		return matchRuneFold(op, instr, ch)
+	}
+	switch op {
+	case InstrChar:
+		return ch == instr.Char
+	case InstrAnyChar:
+		// 改行にマッチするかどうか
+		return instr.Arg == 1 || (ch != '\n' && ch != '\r')
+	case InstrCharClass:
+		return instr.CharClass.matches(ch)
+	}
+	return false
+}
+
+// isLineBreak は、マルチラインの ^ と $（引数は arg）が、文字 r を行の区切りとみなすかどうかを返します。
+func isLineBreak(r rune, arg int) bool {
+	if arg == 2 {
+		return isLineTerminator(r)
+	}
+	return r == '\n'
+}
+
 // isAtWordBoundary は、指定された位置が単語境界かどうかを判定します。
 func isAtWordBoundary(input []rune, pos int) bool {
 	left := false
@@ -406,21 +987,72 @@ func isAtWordBoundary(input []rune, pos int) bool {
 
 // matchString は、文字列に対してマッチングを行います。
 func matchString(prog *program, s string) bool {
-	runes := []rune(s)
-	m := newMatcher(prog, runes)
+	if prog.linear != nil {
+		return prog.linear.MatchString(s)
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return false
+	}
+	m.resetString(s)
 	return m.Match()
 }
 
 // matchBytes は、バイト列に対してマッチングを行います。
 func matchBytes(prog *program, b []byte) bool {
-	s := string(b)
-	return matchString(prog, s)
+	if prog.linear != nil {
+		return prog.linear.Match(b)
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectBytes(b) {
+		return false
+	}
+	m.resetBytes(b)
+	return m.Match()
+}
+
+// matchStringErr は、文字列に対してマッチングを行い、マッチングを打ち切った場合はエラーも返します。
+func matchStringErr(prog *program, s string) (bool, error) {
+	if prog.linear != nil {
+		return prog.linear.MatchString(s), nil
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return false, nil
+	}
+	m.resetString(s)
+	matched := m.Match()
+	return matched, m.err
+}
+
+// matchBytesErr は、バイト列に対してマッチングを行い、マッチングを打ち切った場合はエラーも返します。
+func matchBytesErr(prog *program, b []byte) (bool, error) {
+	if prog.linear != nil {
+		return prog.linear.Match(b), nil
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectBytes(b) {
+		return false, nil
+	}
+	m.resetBytes(b)
+	matched := m.Match()
+	return matched, m.err
 }
 
 // matchReader は、Readerから読み取ったテキストに対してマッチングを行います。
 func matchReader(prog *program, r io.RuneReader) bool {
+	if prog.linear != nil {
+		return prog.linear.MatchReader(r)
+	}
 	var runes []rune
-	for {
+	if prog.latin1 {
+		runes = readLatin1(r)
+	}
+	for !prog.latin1 {
 		r, size, err := r.ReadRune()
 		if err != nil {
 			break
@@ -429,66 +1061,125 @@ func matchReader(prog *program, r io.RuneReader) bool {
 			runes = append(runes, r)
 		}
 	}
-	m := newMatcher(prog, runes)
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if prog.normalization != NoNormalization {
+		m.resetString(string(runes))
+		return m.Match()
+	}
+	m.input = runes
 	return m.Match()
 }
 
+// readLatin1 は、Latin-1 モード用に、Readerから読み取ったバイトをそれぞれ1文字として返します。
+// バイト単位で読めない Reader の場合は、読み取った文字をUTF-8のバイト列に戻して扱います。
+func readLatin1(r io.RuneReader) []rune {
+	var runes []rune
+	if br, ok := r.(io.ByteReader); ok {
+		for {
+			b, err := br.ReadByte()
+			if err != nil {
+				return runes
+			}
+			runes = append(runes, rune(b))
+		}
+	}
+
+	var buf [utf8.UTFMax]byte
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return runes
+		}
+		for _, b := range buf[:utf8.EncodeRune(buf[:], c)] {
+			runes = append(runes, rune(b))
+		}
+	}
+}
+
 // findStringSubmatchIndex は、文字列内のマッチと各サブマッチの位置を返します。
 func findStringSubmatchIndex(prog *program, s string) []int {
-	runes := []rune(s)
+	loc, _ := findStringSubmatchIndexErr(prog, s)
+	return loc
+}
+
+// findStringSubmatchIndexErr は、文字列内のマッチと各サブマッチの位置を返し、
+// マッチングを打ち切った場合はエラーも返します。
+func findStringSubmatchIndexErr(prog *program, s string) ([]int, error) {
+	if prog.linear != nil {
+		return prog.linear.FindStringSubmatchIndex(s), nil
+	}
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return nil, nil
+	}
+	m.resetString(s)
 
 	// 各位置からマッチを試行
-	for start := 0; start <= len(runes); start++ {
-		m := newMatcher(prog, runes)
-		if m.MatchStart(start) {
-			// マッチした場合、キャプチャグループの位置を返す
-			caps := m.Captures()
-			result := make([]int, len(caps)*2)
-			for i, cap := range caps {
-				if cap[0] >= 0 && cap[1] >= 0 {
-					// ルーンインデックスからバイト位置に変換
-					startBytes := runeSliceIndex(s, cap[0])
-					endBytes := runeSliceIndex(s, cap[1])
-					result[i*2] = startBytes
-					result[i*2+1] = endBytes
-				} else {
-					result[i*2] = -1
-					result[i*2+1] = -1
-				}
-			}
-			return result
-		}
+	if !m.search(0) {
+		return nil, m.err
+	}
+	return m.submatchIndex(), nil
+}
+
+// findSubmatchIndex は、バイト列内のマッチと各サブマッチの位置を返します。
+func findSubmatchIndex(prog *program, b []byte) []int {
+	if prog.linear != nil {
+		return prog.linear.FindSubmatchIndex(b)
+	}
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+	if m.rejectBytes(b) {
+		return nil
 	}
+	m.resetBytes(b)
 
-	return nil
+	// 各位置からマッチを試行
+	if !m.search(0) {
+		return nil
+	}
+	return m.submatchIndex()
 }
 
 // findStringSubmatch は、文字列内のマッチと各サブマッチのテキストを返します。
 func findStringSubmatch(prog *program, s string) []string {
-	runes := []rune(s)
+	indices := findStringSubmatchIndex(prog, s)
+	if indices == nil {
+		return nil
+	}
+	return submatchStrings(s, indices)
+}
 
-	// 各位置からマッチを試行
-	for start := 0; start <= len(runes); start++ {
-		m := newMatcher(prog, runes)
-		if m.MatchStart(start) {
-			return m.CaptureTexts()
+// submatchStrings は、各サブマッチの位置から部分文字列を切り出して返します。
+// マッチしなかったグループは空文字列になります。
+func submatchStrings(s string, indices []int) []string {
+	result := make([]string, len(indices)/2)
+	for i := range result {
+		if start, end := indices[i*2], indices[i*2+1]; start >= 0 {
+			result[i] = s[start:end]
 		}
 	}
-
-	return nil
+	return result
 }
 
 // findSubmatch は、バイト列内のマッチと各サブマッチを返します。
 func findSubmatch(prog *program, b []byte) [][]byte {
-	matches := findStringSubmatch(prog, string(b))
-	if matches == nil {
+	indices := findSubmatchIndex(prog, b)
+	if indices == nil {
 		return nil
 	}
+	return submatchBytes(b, indices)
+}
 
-	result := make([][]byte, len(matches))
-	for i, match := range matches {
-		if match != "" {
-			result[i] = []byte(match)
+// submatchBytes は、各サブマッチの位置から b の一部を切り出して返します（標準ライブラリと同じく複製しません）。
+// 容量を長さに切り詰めるため、切り出した部分に append しても b の続きは上書きされません。
+// マッチしなかったグループは nil になります。
+func submatchBytes(b []byte, indices []int) [][]byte {
+	result := make([][]byte, len(indices)/2)
+	for i := range result {
+		if start, end := indices[i*2], indices[i*2+1]; start >= 0 {
+			result[i] = b[start:end:end]
 		}
 	}
 	return result
@@ -496,87 +1187,230 @@ func findSubmatch(prog *program, b []byte) [][]byte {
 
 // findString は、文字列内の最初のマッチを返します。
 func findString(prog *program, s string) string {
-	matches := findStringSubmatch(prog, s)
-	if matches == nil || len(matches) == 0 {
+	loc := findStringIndex(prog, s)
+	if loc == nil {
 		return ""
 	}
-	return matches[0]
+	return s[loc[0]:loc[1]]
 }
 
 // find は、バイト列内の最初のマッチを返します。
 func find(prog *program, b []byte) []byte {
-	s := findString(prog, string(b))
-	if s == "" {
+	loc := findIndex(prog, b)
+	if loc == nil || loc[0] == loc[1] {
 		return nil
 	}
-	return []byte(s)
+	return b[loc[0]:loc[1]:loc[1]]
 }
 
 // findStringIndex は、文字列内のマッチの位置を返します。
 func findStringIndex(prog *program, s string) []int {
+	loc, _ := findStringIndexErr(prog, s)
+	return loc
+}
+
+// findStringIndexErr は、文字列内のマッチの位置を返し、マッチングを打ち切った場合はエラーも返します。
+func findStringIndexErr(prog *program, s string) ([]int, error) {
+	if prog.linear != nil {
+		return prog.linear.FindStringIndex(s), nil
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return nil, nil
+	}
+	m.resetString(s)
+
 	// 各位置からマッチを試行
-	runes := []rune(s)
-	for start := 0; start <= len(runes); start++ {
-		m := newMatcher(prog, runes)
-		if m.MatchStart(start) {
-			// マッチした場合、開始位置と終了位置を返す
-			caps := m.Captures()
-			if len(caps) > 0 && caps[0][0] >= 0 && caps[0][1] >= 0 {
-				// ルーンインデックスからバイト位置に変換
-				startIdx := runeSliceIndex(s, caps[0][0])
-				endIdx := runeSliceIndex(s, caps[0][1])
-				return []int{startIdx, endIdx}
-			}
-		}
+	if !m.search(0) {
+		return nil, m.err
 	}
-	return nil
+	return m.matchIndex(), nil
 }
 
 // findIndex は、バイト列内のマッチの位置を返します。
 func findIndex(prog *program, b []byte) []int {
-	return findStringIndex(prog, string(b))
+	if prog.linear != nil {
+		return prog.linear.FindIndex(b)
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectBytes(b) {
+		return nil
+	}
+	m.resetBytes(b)
+
+	// 各位置からマッチを試行
+	if !m.search(0) {
+		return nil
+	}
+	return m.matchIndex()
 }
 
-// runeSliceIndex は、文字列内のルーンインデックスに対応するバイトインデックスを返します。
-func runeSliceIndex(s string, runeIdx int) int {
-	if runeIdx <= 0 {
-		return 0
+// forEachMatch は、入力中の重ならないマッチを先頭から順に最大 n 個（n が負なら全て）見つけ、
+// マッチごとに f を呼び出します。f の中では m.saved からマッチの位置を参照できます。
+// 標準ライブラリと同様に、直前のマッチの直後に隣接する空マッチは無視します。
+func (m *Matcher) forEachMatch(n int, f func()) {
+	m.scan(0, len(m.input)+1, -1, n, f)
+}
+
+// forEachStringMatch は、文字列内の重ならないマッチを先頭から順に最大 n 個（n が負なら全て）見つけ、
+// マッチごとにその位置（バイト単位）を f に渡します。needSubmatch が true の場合は各サブマッチの位置も含めます。
+// ステップ数の上限や結果の上限に達して走査を打ち切った場合は、そのエラーを返します。
+func forEachStringMatch(prog *program, s string, n int, needSubmatch bool, f func(loc []int)) error {
+	if prog.linear != nil {
+		locs := prog.linear.FindAllStringIndex(s, n)
+		if needSubmatch {
+			locs = prog.linear.FindAllStringSubmatchIndex(s, n)
+		}
+		for _, loc := range locs {
+			f(loc)
+		}
+		return nil
 	}
 
-	// rune単位のインデックスをバイト単位のインデックスに変換
-	count := 0
-	for i := range s {
-		if count == runeIdx {
-			return i
+	m := prog.getMatcher(needSubmatch)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return nil
+	}
+	m.resetString(s)
+	m.forEachMatch(n, func() {
+		if needSubmatch {
+			f(m.submatchIndex())
+		} else {
+			f(m.matchIndex())
 		}
+	})
+	return m.err
+}
+
+// scan は、位置 pos から走査を始め、開始位置が to より前にある重ならないマッチを
+// 順に最大 n 個（n が負なら全て）見つけて、マッチごとに f を呼び出します。
+// prevEnd は直前のマッチの終了位置（なければ-1）で、これに隣接する空マッチは無視します。
+// 走査を続けるべき次の位置と、最後に採用したマッチの終了位置を返します。
+func (m *Matcher) scan(pos, to, prevEnd, n int, f func()) (int, int) {
+	for count := 0; pos < to && (n < 0 || count < n); {
+		if !m.searchRange(pos, to) {
+			return to, prevEnd
+		}
+		start, end := m.saved[0], m.saved[1]
+
+		// 試行した位置から文字を消費していない空マッチの後は、1文字進めて検索を続ける
+		// （\zs により試行した位置より後ろで空マッチした場合は、その位置から続ける）
+		pos = end
+		if start == end {
+			if end == m.startPos {
+				pos++
+			}
+			if start == prevEnd {
+				continue
+			}
+		}
+
+		if m.prog.maxMatches > 0 || m.prog.maxCaptureBytes > 0 {
+			if m.err = m.countResult(); m.err != nil {
+				return to, prevEnd
+			}
+		}
+		f()
 		count++
+		prevEnd = end
 	}
-	return len(s)
+	return pos, prevEnd
 }
 
-// equalFoldRune は、2つのruneが大文字小文字を区別せずに等しいかどうかを判定します。
-func equalFoldRune(r1, r2 rune) bool {
-	// 同じ文字ならtrue
-	if r1 == r2 {
-		return true
+// allIndex は、入力中の重ならないマッチの位置（バイト単位）を最大 n 個返します。
+// マッチャーがサブマッチを記録している場合は、各サブマッチの位置も含めます。
+func (m *Matcher) allIndex(n int) [][]int {
+	var result [][]int
+	m.forEachMatch(n, func() {
+		if m.needSubmatch {
+			result = append(result, m.submatchIndex())
+		} else {
+			result = append(result, m.matchIndex())
+		}
+	})
+	return result
+}
+
+// findAllStringIndex は、文字列内の重ならないマッチの位置（バイト単位）を最大 n 個返します。
+// マッチ全体の位置のみを記録し、サブマッチの位置は計算しません。
+func findAllStringIndex(prog *program, s string, n int) [][]int {
+	if prog.linear != nil {
+		return prog.linear.FindAllStringIndex(s, n)
 	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return nil
+	}
+	m.resetString(s)
+	return m.allIndex(n)
+}
 
-	// 小文字に変換して比較
-	r1Lower := toLowerRune(r1)
-	r2Lower := toLowerRune(r2)
-	return r1Lower == r2Lower
+// findAllIndex は、バイト列内の重ならないマッチの位置を最大 n 個返します。
+func findAllIndex(prog *program, b []byte, n int) [][]int {
+	if prog.linear != nil {
+		return prog.linear.FindAllIndex(b, n)
+	}
+	m := prog.getMatcher(false)
+	defer prog.putMatcher(m)
+	if m.rejectBytes(b) {
+		return nil
+	}
+	m.resetBytes(b)
+	return m.allIndex(n)
 }
 
-// toLowerRune は、runeを小文字に変換します。ライブラリ未使用の実装
-func toLowerRune(r rune) rune {
-	// ASCII範囲の大文字の場合
-	if 'A' <= r && r <= 'Z' {
-		return r + ('a' - 'A')
+// findAllStringSubmatchIndex は、文字列内の重ならないマッチと各サブマッチの位置を最大 n 個返します。
+func findAllStringSubmatchIndex(prog *program, s string, n int) [][]int {
+	if prog.linear != nil {
+		return prog.linear.FindAllStringSubmatchIndex(s, n)
 	}
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+	if m.rejectString(s) {
+		return nil
+	}
+	m.resetString(s)
+	return m.allIndex(n)
+}
 
-	// 以下は簡略化した実装です。完全なUnicode対応にはunicodeパッケージが必要
-	// ここでは英語のアルファベットのみサポート
-	return r
+// findAllSubmatchIndex は、バイト列内の重ならないマッチと各サブマッチの位置を最大 n 個返します。
+func findAllSubmatchIndex(prog *program, b []byte, n int) [][]int {
+	if prog.linear != nil {
+		return prog.linear.FindAllSubmatchIndex(b, n)
+	}
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+	if m.rejectBytes(b) {
+		return nil
+	}
+	m.resetBytes(b)
+	return m.allIndex(n)
+}
+
+// matchIndex は、直前のマッチ全体の位置をバイト単位で返します。
+func (m *Matcher) matchIndex() []int {
+	return []int{m.offsets[m.saved[0]], m.offsets[m.saved[1]]}
+}
+
+// submatchIndex は、直前のマッチ全体と各サブマッチの位置をバイト単位で返します。
+// マッチしなかったグループの位置は-1です。
+func (m *Matcher) submatchIndex() []int {
+	result := make([]int, (m.prog.numCaptures+1)*2)
+	for i := 0; i < len(result); i += 2 {
+		start, end := m.saved[i], m.saved[i+1]
+		if start >= 0 && end >= 0 {
+			result[i] = m.offsets[start]
+			result[i+1] = m.offsets[end]
+		} else {
+			result[i] = -1
+			result[i+1] = -1
+		}
+	}
+	return result
 }
 
 // トランスパイラの警告：isWordChar関数はcompiler.goで定義されているため、
@@ -0,0 +1,68 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "sync/atomic"
+
+// Counter は、Metrics の各カウンタです。expvar.Int はそのまま Counter として使えます。
+// Prometheus などの他の計測の仕組みには、Add を実装する小さな型で橋渡しします。
+// 複数のゴルーチンから同時に呼び出されます。
+type Counter interface {
+	Add(delta int64)
+}
+
+// Metrics は、パッケージ全体の照合とコンパイルの回数を数えるカウンタの組です。
+// SetMetrics で設定すると、すべての Regexp の操作でカウンタを加算するため、
+// 呼び出し箇所ごとに計測のコードを書く必要がありません。nil のカウンタは数えません。
+//
+// 照合の数は、このエンジンで照合した操作だけが対象で、LinearFallback で標準ライブラリに任せた操作は数えません。
+type Metrics struct {
+	Operations        Counter // このエンジンで照合した操作（MatchString や FindAllString などの呼び出し）の数
+	Attempts          Counter // マッチを試行した開始位置の数
+	Steps             Counter // 実行した命令の数
+	StepLimitHits     Counter // ステップ数の上限に達して照合を打ち切った操作の数
+	MatcherPoolMisses Counter // 再利用できるマッチャーがなく、新しく作成した数
+	Compiles          Counter // パターンのコンパイルの数（失敗したものを含む）
+	CompileErrors     Counter // コンパイルに失敗した数
+}
+
+// metrics は、SetMetrics で設定したカウンタです。
+var metrics atomic.Pointer[Metrics]
+
+// SetMetrics は、パッケージ全体で使うカウンタを設定します。nil を指定すると数えるのをやめます。
+// 設定した後で m のフィールドを変更してはいけません。
+func SetMetrics(m *Metrics) {
+	metrics.Store(m)
+}
+
+// count は、カウンタが nil でなく delta が0でなければ加算します。
+func count(c Counter, delta int) {
+	if c != nil && delta != 0 {
+		c.Add(int64(delta))
+	}
+}
+
+// countOperation は、マッチャーを使った1回の操作の統計をカウンタに加算します。
+func countOperation(stats operationStats) {
+	mt := metrics.Load()
+	if mt == nil {
+		return
+	}
+	count(mt.Operations, 1)
+	count(mt.Attempts, stats.attempts)
+	count(mt.Steps, stats.steps)
+	if stats.err == ErrStepLimitExceeded {
+		count(mt.StepLimitHits, 1)
+	}
+}
+
+// countCompile は、パターンのコンパイルの結果をカウンタに加算します。
+func countCompile(err error) {
+	mt := metrics.Load()
+	if mt == nil {
+		return
+	}
+	count(mt.Compiles, 1)
+	if err != nil {
+		count(mt.CompileErrors, 1)
+	}
+}
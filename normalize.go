@@ -0,0 +1,67 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// NormalizationForm は、照合の前にパターンと入力に適用する Unicode 正規化の形式です。
+//
+// 照合は、どちらの形式を指定しても合成済みの文字にそろえたうえで、結合文字の並び（セグメント）を単位として行います。
+// . や文字クラスはセグメント全体にマッチし、マッチがセグメントの途中で始まったり終わったりすることはありません。
+// そのため、NFC と NFD のどちらを指定しても結果は同じです。
+type NormalizationForm int
+
+const (
+	NoNormalization NormalizationForm = iota // 正規化しない
+	NFC                                      // 正規化形式C（合成済みの文字にそろえる）
+	NFD                                      // 正規化形式D（基底文字と結合文字の並びにそろえる）
+)
+
+// segmentAtom は、入力を正規化して照合する場合に、1文字の要素 atom をセグメント全体にマッチする要素に書き換えます。
+//
+//   - 文字は、パターンで後に続く結合文字とまとめて1つの要素にする（量指定子はまとめた全体に掛かる）
+//   - . と否定の文字クラスは、結合文字でない1文字と、それに続く結合文字をすべて消費する
+//   - 否定でない文字クラスは、結合文字を除く（後に結合文字が続く文字にはマッチしない）
+func (p *Parser) segmentAtom(atom Node) (Node, error) {
+	switch n := atom.(type) {
+	case *CharNode:
+		nodes := []Node{n}
+		for isMark(p.peek()) {
+			nodes = append(nodes, &CharNode{r: p.next(), fold: n.fold})
+		}
+		if len(nodes) == 1 {
+			return n, nil
+		}
+		return &GroupNode{node: &ConcatNode{nodes: nodes}}, nil
+
+	case *AnyCharNode:
+		ranges := markRanges()
+		if !n.dotMatchesNewline {
+			ranges = append(ranges, runeRange{'\n', '\n'}, runeRange{'\r', '\r'})
+		}
+		return segmentOf(normalizeRanges(ranges)), nil
+
+	case *CharClassNode:
+		ranges, err := classRanges(n)
+		if err != nil {
+			return nil, err
+		}
+		if n.negate {
+			return segmentOf(normalizeRanges(append(ranges, markRanges()...))), nil
+		}
+		others := normalizeRanges(append(complementRanges(ranges), markRanges()...))
+		return &CharClassNode{classType: ClassCustom, ranges: complementRanges(others)}, nil
+	}
+	return atom, nil
+}
+
+// segmentOf は、除外する文字 excluded（結合文字を含む）以外の1文字と、それに続く結合文字をすべて消費する要素を返します。
+func segmentOf(excluded []runeRange) Node {
+	marks := &RepeatNode{
+		node:       &CharClassNode{classType: ClassCustom, ranges: markRanges()},
+		min:        0,
+		max:        -1,
+		possessive: true,
+	}
+	return &GroupNode{node: &ConcatNode{nodes: []Node{
+		&CharClassNode{classType: ClassCustom, negate: true, ranges: excluded},
+		marks,
+	}}}
+}
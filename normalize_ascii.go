@@ -0,0 +1,22 @@
+//go:build btregexp_ascii
+
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// btregexp_ascii のビルドでは正規化の表を持たないため、Unicode 正規化を指定したパターンはコンパイルできません。
+// 以下は、正規化を使わないこのビルドでの実装です。
+
+// resetNormalized は、正規化せずに入力を設定します。
+func (m *Matcher) resetNormalized(s string, b []byte) {
+	m.prog.normalization = NoNormalization
+	if b != nil {
+		m.resetBytes(b)
+		return
+	}
+	m.resetString(s)
+}
+
+// normalizeString は、文字列をそのまま返します。
+func normalizeString(s string) string {
+	return s
+}
@@ -0,0 +1,44 @@
+//go:build !btregexp_ascii
+
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "golang.org/x/text/unicode/norm"
+
+// resetNormalized は、文字列 s（s が空で b が指定されていればバイト列 b）を合成済みの文字にそろえながら
+// ルーンに変換してマッチャーの入力に設定します。
+//
+// 正規化は結合文字の並び（セグメント）ごとに行うため、元の入力の位置に対応するのはセグメントの境界だけです。
+// セグメントの先頭の文字にはセグメントの開始位置を、それ以外の文字には終了位置を記録し、
+// それ以外の文字の位置ではマッチを始めたり終えたりしないように inSegment に印を付けます。
+func (m *Matcher) resetNormalized(s string, b []byte) {
+	m.runes = m.runes[:0]
+	m.offsets = m.offsets[:0]
+	m.inSegment = m.inSegment[:0]
+
+	var it norm.Iter
+	if b != nil {
+		it.Init(norm.NFC, b)
+	} else {
+		it.InitString(norm.NFC, s)
+	}
+	for !it.Done() {
+		start := it.Pos()
+		seg := string(it.Next())
+		end := it.Pos()
+		offset, inner := start, false
+		for _, r := range seg {
+			m.runes = append(m.runes, r)
+			m.offsets = append(m.offsets, offset)
+			m.inSegment = append(m.inSegment, inner)
+			offset, inner = end, true
+		}
+	}
+	m.offsets = append(m.offsets, len(s)+len(b))
+	m.input = m.runes
+}
+
+// normalizeString は、文字列を合成済みの文字にそろえた文字列を返します。
+func normalizeString(s string) string {
+	return norm.NFC.String(s)
+}
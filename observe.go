@@ -0,0 +1,65 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// ObserveHook は、Options.Observe の設定です。
+// 1回の操作（MatchString や FindAllString などの呼び出し）がマッチを見つけた場合に OnMatch を、
+// マッチを見つけずに終えた場合（ステップ数の上限などで打ち切った場合を含む）に OnFail を呼び出します。
+type ObserveHook struct {
+	// ID は、ObserveStats に添えて渡す、パターンを識別する値です。規則の名前や番号などを指定します。
+	ID string
+
+	// OnMatch は、マッチを見つけた操作の統計を受け取る関数です。nil の場合は何もしません。
+	OnMatch func(ObserveStats)
+
+	// OnFail は、マッチを見つけなかった操作の統計を受け取る関数です。nil の場合は何もしません。
+	// SlowMatchHook と同じく照合したゴルーチンで同期的に呼び出すため、どちらの関数も軽い処理にとどめてください。
+	OnFail func(ObserveStats)
+}
+
+// ObserveStats は、ObserveHook に渡す、1回の操作の統計です。
+// SlowMatchStats と同じく、入力そのものは含めません。
+type ObserveStats struct {
+	ID       string // ObserveHook.ID
+	Pattern  string // パターン
+	InputLen int    // 入力の長さ（バイト単位）
+	Steps    int    // 実行した命令数（Run 命令がまとめて消費した文字も含む）
+	Attempts int    // マッチを試行した開始位置の数
+	Matches  int    // マッチに成功した試行の数（OnFail では0）
+
+	// Err は、照合を打ち切った場合のエラー（ErrStepLimitExceeded など）です。そうでなければ nil です。
+	Err error
+}
+
+// observer は、ObserveHook を設定したプログラムで、操作の結果を報告します。
+type observer struct {
+	pattern string
+	hook    ObserveHook
+}
+
+// newObserver は、hook が有効なら observer を返します。そうでなければ nil を返します。
+func newObserver(pattern string, hook ObserveHook) *observer {
+	if hook.OnMatch == nil && hook.OnFail == nil {
+		return nil
+	}
+	return &observer{pattern: pattern, hook: hook}
+}
+
+// end は、マッチャーを使う操作の終了時に、統計 stats に応じて OnMatch または OnFail を呼び出します。
+func (o *observer) end(stats operationStats) {
+	f := o.hook.OnFail
+	if stats.matches > 0 {
+		f = o.hook.OnMatch
+	}
+	if f == nil {
+		return
+	}
+	f(ObserveStats{
+		ID:       o.hook.ID,
+		Pattern:  o.pattern,
+		InputLen: stats.inputLen,
+		Steps:    stats.steps,
+		Attempts: stats.attempts,
+		Matches:  stats.matches,
+		Err:      stats.err,
+	})
+}
@@ -0,0 +1,317 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "reflect"
+
+// Optimize は、コンパイルの前にASTを、同じ意味でより単純な形に書き換えます。
+// 命令数とバックトラックの回数が減ります。CompileWithOptions はコンパイルの前に必ずこの書き換えを行います。
+//
+// 次の書き換えを行います。いずれも、マッチする文字列だけでなく、選択肢を試す順序やキャプチャの位置も変えません。
+//
+//   - 非キャプチャグループを取り除き、入れ子の連接を平らにし、要素が1つの連接をその要素に置き換える
+//   - キャプチャを含まない本体の入れ子の貪欲な量指定子をまとめる（(?:x*)+ や (?:x+)? を x* に、(?:x+)+ を x+ にする）
+//   - x{1} を x にする
+//   - 1文字だけの文字クラス（[a]）を文字にする
+//   - 選択で、前にあるものと同じ選択肢を取り除く（(?:x|x) を x にする）
+//
+// 渡したASTは書き換えられることがあります。
+func Optimize(node Node) Node {
+	switch n := node.(type) {
+	case *ConcatNode:
+		var nodes []Node
+		for _, child := range n.nodes {
+			child = Optimize(child)
+			if child == nil {
+				// 空のグループ（(?i) など）は空文字列にしかマッチしないため取り除く
+				continue
+			}
+			if concat, ok := child.(*ConcatNode); ok {
+				nodes = append(nodes, concat.nodes...)
+				continue
+			}
+			nodes = append(nodes, child)
+		}
+		if len(nodes) == 1 {
+			return nodes[0]
+		}
+		n.nodes = nodes
+		return n
+
+	case *AltNode:
+		var branches []Node
+		for _, branch := range flattenAlt(n) {
+			branch = Optimize(branch)
+			// 前にある選択肢と同じ選択肢は、試しても同じ結果になる
+			if !containsNode(branches, branch) {
+				branches = append(branches, branch)
+			}
+		}
+		return altOf(branches)
+
+	case *RepeatNode:
+		n.node = Optimize(n.node)
+		if n.min == 1 && n.max == 1 && !n.possessive {
+			return n.node
+		}
+		if inner, ok := n.node.(*RepeatNode); ok && simpleGreedyRepeat(n) && simpleGreedyRepeat(inner) && !containsCapture(inner.node) {
+			switch {
+			case n.min == 1 && inner.min == 1:
+				// (?:x+)+ は x+
+				n.min, n.max = 1, -1
+			case n.max == 1 && inner.max == 1:
+				// (?:x?)? は x?
+				n.min, n.max = 0, 1
+			default:
+				n.min, n.max = 0, -1
+			}
+			n.node = inner.node
+		}
+		return n
+
+	case *CaptureNode:
+		n.node = Optimize(n.node)
+		return n
+
+	case *GroupNode:
+		// グループは構文上の区切りにすぎない
+		return Optimize(n.node)
+
+	case *CharClassNode:
+		if n.classType == ClassCustom && !n.negate && len(n.ranges) == 1 && n.ranges[0].min == n.ranges[0].max {
+			return &CharNode{r: n.ranges[0].min, fold: n.fold}
+		}
+		return n
+
+	default:
+		return node
+	}
+}
+
+// simpleGreedyRepeat は、繰り返しが貪欲な *、+、? のいずれかかどうかを返します。
+func simpleGreedyRepeat(n *RepeatNode) bool {
+	if n.possessive || n.repeatType != RepeatGreedy {
+		return false
+	}
+	return (n.min == 0 || n.min == 1) && (n.max == -1 || (n.min == 0 && n.max == 1))
+}
+
+// containsCapture は、ノードがキャプチャグループを含むかどうかを返します。
+func containsCapture(node Node) bool {
+	switch n := node.(type) {
+	case *CaptureNode:
+		return true
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if containsCapture(child) {
+				return true
+			}
+		}
+	case *AltNode:
+		return containsCapture(n.left) || containsCapture(n.right)
+	case *RepeatNode:
+		return containsCapture(n.node)
+	case *GroupNode:
+		return containsCapture(n.node)
+	}
+	return false
+}
+
+// containsNode は、ノードのリストに node と同じ構造のノードがあるかどうかを返します。
+func containsNode(nodes []Node, node Node) bool {
+	for _, n := range nodes {
+		if reflect.DeepEqual(n, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// factorAlternations は、AST内のすべての選択について共通の接頭辞・接尾辞を括り出します。
+// 例えば abc|abd|abe は ab[cde] に、xab|yab は (?:x|y)ab に書き換えられます。
+//
+// 括り出すのはリテラル文字だけです。リテラルは入力のどこでも高々1通りにしかマッチしないため、
+// 書き換え後も選択肢を試す順序（最左優先の意味論）は変わりません。
+func factorAlternations(node Node) Node {
+	switch n := node.(type) {
+	case *AltNode:
+		branches := flattenAlt(n)
+		for i := range branches {
+			branches[i] = factorAlternations(branches[i])
+		}
+		return factorBranches(branches)
+
+	case *ConcatNode:
+		for i := range n.nodes {
+			n.nodes[i] = factorAlternations(n.nodes[i])
+		}
+		return n
+
+	case *RepeatNode:
+		n.node = factorAlternations(n.node)
+		return n
+
+	case *CaptureNode:
+		n.node = factorAlternations(n.node)
+		return n
+
+	case *GroupNode:
+		n.node = factorAlternations(n.node)
+		return n
+
+	default:
+		return node
+	}
+}
+
+// factorBranches は、選択肢のリストから共通部分を括り出した選択ノードを組み立てます。
+func factorBranches(branches []Node) Node {
+	if len(branches) == 1 {
+		return branches[0]
+	}
+
+	// 隣接する選択肢の共通接頭辞を括り出す
+	// （離れた選択肢をまとめると試行順序が変わるため、隣接するものだけを対象にする）
+	var factored []Node
+	for i := 0; i < len(branches); {
+		seqs := [][]Node{sequenceOf(branches[i])}
+		j := i + 1
+		for ; j < len(branches); j++ {
+			seq := sequenceOf(branches[j])
+			if commonLiteralPrefix(seqs[0], seq) == 0 {
+				break
+			}
+			seqs = append(seqs, seq)
+		}
+
+		if len(seqs) < 2 {
+			factored = append(factored, branches[i])
+			i = j
+			continue
+		}
+
+		// まとめた選択肢全体に共通する接頭辞の長さ
+		prefixLen := len(seqs[0])
+		for _, seq := range seqs[1:] {
+			if l := commonLiteralPrefix(seqs[0], seq); l < prefixLen {
+				prefixLen = l
+			}
+		}
+
+		// 接頭辞の後ろの部分を再帰的に括り出す
+		rests := make([]Node, len(seqs))
+		for k, seq := range seqs {
+			rests[k] = concatOf(seq[prefixLen:])
+		}
+		prefix := append([]Node{}, seqs[0][:prefixLen]...)
+		factored = append(factored, concatOf(append(prefix, factorBranches(rests))))
+		i = j
+	}
+
+	// 隣接する1文字の選択肢を文字クラスにまとめる
+	factored = mergeCharBranches(factored)
+	if len(factored) == 1 {
+		return factored[0]
+	}
+
+	// すべての選択肢に共通する接尾辞を括り出す
+	seqs := make([][]Node, len(factored))
+	for k, branch := range factored {
+		seqs[k] = sequenceOf(branch)
+	}
+	suffixLen := len(seqs[0])
+	for _, seq := range seqs[1:] {
+		if l := commonLiteralSuffix(seqs[0], seq); l < suffixLen {
+			suffixLen = l
+		}
+	}
+	if suffixLen > 0 {
+		heads := make([]Node, len(seqs))
+		for k, seq := range seqs {
+			heads[k] = concatOf(seq[:len(seq)-suffixLen])
+		}
+		suffix := seqs[0][len(seqs[0])-suffixLen:]
+		head := &GroupNode{node: factorBranches(heads)}
+		return concatOf(append([]Node{head}, suffix...))
+	}
+
+	return altOf(factored)
+}
+
+// mergeCharBranches は、隣接する1文字リテラルの選択肢を1つの文字クラスにまとめます。
+// 1文字の選択肢は互いに異なる文字にしかマッチしないため、まとめても試行順序は変わりません。
+func mergeCharBranches(branches []Node) []Node {
+	var result []Node
+	for i := 0; i < len(branches); {
+		var ranges []runeRange
+		j := i
+		for ; j < len(branches); j++ {
+			ch, ok := branches[j].(*CharNode)
+			if !ok || ch.fold {
+				break
+			}
+			ranges = append(ranges, runeRange{min: ch.r, max: ch.r})
+		}
+
+		if j-i >= 2 {
+			result = append(result, &CharClassNode{classType: ClassCustom, ranges: ranges})
+			i = j
+			continue
+		}
+		result = append(result, branches[i])
+		i++
+	}
+	return result
+}
+
+// sequenceOf は、ノードを連接の要素列として返します。
+func sequenceOf(node Node) []Node {
+	if concat, ok := node.(*ConcatNode); ok {
+		return concat.nodes
+	}
+	return []Node{node}
+}
+
+// concatOf は、要素列から連接ノードを組み立てます。要素が1つならそのノードを返します。
+func concatOf(nodes []Node) Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &ConcatNode{nodes: append([]Node(nil), nodes...)}
+}
+
+// altOf は、選択肢のリストから左結合の選択ノードを組み立てます。
+func altOf(branches []Node) Node {
+	node := branches[0]
+	for _, branch := range branches[1:] {
+		node = &AltNode{left: node, right: branch}
+	}
+	return node
+}
+
+// commonLiteralPrefix は、2つの要素列の先頭で共通するリテラル文字の数を返します。
+func commonLiteralPrefix(a, b []Node) int {
+	n := 0
+	for n < len(a) && n < len(b) && sameLiteral(a[n], b[n]) {
+		n++
+	}
+	return n
+}
+
+// commonLiteralSuffix は、2つの要素列の末尾で共通するリテラル文字の数を返します。
+func commonLiteralSuffix(a, b []Node) int {
+	n := 0
+	for n < len(a) && n < len(b) && sameLiteral(a[len(a)-1-n], b[len(b)-1-n]) {
+		n++
+	}
+	return n
+}
+
+// sameLiteral は、2つのノードが同じリテラル文字（大小文字の扱いも同じ）かどうかを返します。
+func sameLiteral(a, b Node) bool {
+	ca, ok := a.(*CharNode)
+	if !ok {
+		return false
+	}
+	cb, ok := b.(*CharNode)
+	return ok && ca.r == cb.r && ca.fold == cb.fold
+}
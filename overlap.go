@@ -0,0 +1,91 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// FindAllStringOverlapping は、s の中で正規表現にマッチする部分文字列を、互いに重なるものも含めてすべて返します。
+// FindAllString はマッチの終了位置から次のマッチを探すため、前のマッチと重なるマッチを返しませんが、
+// このメソッドは、マッチを試行し始めた位置の1文字後から次のマッチを探します。そのため、各開始位置のマッチを1つずつ返します。
+// 塩基配列の中のモチーフや、ログの中の関連するイベントの組のように、重なり合う出現をすべて数える場合に使います。
+// n が負の場合はすべてのマッチを返し、それ以外の場合は最大で n 個のマッチを返します。
+//
+// 各開始位置で採用するマッチは Find と同じく最左優先の規則で決めるため、同じ位置から始まるより短いマッチや長いマッチは返しません。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+func (re *Regexp) FindAllStringOverlapping(s string, n int) []string {
+	var result []string
+	for _, loc := range re.FindAllStringOverlappingIndex(s, n) {
+		result = append(result, s[loc[0]:loc[1]])
+	}
+	return result
+}
+
+// FindAllOverlapping は、FindAllStringOverlapping のバイト列版です。各要素は b の一部を複製せずに共有します。
+func (re *Regexp) FindAllOverlapping(b []byte, n int) [][]byte {
+	var result [][]byte
+	for _, loc := range re.FindAllOverlappingIndex(b, n) {
+		result = append(result, b[loc[0]:loc[1]:loc[1]])
+	}
+	return result
+}
+
+// FindAllStringOverlappingIndex は、FindAllStringOverlapping が返す各マッチの位置を返します。
+func (re *Regexp) FindAllStringOverlappingIndex(s string, n int) [][]int {
+	m := re.prog.getMatcher(false)
+	defer re.prog.putMatcher(m)
+	m.resetString(s)
+	return m.allOverlapping(n)
+}
+
+// FindAllOverlappingIndex は、FindAllStringOverlappingIndex のバイト列版です。
+func (re *Regexp) FindAllOverlappingIndex(b []byte, n int) [][]int {
+	m := re.prog.getMatcher(false)
+	defer re.prog.putMatcher(m)
+	m.resetBytes(b)
+	return m.allOverlapping(n)
+}
+
+// FindAllStringOverlappingSubmatch は、FindAllStringOverlapping の各マッチについて、マッチした文字列と各グループの文字列を返します。
+func (re *Regexp) FindAllStringOverlappingSubmatch(s string, n int) [][]string {
+	var result [][]string
+	for _, loc := range re.FindAllStringOverlappingSubmatchIndex(s, n) {
+		result = append(result, submatchStrings(s, loc))
+	}
+	return result
+}
+
+// FindAllStringOverlappingSubmatchIndex は、FindAllStringOverlapping の各マッチについて、
+// マッチと各グループの位置を返します（FindAllStringSubmatchIndex と同じ形式）。
+func (re *Regexp) FindAllStringOverlappingSubmatchIndex(s string, n int) [][]int {
+	m := re.prog.getMatcher(true)
+	defer re.prog.putMatcher(m)
+	m.resetString(s)
+	return m.allOverlapping(n)
+}
+
+// FindAllOverlappingSubmatchIndex は、FindAllStringOverlappingSubmatchIndex のバイト列版です。
+func (re *Regexp) FindAllOverlappingSubmatchIndex(b []byte, n int) [][]int {
+	m := re.prog.getMatcher(true)
+	defer re.prog.putMatcher(m)
+	m.resetBytes(b)
+	return m.allOverlapping(n)
+}
+
+// allOverlapping は、入力中のマッチを、試行し始めた位置の1文字後から次を探しながら最大 n 個（n が負なら全て）返します。
+// マッチャーがサブマッチを記録している場合は、各サブマッチの位置も含めます。結果の上限（Options.MaxMatches など）に達すると打ち切ります。
+func (m *Matcher) allOverlapping(n int) [][]int {
+	var result [][]int
+	for pos := 0; pos <= len(m.input) && (n < 0 || len(result) < n); pos = m.startPos + 1 {
+		if !m.searchRange(pos, len(m.input)+1) {
+			break
+		}
+		if m.prog.maxMatches > 0 || m.prog.maxCaptureBytes > 0 {
+			if m.err = m.countResult(); m.err != nil {
+				break
+			}
+		}
+		if m.needSubmatch {
+			result = append(result, m.submatchIndex())
+		} else {
+			result = append(result, m.matchIndex())
+		}
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"runtime"
+	"sync"
+)
+
+// DefaultParallelChunkSize は、ParallelOptions.ChunkSize が0の場合に使われるチャンクの大きさ（ルーン数）です。
+const DefaultParallelChunkSize = 1 << 20
+
+// ParallelOptions は、FindAllIndexParallel による並列走査の設定です。
+type ParallelOptions struct {
+	// Workers は、走査に使うゴルーチンの数です。0以下の場合は runtime.GOMAXPROCS(0) を使います。
+	Workers int
+
+	// ChunkSize は、各ゴルーチンが1度に受け持つ範囲の大きさ（ルーン数）です。
+	// 0以下の場合は DefaultParallelChunkSize を使います。
+	ChunkSize int
+}
+
+// matchSpan は、マッチの開始位置と終了位置（ルーン単位）を表します。
+type matchSpan struct {
+	start int
+	end   int
+}
+
+// chunkResult は、1つのチャンクを単独で走査した結果です。
+type chunkResult struct {
+	spans []matchSpan // 開始位置がチャンク内にあるマッチ
+	next  int         // 走査を終えた位置（チャンクの終端以降）
+}
+
+// FindAllIndexParallel は、FindAllIndex と同じ結果を、入力を複数のゴルーチンで分担して求めます。
+//
+// 入力はチャンクに分割され、各ゴルーチンは開始位置が自分のチャンク内にあるマッチを探します。
+// マッチはチャンクの境界を越えて続いてもかまいません（境界の前後で重なって照合します）。
+// 最後に先頭のチャンクから順に結果をつなぎ、前のチャンクのマッチが境界を越えた場合など
+// 走査の位置がずれた部分だけを逐次的に照合し直すため、結果は逐次的な走査と一致します。
+func (re *Regexp) FindAllIndexParallel(b []byte, n int, opts ParallelOptions) [][]int {
+	if n == 0 {
+		return nil
+	}
+	// 標準ライブラリに照合を任せる場合や、マッチの開始位置が試行した位置と異なり得る場合（\zs）は、
+	// 分割せずに走査する
+	if re.prog.linear != nil || re.prog.matchBounds {
+		return findAllIndex(re.prog, b, n)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	size := opts.ChunkSize
+	if size <= 0 {
+		size = DefaultParallelChunkSize
+	}
+
+	m := re.prog.getMatcher(false)
+	defer re.prog.putMatcher(m)
+	m.resetBytes(b)
+
+	// 開始位置の範囲 [0, len(input)] をチャンクに分割
+	numChunks := (len(m.input) + size) / size
+	if workers == 1 || numChunks == 1 {
+		return m.allIndex(n)
+	}
+	bounds := func(i int) (int, int) {
+		start, end := i*size, (i+1)*size
+		if end > len(m.input)+1 {
+			end = len(m.input) + 1
+		}
+		return start, end
+	}
+
+	// 各チャンクを並列に走査
+	results := make([]chunkResult, numChunks)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < numChunks; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 入力は共有し、状態だけを持つマッチャーをゴルーチンごとに用意する
+			wm := newMatcher(re.prog, m.input)
+			wm.needSubmatch = false
+			wm.alloc = m.alloc
+			for i := range jobs {
+				start, end := bounds(i)
+				var spans []matchSpan
+				next, _ := wm.scan(start, end, -1, -1, func() {
+					spans = append(spans, matchSpan{start: wm.saved[0], end: wm.saved[1]})
+				})
+				results[i] = chunkResult{spans: spans, next: next}
+			}
+		}()
+	}
+	for i := 0; i < numChunks; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// 先頭のチャンクから順に結果をつなぐ
+	var result [][]int
+	add := func(start, end int) {
+		result = append(result, []int{m.offsets[start], m.offsets[end]})
+	}
+	pos, prevEnd := 0, -1
+	for i, res := range results {
+		start, end := bounds(i)
+		if pos < start {
+			pos = start
+		}
+
+		k := 0
+		for pos < end && (n < 0 || len(result) < n) {
+			// 走査位置より前から始まるマッチは、実際の走査では現れない
+			for k < len(res.spans) && res.spans[k].start < pos {
+				k++
+			}
+
+			// チャンクの走査が pos を含む範囲をすでに同じ条件で調べていれば、残りの結果をそのまま使える
+			if res.syncedAt(k, start, pos, prevEnd) {
+				for _, span := range res.spans[k:] {
+					if n >= 0 && len(result) >= n {
+						break
+					}
+					if span.start == span.end && span.start == prevEnd {
+						// 直前のマッチに隣接する空マッチは無視
+						continue
+					}
+					add(span.start, span.end)
+					prevEnd = span.end
+				}
+				pos = res.next
+				break
+			}
+
+			// 位置がずれている間は、マッチを1つずつ逐次的に探す
+			pos, prevEnd = m.scan(pos, end, prevEnd, 1, func() {
+				add(m.saved[0], m.saved[1])
+			})
+		}
+	}
+
+	if n >= 0 && len(result) > n {
+		result = result[:n]
+	}
+	// 各ゴルーチンのマッチャーが見つけたマッチは、操作のマッチの数として数え直す
+	m.matches = len(result)
+	return result
+}
+
+// syncedAt は、チャンクの走査が k 番目のマッチを探し始めた状態と、
+// 位置 pos（直前のマッチの終了位置は prevEnd）から走査を続ける状態とで、
+// 以降に見つかるマッチが一致するかどうかを返します。chunkStart はチャンクの開始位置です。
+func (r *chunkResult) syncedAt(k, chunkStart, pos, prevEnd int) bool {
+	// チャンクの走査が k 番目のマッチを探し始めた位置と、その時点の直前のマッチの終了位置
+	scanPos, scanPrevEnd := chunkStart, -1
+	if k > 0 {
+		last := r.spans[k-1]
+		scanPos, scanPrevEnd = last.end, last.end
+		if last.start == last.end {
+			scanPos++
+		}
+	}
+
+	// チャンクの走査は [scanPos, 次のマッチの開始位置) にマッチがないことを確認済み。
+	// ちょうど同じ位置から始める場合は、隣接する空マッチを無視するかどうかも一致している必要がある
+	if scanPos < pos {
+		return true
+	}
+	return scanPos == pos && (scanPrevEnd == pos) == (prevEnd == pos)
+}
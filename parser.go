@@ -4,6 +4,7 @@ package btregexp
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -16,6 +17,15 @@ type Parser struct {
 	capNames    map[string]int // 名前付きキャプチャグループ名と番号のマッピング
 	subexpNames []string       // キャプチャグループの名前のリスト
 	flags       regexpFlags    // 現在有効なフラグ
+	backrefs    []*BackrefNode // 解析中に現れたバックリファレンス（参照先は解析の最後に解決する）
+	latin1      bool           // パターンの各バイトを1文字として扱うかどうか
+	dialect     Dialect        // パターンの構文の方言
+	maxCaptures int            // キャプチャグループの数の上限（0は無制限）
+	maxDepth    int            // グループの入れ子の深さの上限（0は無制限）
+	segments    bool           // 正規化した入力と照合するため、1文字の要素をセグメント（結合文字の並び）全体にマッチさせるかどうか
+
+	// パターン全体のキャプチャグループの数（JavaScript の方言で、\N が参照か8進エスケープかの判定に使う）
+	totalCaptures int
 }
 
 // regexpFlags は、正規表現のフラグを表します。
@@ -38,6 +48,14 @@ func newParser(input string) *Parser {
 // Parse は、正規表現パターンを解析して抽象構文木を構築します。
 // また、パース中に検出したフラグも返します。
 func (p *Parser) Parse() (Node, regexpFlags, error) {
+	// 量指定子の誤りは、位置を特定できるよう解析の前に検出する
+	if err := validateQuantifiers(p.input, p.dialect); err != nil {
+		return nil, p.flags, err
+	}
+	if p.dialect == DialectJavaScript {
+		p.totalCaptures = countCaptures(p.input)
+	}
+
 	// パターン全体の解析を開始
 	expr, err := p.parseExpr()
 	if err != nil {
@@ -49,75 +67,147 @@ func (p *Parser) Parse() (Node, regexpFlags, error) {
 		return nil, p.flags, fmt.Errorf("予期しない文字: %q", p.peek())
 	}
 
+	// 後方で定義されたグループへの参照も許すため、参照先はすべてのグループを見た後で解決する
+	if err := p.resolveBackrefs(); err != nil {
+		return nil, p.flags, err
+	}
+
 	return expr, p.flags, nil
 }
 
-// parseExpr は、トップレベルの式（正規表現の全体）をパースします。
-// 内部では選択演算子（|）を処理します。
-func (p *Parser) parseExpr() (Node, error) {
-	// 最初のサブ式を解析
-	left, err := p.parseConcat()
-	if err != nil {
-		return nil, err
+// resolveBackrefs は、パターン中のバックリファレンスの参照先を解決し、存在するかを検証します。
+// 名前付きの参照には、名前に対応するグループ番号を設定します。
+func (p *Parser) resolveBackrefs() error {
+	for _, ref := range p.backrefs {
+		if ref.name != "" {
+			index, ok := p.capNames[ref.name]
+			if !ok {
+				return fmt.Errorf("存在しない名前付きキャプチャグループへの参照: \\k<%s>", ref.name)
+			}
+			ref.index = index
+			continue
+		}
+		if ref.index > p.captures {
+			return fmt.Errorf("存在しないキャプチャグループへの参照: \\%d", ref.index)
+		}
 	}
+	return nil
+}
 
-	// 選択演算子（|）が続く場合、右側のサブ式も解析
-	for p.peek() == '|' {
-		p.next() // '|' を消費
-		right, err := p.parseConcat()
-		if err != nil {
-			return nil, err
-		}
-		// 左辺と右辺を選択ノードに組み立て
-		left = &AltNode{left: left, right: right}
+// groupFrame は、parseExpr が解析中のグループ（またはパターン全体）の状態です。
+// 入れ子のグループは再帰呼び出しではなく、開き括弧で積み、閉じ括弧で降ろすスタックで解析します。
+type groupFrame struct {
+	wrap     func(Node) Node // 閉じ括弧で、グループの内容からグループのノードを作成する関数
+	oldFlags regexpFlags     // グループの外側で有効なフラグ（グループの終わりで元に戻す）
+	alt      Node            // 直前の | までの選択肢を組み立てたノード（| がまだなければ nil）
+	nodes    []Node          // 解析中の選択肢の連接の各項
+}
+
+// concat は、解析中の選択肢の連接を返します。
+// 項が1つしかない場合は、そのノードをそのまま返します。
+func (f *groupFrame) concat() Node {
+	if len(f.nodes) == 1 {
+		return f.nodes[0]
 	}
+	return &ConcatNode{nodes: f.nodes}
+}
 
-	return left, nil
+// alternate は、選択演算子（|）までの選択肢を選択ノードに組み立て、次の選択肢の解析を始めます。
+func (f *groupFrame) alternate() {
+	if f.alt == nil {
+		f.alt = f.concat()
+	} else {
+		f.alt = &AltNode{left: f.alt, right: f.concat()}
+	}
+	f.nodes = nil
 }
 
-// parseConcat は、連接を解析します（例: "ab"）。
-// 連続する項目を連接ノードとして処理します。
-func (p *Parser) parseConcat() (Node, error) {
-	var nodes []Node
+// expr は、グループの内容全体のノードを返します。
+func (f *groupFrame) expr() Node {
+	if f.alt == nil {
+		return f.concat()
+	}
+	return &AltNode{left: f.alt, right: f.concat()}
+}
+
+// parseExpr は、トップレベルの式（正規表現の全体）をパースします。
+// 選択演算子（|）、連接、入れ子のグループを明示的なスタックで処理するため、入れ子がどれだけ深くても再帰しません。
+// 入れ子の深さの上限（maxDepth）は、スタックの安全のためではなく、後段の処理の負荷を抑える方針として検査します。
+func (p *Parser) parseExpr() (Node, error) {
+	cur := &groupFrame{}
+	var stack []*groupFrame
 
-	// 連接の各項を処理
 	for {
-		// 連接を終了する文字をチェック
-		r := p.peek()
-		if r == 0 || r == '|' || r == ')' {
-			break
+		var atom Node
+		switch r := p.peek(); r {
+		case '|':
+			p.next() // '|' を消費
+			cur.alternate()
+			continue
+
+		case 0, ')':
+			if len(stack) == 0 {
+				// 対応する開き括弧のない ')' は、呼び出し元で予期しない文字として扱う
+				return cur.expr(), nil
+			}
+			if r != ')' {
+				return nil, fmt.Errorf("閉じ括弧 ')' がありません")
+			}
+			p.next() // ')' を消費
+
+			// グループ内の (?i) などの効果はグループの終わりまで
+			p.flags = cur.oldFlags
+			atom = cur.wrap(cur.expr())
+			cur, stack = stack[len(stack)-1], stack[:len(stack)-1]
+
+		case '(':
+			if p.maxDepth > 0 && len(stack) >= p.maxDepth {
+				return nil, fmt.Errorf("%w: 上限 %d を超えました（位置 %d）", ErrNestingTooDeep, p.maxDepth, p.pos)
+			}
+			frame, node, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			if frame != nil {
+				// グループの内容は、閉じ括弧まで新しいフレームに集める
+				stack = append(stack, cur)
+				cur = frame
+				continue
+			}
+			atom = node
+
+		default:
+			// 基本的な要素（文字、文字クラスなど）を解析
+			node, err := p.parseAtom()
+			if err == nil && p.segments {
+				node, err = p.segmentAtom(node)
+			}
+			if err != nil {
+				return nil, err
+			}
+			atom = node
 		}
 
-		// 次の項を解析
-		item, err := p.parseTerm()
+		item, err := p.parseQuantifier(atom)
 		if err != nil {
 			return nil, err
 		}
-		nodes = append(nodes, item)
+		cur.nodes = append(cur.nodes, item)
 	}
-
-	// 連接ノードが1つしかない場合は、そのノードをそのまま返す
-	if len(nodes) == 1 {
-		return nodes[0], nil
-	}
-
-	// 複数のノードがある場合は、連接ノードを作成
-	return &ConcatNode{nodes: nodes}, nil
 }
 
-// parseTerm は、繰り返し演算子（*, +, ?, {n,m}）を含む単一の項を解析します。
-func (p *Parser) parseTerm() (Node, error) {
-	// 基本的な要素（文字、グループなど）を解析
-	atom, err := p.parseAtom()
-	if err != nil {
-		return nil, err
-	}
-
+// parseQuantifier は、要素 atom に続く繰り返し演算子（*, +, ?, {n,m}）を解析します。
+// 繰り返し演算子が続かない場合は、atom をそのまま返します。
+func (p *Parser) parseQuantifier(atom Node) (Node, error) {
 	// 繰り返し演算子が続くかチェック
 	switch p.peek() {
 	case '*', '+', '?':
 		return p.parseRepeat(atom)
 	case '{':
+		// JavaScript では、量指定子の形をしていない { は文字として扱う
+		if p.dialect == DialectJavaScript && !p.quantifierAhead() {
+			break
+		}
 		if pos := p.pos + 1; pos < len(p.input) {
 			// {n,m} 形式の範囲指定量指定子をチェック
 			if isDigit(rune(p.input[pos])) {
@@ -151,7 +241,7 @@ func (p *Parser) parseRepeat(node Node) (Node, error) {
 	if p.peek() == '?' {
 		p.next() // ? を消費
 		repeatType = RepeatNonGreedy
-	} else if p.peek() == '+' {
+	} else if p.peek() == '+' && p.dialect != DialectJavaScript {
 		p.next() // + を消費
 		// 所有的量指定子 (*+, ++, ?+) の実装
 		return &RepeatNode{
@@ -217,7 +307,7 @@ func (p *Parser) parseRepeatRange(node Node) (Node, error) {
 	if p.peek() == '?' {
 		p.next() // ? を消費
 		repeatType = RepeatNonGreedy
-	} else if p.peek() == '+' {
+	} else if p.peek() == '+' && p.dialect != DialectJavaScript {
 		p.next() // + を消費
 		possessive = true
 	}
@@ -259,7 +349,8 @@ func (p *Parser) parseNumber() (int, error) {
 	return n, nil
 }
 
-// parseAtom は、基本的な正規表現要素（文字、文字クラス、グループなど）を解析します。
+// parseAtom は、基本的な正規表現要素（文字、文字クラスなど）を解析します。
+// グループは、入れ子をスタックで扱うため parseExpr が解析します。
 func (p *Parser) parseAtom() (Node, error) {
 	r := p.peek()
 
@@ -267,39 +358,48 @@ func (p *Parser) parseAtom() (Node, error) {
 	case 0:
 		return nil, fmt.Errorf("予期しない入力終了")
 	case '|', '*', '+', '?', '}':
+		// JavaScript では、対応する { のない } は文字として扱う
+		if r == '}' && p.dialect == DialectJavaScript {
+			p.next()
+			return &CharNode{r: r}, nil
+		}
 		return nil, fmt.Errorf("予期しない文字: %c", r)
 	case '.':
 		p.next() // '.' を消費
+		if p.dialect == DialectJavaScript && !p.flags.dotMatchesNL {
+			// JavaScript の . は、\n と \r に加えて U+2028 と U+2029 にもマッチしない
+			return &CharClassNode{classType: ClassCustom, negate: true, ranges: jsLineTerminators}, nil
+		}
 		return &AnyCharNode{dotMatchesNewline: p.flags.dotMatchesNL}, nil
 	case '[':
 		return p.parseCharClass()
-	case '(':
-		return p.parseGroup()
 	case ')':
 		return nil, fmt.Errorf("閉じ括弧に対応する開き括弧がありません")
 	case '\\':
 		return p.parseEscape()
 	case '^':
 		p.next() // '^' を消費
-		return &BoundaryNode{nodeType: NodeBeginLine}, nil
+		return &BoundaryNode{nodeType: NodeBeginLine, multiline: p.flags.multiline, lineTerminators: p.dialect == DialectJavaScript}, nil
 	case '$':
 		p.next() // '$' を消費
-		return &BoundaryNode{nodeType: NodeEndLine}, nil
+		return &BoundaryNode{nodeType: NodeEndLine, multiline: p.flags.multiline, lineTerminators: p.dialect == DialectJavaScript}, nil
 	default:
 		p.next() // 文字を消費
-		return &CharNode{r: r}, nil
+		return &CharNode{r: r, fold: p.flags.caseInsensitive}, nil
 	}
 }
 
-// parseGroup は、括弧で囲まれたグループを解析します。
-func (p *Parser) parseGroup() (Node, error) {
+// parseGroup は、グループの開き括弧と、(?:、(?P<name> などのグループの指定を解析します。
+// 内容を持つグループでは、内容を集めるフレームを返します。内容は parseExpr が解析し、閉じ括弧でフレームからノードを作成します。
+// (?i) のように内容のないグループでは、閉じ括弧まで解析したノードを返します。
+func (p *Parser) parseGroup() (*groupFrame, Node, error) {
 	p.next() // '(' を消費
 
 	// グループタイプをチェック
 	if p.peek() == '?' {
 		p.next() // '?' を消費
 		if p.pos >= len(p.input) {
-			return nil, fmt.Errorf("グループの設定が不完全です")
+			return nil, nil, fmt.Errorf("グループの設定が不完全です")
 		}
 
 		// グループタイプを処理
@@ -307,56 +407,50 @@ func (p *Parser) parseGroup() (Node, error) {
 		case ':':
 			// 非キャプチャグループ (?:...)
 			p.next() // ':' を消費
-			expr, err := p.parseExpr()
-			if err != nil {
-				return nil, err
-			}
-			if p.peek() != ')' {
-				return nil, fmt.Errorf("閉じ括弧 ')' がありません")
-			}
-			p.next() // ')' を消費
-			return &GroupNode{node: expr}, nil
-
-		case 'P':
-			// 名前付きキャプチャグループ (?P<name>...)
-			return p.parseNamedCapture()
-
-		case 'i', 'm', 's', 'U':
-			// フラグ設定 (?i), (?m), (?s), (?U)
+			return &groupFrame{
+				wrap:     func(expr Node) Node { return &GroupNode{node: expr} },
+				oldFlags: p.flags,
+			}, nil, nil
+
+		case 'P', '<':
+			// 名前付きキャプチャグループ (?P<name>...) または (?<name>...)
+			frame, err := p.parseNamedCapture()
+			return frame, nil, err
+
+		case 'i', 'm', 's', 'U', '-':
+			// フラグ設定 (?i), (?m), (?s), (?U), (?-i) など
 			return p.parseFlags()
 
 		default:
-			return nil, fmt.Errorf("不明なグループ指定: %c", p.peek())
+			return nil, nil, fmt.Errorf("不明なグループ指定: %c", p.peek())
 		}
 	}
 
 	// 通常のキャプチャグループ
-	p.captures++
+	if err := p.addCapture(); err != nil {
+		return nil, nil, err
+	}
 	index := p.captures
 	p.subexpNames = append(p.subexpNames, "")
 
-	expr, err := p.parseExpr()
-	if err != nil {
-		return nil, err
-	}
-
-	if p.peek() != ')' {
-		return nil, fmt.Errorf("閉じ括弧 ')' がありません")
-	}
-	p.next() // ')' を消費
-
-	return &CaptureNode{
-		index: index,
-		node:  expr,
-	}, nil
+	return &groupFrame{
+		wrap:     func(expr Node) Node { return &CaptureNode{index: index, node: expr} },
+		oldFlags: p.flags,
+	}, nil, nil
 }
 
 // parseNamedCapture は、名前付きキャプチャグループ (?P<name>...) を解析します。
-func (p *Parser) parseNamedCapture() (Node, error) {
-	// "P<" を確認
-	if p.next() != 'P' || p.next() != '<' {
+func (p *Parser) parseNamedCapture() (*groupFrame, error) {
+	// "P<" または "<" を確認
+	if p.peek() == 'P' {
+		p.next() // 'P' を消費
+	}
+	if p.next() != '<' {
 		return nil, fmt.Errorf("無効な名前付きキャプチャグループ形式: (?P")
 	}
+	if r := p.peek(); r == '=' || r == '!' {
+		return nil, fmt.Errorf("後読みには対応していません: (?<%c", r)
+	}
 
 	// グループ名を解析
 	start := p.pos
@@ -384,31 +478,21 @@ func (p *Parser) parseNamedCapture() (Node, error) {
 	}
 
 	// キャプチャグループを登録
-	p.captures++
+	if err := p.addCapture(); err != nil {
+		return nil, err
+	}
 	index := p.captures
 	p.capNames[name] = index
 	p.subexpNames = append(p.subexpNames, name)
 
-	// グループの内容を解析
-	expr, err := p.parseExpr()
-	if err != nil {
-		return nil, err
-	}
-
-	if p.peek() != ')' {
-		return nil, fmt.Errorf("閉じ括弧 ')' がありません")
-	}
-	p.next() // ')' を消費
-
-	return &CaptureNode{
-		index: index,
-		name:  name,
-		node:  expr,
+	return &groupFrame{
+		wrap:     func(expr Node) Node { return &CaptureNode{index: index, name: name, node: expr} },
+		oldFlags: p.flags,
 	}, nil
 }
 
 // parseFlags は、正規表現のフラグを解析します。
-func (p *Parser) parseFlags() (Node, error) {
+func (p *Parser) parseFlags() (*groupFrame, Node, error) {
 	// フラグを読み取る
 	oldFlags := p.flags
 	onFlags, offFlags := p.parseModifiers()
@@ -446,31 +530,21 @@ func (p *Parser) parseFlags() (Node, error) {
 	if p.peek() == ':' {
 		p.next() // ':' を消費
 
-		// グループの内容を解析
-		expr, err := p.parseExpr()
-		if err != nil {
-			return nil, err
-		}
-
-		if p.peek() != ')' {
-			return nil, fmt.Errorf("閉じ括弧 ')' がありません")
-		}
-		p.next() // ')' を消費
-
-		// フラグを元に戻す（フラグの効果はこのグループ内だけ）
-		p.flags = oldFlags
-
-		return expr, nil
+		// フラグの効果はこのグループ内だけで、グループ自体はノードを作らない
+		return &groupFrame{
+			wrap:     func(expr Node) Node { return expr },
+			oldFlags: oldFlags,
+		}, nil, nil
 	}
 
 	// グループがない場合（(?i)）
 	if p.peek() != ')' {
-		return nil, fmt.Errorf("閉じ括弧 ')' がありません")
+		return nil, nil, fmt.Errorf("閉じ括弧 ')' がありません")
 	}
 	p.next() // ')' を消費
 
 	// 空のグループを返す（フラグ設定のみのグループは内容がない）
-	return &GroupNode{node: &ConcatNode{nodes: []Node{}}}, nil
+	return nil, &GroupNode{node: &ConcatNode{nodes: []Node{}}}, nil
 }
 
 // parseModifiers は、フラグ修飾子を解析します。
@@ -531,10 +605,15 @@ func (p *Parser) parseCharClass() (Node, error) {
 	node := &CharClassNode{
 		classType: ClassCustom,
 		negate:    negate,
+		fold:      p.flags.caseInsensitive,
 	}
 
 	// 文字クラスの内容を解析
 	for p.peek() != ']' && p.peek() != 0 {
+		if ranges, ok := p.parseClassEscape(); ok {
+			node.ranges = append(node.ranges, ranges...)
+			continue
+		}
 		min, err := p.parseClassAtom()
 		if err != nil {
 			return nil, err
@@ -544,8 +623,8 @@ func (p *Parser) parseCharClass() (Node, error) {
 		max := min
 		if p.peek() == '-' {
 			p.next() // '-' を消費
-			if p.peek() == ']' {
-				// ハイフンが文字クラスの最後にある場合、リテラルとして扱う
+			if p.peek() == ']' || p.classEscapeAhead() {
+				// ハイフンが文字クラスの最後にある場合や、[a-\d] のように範囲の端が \d などの場合、リテラルとして扱う
 				node.ranges = append(node.ranges, runeRange{min: min, max: min})
 				node.ranges = append(node.ranges, runeRange{min: '-', max: '-'})
 				continue
@@ -573,6 +652,36 @@ func (p *Parser) parseCharClass() (Node, error) {
 	return node, nil
 }
 
+// classEscapeAhead は、現在の位置から文字クラス内の \d、\w、\s、\D、\W、\S のいずれかが始まるかどうかを返します。
+// Vim の文字の集まりでは、これらは文字クラスを表さないため常に false を返します。
+func (p *Parser) classEscapeAhead() bool {
+	return p.dialect != DialectVim && p.pos+1 < len(p.input) && p.input[p.pos] == '\\' &&
+		strings.IndexByte("dwsDWS", p.input[p.pos+1]) >= 0
+}
+
+// parseClassEscape は、文字クラス内の \d、\w、\s とその否定を解析し、表す文字の範囲を返します（[\w.-] など）。
+// 現在の位置がこれらのエスケープでなければ、何も消費せずに false を返します。
+// [\w-z] のように範囲の端に置いた場合、JavaScript の Annex B と同じく、続く - は文字そのものとして扱います。
+func (p *Parser) parseClassEscape() ([]runeRange, bool) {
+	if !p.classEscapeAhead() {
+		return nil, false
+	}
+	p.next() // '\\' を消費
+	esc := p.next()
+	classType := ClassDigit
+	switch esc {
+	case 'w', 'W':
+		classType = ClassWord
+	case 's', 'S':
+		classType = ClassSpace
+	}
+	ranges, _ := classRanges(&CharClassNode{classType: classType})
+	if esc == 'D' || esc == 'W' || esc == 'S' {
+		ranges = complementRanges(ranges)
+	}
+	return ranges, true
+}
+
 // parseClassAtom は、文字クラス内の1文字またはエスケープシーケンスを解析します。
 func (p *Parser) parseClassAtom() (rune, error) {
 	r := p.peek()
@@ -589,6 +698,14 @@ func (p *Parser) parseClassAtom() (rune, error) {
 		}
 		p.next() // エスケープ文字を消費
 
+		// JavaScript の \u, \x, \c, \0 のエスケープ
+		switch esc {
+		case 'u', 'x', 'c', '0':
+			if p.dialect == DialectJavaScript {
+				return p.parseJSCharEscape(esc)
+			}
+		}
+
 		// 特殊文字のエスケープを処理
 		switch esc {
 		case 'n':
@@ -601,6 +718,9 @@ func (p *Parser) parseClassAtom() (rune, error) {
 			return '\f', nil
 		case 'v':
 			return '\v', nil
+		case 'b':
+			// 文字クラスの中の \b は、単語境界ではなくバックスペース（Perl や JavaScript と同じ）
+			return '\b', nil
 		default:
 			// それ以外はそのまま返す（\., \*, \[ など）
 			return esc, nil
@@ -623,10 +743,24 @@ func (p *Parser) parseEscape() (Node, error) {
 	r := p.peek()
 	p.next() // エスケープ文字を消費
 
+	if p.dialect == DialectJavaScript {
+		if node, ok, err := p.parseJSEscape(r); ok {
+			return node, err
+		}
+	}
+
+	// Vim の \zs と \ze（translateVim が書き換えたパターンにそのまま残る）
+	if p.dialect == DialectVim && r == 'z' && (p.peek() == 's' || p.peek() == 'e') {
+		if p.next() == 's' {
+			return &BoundaryNode{nodeType: NodeMatchStart}, nil
+		}
+		return &BoundaryNode{nodeType: NodeMatchEnd}, nil
+	}
+
 	switch r {
 	// メタ文字のエスケープ
 	case '.', '*', '+', '?', '|', '(', ')', '[', ']', '{', '}', '\\', '^', '$':
-		return &CharNode{r: r}, nil
+		return &CharNode{r: r, fold: p.flags.caseInsensitive}, nil
 
 	// よく使われるエスケープシーケンス
 	case 'n':
@@ -666,16 +800,18 @@ func (p *Parser) parseEscape() (Node, error) {
 
 	// バックリファレンス
 	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		index := int(r - '0')
-		if index > p.captures {
-			return nil, fmt.Errorf("存在しないキャプチャグループへの参照: \\%d", index)
-		}
-		return &BackrefNode{index: index}, nil
+		ref := &BackrefNode{index: int(r - '0')}
+		p.backrefs = append(p.backrefs, ref)
+		return ref, nil
 
 	// Unicodeプロパティ
 	case 'p', 'P':
 		isNegative := r == 'P'
 
+		if !unicodeTables {
+			return nil, fmt.Errorf("このビルド（btregexp_ascii）では Unicodeプロパティ \\%c に対応していません", r)
+		}
+
 		if p.peek() != '{' {
 			return nil, fmt.Errorf("Unicodeプロパティは \\p{...} 形式でなければなりません")
 		}
@@ -718,16 +854,14 @@ func (p *Parser) parseEscape() (Node, error) {
 		name := p.input[start:p.pos]
 		p.next() // '>' を消費
 
-		index, ok := p.capNames[name]
-		if !ok {
-			return nil, fmt.Errorf("存在しない名前付きキャプチャグループへの参照: \\k<%s>", name)
-		}
-
-		return &BackrefNode{index: index, name: name}, nil
+		// 番号は解析の最後に解決する
+		ref := &BackrefNode{name: name}
+		p.backrefs = append(p.backrefs, ref)
+		return ref, nil
 
 	default:
 		// その他のエスケープは単なる文字として扱う
-		return &CharNode{r: r}, nil
+		return &CharNode{r: r, fold: p.flags.caseInsensitive}, nil
 	}
 }
 
@@ -738,7 +872,7 @@ func (p *Parser) next() rune {
 		return 0
 	}
 
-	r, width := utf8.DecodeRuneInString(p.input[p.pos:])
+	r, width := p.decode()
 	p.width = width
 	p.pos += p.width
 	return r
@@ -750,10 +884,28 @@ func (p *Parser) peek() rune {
 		return 0
 	}
 
-	r, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	r, _ := p.decode()
 	return r
 }
 
+// decode は、現在の位置にある文字とそのバイト数を返します。
+// Latin-1 モードでは、1バイトを1文字とします。
+func (p *Parser) decode() (rune, int) {
+	if p.latin1 {
+		return rune(p.input[p.pos]), 1
+	}
+	return utf8.DecodeRuneInString(p.input[p.pos:])
+}
+
+// addCapture は、キャプチャグループを1つ数えます。数が上限を超える場合は ErrTooManyCaptures をラップしたエラーを返します。
+func (p *Parser) addCapture() error {
+	if p.maxCaptures > 0 && p.captures >= p.maxCaptures {
+		return fmt.Errorf("%w: 上限 %d を超えました", ErrTooManyCaptures, p.maxCaptures)
+	}
+	p.captures++
+	return nil
+}
+
 // isDigit は、rが数字かどうかを返します。
 func isDigit(r rune) bool {
 	return '0' <= r && r <= '9'
@@ -765,4 +917,51 @@ type Flags struct {
 	Multiline       bool // マルチラインモード
 	DotMatchesNL    bool // ドットが改行にもマッチ
 	Ungreedy        bool // デフォルトで非貪欲
+
+	// Normalization は、照合の前にパターンと入力に適用する Unicode 正規化の形式です。
+	// 指定すると、"é" が合成済みの文字でも結合文字の並びでもマッチするようになります。
+	// 入力を正規化するコストがかかるため、既定では正規化しません。Latin-1 モードでは無視されます。
+	Normalization NormalizationForm
+}
+
+// ParseFlags は、"ims" のようなフラグの文字の並びを Flags に変換します。
+// 文字はインラインのフラグと同じく、i（CaseInsensitive）、m（Multiline）、s（DotMatchesNL）、U（Ungreedy）です。
+// 文字の順序や重複は問いません。それ以外の文字を含む場合はエラーを返します。
+func ParseFlags(s string) (Flags, error) {
+	var f Flags
+	for _, r := range s {
+		switch r {
+		case 'i':
+			f.CaseInsensitive = true
+		case 'm':
+			f.Multiline = true
+		case 's':
+			f.DotMatchesNL = true
+		case 'U':
+			f.Ungreedy = true
+		default:
+			return Flags{}, fmt.Errorf("不明なフラグ %q: %q", r, s)
+		}
+	}
+	return f, nil
+}
+
+// String は、フラグを ParseFlags で読み取れる文字の並び（"imsU" の順）で返します。
+// Normalization は文字で表せないため含みません。
+func (f Flags) String() string {
+	var b []byte
+	for _, flag := range []struct {
+		on     bool
+		letter byte
+	}{
+		{f.CaseInsensitive, 'i'},
+		{f.Multiline, 'm'},
+		{f.DotMatchesNL, 's'},
+		{f.Ungreedy, 'U'},
+	} {
+		if flag.on {
+			b = append(b, flag.letter)
+		}
+	}
+	return string(b)
 }
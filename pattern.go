@@ -0,0 +1,31 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "regexp"
+
+// Pattern は、コンパイル済みの正規表現が提供する、文字列を対象とした操作の集まりです。
+// *Regexp と標準ライブラリの *regexp.Regexp のどちらもこのインターフェースを満たすため、
+// パターンごとに設定でエンジンを切り替えるアプリケーションは、型による分岐なしに両者を同じように扱えます。
+type Pattern interface {
+	MatchString(s string) bool
+	FindString(s string) string
+	FindStringIndex(s string) []int
+	FindStringSubmatch(s string) []string
+	FindStringSubmatchIndex(s string) []int
+	FindAllString(s string, n int) []string
+	FindAllStringIndex(s string, n int) [][]int
+	FindAllStringSubmatch(s string, n int) [][]string
+	FindAllStringSubmatchIndex(s string, n int) [][]int
+	ReplaceAllString(src, repl string) string
+	ReplaceAllLiteralString(src, repl string) string
+	Split(s string, n int) []string
+	NumSubexp() int
+	SubexpNames() []string
+	String() string
+}
+
+// どちらのエンジンも Pattern を満たすことをコンパイル時に確認する
+var (
+	_ Pattern = (*Regexp)(nil)
+	_ Pattern = (*regexp.Regexp)(nil)
+)
@@ -0,0 +1,412 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PCRETest は、PCRE のテストスイートの形式（pcre2test の testinput に結果を添えた testoutput）のファイルにある、
+// 1つのパターンとその照合の一覧です。ParsePCRETests で読み込み、RunPCRETests で照合します。
+type PCRETest struct {
+	Line      int           // パターンの行番号（1から）
+	Pattern   string        // 区切り文字を除いたパターン
+	Modifiers string        // 区切り文字の後の修飾子
+	Options   Options       // 修飾子から組み立てたオプション
+	Global    bool          // すべてのマッチを探すかどうか（修飾子 g）
+	WantError bool          // コンパイルに失敗するはずのパターンかどうか（結果が "Failed:" の行）
+	Subjects  []PCRESubject // 照合する文字列と期待する結果
+
+	// Skip は、対応していない修飾子や出力があるため照合しない理由です。照合する場合は空文字列です。
+	Skip string
+}
+
+// PCRESubject は、PCRETest の1つの照合です。
+type PCRESubject struct {
+	Line  int    // 行番号（1から）
+	Input string // エスケープを解釈した文字列
+
+	// Want は、期待する結果の行です。"0: abc" や "1: <unset>"、"No match" のように、前後の空白を除いた形で持ちます。
+	Want []string
+
+	// Skip は、対応していないエスケープや修飾子があるため照合しない理由です。照合する場合は空文字列です。
+	Skip string
+}
+
+// PCREDivergence は、RunPCRETests で見つけた、このエンジンと PCRE の結果の違いです。
+type PCREDivergence struct {
+	Line    int      // 照合の行番号（パターンのコンパイルの違いならパターンの行番号）
+	Pattern string   // パターン
+	Input   string   // 照合した文字列
+	Got     []string // このエンジンの結果（Want と同じ形式）
+	Want    []string // PCRE の結果
+}
+
+// String は、違いを1行の文字列で返します。
+func (d PCREDivergence) String() string {
+	return fmt.Sprintf("%d 行目: パターン %q、入力 %q の結果が %q で、PCRE の結果 %q と異なります", d.Line, d.Pattern, d.Input, d.Got, d.Want)
+}
+
+// PCREReport は、RunPCRETests の結果です。
+type PCREReport struct {
+	Passed      int              // PCRE と結果が一致した照合の数
+	Skipped     int              // 対応していない修飾子などで照合しなかった数
+	Divergences []PCREDivergence // PCRE と結果が異なった照合
+}
+
+// LoadPCRETests は、ファイル path を ParsePCRETests で読み込みます。
+func LoadPCRETests(path string) ([]PCRETest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tests, err := ParsePCRETests(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tests, nil
+}
+
+// ParsePCRETests は、PCRE の testoutput の形式のテストを読み込みます。
+//
+// 各テストは、/abc/i のように区切り文字で囲んだパターン（複数行にわたってもかまいません）で始まり、
+// 空白で字下げした照合する文字列と、" 0: abc" や "No match" の形式の期待する結果が続き、空行で終わります。
+// "#" で始まる行は、pcre2test の指示として読み飛ばします。
+// 修飾子は i、m、s、U、g、utf（PCRE1 の 8）と、それらの長い名前（caseless など）に対応し、
+// それ以外の修飾子や、"\=" で指定する照合の修飾子、結果以外の出力（"MK:" など）を含むテストや照合は、Skip に理由を記録して照合しません。
+// utf を指定しないテストは、PCRE と同じくバイト列として（Options.Latin1 で）照合します。
+func ParsePCRETests(r io.Reader) ([]PCRETest, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var tests []PCRETest
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" || line[0] == '#' || isPCRESpace(line[0]) {
+			i++
+			continue
+		}
+		test := PCRETest{Line: i + 1}
+		next, err := test.parsePattern(lines, i)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+			test.parseBodyLine(lines[i], i+1)
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
+
+// parsePattern は、lines[i] から始まるパターンの行を読み込み、次の行の位置を返します。
+func (t *PCRETest) parsePattern(lines []string, i int) (int, error) {
+	delim := lines[i][0]
+	if delim == '\\' || delim >= utf8.RuneSelf || isPCREWordChar(rune(delim)) {
+		return 0, fmt.Errorf("%d 行目: パターンの区切り文字 %q は使えません", i+1, delim)
+	}
+	text := lines[i][1:]
+	for {
+		if end := closingDelim(text, delim); end >= 0 {
+			t.Pattern, t.Modifiers = text[:end], strings.TrimSpace(text[end+1:])
+			break
+		}
+		if i++; i >= len(lines) {
+			return 0, fmt.Errorf("%d 行目: パターンの区切り文字 %q が閉じられていません", t.Line, delim)
+		}
+		text += "\n" + lines[i]
+	}
+	t.parseModifiers()
+	return i + 1, nil
+}
+
+// closingDelim は、s の中の、エスケープされていない最初の区切り文字 delim の位置を返します。なければ-1を返します。
+func closingDelim(s string, delim byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case delim:
+			return i
+		}
+	}
+	return -1
+}
+
+// pcreModifierNames は、対応している修飾子の長い名前と、対応する1文字の修飾子です。
+var pcreModifierNames = map[string]string{
+	"caseless":  "i",
+	"multiline": "m",
+	"dotall":    "s",
+	"ungreedy":  "U",
+	"global":    "g",
+	"utf":       "8",
+}
+
+// parseModifiers は、修飾子から Options と Global を設定します。対応していない修飾子があれば Skip に記録します。
+func (t *PCRETest) parseModifiers() {
+	utf := false
+	for _, word := range strings.Split(t.Modifiers, ",") {
+		word = strings.TrimSpace(word)
+		if short, ok := pcreModifierNames[word]; ok {
+			word = short
+		}
+		for _, r := range word {
+			switch r {
+			case 'i':
+				t.Options.Flags.CaseInsensitive = true
+			case 'm':
+				t.Options.Flags.Multiline = true
+			case 's':
+				t.Options.Flags.DotMatchesNL = true
+			case 'U':
+				t.Options.Flags.Ungreedy = true
+			case 'g':
+				t.Global = true
+			case '8':
+				utf = true
+			default:
+				t.Skip = fmt.Sprintf("対応していない修飾子 %q", word)
+				return
+			}
+		}
+	}
+	t.Options.Latin1 = !utf
+}
+
+// parseBodyLine は、パターンに続く行（照合する文字列か、期待する結果）を読み込みます。
+func (t *PCRETest) parseBodyLine(line string, lineNo int) {
+	var last *PCRESubject
+	if len(t.Subjects) > 0 {
+		last = &t.Subjects[len(t.Subjects)-1]
+	}
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "Failed:") && last == nil:
+		t.WantError = true
+	case last != nil && (trimmed == "No match" || isPCREGroupLine(trimmed)):
+		last.Want = append(last.Want, trimmed)
+	case isPCRESpace(line[0]):
+		s := PCRESubject{Line: lineNo}
+		s.Input, s.Skip = decodePCRESubject(trimmed, !t.Options.Latin1)
+		t.Subjects = append(t.Subjects, s)
+	case last != nil:
+		if last.Skip == "" {
+			last.Skip = fmt.Sprintf("対応していない出力 %q", trimmed)
+		}
+	default:
+		if t.Skip == "" {
+			t.Skip = fmt.Sprintf("対応していない出力 %q", trimmed)
+		}
+	}
+}
+
+// isPCREGroupLine は、s が " 0: abc" のような、グループの文字列を表す結果の行（前後の空白を除いたもの）かどうかを返します。
+func isPCREGroupLine(s string) bool {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return i > 0 && i < len(s) && s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ')
+}
+
+// isPCRESpace は、b が行の字下げに使う空白かどうかを返します。
+func isPCRESpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+// isPCREWordChar は、r が英数字かどうかを返します。
+func isPCREWordChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+}
+
+// pcreEscapes は、照合する文字列の、1文字のエスケープが表す文字です。
+var pcreEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'e': 0x1b, 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+}
+
+// decodePCRESubject は、照合する文字列のエスケープを解釈します。utf が true なら、文字コードを UTF-8 で表します。
+// 対応していないエスケープや、"\=" で指定する照合の修飾子があれば、照合しない理由を2つ目の戻り値で返します。
+func decodePCRESubject(s string, utf bool) (string, string) {
+	var sb strings.Builder
+	writeCode := func(code uint64) bool {
+		switch {
+		case utf && code <= utf8.MaxRune:
+			sb.WriteRune(rune(code))
+		case !utf && code <= 0xff:
+			sb.WriteByte(byte(code))
+		default:
+			return false
+		}
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i++; i >= len(s) {
+			break
+		}
+		c := s[i]
+		switch {
+		case c == 'x' || c == 'o':
+			base, digits := 16, "0123456789abcdefABCDEF"
+			if c == 'o' {
+				base, digits = 8, "01234567"
+			}
+			var hex string
+			if i+1 < len(s) && s[i+1] == '{' {
+				end := strings.IndexByte(s[i:], '}')
+				if end < 0 {
+					return "", fmt.Sprintf("閉じられていないエスケープ %q", s[i-1:])
+				}
+				hex, i = s[i+2:i+end], i+end
+			} else if c == 'x' {
+				j := i + 1
+				for j < len(s) && j < i+3 && strings.IndexByte(digits, s[j]) >= 0 {
+					j++
+				}
+				hex, i = s[i+1:j], j-1
+			} else {
+				return "", fmt.Sprintf("対応していないエスケープ %q", s[i-1:i+1])
+			}
+			code, err := strconv.ParseUint(hex, base, 32)
+			if hex == "" {
+				code, err = 0, nil
+			}
+			if err != nil || !writeCode(code) {
+				return "", fmt.Sprintf("表せない文字コード %q", hex)
+			}
+		case '0' <= c && c <= '7':
+			j := i
+			for j < len(s) && j < i+3 && '0' <= s[j] && s[j] <= '7' {
+				j++
+			}
+			code, _ := strconv.ParseUint(s[i:j], 8, 32)
+			if !writeCode(code) {
+				return "", fmt.Sprintf("表せない文字コード %q", s[i:j])
+			}
+			i = j - 1
+		case c == '=':
+			return "", fmt.Sprintf("対応していない照合の修飾子 %q", s[i-1:])
+		case pcreEscapes[c] != 0:
+			sb.WriteByte(pcreEscapes[c])
+		case isPCREWordChar(rune(c)):
+			return "", fmt.Sprintf("対応していないエスケープ %q", s[i-1:i+1])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), ""
+}
+
+// RunPCRETests は、tests の各パターンをコンパイルして照合し、PCRE と結果が異なるものを返します。
+// 照合の結果は、pcre2test と同じく、最後にマッチしたグループまでの各グループを "1: abc" や "1: <unset>" の形式で並べ、
+// 表示できない文字を \x{hh}（utf を指定しない場合は \xhh）で表したものを比べます。マッチしなければ "No match" です。
+// 修飾子 g では、すべてのマッチの結果を順に並べます（空マッチの後の進め方は FindAll と同じで、PCRE とは異なる場合があります）。
+func RunPCRETests(tests []PCRETest) PCREReport {
+	var report PCREReport
+	for _, t := range tests {
+		if t.Skip != "" {
+			report.Skipped += max(len(t.Subjects), 1)
+			continue
+		}
+		re, err := CompileWithOptions(t.Pattern, t.Options)
+		if t.WantError || err != nil {
+			if t.WantError == (err != nil) {
+				report.Passed++
+				continue
+			}
+			d := PCREDivergence{Line: t.Line, Pattern: t.Pattern, Got: []string{"Compiled"}, Want: []string{"Failed"}}
+			if err != nil {
+				d.Got, d.Want = []string{"Failed: " + err.Error()}, []string{"Compiled"}
+			}
+			report.Divergences = append(report.Divergences, d)
+			continue
+		}
+		for _, s := range t.Subjects {
+			if s.Skip != "" || len(s.Want) == 0 {
+				report.Skipped++
+				continue
+			}
+			got := pcreResult(re, t, s.Input)
+			if slices.Equal(got, s.Want) {
+				report.Passed++
+				continue
+			}
+			report.Divergences = append(report.Divergences, PCREDivergence{
+				Line: s.Line, Pattern: t.Pattern, Input: s.Input, Got: got, Want: s.Want,
+			})
+		}
+	}
+	return report
+}
+
+// pcreResult は、入力 s を照合した結果を、PCRESubject.Want と同じ形式で返します。
+func pcreResult(re *Regexp, t PCRETest, s string) []string {
+	n := 1
+	if t.Global {
+		n = -1
+	}
+	locs, err := re.FindAllStringSubmatchIndexErr(s, n)
+	if err != nil {
+		return []string{"Error: " + err.Error()}
+	}
+	if len(locs) == 0 {
+		return []string{"No match"}
+	}
+	var result []string
+	for _, loc := range locs {
+		last := len(loc)/2 - 1
+		for last > 0 && loc[2*last] < 0 {
+			last--
+		}
+		for g := 0; g <= last; g++ {
+			text := "<unset>"
+			if loc[2*g] >= 0 {
+				text = escapePCREOutput(s[loc[2*g]:loc[2*g+1]], !t.Options.Latin1)
+			}
+			result = append(result, strings.TrimSpace(strconv.Itoa(g)+": "+text))
+		}
+	}
+	return result
+}
+
+// escapePCREOutput は、pcre2test と同じく、表示できない文字を \x{hh}（utf が false なら \xhh）で表した s を返します。
+func escapePCREOutput(s string, utf bool) string {
+	var sb strings.Builder
+	if !utf {
+		for i := 0; i < len(s); i++ {
+			if b := s[i]; b < 0x20 || b >= 0x7f {
+				fmt.Fprintf(&sb, `\x%02x`, b)
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+		return sb.String()
+	}
+	for _, r := range s {
+		if r < 0x20 || r >= 0x7f {
+			fmt.Fprintf(&sb, `\x{%x}`, r)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
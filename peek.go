@@ -0,0 +1,65 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// ReadMatch は、r の現在の読み取り位置から始まるマッチを探し、マッチした部分だけを r から読み進めます。
+// マッチしなければ、r を読み進めずに false を返します。同じ Reader で、正規表現の照合とほかの読み取りを交互に行えます。
+//
+// offset には、r の現在の読み取り位置のストリーム上の位置を渡します。返す Match の位置は offset を加えたものです。
+// 次の読み取り位置のストリーム上の位置は、マッチすれば Match.Index[1] です。
+//
+// 照合は r.Peek で先読みしたデータに対して行い、結果が確定するまで先読みを少しずつ広げます。
+// 先読みできるのは r のバッファの大きさ（r.Size()）までで、それでも結果が確定しない場合は、
+// r を読み進めずに bufio.ErrBufferFull を返します。先読みが入力の終わりに達した場合は、そこを入力の終わりとして照合します。
+// 入力を正規化する設定（Flags.Normalization）では、はじめからバッファの大きさまで先読みします。
+//
+// 読み取りでエラーが発生した場合や、ステップ数の上限に達した場合は、r を読み進めずにそのエラーを返します（io.EOF はエラーとしません）。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+func (re *Regexp) ReadMatch(r *bufio.Reader, offset int64) (Match, bool, error) {
+	prog := re.prog
+	m := prog.getMatcher(true)
+	defer prog.putMatcher(m)
+
+	size := r.Buffered()
+	if prog.normalization != NoNormalization {
+		size = r.Size()
+	}
+	for {
+		data, err := r.Peek(min(max(size, 1), r.Size()))
+		eof := err == io.EOF
+		if err != nil && !eof && err != bufio.ErrBufferFull {
+			return Match{}, false, err
+		}
+
+		// 末尾で途切れた文字は、続きを先読みするまで照合しない
+		avail := len(data)
+		if !eof && !prog.latin1 {
+			avail = fullRunesLen(data)
+		}
+		m.resetBytes(data[:avail])
+		matched := m.MatchStart(0)
+		if m.err != nil {
+			return Match{}, false, m.err
+		}
+		if !eof && (m.hitEnd || m.tooShort(0)) {
+			if len(data) >= r.Size() {
+				return Match{}, false, fmt.Errorf("%w: %d バイトの先読みでは照合の結果が確定しません", bufio.ErrBufferFull, len(data))
+			}
+			// 次の読み取りで届く分だけ先読みを広げる
+			size = len(data) + 1
+			continue
+		}
+		if !matched {
+			return Match{}, false, nil
+		}
+		loc := m.submatchIndex()
+		match := newMatch(data, loc, offset)
+		r.Discard(loc[1])
+		return match, true, nil
+	}
+}
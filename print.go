@@ -0,0 +1,361 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format は、パターンを解析し、正規形で書き直したパターンを返します。
+//
+// 正規形では、不要なエスケープと非キャプチャグループを取り除き、グループは必要な箇所にだけ (?:...) で補います。
+// (?i) などのフラグは、それが効く要素ごとに (?i:...) の形で書きます。
+func Format(pattern string) (string, error) {
+	ast, err := parse(pattern, Options{})
+	if err != nil {
+		return "", err
+	}
+	return nodeString(ast), nil
+}
+
+// Parse は、パターンを設定 opts に従って解析し、構文木を返します。構文木は Options.Anchored などの設定を反映したもので、
+// Optimize などで書き換える前のものです。Expr と組み合わせて書き換え、Render で再びパターンに書き出せます。
+func Parse(pattern string, opts Options) (Node, error) {
+	return parse(pattern, opts)
+}
+
+// Render は、構文木をこのパッケージの既定の構文で書き表したパターンを返します。Node.String と同じ正規形ですが、
+// 既定の構文で書けないノードを含む場合はエラーを返します。書けないのは、Options.WholeWord が加える単語の境界の判定と、
+// Vim の \zs と \ze、JavaScript の方言のマルチラインの ^ と $ です。
+// 返したパターンを同じ設定（Dialect を除く）でコンパイルすると、元の構文木と同じ意味になります。
+func Render(node Node) (string, error) {
+	if bad := unrenderable(node); bad != nil {
+		return "", fmt.Errorf("既定の構文で書けないノードを含みます: %s", nodeStringFor(bad, DialectPCRE))
+	}
+	return nodeString(node), nil
+}
+
+// CompileNode は、構文木を Render でパターンに書き出し、設定を指定してコンパイルします。
+// Options.Dialect の構文は使わず、JavaScript と Vim の方言のバックリファレンスの意味（UnsetBackrefMatchesEmpty）だけを引き継ぎます。
+func CompileNode(node Node, opts Options) (*Regexp, error) {
+	pattern, err := Render(node)
+	if err != nil {
+		return nil, err
+	}
+	// 方言による照合の意味の違いは、設定に移してから既定の構文でコンパイルする
+	if opts.Dialect == DialectJavaScript || opts.Dialect == DialectVim {
+		opts.UnsetBackrefMatchesEmpty = true
+	}
+	opts.Dialect = DialectDefault
+	return CompileWithOptions(pattern, opts)
+}
+
+// unrenderable は、構文木の中の、既定の構文で書けない最初のノードを返します。すべて書ければ nil を返します。
+func unrenderable(node Node) Node {
+	switch n := node.(type) {
+	case *ConcatNode:
+		for _, child := range n.nodes {
+			if bad := unrenderable(child); bad != nil {
+				return bad
+			}
+		}
+	case *AltNode:
+		if bad := unrenderable(n.left); bad != nil {
+			return bad
+		}
+		return unrenderable(n.right)
+	case *RepeatNode:
+		return unrenderable(n.node)
+	case *CaptureNode:
+		return unrenderable(n.node)
+	case *GroupNode:
+		return unrenderable(n.node)
+	case *BoundaryNode:
+		switch n.nodeType {
+		case NodeMatchStart, NodeMatchEnd, NodeNotAfterWord, NodeNotBeforeWord:
+			return n
+		case NodeBeginLine, NodeEndLine:
+			if n.multiline && n.lineTerminators {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// nodeString は、ノードを正規表現の構文で書き表した文字列を返します。
+// 再びパースすると同じ意味のノードになるように、必要な括弧やエスケープを補います。
+func nodeString(node Node) string {
+	return nodeStringFor(node, DialectDefault)
+}
+
+// nodeStringFor は、ノードを target の正規表現エンジンの構文で書き表した文字列を返します。
+// target には DialectDefault か DialectPCRE を指定します。
+func nodeStringFor(node Node, target Dialect) string {
+	var sb strings.Builder
+	writeNode(&sb, node, target)
+	return sb.String()
+}
+
+// writeNode は、ノードを target の正規表現エンジンの構文で sb に書き込みます。
+func writeNode(sb *strings.Builder, node Node, target Dialect) {
+	switch n := node.(type) {
+	case *CharNode:
+		if n.fold {
+			sb.WriteString("(?i:")
+			writeLiteral(sb, n.r)
+			sb.WriteString(")")
+			return
+		}
+		writeLiteral(sb, n.r)
+
+	case *ConcatNode:
+		// 非キャプチャグループや入れ子の連接で区切られていても、同じ正規形になるよう平らにしてから書く
+		nodes := flattenConcat(nil, n.nodes)
+		for i := 0; i < len(nodes); i++ {
+			child := nodes[i]
+			// 大小文字を区別しない連続する文字は、1つの (?i:...) にまとめる
+			if ch, ok := child.(*CharNode); ok && ch.fold {
+				sb.WriteString("(?i:")
+				for ; i < len(nodes); i++ {
+					ch, ok := nodes[i].(*CharNode)
+					if !ok || !ch.fold {
+						break
+					}
+					writeLiteral(sb, ch.r)
+				}
+				i--
+				sb.WriteString(")")
+				continue
+			}
+			// 連接の中の選択は括弧で囲む
+			if _, ok := unwrapGroups(child).(*AltNode); ok {
+				sb.WriteString("(?:")
+				writeNode(sb, child, target)
+				sb.WriteString(")")
+				continue
+			}
+			writeNode(sb, child, target)
+		}
+
+	case *AltNode:
+		writeNode(sb, n.left, target)
+		sb.WriteString("|")
+		writeNode(sb, n.right, target)
+
+	case *RepeatNode:
+		// 1文字やグループ以外の繰り返し対象は括弧で囲む
+		switch unwrapGroups(n.node).(type) {
+		case *CharNode, *AnyCharNode, *CharClassNode, *CaptureNode, *BackrefNode:
+			writeNode(sb, n.node, target)
+		default:
+			sb.WriteString("(?:")
+			writeNode(sb, n.node, target)
+			sb.WriteString(")")
+		}
+		switch {
+		case n.min == 0 && n.max == -1:
+			sb.WriteString("*")
+		case n.min == 1 && n.max == -1:
+			sb.WriteString("+")
+		case n.min == 0 && n.max == 1:
+			sb.WriteString("?")
+		case n.max == -1:
+			sb.WriteString("{" + strconv.Itoa(n.min) + ",}")
+		case n.min == n.max:
+			sb.WriteString("{" + strconv.Itoa(n.min) + "}")
+		default:
+			sb.WriteString("{" + strconv.Itoa(n.min) + "," + strconv.Itoa(n.max) + "}")
+		}
+		if n.possessive {
+			sb.WriteString("+")
+		} else if n.repeatType == RepeatNonGreedy {
+			sb.WriteString("?")
+		}
+
+	case *CaptureNode:
+		if n.name != "" {
+			sb.WriteString("(?P<" + n.name + ">")
+		} else {
+			sb.WriteString("(")
+		}
+		writeNode(sb, n.node, target)
+		sb.WriteString(")")
+
+	case *GroupNode:
+		// 非キャプチャグループは書かず、必要な括弧は親のノードが補う
+		writeNode(sb, n.node, target)
+
+	case *BackrefNode:
+		switch {
+		case n.name != "":
+			sb.WriteString(`\k<` + n.name + ">")
+		case target == DialectPCRE:
+			// 続く数字と合わせて読まれないよう、番号を括弧で区切る
+			sb.WriteString(`\g{` + strconv.Itoa(n.index) + "}")
+		default:
+			sb.WriteString(`\` + strconv.Itoa(n.index))
+		}
+
+	case *AnyCharNode:
+		switch {
+		case n.dotMatchesNewline:
+			sb.WriteString("(?s:.)")
+		case target == DialectPCRE || target == DialectRE2:
+			// このエンジンの . は \n と \r のどちらにもマッチしない
+			sb.WriteString(`[^\n\r]`)
+		default:
+			sb.WriteString(".")
+		}
+
+	case *CharClassNode:
+		writeCharClass(sb, n, target)
+
+	case *BoundaryNode:
+		switch n.nodeType {
+		case NodeBeginLine:
+			if n.multiline {
+				sb.WriteString("(?m:^)")
+			} else {
+				sb.WriteString("^")
+			}
+		case NodeEndLine:
+			switch {
+			case n.multiline:
+				sb.WriteString("(?m:$)")
+			case target == DialectPCRE:
+				// PCRE の $ は末尾の改行の直前にもマッチする
+				sb.WriteString(`\z`)
+			default:
+				sb.WriteString("$")
+			}
+		case NodeBeginText:
+			sb.WriteString(`\A`)
+		case NodeEndText:
+			sb.WriteString(`\z`)
+		case NodeWordBoundary:
+			sb.WriteString(`\b`)
+		case NodeNonWordBoundary:
+			sb.WriteString(`\B`)
+		case NodeMatchStart:
+			sb.WriteString(`\zs`)
+		case NodeMatchEnd:
+			sb.WriteString(`\ze`)
+		case NodeNotAfterWord:
+			// パターンの構文では書けないため、PCRE の後読みの形で示す
+			sb.WriteString(`(?<!\w)`)
+		case NodeNotBeforeWord:
+			sb.WriteString(`(?!\w)`)
+		}
+
+	case nil:
+		// 空のパターン
+	}
+}
+
+// flattenConcat は、連接の子 nodes から非キャプチャグループを取り除き、入れ子の連接を展開して dst に追加します。
+func flattenConcat(dst []Node, nodes []Node) []Node {
+	for _, child := range nodes {
+		child = unwrapGroups(child)
+		if concat, ok := child.(*ConcatNode); ok {
+			dst = flattenConcat(dst, concat.nodes)
+			continue
+		}
+		dst = append(dst, child)
+	}
+	return dst
+}
+
+// unwrapGroups は、非キャプチャグループを取り除いた中身のノードを返します。
+func unwrapGroups(node Node) Node {
+	for {
+		group, ok := node.(*GroupNode)
+		if !ok {
+			return node
+		}
+		node = group.node
+	}
+}
+
+// writeCharClass は、文字クラスを target の正規表現エンジンの構文で sb に書き込みます。
+func writeCharClass(sb *strings.Builder, n *CharClassNode, target Dialect) {
+	// PCRE と RE2 の \s は ASCII の空白類だけにマッチするため、Unicode の空白類を列挙する
+	if n.classType == ClassSpace && (target == DialectPCRE || target == DialectRE2) {
+		n = &CharClassNode{classType: ClassCustom, negate: n.negate, ranges: spaceRanges()}
+	}
+
+	switch n.classType {
+	case ClassDigit, ClassWord, ClassSpace:
+		letter := map[CharClassType]string{ClassDigit: "d", ClassWord: "w", ClassSpace: "s"}[n.classType]
+		if n.negate {
+			letter = strings.ToUpper(letter)
+		}
+		sb.WriteString(`\` + letter)
+		return
+	case ClassUnicode:
+		if n.negate {
+			sb.WriteString(`\P{` + n.unicodeKey + "}")
+		} else {
+			sb.WriteString(`\p{` + n.unicodeKey + "}")
+		}
+		return
+	}
+
+	if n.fold {
+		sb.WriteString("(?i:")
+	}
+	sb.WriteString("[")
+	if n.negate {
+		sb.WriteString("^")
+	}
+	for _, rng := range n.ranges {
+		writeClassRune(sb, rng.min)
+		if rng.max != rng.min {
+			sb.WriteString("-")
+			writeClassRune(sb, rng.max)
+		}
+	}
+	sb.WriteString("]")
+	if n.fold {
+		sb.WriteString(")")
+	}
+}
+
+// writeLiteral は、文字クラスの外に置く1文字を、必要ならエスケープして sb に書き込みます。
+func writeLiteral(sb *strings.Builder, r rune) {
+	if strings.ContainsRune(`.*+?|()[]{}\^$`, r) {
+		sb.WriteByte('\\')
+		sb.WriteRune(r)
+		return
+	}
+	writeControl(sb, r)
+}
+
+// writeClassRune は、文字クラスの中に置く1文字を、必要ならエスケープして sb に書き込みます。
+func writeClassRune(sb *strings.Builder, r rune) {
+	if strings.ContainsRune(`]\^-[`, r) {
+		sb.WriteByte('\\')
+		sb.WriteRune(r)
+		return
+	}
+	writeControl(sb, r)
+}
+
+// writeControl は、制御文字をエスケープシーケンスで、それ以外の文字はそのまま sb に書き込みます。
+func writeControl(sb *strings.Builder, r rune) {
+	switch r {
+	case '\n':
+		sb.WriteString(`\n`)
+	case '\r':
+		sb.WriteString(`\r`)
+	case '\t':
+		sb.WriteString(`\t`)
+	case '\f':
+		sb.WriteString(`\f`)
+	case '\v':
+		sb.WriteString(`\v`)
+	default:
+		sb.WriteRune(r)
+	}
+}
@@ -0,0 +1,130 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// profile は、Options.Profile を指定したプログラムの、命令ごとの実行回数です。
+// 同じ Regexp を複数のゴルーチンから使えるよう、回数は不可分に加算します。
+type profile struct {
+	ast    Node            // コンパイルしたAST（Optimize などで書き換えた後のもの）
+	owners []Node          // 各命令を生成したノード
+	counts []atomic.Uint64 // 各命令の実行回数
+}
+
+// newProfile は、命令数 n のプログラムの実行回数を数える profile を返します。
+func newProfile(ast Node, owners []Node, n int) *profile {
+	return &profile{ast: ast, owners: owners, counts: make([]atomic.Uint64, n)}
+}
+
+// InstrProfile は、1つの命令の実行回数です。
+type InstrProfile struct {
+	PC      int    // 命令の位置
+	Instr   string // 命令（ProgramListing と同じ表記）
+	Pattern string // 命令を生成したパターンの部分（パターン全体の出口の命令では空文字列）
+	Count   uint64 // 実行回数
+}
+
+// NodeProfile は、パターンの1つの部分（構文木のノード）の実行回数です。
+type NodeProfile struct {
+	Pattern string // パターンの部分（Format と同じ表記）
+	Depth   int    // 構文木での深さ（パターン全体が0）
+	Self    uint64 // この部分自身の命令の実行回数
+	Total   uint64 // この部分に含まれる部分の命令も合わせた実行回数
+}
+
+// ProfileReport は、Regexp.Profile が返す、照合で実行した命令の回数の報告です。
+type ProfileReport struct {
+	Total uint64 // 実行した命令の総数
+
+	// Instrs は、各命令の実行回数を、回数の多い順に並べたものです。
+	Instrs []InstrProfile
+
+	// Nodes は、構文木の各ノードの実行回数を、Self の多い順に並べたものです。
+	// 先頭の方が、照合で時間がかかっている（バックトラックを繰り返している）パターンの部分です。
+	Nodes []NodeProfile
+}
+
+// Profile は、Options.Profile を指定してコンパイルした Regexp について、これまでの照合で実行した命令の回数を返します。
+// Profile を指定していない場合は nil を返します。
+func (re *Regexp) Profile() *ProfileReport {
+	p := re.prog.profile
+	if p == nil {
+		return nil
+	}
+	report := &ProfileReport{}
+	self := make(map[Node]uint64)
+	for pc := range p.counts {
+		count := p.counts[pc].Load()
+		report.Total += count
+		instr := InstrProfile{PC: pc, Instr: re.prog.instrs[pc].String(), Count: count}
+		if pc < len(p.owners) && p.owners[pc] != nil {
+			instr.Pattern = nodeString(p.owners[pc])
+			self[p.owners[pc]] += count
+		}
+		report.Instrs = append(report.Instrs, instr)
+	}
+	sort.SliceStable(report.Instrs, func(i, j int) bool { return report.Instrs[i].Count > report.Instrs[j].Count })
+
+	var walk func(node Node, depth int) uint64
+	walk = func(node Node, depth int) uint64 {
+		i := len(report.Nodes)
+		report.Nodes = append(report.Nodes, NodeProfile{Pattern: nodeString(node), Depth: depth, Self: self[node]})
+		total := self[node]
+		for _, child := range childNodes(node) {
+			total += walk(child, depth+1)
+		}
+		report.Nodes[i].Total = total
+		return total
+	}
+	walk(p.ast, 0)
+	sort.SliceStable(report.Nodes, func(i, j int) bool { return report.Nodes[i].Self > report.Nodes[j].Self })
+	return report
+}
+
+// ResetProfile は、Options.Profile で数えた命令の実行回数を0に戻します。
+func (re *Regexp) ResetProfile() {
+	if p := re.prog.profile; p != nil {
+		for pc := range p.counts {
+			p.counts[pc].Store(0)
+		}
+	}
+}
+
+// String は、実行回数の多いパターンの部分（ホットスポット）を、1行に1つずつ並べた表で返します。
+// 実行されなかった部分は含めません。
+func (r *ProfileReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "実行した命令の総数: %d\n", r.Total)
+	fmt.Fprintf(&sb, "%12s %12s %6s  %s\n", "self", "total", "self%", "pattern")
+	for _, n := range r.Nodes {
+		if n.Self == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "%12d %12d %5.1f%%  %s\n", n.Self, n.Total, 100*float64(n.Self)/float64(r.Total), n.Pattern)
+	}
+	return sb.String()
+}
+
+// childNodes は、ノードの子ノードを返します。
+func childNodes(node Node) []Node {
+	switch n := node.(type) {
+	case *ConcatNode:
+		return n.nodes
+	case *AltNode:
+		return []Node{n.left, n.right}
+	case *RepeatNode:
+		return []Node{n.node}
+	case *CaptureNode:
+		return []Node{n.node}
+	case *GroupNode:
+		if n.node != nil {
+			return []Node{n.node}
+		}
+	}
+	return nil
+}
@@ -3,10 +3,13 @@
 package btregexp
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"regexp"
+	"strconv"
 	"strings"
-	"unicode/utf8"
+	"sync"
 )
 
 // Regexp は、コンパイルされた正規表現を表します。
@@ -15,6 +18,9 @@ type Regexp struct {
 	// 正規表現のソースパターン
 	expr string
 
+	// コンパイル時の設定
+	opts Options
+
 	// コンパイルされた正規表現プログラム
 	prog *program
 
@@ -23,6 +29,15 @@ type Regexp struct {
 
 	// サブマッチの名前（名前付きキャプチャグループ用）
 	subexpNames []string
+
+	// 最悪計算量の見積もり
+	complexity ComplexityReport
+
+	// 標準ライブラリとのクロスチェック（Options.CrossCheck を指定した場合のみ）
+	crossCheck *crossChecker
+
+	// 近似照合用のプログラム（FindFuzzy を初めて呼んだときにコンパイル）
+	fuzzy fuzzyProgram
 }
 
 // program は、コンパイルされた正規表現プログラムを表します。
@@ -30,61 +45,481 @@ type program struct {
 	// 命令列
 	instrs []Instr
 
+	// 実行を開始する命令の位置
+	start int
+
+	// パターン全体に適用されたフラグ（インラインのフラグ指定は各命令に反映済み）
+	flags Flags
+
 	// キャプチャグループの数
 	numCaptures int
 
 	// サブマッチの名前のリスト
 	subexpNames []string
+
+	// バックリファレンスから参照されているキャプチャグループ（インデックスはグループ番号）
+	backrefs []bool
+
+	// バックリファレンスを1つ以上含むかどうか
+	hasBackrefs bool
+
+	// マッチしていないグループへのバックリファレンスを空文字列にマッチさせるかどうか
+	unsetBackrefMatchesEmpty bool
+
+	// 入力をUTF-8としてデコードせず、各バイトを1文字として扱うかどうか
+	latin1 bool
+
+	// 照合の前に入力に適用する Unicode 正規化の形式
+	normalization NormalizationForm
+
+	// \zs または \ze でマッチ全体の位置を変更するかどうか（マッチの開始位置が試行した位置と異なり得る）
+	matchBounds bool
+
+	// 照合を任せる標準ライブラリの正規表現（Options.LinearFallback で RE2 で表せる場合のみ）
+	linear *regexp.Regexp
+
+	// 繰り返しカウンタの数（{n,m} ごとに1つ）
+	numCounters int
+
+	// マッチに必要な最小のルーン数
+	minLen int
+
+	// マッチし得る最大のルーン数（-1は上限なし）
+	maxLen int
+
+	// 命令ごとの実行回数（Options.Profile の場合のみ）
+	profile *profile
+
+	// 選択の分岐や省略可能なグループを通ったマッチの数（Options.Coverage の場合のみ）
+	coverage *coverage
+
+	// しきい値を超えた操作の報告（Options.SlowMatch を指定した場合のみ）
+	slowMatch *slowMatch
+
+	// 操作の結果の報告（Options.Observe を指定した場合のみ）
+	observer *observer
+
+	// 1回の操作の結果の上限（Options.MaxMatches と Options.MaxCaptureBytes。0は上限なし）
+	maxMatches      int
+	maxCaptureBytes int
+
+	// 照合の作業領域を供給する Allocator（Options.Allocator。nil なら通常どおり確保する）
+	alloc Allocator
+
+	// ストリームの照合で保持する入力のバイト数の上限（Options.MaxStreamHistory。0は上限なし）
+	maxStreamHistory int
+
+	// マッチが必ずテキスト末尾で終わるかどうか（\z で終わるパターン）
+	endAnchored bool
+
+	// テキスト末尾の直前に必ず現れるリテラル
+	endSuffix []rune
+
+	// endSuffix を入力と同じ符号化で表したもの（入力をルーンに変換する前に比べるため。比べられなければ空文字列）
+	rawSuffix string
+
+	// どのマッチもいずれかを含むリテラルの集合（CompileBundle の前処理で使う）
+	requiredLiterals [][]rune
+
+	// どのマッチもいずれかで始まるリテラルを探すオートマトン（開始位置の候補を探すため。なければ nil）
+	startLiterals *acAutomaton
+
+	// 近似照合で編集の回数を記録するスロット（近似照合用のプログラムでなければ0）
+	editSlot int
+
+	// 再利用するマッチャーのプール（複数のゴルーチンから同時に使用される）
+	matchers sync.Pool
+}
+
+// numSlots は、マッチャーが保持するスロットの総数を返します。
+// キャプチャグループの開始・終了位置に続いて、繰り返しカウンタが配置されます。
+// バックリファレンスを含む場合は、さらに各グループが最後に閉じた範囲が続きます。
+func (p *program) numSlots() int {
+	n := p.spanBase()
+	if p.hasBackrefs {
+		n += len(p.backrefs) * 2
+	}
+	return n
+}
+
+// counterBase は、最初の繰り返しカウンタのスロット番号を返します。
+func (p *program) counterBase() int {
+	return (p.numCaptures + 1) * 2
+}
+
+// spanBase は、グループが最後に閉じた範囲を記録する最初のスロット番号を返します。
+// バックリファレンスはこの範囲を参照するため、繰り返しの次の回でグループの開始位置を記録し直しても、
+// グループが閉じるまでは前の回の範囲を参照します。
+func (p *program) spanBase() int {
+	return p.counterBase() + p.numCounters
+}
+
+// DefaultMaxProgramSize は、Options.MaxProgramSize が0の場合に使われる命令数の上限です。
+const DefaultMaxProgramSize = 100000
+
+// ErrProgramTooLarge は、コンパイルされたプログラムの命令数が上限を超えた場合のエラーです。
+var ErrProgramTooLarge = errors.New("正規表現が大きすぎます")
+
+// DefaultMaxCaptureGroups は、Options.MaxCaptureGroups が0の場合に使われるキャプチャグループの数の上限です。
+const DefaultMaxCaptureGroups = 1000
+
+// ErrTooManyCaptures は、パターンのキャプチャグループの数が上限を超えた場合のエラーです。
+var ErrTooManyCaptures = errors.New("キャプチャグループが多すぎます")
+
+// DefaultMaxNestingDepth は、Options.MaxNestingDepth が0の場合に使われるグループの入れ子の深さの上限です。
+const DefaultMaxNestingDepth = 1000
+
+// MaxNestingDepthLimit は、Options.MaxNestingDepth に指定できる最大の値です。
+// コンパイルの各段階は入れ子の深さだけ再帰するため、上限をなくすことはできません。
+const MaxNestingDepthLimit = 100000
+
+// ErrNestingTooDeep は、パターンのグループの入れ子が深すぎる場合のエラーです。
+var ErrNestingTooDeep = errors.New("グループの入れ子が深すぎます")
+
+// ErrStepLimitExceeded は、マッチングの実行ステップ数が上限を超えたため、
+// マッチするかどうかを判定できずに打ち切った場合のエラーです。
+// 破滅的なバックトラックを起こすパターンで発生します。
+var ErrStepLimitExceeded = errors.New("マッチングのステップ数が上限を超えました")
+
+// Anchor は、Options.Anchored で指定する、パターンを固定する位置です。
+type Anchor int
+
+const (
+	AnchorNone  Anchor = iota // 固定しない
+	AnchorStart               // テキストの先頭に固定する（\A... と同じ）
+	AnchorEnd                 // テキストの末尾に固定する（...\z と同じ）
+	AnchorBoth                // テキスト全体に固定する（\A...\z と同じ）
+)
+
+// Options は、正規表現のコンパイル時に指定できる設定を表します。
+type Options struct {
+	// Flags は、パターン全体に適用されるフラグです。
+	Flags Flags
+
+	// MaxProgramSize は、コンパイル後の命令数の上限です。
+	// 0の場合は DefaultMaxProgramSize、負の場合は無制限です。
+	MaxProgramSize int
+
+	// MaxCaptureGroups は、パターンのキャプチャグループの数の上限です。超える場合は ErrTooManyCaptures を返します。
+	// 照合の作業領域やバックトラックの記録はグループの数に比例して大きくなります。
+	// 0の場合は DefaultMaxCaptureGroups、負の場合は無制限です。
+	MaxCaptureGroups int
+
+	// MaxNestingDepth は、パターンのグループ（括弧）の入れ子の深さの上限です。超える場合は ErrNestingTooDeep を返します。
+	// 構文解析は入れ子を再帰せずに扱いますが、最適化やコンパイルは入れ子の深さだけ再帰します。
+	// 0の場合は DefaultMaxNestingDepth です。負の値や MaxNestingDepthLimit を超える値を指定すると、ErrNestingTooDeep を返します。
+	MaxNestingDepth int
+
+	// UnsetBackrefMatchesEmpty は、まだマッチしていないグループへのバックリファレンスを
+	// 空文字列にマッチさせるかどうかです（JavaScript と同じ動作）。
+	// false の場合は PCRE と同様に、そのようなバックリファレンスはマッチに失敗します。
+	UnsetBackrefMatchesEmpty bool
+
+	// Latin1 は、パターンと入力をバイト列として扱うかどうかです（RE2 の Latin-1 モードに相当）。
+	// true の場合、UTF-8 としてデコードせず、各バイトを U+0000 から U+00FF の1文字として照合します。
+	// パターン中の 0x80 以上の文字も、"\xe9" のように1バイトで書きます。
+	Latin1 bool
+
+	// LinearFallback は、パターンがバックリファレンスや所有的量指定子などを使わず RE2 の構文で表せる場合に、
+	// 照合を標準ライブラリの regexp に任せるかどうかです。
+	// 標準ライブラリは入力長に対して線形時間で照合するため、破滅的なバックトラックが起こらなくなります。
+	// このエンジン固有の機能が必要なパターンは、これまでどおりこのエンジンで照合します。
+	// btregexp_ascii のビルドタグを指定した場合は、常にこのエンジンで照合します。
+	LinearFallback bool
+
+	// Dialect は、パターンの構文の方言です。
+	Dialect Dialect
+
+	// CrossCheck は、標準ライブラリとのクロスチェックで結果の違いが見つかった場合に呼び出される関数です。
+	// nil でなければ、標準ライブラリでもコンパイルできるパターンについて、Match や Find などの各操作を
+	// 標準ライブラリの regexp でも実行し、結果が異なる場合に呼び出します。
+	// 照合の時間が倍以上になるため、テスト環境でこのエンジンの意味の退行を早期に見つけるためのデバッグ用の設定です。
+	// btregexp_ascii のビルドタグを指定した場合は、呼び出されません。
+	CrossCheck func(Discrepancy)
+
+	// Anchored は、パターンをテキストの先頭や末尾に固定するかどうかです。
+	// パターンを (?:...) で囲んで \A や \z を付けた場合と同じで、マルチラインモードでも行ではなくテキストの先頭と末尾に固定します。
+	// 入力の検証のように、文字列全体がパターンにマッチするかを調べる場合は AnchorBoth を指定します。
+	Anchored Anchor
+
+	// Profile は、照合で実行した命令の回数を数えるかどうかです。
+	// 数えた回数は Regexp.Profile で、パターンのどの部分で時間がかかっているかの報告として取得できます。
+	// 照合が遅くなるため、遅いパターンを調べるときだけ指定します。指定した場合、LinearFallback は無視します。
+	Profile bool
+
+	// Coverage は、マッチに使われた選択の分岐と省略可能なグループ（(...)? や (?:...)* など）を記録するかどうかです。
+	// 記録は Regexp.Coverage で取得でき、大量の入力で一度も使われない（不要な可能性がある）分岐を探すのに使えます。
+	// 分岐を書いたとおりに記録するため、パターンの最適化の一部を行わず、照合が遅くなります。
+	// 指定した場合、LinearFallback は無視します。
+	Coverage bool
+
+	// WholeWord は、マッチを単語全体に限るかどうかです（grep -w と同じ）。
+	// マッチの直前と直後が単語文字（\w）でないことを要求し、そうでなければ別のマッチを探します。
+	// パターンを \b で囲む場合と異なり、"!important" や "c++" のように単語文字でない文字で始まる・終わるパターンでも、
+	// 前後が単語の途中でなければマッチします。
+	WholeWord bool
+
+	// RequireLinear は、1つの開始位置からの照合が入力長に比例することを保証できないパターンを、
+	// コンパイル時に ErrNotLinear で拒否するかどうかです。バックリファレンスと、Complexity の見積もりが
+	// 線形でない繰り返し（(a+)+ のような曖昧な繰り返しの入れ子や、.*x.* のような重なり合う繰り返しの並び）を拒否します。
+	// 入力全体を走査する Find などでは開始位置の数が掛かるため、全体でも線形時間にするには LinearFallback も指定します。
+	RequireLinear bool
+
+	// RejectSuperlinear は、Complexity の見積もりが指数的（破滅的なバックトラックを起こし得る）パターンを、
+	// コンパイル時に ErrSuperlinear で拒否するかどうかです。エラーは原因となる繰り返しの部分式を示します。
+	// 複数の利用者のパターンを実行するサービスで、1つのパターンが照合を占有しないようにするための安全策です。
+	// 多項式の計算量のパターン（.*x.* など）やバックリファレンスは拒否しません。それらも拒否するには RequireLinear を使います。
+	RejectSuperlinear bool
+
+	// MaxMatches は、FindAll や Split などの1回の操作で見つけるマッチの数の上限です。0なら上限はありません。
+	// 上限を超えるマッチを見つけると操作を打ち切り、それまでの結果を返します。
+	// 打ち切ったことは、FindAllStringErr などの Err の付くメソッドが *ResultLimitError で報告します。
+	// MaxMatches か MaxCaptureBytes を指定した場合、LinearFallback と CrossCheck は無視します。
+	MaxMatches int
+
+	// MaxCaptureBytes は、1回の操作で返すマッチと各グループの文字列の合計バイト数の上限です。0なら上限はありません。
+	// 上限を超えた場合は MaxMatches と同じく操作を打ち切ります。
+	MaxCaptureBytes int
+
+	// Allocator は、照合の作業領域（バックトラックスタックとキャプチャの取り消し記録）を供給する Allocator です。
+	// nil なら通常どおり確保します。確保できない場合、照合は ErrMemoryLimit で打ち切ります。
+	// 複数のゴルーチンの照合から同時に呼び出されます。リクエストごとに分ける場合は、Matcher.SetAllocator を使います。
+	Allocator Allocator
+
+	// MaxStreamHistory は、ストリームの照合で、結果が確定するまで保持する入力のバイト数の上限です。0なら上限はありません。
+	// AllMatchesReader、SplitReader、CountingWriter では、照合の途中のマッチの開始位置から読み取った入力の末尾までを、
+	// ResumableMatch では、届いた入力全体を上限と比べます。上限を超えると、照合を ErrStreamHistoryExceeded で打ち切ります。
+	// このエンジンは後読みに対応していないため、照合が入力を後ろ向きにさかのぼるのも、保持した入力の範囲だけです。
+	MaxStreamHistory int
+
+	// SlowMatch は、1回の操作で実行した命令数やかかった時間がしきい値を超えた場合に呼び出す関数の設定です。
+	// このエンジンで照合した操作だけが対象で、LinearFallback で標準ライブラリに任せた操作は報告しません。
+	SlowMatch SlowMatchHook
+
+	// Observe は、1回の操作がマッチを見つけた場合と見つけなかった場合に呼び出す関数の設定です。
+	// SlowMatch と同じく、このエンジンで照合した操作だけが対象です。
+	Observe ObserveHook
 }
 
 // CompileWithFlags は、フラグを指定して正規表現パターンをコンパイルします。
 func CompileWithFlags(expr string, flags Flags) (*Regexp, error) {
-	// パーサーを作成
-	parser := newParser(expr)
+	return CompileWithOptions(expr, Options{Flags: flags})
+}
 
-	// パーサーのフラグを設定
-	parser.flags = regexpFlags{
-		caseInsensitive: flags.CaseInsensitive,
-		multiline:       flags.Multiline,
-		dotMatchesNL:    flags.DotMatchesNL,
-		ungreedy:        flags.Ungreedy,
+// CompileWithFlagString は、"ims" のような文字の並び（ParseFlags を参照）でフラグを指定して正規表現パターンをコンパイルします。
+// フラグの文字が正しくない場合はエラーを返します。
+func CompileWithFlagString(expr, flags string) (*Regexp, error) {
+	f, err := ParseFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+	return CompileWithFlags(expr, f)
+}
+
+// CompileWithOptions は、設定を指定して正規表現パターンをコンパイルします。
+// 命令数が MaxProgramSize を超える場合は ErrProgramTooLarge を返します。
+func CompileWithOptions(expr string, opts Options) (*Regexp, error) {
+	re, err := compileWithOptions(expr, opts)
+	countCompile(err)
+	return re, err
+}
+
+// compileWithOptions は、CompileWithOptions の本体です。
+func compileWithOptions(expr string, opts Options) (*Regexp, error) {
+	// JavaScript と Vim では、マッチしていないグループへのバックリファレンスは空文字列にマッチする
+	if opts.Dialect == DialectJavaScript || opts.Dialect == DialectVim {
+		opts.UnsetBackrefMatchesEmpty = true
 	}
 
 	// 正規表現をパース
-	ast, parsedFlags, err := parser.Parse()
+	ast, err := parse(expr, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	// コンパイラーを作成
-	compiler := newCompiler()
+	// 計算量は、書き換える前の（利用者が書いた形の）ASTで見積もる
+	complexity := analyzeComplexity(ast)
+	if opts.RequireLinear {
+		if err := checkLinear(ast, complexity); err != nil {
+			return nil, err
+		}
+	}
+	if opts.RejectSuperlinear {
+		if err := checkExponential(complexity); err != nil {
+			return nil, err
+		}
+	}
 
-	// フラグをマージ
-	mergedFlags := Flags{
-		CaseInsensitive: flags.CaseInsensitive || parsedFlags.caseInsensitive,
-		Multiline:       flags.Multiline || parsedFlags.multiline,
-		DotMatchesNL:    flags.DotMatchesNL || parsedFlags.dotMatchesNL,
-		Ungreedy:        flags.Ungreedy || parsedFlags.ungreedy,
+	// RE2 で表せるパターンは、標準ライブラリに照合を任せられる
+	var linear *regexp.Regexp
+	if opts.LinearFallback && !opts.Profile && !opts.Coverage && !opts.limitsResults() {
+		linear = linearRegexp(ast, opts)
 	}
-	compiler.flags = mergedFlags
+
+	crossCheck := newCrossChecker(expr, ast, opts)
 
 	// ASTをコンパイル
-	prog, err := compiler.compile(ast)
+	prog, err := compileProgram(ast, opts)
 	if err != nil {
 		return nil, err
 	}
+	prog.linear = linear
+	prog.slowMatch = newSlowMatch(expr, opts.SlowMatch)
+	prog.observer = newObserver(expr, opts.Observe)
+	prog.maxMatches, prog.maxCaptureBytes = opts.MaxMatches, opts.MaxCaptureBytes
+	prog.alloc = opts.Allocator
+	prog.maxStreamHistory = opts.MaxStreamHistory
 
 	// Regexpオブジェクトを作成
 	re := &Regexp{
 		expr:        expr,
+		opts:        opts,
 		prog:        prog,
-		numSubexp:   compiler.numCaptures,
-		subexpNames: compiler.subexpNames,
+		numSubexp:   prog.numCaptures,
+		subexpNames: prog.subexpNames,
+		complexity:  complexity,
+		crossCheck:  crossCheck,
 	}
 
 	return re, nil
 }
 
+// compileProgram は、ASTを設定に従ってプログラムにコンパイルします。
+func compileProgram(ast Node, opts Options) (*program, error) {
+	// コンパイラーを作成
+	compiler := newCompiler()
+
+	var cov *coverage
+	if opts.Coverage {
+		// 分岐の構造を書いたとおりに残すため、ASTを書き換えない
+		// （記録に使うカウンタは、繰り返しのカウンタより前に割り当てる）
+		cov, compiler.coverageCounters = newCoverage(ast)
+		compiler.numCounters = len(cov.items)
+	} else {
+		// ASTを単純にしてから、選択の共通部分を括り出す
+		ast = factorAlternations(Optimize(ast))
+	}
+
+	compiler.flags = opts.Flags
+	compiler.recordOwners = opts.Profile
+
+	// 命令数の上限を設定
+	switch {
+	case opts.MaxProgramSize == 0:
+		compiler.maxInstrs = DefaultMaxProgramSize
+	case opts.MaxProgramSize > 0:
+		compiler.maxInstrs = opts.MaxProgramSize
+	}
+
+	// ASTをコンパイル
+	prog, err := compiler.compile(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	prog.unsetBackrefMatchesEmpty = opts.UnsetBackrefMatchesEmpty
+	prog.latin1 = opts.Latin1
+	prog.normalization = normalizationOf(opts)
+	prog.rawSuffix = prog.encodeEndSuffix()
+	prog.coverage = cov
+	if opts.Profile {
+		prog.profile = newProfile(ast, compiler.owners, len(prog.instrs))
+	}
+	return prog, nil
+}
+
+// parse は、設定に従って正規表現パターンをパースし、ASTを返します。
+// （(?i) などのインラインのフラグは、その範囲内の各ノードに記録される）
+func parse(expr string, opts Options) (Node, error) {
+	// 正規化する場合は、パターンのリテラルも入力と同じ形式にそろえる
+	pattern := expr
+	if normalization := normalizationOf(opts); normalization != NoNormalization {
+		if !unicodeTables {
+			return nil, errors.New("このビルド（btregexp_ascii）では Unicode 正規化に対応していません")
+		}
+		pattern = normalizeString(expr)
+	}
+
+	// Vim のパターンは、このパッケージの構文に書き換えてから解析する
+	if opts.Dialect == DialectVim {
+		translated, err := translateVim(pattern)
+		if err != nil {
+			return nil, err
+		}
+		pattern = translated
+	}
+
+	// パーサーを作成
+	parser := newParser(pattern)
+	parser.latin1 = opts.Latin1
+	parser.dialect = opts.Dialect
+	parser.segments = normalizationOf(opts) != NoNormalization
+	switch {
+	case opts.MaxCaptureGroups == 0:
+		parser.maxCaptures = DefaultMaxCaptureGroups
+	case opts.MaxCaptureGroups > 0:
+		parser.maxCaptures = opts.MaxCaptureGroups
+	}
+	switch {
+	case opts.MaxNestingDepth == 0:
+		parser.maxDepth = DefaultMaxNestingDepth
+	case opts.MaxNestingDepth < 0 || opts.MaxNestingDepth > MaxNestingDepthLimit:
+		return nil, fmt.Errorf("%w: Options.MaxNestingDepth は 0 から %d の範囲で指定します（%d）", ErrNestingTooDeep, MaxNestingDepthLimit, opts.MaxNestingDepth)
+	default:
+		parser.maxDepth = opts.MaxNestingDepth
+	}
+
+	// パーサーのフラグを設定
+	flags := opts.Flags
+	parser.flags = regexpFlags{
+		caseInsensitive: flags.CaseInsensitive,
+		multiline:       flags.Multiline,
+		dotMatchesNL:    flags.DotMatchesNL,
+		ungreedy:        flags.Ungreedy,
+	}
+
+	ast, _, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if opts.WholeWord {
+		ast = wholeWordAST(ast)
+	}
+	return anchorAST(ast, opts.Anchored), nil
+}
+
+// wholeWordAST は、マッチの直前と直後が単語文字でないことを要求するASTを返します（Options.WholeWord）。
+func wholeWordAST(ast Node) Node {
+	return &ConcatNode{nodes: []Node{
+		&BoundaryNode{nodeType: NodeNotAfterWord},
+		&GroupNode{node: ast},
+		&BoundaryNode{nodeType: NodeNotBeforeWord},
+	}}
+}
+
+// anchorAST は、ASTを Anchored の指定に従ってテキストの先頭や末尾に固定したASTを返します。
+func anchorAST(ast Node, anchor Anchor) Node {
+	if anchor == AnchorNone {
+		return ast
+	}
+	nodes := []Node{&GroupNode{node: ast}}
+	if anchor == AnchorStart || anchor == AnchorBoth {
+		nodes = append([]Node{&BoundaryNode{nodeType: NodeBeginText}}, nodes...)
+	}
+	if anchor == AnchorEnd || anchor == AnchorBoth {
+		nodes = append(nodes, &BoundaryNode{nodeType: NodeEndText})
+	}
+	return &ConcatNode{nodes: nodes}
+}
+
+// normalizationOf は、設定から入力に適用する正規化の形式を返します（Latin-1 モードでは正規化しない）。
+func normalizationOf(opts Options) NormalizationForm {
+	if opts.Latin1 {
+		return NoNormalization
+	}
+	return opts.Flags.Normalization
+}
+
 // Compile は、正規表現パターンをコンパイルし、Regexpオブジェクトを返します。
 // パターンが無効な場合はエラーを返します。
 //
@@ -138,12 +573,18 @@ func quote(s string) string {
 }
 
 // Match は、bのどこかで正規表現がマッチするかどうかを報告します。
-func (re *Regexp) Match(b []byte) bool {
+func (re *Regexp) Match(b []byte) (result bool) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("Match", string(b), &result, func(std *regexp.Regexp) any { return std.Match(b) })
+	}
 	return matchBytes(re.prog, b)
 }
 
 // MatchString は、sのどこかで正規表現がマッチするかどうかを報告します。
-func (re *Regexp) MatchString(s string) bool {
+func (re *Regexp) MatchString(s string) (result bool) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("MatchString", s, &result, func(std *regexp.Regexp) any { return std.MatchString(s) })
+	}
 	return matchString(re.prog, s)
 }
 
@@ -152,37 +593,83 @@ func (re *Regexp) MatchReader(r io.RuneReader) bool {
 	return matchReader(re.prog, r)
 }
 
+// MatchErr は Match と同様ですが、ステップ数の上限に達してマッチングを打ち切った場合は
+// false と ErrStepLimitExceeded を返します。
+func (re *Regexp) MatchErr(b []byte) (bool, error) {
+	return matchBytesErr(re.prog, b)
+}
+
+// MatchStringErr は MatchString と同様ですが、ステップ数の上限に達してマッチングを打ち切った場合は
+// false と ErrStepLimitExceeded を返します。
+func (re *Regexp) MatchStringErr(s string) (bool, error) {
+	return matchStringErr(re.prog, s)
+}
+
 // Find は、bの中で正規表現にマッチする最初の部分文字列を返します。
 // マッチしない場合はnilを返します。
-func (re *Regexp) Find(b []byte) []byte {
+// 戻り値は b の一部を複製せずに共有するため、b を変更すると戻り値も変わります（容量は長さに切り詰めます）。
+func (re *Regexp) Find(b []byte) (result []byte) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("Find", string(b), &result, func(std *regexp.Regexp) any { return std.Find(b) })
+	}
 	return find(re.prog, b)
 }
 
 // FindString は、sの中で正規表現にマッチする最初の部分文字列を返します。
 // マッチしない場合は空文字列を返します。
-func (re *Regexp) FindString(s string) string {
+func (re *Regexp) FindString(s string) (result string) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindString", s, &result, func(std *regexp.Regexp) any { return std.FindString(s) })
+	}
 	return findString(re.prog, s)
 }
 
+// FindStringErr は FindString と同様ですが、ステップ数の上限に達してマッチングを打ち切った場合は
+// 空文字列と ErrStepLimitExceeded を返します。
+func (re *Regexp) FindStringErr(s string) (string, error) {
+	loc, err := findStringIndexErr(re.prog, s)
+	if loc == nil {
+		return "", err
+	}
+	return s[loc[0]:loc[1]], nil
+}
+
 // FindIndex は、bの中で正規表現にマッチする最初の部分文字列の位置を返します。
 // 戻り値のスライスには、マッチの開始位置と終了位置が含まれます。
 // マッチしない場合はnilを返します。
-func (re *Regexp) FindIndex(b []byte) []int {
+func (re *Regexp) FindIndex(b []byte) (result []int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindIndex", string(b), &result, func(std *regexp.Regexp) any { return std.FindIndex(b) })
+	}
 	return findIndex(re.prog, b)
 }
 
 // FindStringIndex は、sの中で正規表現にマッチする最初の部分文字列の位置を返します。
 // 戻り値のスライスには、マッチの開始位置と終了位置が含まれます。
 // マッチしない場合はnilを返します。
-func (re *Regexp) FindStringIndex(s string) []int {
+func (re *Regexp) FindStringIndex(s string) (result []int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindStringIndex", s, &result, func(std *regexp.Regexp) any { return std.FindStringIndex(s) })
+	}
 	return findStringIndex(re.prog, s)
 }
 
+// FindStringIndexErr は FindStringIndex と同様ですが、ステップ数の上限に達してマッチングを打ち切った場合は
+// nil と ErrStepLimitExceeded を返します。
+func (re *Regexp) FindStringIndexErr(s string) ([]int, error) {
+	return findStringIndexErr(re.prog, s)
+}
+
 // FindSubmatch は、bの中で正規表現にマッチする最初の部分文字列と、
 // 各サブマッチ（キャプチャグループ）を返します。
 // 戻り値のスライスの最初の要素は、マッチ全体に対応します。
 // マッチしない場合はnilを返します。
-func (re *Regexp) FindSubmatch(b []byte) [][]byte {
+// 各要素は、標準ライブラリと同じく b の一部を複製せずに共有します。大きな入力から取り出したグループを
+// 長く保持する場合や、b を後で変更する場合は複製してください。容量は長さに切り詰めるため、append しても b は上書きされません。
+func (re *Regexp) FindSubmatch(b []byte) (result [][]byte) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindSubmatch", string(b), &result, func(std *regexp.Regexp) any { return std.FindSubmatch(b) })
+	}
 	return findSubmatch(re.prog, b)
 }
 
@@ -190,7 +677,10 @@ func (re *Regexp) FindSubmatch(b []byte) [][]byte {
 // 各サブマッチ（キャプチャグループ）を返します。
 // 戻り値のスライスの最初の要素は、マッチ全体に対応します。
 // マッチしない場合はnilを返します。
-func (re *Regexp) FindStringSubmatch(s string) []string {
+func (re *Regexp) FindStringSubmatch(s string) (result []string) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindStringSubmatch", s, &result, func(std *regexp.Regexp) any { return std.FindStringSubmatch(s) })
+	}
 	return findStringSubmatch(re.prog, s)
 }
 
@@ -199,8 +689,11 @@ func (re *Regexp) FindStringSubmatch(s string) []string {
 // 戻り値のスライスには、マッチ全体の開始位置と終了位置、
 // 続いて各サブマッチの開始位置と終了位置が含まれます。
 // マッチしない場合はnilを返します。
-func (re *Regexp) FindSubmatchIndex(b []byte) []int {
-	return findStringSubmatchIndex(re.prog, string(b))
+func (re *Regexp) FindSubmatchIndex(b []byte) (result []int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindSubmatchIndex", string(b), &result, func(std *regexp.Regexp) any { return std.FindSubmatchIndex(b) })
+	}
+	return findSubmatchIndex(re.prog, b)
 }
 
 // FindStringSubmatchIndex は、sの中で正規表現にマッチする最初の部分文字列と、
@@ -208,10 +701,19 @@ func (re *Regexp) FindSubmatchIndex(b []byte) []int {
 // 戻り値のスライスには、マッチ全体の開始位置と終了位置、
 // 続いて各サブマッチの開始位置と終了位置が含まれます。
 // マッチしない場合はnilを返します。
-func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+func (re *Regexp) FindStringSubmatchIndex(s string) (result []int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindStringSubmatchIndex", s, &result, func(std *regexp.Regexp) any { return std.FindStringSubmatchIndex(s) })
+	}
 	return findStringSubmatchIndex(re.prog, s)
 }
 
+// FindStringSubmatchIndexErr は FindStringSubmatchIndex と同様ですが、
+// ステップ数の上限に達してマッチングを打ち切った場合は nil と ErrStepLimitExceeded を返します。
+func (re *Regexp) FindStringSubmatchIndexErr(s string) ([]int, error) {
+	return findStringSubmatchIndexErr(re.prog, s)
+}
+
 // NumSubexp は、この正規表現内のサブマッチ（キャプチャグループ）の数を返します。
 func (re *Regexp) NumSubexp() int {
 	return re.numSubexp
@@ -237,82 +739,96 @@ func (re *Regexp) String() string {
 // ReplaceAll は、bの中でマッチする全ての部分文字列をrepl（の展開）で置き換えます。
 // 展開では、$1, $2, ...はキャプチャグループの内容に置き換えられます。
 // $0はマッチ全体に置き換えられます。
-func (re *Regexp) ReplaceAll(src, repl []byte) []byte {
-	return re.replaceAll(src, repl, false)
+// $#は、この呼び出しの中で何番目のマッチか（1始まり）に置き換えられます。
+func (re *Regexp) ReplaceAll(src, repl []byte) (result []byte) {
+	if re.crossCheck != nil && !hasMatchCounter(string(repl)) {
+		defer re.crossCheck.compare("ReplaceAll", string(src), &result, func(std *regexp.Regexp) any { return std.ReplaceAll(src, repl) })
+	}
+	return []byte(re.replaceAll(string(src), string(repl), -1, false))
 }
 
 // ReplaceAllString は、sの中でマッチする全ての部分文字列をrepl（の展開）で置き換えます。
 // 展開では、$1, $2, ...はキャプチャグループの内容に置き換えられます。
 // $0はマッチ全体に置き換えられます。
-func (re *Regexp) ReplaceAllString(src, repl string) string {
-	return string(re.replaceAll([]byte(src), []byte(repl), false))
+// $#は、この呼び出しの中で何番目のマッチか（1始まり）に置き換えられます。
+// たとえば "footnote-$#" で、脚注に先頭から番号を振れます。
+func (re *Regexp) ReplaceAllString(src, repl string) (result string) {
+	if re.crossCheck != nil && !hasMatchCounter(repl) {
+		defer re.crossCheck.compare("ReplaceAllString", src, &result, func(std *regexp.Regexp) any { return std.ReplaceAllString(src, repl) })
+	}
+	return re.replaceAll(src, repl, -1, false)
 }
 
-// ReplaceAllLiteralString は、マッチする全ての部分文字列をreplで置き換えます（展開なし）。
-func (re *Regexp) ReplaceAllLiteralString(src, repl string) string {
-	return string(re.replaceAll([]byte(src), []byte(repl), true))
+// ReplaceN は、ReplaceAll と同じく repl を展開して置き換えますが、置き換えるのは先頭から最大 n 個（n が負なら全て）のマッチだけです。
+// strings.Replace の n と同じく、先頭から k 個のマッチだけを置き換える場合に使います。
+func (re *Regexp) ReplaceN(src, repl []byte, n int) []byte {
+	return []byte(re.replaceAll(string(src), string(repl), n, false))
+}
+
+// ReplaceNString は、ReplaceAllString と同じく repl を展開して置き換えますが、置き換えるのは先頭から最大 n 個（n が負なら全て）のマッチだけです。
+func (re *Regexp) ReplaceNString(src, repl string, n int) string {
+	return re.replaceAll(src, repl, n, false)
 }
 
-// replaceAll は、すべての置換を処理する内部関数です。
-func (re *Regexp) replaceAll(src, repl []byte, literal bool) []byte {
-	// マッチを検索
-	indices := re.FindSubmatchIndex(src)
-	if indices == nil {
-		return src // マッチしなければ元の文字列を返す
+// ReplaceAllLiteralString は、マッチする全ての部分文字列をreplで置き換えます（展開なし）。
+func (re *Regexp) ReplaceAllLiteralString(src, repl string) (result string) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("ReplaceAllLiteralString", src, &result, func(std *regexp.Regexp) any { return std.ReplaceAllLiteralString(src, repl) })
 	}
+	return re.replaceAll(src, repl, -1, true)
+}
 
-	var result bytes.Buffer
+// replaceAll は、すべての置換を処理する内部関数です。先頭から最大 n 個（n が負なら全て）のマッチを置き換えます。
+// 入力を先頭から1度だけ走査し、結果を strings.Builder に組み立てます。
+func (re *Regexp) replaceAll(src, repl string, n int, literal bool) string {
+	var result strings.Builder
 	lastEnd := 0
+	count := 0
 
-	// マッチごとに処理
-	for indices != nil {
+	// マッチごとに処理（リテラル置換ではサブマッチの位置は不要）
+	forEachStringMatch(re.prog, src, n, !literal, func(loc []int) {
 		// マッチ前の部分を追加
-		result.Write(src[lastEnd:indices[0]])
+		result.WriteString(src[lastEnd:loc[0]])
 
 		// 置換テキストを処理
 		if literal {
 			// リテラル置換
-			result.Write(repl)
+			result.WriteString(repl)
 		} else {
 			// 展開付き置換
-			expanded := re.expandReplacement(repl, src, indices)
-			result.Write(expanded)
+			count++
+			re.expandReplacement(&result, repl, src, loc, count)
 		}
 
-		// 次の検索開始位置を更新
-		lastEnd = indices[1]
-
-		// 次のマッチを検索
-		if lastEnd >= len(src) {
-			break
-		}
-		indices = re.FindSubmatchIndex(src[lastEnd:])
-		if indices != nil {
-			// 検索開始位置を調整
-			for i := range indices {
-				indices[i] += lastEnd
-			}
-		}
-	}
+		// 次のマッチの前の部分はここから
+		lastEnd = loc[1]
+	})
 
 	// 最後のマッチ以降の部分を追加
-	if lastEnd < len(src) {
-		result.Write(src[lastEnd:])
-	}
+	result.WriteString(src[lastEnd:])
+
+	return result.String()
+}
 
-	return result.Bytes()
+// hasMatchCounter は、置換テキストが$#を含むかどうかを返します。
+// 標準ライブラリは$#を展開しないため、含む場合は CrossCheck で比較しません。
+func hasMatchCounter(repl string) bool {
+	return strings.Contains(repl, "$#")
 }
 
-// expandReplacement は、置換テキスト内の$1, $2, ...を展開します。
-func (re *Regexp) expandReplacement(repl, src []byte, indices []int) []byte {
-	var result bytes.Buffer
+// expandReplacement は、置換テキスト内の$1, $2, ...を展開して dst に書き込みます。
+// $#は、マッチの番号 count に置き換えます。
+func (re *Regexp) expandReplacement(dst *strings.Builder, repl, src string, indices []int, count int) {
 	for i := 0; i < len(repl); i++ {
 		if repl[i] == '$' && i+1 < len(repl) {
 			i++ // $の次の文字へ
 			switch {
 			case repl[i] == '$':
 				// $$は$にエスケープ
-				result.WriteByte('$')
+				dst.WriteByte('$')
+			case repl[i] == '#':
+				// マッチの番号
+				dst.WriteString(strconv.Itoa(count))
 			case '0' <= repl[i] && repl[i] <= '9':
 				// グループ参照
 				group := int(repl[i] - '0')
@@ -330,170 +846,178 @@ func (re *Regexp) expandReplacement(repl, src []byte, indices []int) []byte {
 				if group <= re.numSubexp && 2*group+1 < len(indices) {
 					start, end := indices[2*group], indices[2*group+1]
 					if start >= 0 && end >= 0 {
-						result.Write(src[start:end])
+						dst.WriteString(src[start:end])
 					}
 				}
 			default:
 				// 不明な$シーケンスは$そのものとして処理
-				result.WriteByte('$')
-				result.WriteByte(repl[i])
+				dst.WriteByte('$')
+				dst.WriteByte(repl[i])
 			}
 		} else {
 			// 通常の文字
-			result.WriteByte(repl[i])
+			dst.WriteByte(repl[i])
 		}
 	}
-	return result.Bytes()
 }
 
 // FindAllStringSubmatch は、sの中で正規表現にマッチするすべての部分文字列と、
 // 各サブマッチ（キャプチャグループ）を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
+func (re *Regexp) FindAllStringSubmatch(s string, n int) (result [][]string) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllStringSubmatch", s, &result, func(std *regexp.Regexp) any { return std.FindAllStringSubmatch(s, n) })
+	}
 	if n == 0 {
 		return nil
 	}
 
-	var result [][]string
-	start := 0
-
-	for {
-		if n > 0 && len(result) >= n {
-			break
-		}
-
-		// 現在位置からマッチを検索
-		input := s[start:]
-		runes := []rune(input)
-		m := newMatcher(re.prog, runes)
-		if !m.Match() {
-			break
-		}
-
-		// マッチ結果を取得
-		caps := m.CaptureTexts()
-		result = append(result, caps)
-
-		// マッチの終了位置を取得（次の検索開始位置）
-		matchPos := m.Captures()[0]
-		if matchPos[0] == matchPos[1] {
-			// 空マッチの場合は1文字進める
-			start += 1
-		} else {
-			// 通常のマッチの場合はマッチの終了位置から
-			runeEnd := matchPos[1]
-			if runeEnd > 0 {
-				// ルーンインデックスからバイトインデックスへ変換
-				byteEnd := runeSliceIndex(input, runeEnd)
-				start += byteEnd
-			}
-		}
-
-		// 検索文字列の終わりに達した場合は終了
-		if start >= len(s) {
-			break
-		}
-	}
-
+	forEachStringMatch(re.prog, s, n, true, func(loc []int) {
+		result = append(result, submatchStrings(s, loc))
+	})
 	return result
 }
 
 // Split は、正規表現がマッチする位置で文字列を分割します。
 // nが正の場合は最大でn個の部分文字列を返し、それ以外の場合はすべての部分文字列を返します。
-func (re *Regexp) Split(s string, n int) []string {
+// 標準ライブラリと同様に、先頭の空マッチでは分割せず、文字列の末尾で終わるマッチの後には空文字列を加えません。
+func (re *Regexp) Split(s string, n int) (result []string) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("Split", s, &result, func(std *regexp.Regexp) any { return std.Split(s, n) })
+	}
 	if n == 0 {
 		return nil
 	}
-
-	if n < 0 {
-		n = len(s) + 1
+	if len(re.expr) > 0 && len(s) == 0 {
+		return []string{""}
 	}
 
-	var result []string
-	matches := re.FindAllStringIndex(s, n-1)
+	result, _ = re.split(s, n)
+	return result
+}
 
-	if matches == nil {
-		return []string{s}
+// SplitErr は Split と同様ですが、ステップ数の上限や結果の上限（Options.MaxMatches など）に達して
+// 照合を打ち切った場合は、それまでに分割した部分文字列とエラーを返します。
+func (re *Regexp) SplitErr(s string, n int) ([]string, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if len(re.expr) > 0 && len(s) == 0 {
+		return []string{""}, nil
 	}
+	return re.split(s, n)
+}
 
-	lastEnd := 0
-	for _, match := range matches {
-		// マッチ前の部分を結果に追加
-		result = append(result, s[lastEnd:match[0]])
-		lastEnd = match[1]
+// split は、Split の本体です。照合を打ち切った場合は、それまでに分割した部分文字列とエラーを返します。
+func (re *Regexp) split(s string, n int) ([]string, error) {
+	// 先頭から順にマッチを見つけ、その間の部分を切り出す
+	// （先頭の空マッチを除くため、n-1 個の部分文字列に n 個のマッチが必要な場合がある）
+	result := make([]string, 0)
+	beg, end := 0, 0
+	err := forEachStringMatch(re.prog, s, n, false, func(loc []int) {
+		if n > 0 && len(result) == n-1 {
+			return
+		}
+		end = loc[0]
+		if loc[1] != 0 {
+			result = append(result, s[beg:end])
+		}
+		beg = loc[1]
+	})
+
+	if err != nil {
+		return result, err
 	}
 
 	// 最後のマッチ以降の部分を追加
-	result = append(result, s[lastEnd:])
+	if end != len(s) {
+		result = append(result, s[beg:])
+	}
+	return result, nil
+}
+
+// SplitOptions は、SplitWithOptions による分割の設定です。ゼロ値では Split と同じ結果になります。
+type SplitOptions struct {
+	// OmitTrailingEmpty は、結果の末尾に続く空文字列を取り除くかどうかです（Java の String.split と同じ）。
+	// "a,b,," を "," で分割すると、Split では ["a" "b" "" ""] ですが、これを指定すると ["a" "b"] になります。
+	// n で分割の数を制限した場合、最後の残りの部分が空文字列であればそれも取り除きます。
+	OmitTrailingEmpty bool
+}
 
+// SplitWithOptions は、Split と同じく文字列を分割し、opts に従って結果を調整します。
+// 戻り値は、opts の指定がなければ Split（および標準ライブラリの regexp.Split）と同じです。
+func (re *Regexp) SplitWithOptions(s string, n int, opts SplitOptions) []string {
+	result := re.Split(s, n)
+	if opts.OmitTrailingEmpty {
+		for len(result) > 0 && result[len(result)-1] == "" {
+			result = result[:len(result)-1]
+		}
+	}
 	return result
 }
 
+// FieldsString は、正規表現にマッチする部分の連続を区切りとして文字列を分割し、空でない部分文字列を返します。
+// strings.Fields の区切りを正規表現で指定するものに相当し、Split と異なり先頭や末尾、連続する区切りの間の空文字列を含みません。
+// 空でない部分文字列がない場合は空のスライスを返します。
+func (re *Regexp) FieldsString(s string) []string {
+	fields := make([]string, 0)
+	beg := 0
+	forEachStringMatch(re.prog, s, -1, false, func(loc []int) {
+		if loc[0] > beg {
+			fields = append(fields, s[beg:loc[0]])
+		}
+		beg = loc[1]
+	})
+	if beg < len(s) {
+		fields = append(fields, s[beg:])
+	}
+	return fields
+}
+
 // FindAllStringIndex は、sの中で正規表現にマッチするすべての部分文字列の位置を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
+func (re *Regexp) FindAllStringIndex(s string, n int) (result [][]int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllStringIndex", s, &result, func(std *regexp.Regexp) any { return std.FindAllStringIndex(s, n) })
+	}
 	if n == 0 {
 		return nil
 	}
+	return findAllStringIndex(re.prog, s, n)
+}
 
-	var result [][]int
-	start := 0
-
-	for {
-		if n > 0 && len(result) >= n {
-			break
-		}
-
-		// 現在位置からマッチを検索
-		input := s[start:]
-		index := re.FindStringIndex(input)
-		if index == nil {
-			break
-		}
-
-		// マッチ位置を調整（全体の文字列での位置に）
-		adjustedIndex := []int{start + index[0], start + index[1]}
-		result = append(result, adjustedIndex)
-
-		// 次の検索開始位置を更新
-		start = adjustedIndex[1]
-		if start == adjustedIndex[0] {
-			// 空マッチの場合は1文字進める
-			if start < len(s) {
-				_, size := utf8.DecodeRuneInString(s[start:])
-				start += size
-			} else {
-				break
-			}
-		}
-
-		// 検索文字列の終わりに達した場合は終了
-		if start >= len(s) {
-			break
-		}
+// FindAllIndex は、bの中で正規表現にマッチするすべての部分文字列の位置を返します。
+// nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
+func (re *Regexp) FindAllIndex(b []byte, n int) (result [][]int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllIndex", string(b), &result, func(std *regexp.Regexp) any { return std.FindAllIndex(b, n) })
 	}
-
-	return result
+	if n == 0 {
+		return nil
+	}
+	return findAllIndex(re.prog, b, n)
 }
 
 // FindAllSubmatch は、bの中で正規表現にマッチするすべての部分文字列と、
 // 各サブマッチ（キャプチャグループ）を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
-	matches := re.FindAllStringSubmatch(string(b), n)
+// 各要素は、FindSubmatch と同じく b の一部を複製せずに共有します。
+func (re *Regexp) FindAllSubmatch(b []byte, n int) (result [][][]byte) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllSubmatch", string(b), &result, func(std *regexp.Regexp) any { return std.FindAllSubmatch(b, n) })
+	}
+	if n == 0 {
+		return nil
+	}
+
+	matches := findAllSubmatchIndex(re.prog, b, n)
 	if matches == nil {
 		return nil
 	}
 
-	result := make([][][]byte, len(matches))
+	result = make([][][]byte, len(matches))
 	for i, match := range matches {
-		byteMatch := make([][]byte, len(match))
-		for j, s := range match {
-			if s != "" {
-				byteMatch[j] = []byte(s)
-			}
-		}
-		result[i] = byteMatch
+		result[i] = submatchBytes(b, match)
 	}
 
 	return result
@@ -501,17 +1025,23 @@ func (re *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
 
 // FindAll は、bの中で正規表現にマッチするすべての部分文字列を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAll(b []byte, n int) [][]byte {
-	matches := re.FindAllStringSubmatch(string(b), n)
+func (re *Regexp) FindAll(b []byte, n int) (result [][]byte) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAll", string(b), &result, func(std *regexp.Regexp) any { return std.FindAll(b, n) })
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// サブマッチは不要なので、マッチ全体の位置だけを求める
+	matches := findAllIndex(re.prog, b, n)
 	if matches == nil {
 		return nil
 	}
 
-	result := make([][]byte, len(matches))
+	result = make([][]byte, len(matches))
 	for i, match := range matches {
-		if len(match) > 0 && match[0] != "" {
-			result[i] = []byte(match[0])
-		}
+		result[i] = b[match[0]:match[1]:match[1]]
 	}
 
 	return result
@@ -519,17 +1049,23 @@ func (re *Regexp) FindAll(b []byte, n int) [][]byte {
 
 // FindAllString は、sの中で正規表現にマッチするすべての部分文字列を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAllString(s string, n int) []string {
-	matches := re.FindAllStringSubmatch(s, n)
+func (re *Regexp) FindAllString(s string, n int) (result []string) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllString", s, &result, func(std *regexp.Regexp) any { return std.FindAllString(s, n) })
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// サブマッチは不要なので、マッチ全体の位置だけを求める
+	matches := findAllStringIndex(re.prog, s, n)
 	if matches == nil {
 		return nil
 	}
 
-	result := make([]string, len(matches))
+	result = make([]string, len(matches))
 	for i, match := range matches {
-		if len(match) > 0 {
-			result[i] = match[0]
-		}
+		result[i] = s[match[0]:match[1]]
 	}
 
 	return result
@@ -538,60 +1074,25 @@ func (re *Regexp) FindAllString(s string, n int) []string {
 // FindAllSubmatchIndex は、bの中で正規表現にマッチするすべての部分文字列と、
 // 各サブマッチ（キャプチャグループ）の位置を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAllSubmatchIndex(b []byte, n int) [][]int {
-	return re.FindAllStringSubmatchIndex(string(b), n)
+func (re *Regexp) FindAllSubmatchIndex(b []byte, n int) (result [][]int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllSubmatchIndex", string(b), &result, func(std *regexp.Regexp) any { return std.FindAllSubmatchIndex(b, n) })
+	}
+	if n == 0 {
+		return nil
+	}
+	return findAllSubmatchIndex(re.prog, b, n)
 }
 
 // FindAllStringSubmatchIndex は、sの中で正規表現にマッチするすべての部分文字列と、
 // 各サブマッチ（キャプチャグループ）の位置を返します。
 // nが負の場合はすべてのマッチを返し、それ以外の場合は最大でn個のマッチを返します。
-func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
+func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) (result [][]int) {
+	if re.crossCheck != nil {
+		defer re.crossCheck.compare("FindAllStringSubmatchIndex", s, &result, func(std *regexp.Regexp) any { return std.FindAllStringSubmatchIndex(s, n) })
+	}
 	if n == 0 {
 		return nil
 	}
-
-	var result [][]int
-	start := 0
-
-	for {
-		if n > 0 && len(result) >= n {
-			break
-		}
-
-		// 現在位置からマッチを検索
-		input := s[start:]
-		indices := re.FindStringSubmatchIndex(input)
-		if indices == nil {
-			break
-		}
-
-		// マッチ位置を調整（全体の文字列での位置に）
-		for i := range indices {
-			if indices[i] >= 0 {
-				indices[i] += start
-			}
-		}
-		result = append(result, indices)
-
-		// 次の検索開始位置を更新
-		matchEnd := indices[1]
-		if matchEnd == indices[0] {
-			// 空マッチの場合は1文字進める
-			if start < len(s) {
-				_, size := utf8.DecodeRuneInString(s[start:])
-				start += size
-			} else {
-				break
-			}
-		} else {
-			start = matchEnd
-		}
-
-		// 検索文字列の終わりに達した場合は終了
-		if start >= len(s) {
-			break
-		}
-	}
-
-	return result
+	return findAllStringSubmatchIndex(re.prog, s, n)
 }
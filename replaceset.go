@@ -0,0 +1,124 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "strings"
+
+// ReplaceRule は、ReplaceSet の1つの置換の規則です。
+type ReplaceRule struct {
+	Pattern  string // 置き換える部分のパターン
+	Template string // 置換テキスト（ReplaceAllString と同じく $1 や $# を展開します）
+}
+
+// ReplaceSet は、NewReplaceSet でまとめてコンパイルした置換の規則の組です。
+// ReplaceSet は作成後に変更しないため、複数のゴルーチンから同時に使えます。
+type ReplaceSet struct {
+	regexps   []*Regexp
+	templates []string
+}
+
+// NewReplaceSet は、順序のある置換の規則 rules をまとめてコンパイルします。パターンは CompileBundle と同じくコンパイルします。
+// コンパイルに失敗したパターンや、パターンにないグループを参照する置換テキスト（ValidateTemplate を参照）があれば、
+// それらの規則の番号を含む BundleErrors を返します。
+func NewReplaceSet(rules []ReplaceRule, opts Options) (*ReplaceSet, error) {
+	patterns := make([]string, len(rules))
+	for i, rule := range rules {
+		patterns[i] = rule.Pattern
+	}
+	b, err := CompileBundle(patterns, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ReplaceSet{regexps: b.regexps, templates: make([]string, len(rules))}
+	var errs BundleErrors
+	for i, rule := range rules {
+		if err := rs.regexps[i].ValidateTemplate(rule.Template); err != nil {
+			errs = append(errs, &BundleError{Index: i, Pattern: rule.Pattern, Err: err})
+		}
+		rs.templates[i] = rule.Template
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rs, nil
+}
+
+// ReplaceAllString は、src を先頭から1度だけ走査し、いずれかの規則のパターンにマッチする部分を、その規則の置換テキストで置き換えます。
+// 規則ごとに ReplaceAllString を順に適用する場合と異なり、置き換えた結果をほかの規則で再び照合することはありません。
+//
+// 走査している位置より後ろで、最も前から始まるマッチを採用し、同じ位置から始まるマッチが複数あれば、先の規則のものを採用します。
+// 採用したマッチと重なるほかの規則のマッチは捨て、採用したマッチの後から探し直します。
+// 空マッチの扱いは ReplaceAllString と同じで、$# は置き換えたマッチの通し番号に展開します。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+func (rs *ReplaceSet) ReplaceAllString(src string) string {
+	// 各規則の、走査している位置以降の最初のマッチ
+	type candidate struct {
+		start, end int   // ルーン単位の位置
+		loc        []int // マッチと各グループのバイト位置
+		done       bool  // これ以上マッチしない
+	}
+	matchers := make([]*Matcher, len(rs.regexps))
+	for i, re := range rs.regexps {
+		matchers[i] = re.prog.getMatcher(true)
+		defer re.prog.putMatcher(matchers[i])
+		matchers[i].resetString(src)
+	}
+	cands := make([]candidate, len(rs.regexps))
+	prevEnd := -1 // 直前に置き換えたマッチの終了位置（ルーン単位）
+
+	// find は、規則 i のマッチを位置 from 以降で探し直します。直前のマッチの直後に隣接する空マッチは無視します。
+	find := func(i, from int) {
+		m, c := matchers[i], &cands[i]
+		for {
+			if from > len(m.input) || !m.searchRange(from, len(m.input)+1) {
+				c.done = true
+				return
+			}
+			start, end := m.saved[0], m.saved[1]
+			if start == end && start == prevEnd {
+				from = start + 1
+				continue
+			}
+			c.start, c.end, c.loc = start, end, m.submatchIndex()
+			return
+		}
+	}
+	for i := range cands {
+		find(i, 0)
+	}
+
+	var result strings.Builder
+	lastEnd, count := 0, 0
+	for pos := 0; ; {
+		best := -1
+		for i := range cands {
+			c := &cands[i]
+			if !c.done && (c.start < pos || (c.start == c.end && c.start == prevEnd)) {
+				find(i, pos)
+			}
+			if !c.done && (best < 0 || c.start < cands[best].start) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		c := cands[best]
+		result.WriteString(src[lastEnd:c.loc[0]])
+		count++
+		rs.regexps[best].expandReplacement(&result, rs.templates[best], src, c.loc, count)
+		lastEnd, prevEnd, pos = c.loc[1], c.end, c.end
+		if c.start == c.end {
+			// 空マッチの後は、1文字進めて探す
+			pos++
+		}
+	}
+	result.WriteString(src[lastEnd:])
+	return result.String()
+}
+
+// ReplaceAll は、ReplaceAllString のバイト列版です。
+func (rs *ReplaceSet) ReplaceAll(src []byte) []byte {
+	return []byte(rs.ReplaceAllString(string(src)))
+}
@@ -0,0 +1,217 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// traceMagic は、RecordTrace が返す記録の先頭に置く識別子と形式の版です。
+const traceMagic = "btrt\x01"
+
+// ErrInvalidTrace は、ReplayTrace に渡した記録の形式が正しくない場合のエラーです。
+var ErrInvalidTrace = errors.New("トレースの記録の形式が正しくありません")
+
+// 記録する設定のフラグのビット（RecordTrace で並べる順）
+const (
+	traceCaseInsensitive = 1 << iota
+	traceMultiline
+	traceDotMatchesNL
+	traceUngreedy
+	traceLatin1
+	traceUnsetBackrefMatchesEmpty
+	traceCoverage
+	traceWholeWord
+)
+
+// RecordTrace は、文字列 s の最初のマッチを探す照合を Trace と同じように1命令ずつ記録し、
+// パターンと設定、入力とともに、コンパクトなバイト列にして返します。
+// 記録は ReplayTrace（または btregexp-debug コマンドの -replay）で再生できます。
+//
+// 記録は照合の命令数に比例して大きくなります。ステップ数の上限（破滅的なバックトラック）まで照合した場合は、非常に大きくなります。
+func (re *Regexp) RecordTrace(s string) []byte {
+	var steps []TraceStep
+	loc := re.Trace(s, func(step TraceStep) { steps = append(steps, step) })
+
+	opts := re.opts
+	var bits uint64
+	for i, on := range []bool{
+		opts.Flags.CaseInsensitive,
+		opts.Flags.Multiline,
+		opts.Flags.DotMatchesNL,
+		opts.Flags.Ungreedy,
+		opts.Latin1,
+		opts.UnsetBackrefMatchesEmpty,
+		opts.Coverage,
+		opts.WholeWord,
+	} {
+		bits |= uint64(boolToInt(on)) << i
+	}
+
+	buf := []byte(traceMagic)
+	buf = appendTraceString(buf, re.expr)
+	buf = appendTraceString(buf, s)
+	buf = binary.AppendUvarint(buf, bits)
+	buf = binary.AppendUvarint(buf, uint64(opts.Flags.Normalization))
+	buf = binary.AppendUvarint(buf, uint64(opts.Dialect))
+	buf = binary.AppendUvarint(buf, uint64(opts.Anchored))
+	buf = binary.AppendVarint(buf, int64(opts.MaxProgramSize))
+
+	// 結果（位置は-1があり得るので1を足す）
+	buf = binary.AppendUvarint(buf, uint64(len(loc)))
+	for _, pos := range loc {
+		buf = binary.AppendUvarint(buf, uint64(pos+1))
+	}
+
+	// 各ステップ（開始位置は直前のステップとの差）
+	buf = binary.AppendUvarint(buf, uint64(len(steps)))
+	start := 0
+	for _, step := range steps {
+		buf = binary.AppendUvarint(buf, uint64(step.PC)<<1|uint64(boolToInt(step.Backtrack)))
+		buf = binary.AppendUvarint(buf, uint64(step.Pos))
+		buf = binary.AppendUvarint(buf, uint64(step.Depth))
+		buf = binary.AppendUvarint(buf, uint64(step.Start-start))
+		start = step.Start
+	}
+	return buf
+}
+
+// appendTraceString は、長さを前に付けた文字列を buf に追加します。
+func appendTraceString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// TraceReplay は、ReplayTrace で再生した照合の記録です。
+type TraceReplay struct {
+	Pattern string  // パターン
+	Options Options // パターンのコンパイルに使った設定（照合の結果に影響するものだけ）
+	Input   string  // 入力
+
+	// Steps は、記録した各ステップです。Instr は、このビルドでパターンをコンパイルし直した命令の表記です。
+	Steps []TraceStep
+
+	// Result は、記録した照合の結果です（FindStringSubmatchIndex と同じ形式）。
+	Result []int
+
+	// Diverged は、このビルドで同じ照合をし直した結果が記録と異なる場合に、最初に異なるステップの番号です。
+	// 記録と同じ場合は-1です。ステップ数が異なる場合は、短い方のステップ数になります。
+	Diverged int
+}
+
+// ReplayTrace は、RecordTrace の記録を読み取り、記録したパターンと設定でコンパイルし直して同じ照合をし直し、
+// 記録と比べた結果を返します。記録の形式が正しくない場合は ErrInvalidTrace を返します。
+func ReplayTrace(data []byte) (*TraceReplay, error) {
+	d := traceDecoder{data: data}
+	if len(data) < len(traceMagic) || string(data[:len(traceMagic)]) != traceMagic {
+		return nil, ErrInvalidTrace
+	}
+	d.data = data[len(traceMagic):]
+
+	r := &TraceReplay{Pattern: d.string(), Input: d.string(), Diverged: -1}
+	bits := d.uvarint()
+	r.Options = Options{
+		Flags: Flags{
+			CaseInsensitive: bits&traceCaseInsensitive != 0,
+			Multiline:       bits&traceMultiline != 0,
+			DotMatchesNL:    bits&traceDotMatchesNL != 0,
+			Ungreedy:        bits&traceUngreedy != 0,
+			Normalization:   NormalizationForm(d.uvarint()),
+		},
+		Latin1:                   bits&traceLatin1 != 0,
+		UnsetBackrefMatchesEmpty: bits&traceUnsetBackrefMatchesEmpty != 0,
+		Coverage:                 bits&traceCoverage != 0,
+		WholeWord:                bits&traceWholeWord != 0,
+		Dialect:                  Dialect(d.uvarint()),
+		Anchored:                 Anchor(d.uvarint()),
+		MaxProgramSize:           int(d.varint()),
+	}
+	if n := d.count(); n > 0 {
+		r.Result = make([]int, n)
+		for i := range r.Result {
+			r.Result[i] = int(d.uvarint()) - 1
+		}
+	}
+	n := d.count()
+	start := 0
+	for i := 0; i < n && d.err == nil; i++ {
+		pc := d.uvarint()
+		step := TraceStep{PC: int(pc >> 1), Backtrack: pc&1 != 0, Pos: int(d.uvarint()), Depth: int(d.uvarint())}
+		start += int(d.uvarint())
+		step.Start = start
+		r.Steps = append(r.Steps, step)
+	}
+	if d.err != nil || len(d.data) != 0 {
+		return nil, ErrInvalidTrace
+	}
+
+	re, err := CompileWithOptions(r.Pattern, r.Options)
+	if err != nil {
+		return nil, fmt.Errorf("記録したパターンをコンパイルできません: %w", err)
+	}
+	for i := range r.Steps {
+		if pc := r.Steps[i].PC; pc < len(re.prog.instrs) {
+			r.Steps[i].Instr = re.prog.instrs[pc].String()
+		}
+	}
+
+	// このビルドで照合し直し、記録と比べる
+	i := 0
+	re.Trace(r.Input, func(step TraceStep) {
+		if r.Diverged < 0 && (i >= len(r.Steps) || step != r.Steps[i]) {
+			r.Diverged = i
+		}
+		i++
+	})
+	if r.Diverged < 0 && i != len(r.Steps) {
+		r.Diverged = i
+	}
+	return r, nil
+}
+
+// traceDecoder は、RecordTrace の記録を先頭から読み取ります。形式の誤りは err に記録します。
+type traceDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *traceDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = ErrInvalidTrace
+		d.data = nil
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *traceDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = ErrInvalidTrace
+		d.data = nil
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count は、後に続く要素の数を読み取ります。残りのデータより多い数は誤りとします。
+func (d *traceDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.err = ErrInvalidTrace
+		d.data = nil
+		return 0
+	}
+	return int(n)
+}
+
+func (d *traceDecoder) string() string {
+	n := d.count()
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
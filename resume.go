@@ -0,0 +1,195 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"iter"
+	"unicode/utf8"
+)
+
+// ResumableMatch は、少しずつ届く入力の中の最初のマッチを探す照合です。
+// 照合が届いた入力の末尾に達すると、実行中の命令の位置、入力の位置、バックトラックスタック、キャプチャの状態を保ったまま中断し、
+// 続きの入力が Write で届くと、そこから照合を再開します。
+//
+// AllMatchesReader と異なり、続きの入力が届いたときに照合を最初からやり直しません。
+// その代わり、照合を終えるまで、届いた入力をすべて保持します。
+// 保持する入力が Options.MaxStreamHistory を超えると、照合を ErrStreamHistoryExceeded で打ち切ります。
+// 入力を正規化する設定（Flags.Normalization）では、Close で入力がすべて届いてから照合します。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+//
+// 使い終わったら、照合を終えていなくても必ず Close を呼び出してください。
+// ResumableMatch は複数のゴルーチンから同時に使えません。
+type ResumableMatch struct {
+	prog     *program
+	m        *Matcher
+	anchored bool
+	partial  []byte // 末尾で途切れた文字のバイト列（続きが届くまで照合しない）
+	size     int    // 照合の入力に加えたバイト数
+	eof      bool
+	next     func() (struct{}, bool) // 中断した照合を再開する関数（照合を始めていなければ nil）
+	stop     func()
+	done     bool
+	result   []int
+	err      error
+}
+
+// NewResumableMatch は、入力の先頭から照合を始める ResumableMatch を返します。
+// anchored が true なら、入力の先頭から始まるマッチだけを探します（先頭でマッチしないと分かれば、続きを待たずに終えます）。
+func (re *Regexp) NewResumableMatch(anchored bool) *ResumableMatch {
+	m := re.prog.getMatcher(true)
+	m.resetBytes(nil)
+	return &ResumableMatch{prog: re.prog, m: m, anchored: anchored}
+}
+
+// Write は、入力の続き chunk を加えて照合を再開し、照合を終えたかどうかを返します。
+// 照合が入力の末尾に達すると、再び中断して false を返します。照合を終えた後に呼び出しても何もしません。
+func (rm *ResumableMatch) Write(chunk []byte) bool {
+	if rm.done {
+		return true
+	}
+	if rm.prog.normalization != NoNormalization {
+		rm.partial = append(rm.partial, chunk...)
+		return rm.checkHistory()
+	}
+	added := rm.appendInput(chunk)
+	if rm.checkHistory() {
+		return true
+	}
+	if !added && rm.next != nil {
+		// 完全な文字が届いていなければ、中断したままにする
+		return false
+	}
+	rm.resume()
+	return rm.done
+}
+
+// Close は、入力の終わりを伝えて照合を最後まで実行します。照合を終えた後に呼び出しても何もしません。
+func (rm *ResumableMatch) Close() {
+	if rm.done {
+		return
+	}
+	rm.eof = true
+	if rm.prog.normalization != NoNormalization {
+		rm.m.resetBytes(rm.partial)
+		rm.partial = nil
+	} else {
+		rm.appendInput(nil)
+	}
+	rm.resume()
+}
+
+// Done は、照合を終えたかどうかを返します。
+func (rm *ResumableMatch) Done() bool {
+	return rm.done
+}
+
+// Result は、見つけたマッチ全体と各グループの位置を返します（FindSubmatchIndex と同じ形式の、入力の先頭からのバイト位置）。
+// 照合を終えていない場合や、マッチしなかった場合は nil を返します。
+func (rm *ResumableMatch) Result() []int {
+	return rm.result
+}
+
+// Err は、ステップ数の上限に達して照合を打ち切った場合に ErrStepLimitExceeded を返します。
+// 保持する入力が上限を超えて照合を打ち切った場合は ErrStreamHistoryExceeded を返します。
+func (rm *ResumableMatch) Err() error {
+	return rm.err
+}
+
+// appendInput は、chunk を文字に変換して照合の入力に加え、文字が増えたかどうかを返します。
+// 入力の終わりでなければ、末尾で途切れた文字は次の chunk まで残します。
+func (rm *ResumableMatch) appendInput(chunk []byte) bool {
+	m := rm.m
+	data := append(rm.partial, chunk...)
+	added := len(m.runes)
+	m.offsets = m.offsets[:len(m.offsets)-1] // 末尾の入力の長さは、加えた後に付け直す
+	i := 0
+	for i < len(data) {
+		r, size := rune(data[i]), 1
+		if !rm.prog.latin1 {
+			if !rm.eof && !utf8.FullRune(data[i:]) {
+				break
+			}
+			r, size = utf8.DecodeRune(data[i:])
+		}
+		m.runes = append(m.runes, r)
+		m.offsets = append(m.offsets, rm.size+i)
+		i += size
+	}
+	rm.size += i
+	m.offsets = append(m.offsets, rm.size)
+	m.input = m.runes
+	rm.partial = append(rm.partial[:0], data[i:]...)
+	return len(m.runes) > added
+}
+
+// resume は、照合を始めるか、中断した照合を再開し、照合を終えたら結果を記録します。
+func (rm *ResumableMatch) resume() {
+	if rm.next == nil {
+		rm.next, rm.stop = iter.Pull(rm.search)
+	}
+	if _, ok := rm.next(); ok {
+		return
+	}
+	rm.err = rm.m.err
+	rm.finish()
+}
+
+// checkHistory は、保持している入力が Options.MaxStreamHistory を超えていれば、照合を打ち切って true を返します。
+func (rm *ResumableMatch) checkHistory() bool {
+	size, limit := rm.size+len(rm.partial), rm.prog.maxStreamHistory
+	if limit <= 0 || size <= limit {
+		return false
+	}
+	rm.err = fmt.Errorf("%w: %d バイト（上限 %d バイト）", ErrStreamHistoryExceeded, size, limit)
+	rm.partial = nil
+	rm.finish()
+	return true
+}
+
+// finish は、照合を終えた状態にし、中断している照合があれば中止して、マッチャーを返却します。
+func (rm *ResumableMatch) finish() {
+	rm.done = true
+	if rm.stop != nil {
+		rm.stop()
+	}
+	rm.m.more = nil
+	rm.prog.putMatcher(rm.m)
+	rm.m = nil
+}
+
+// search は、照合を実行するコルーチンの本体です。入力の末尾に達するたびに yield で中断し、続きの入力を待ちます。
+func (rm *ResumableMatch) search(yield func(struct{}) bool) {
+	m := rm.m
+	defer func() {
+		// 中断したまま中止した照合は、結果を記録せずに抜け出す
+		if r := recover(); r != nil {
+			if _, ok := r.(stepAborted); !ok {
+				panic(r)
+			}
+		}
+	}()
+	m.more = func() bool {
+		if rm.eof {
+			return false
+		}
+		if !yield(struct{}{}) {
+			panic(stepAborted{})
+		}
+		return true
+	}
+	for start := 0; ; start++ {
+		// 開始位置までの入力と、マッチに必要な最小長の入力が届くのを待つ
+		for (start >= len(m.input) || m.tooShort(start)) && m.moreInput() {
+		}
+		if start > len(m.input) {
+			return
+		}
+		if m.MatchStart(start) {
+			rm.result = m.submatchIndex()
+			return
+		}
+		if m.err != nil || rm.anchored {
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Segments は、複数のバイト列を順につないだものを1つの入力として扱うための型です。
+// ネットワークの scatter/gather バッファ（net.Buffers は Segments(bufs) で変換できます）やロープのように断片に分かれたデータを、連結せずに照合できます。
+// 文字がバイト列の境界をまたいでいてもかまいません。
+//
+// Segments を受け取るメソッドが返す位置は、つないだ入力の先頭からのバイト位置です。
+// バイト列の番号とその中の位置は Pos で、マッチした部分は Slice で取り出します。
+type Segments [][]byte
+
+// Len は、つないだ入力のバイト数を返します。
+func (s Segments) Len() int {
+	n := 0
+	for _, seg := range s {
+		n += len(seg)
+	}
+	return n
+}
+
+// Pos は、つないだ入力の先頭からのバイト位置 off を、そのバイトを含むバイト列の番号と、その中の位置に変換します。
+// off が入力のバイト数以上なら (len(s), off-s.Len()) を返します。
+func (s Segments) Pos(off int) (seg, offset int) {
+	for i, b := range s {
+		if off < len(b) {
+			return i, off
+		}
+		off -= len(b)
+	}
+	return len(s), off
+}
+
+// Slice は、つないだ入力のバイト位置 start から end までの部分を、元のバイト列の部分スライスの列で返します。
+// データはコピーしません。範囲が入力の外にある部分と、空になる部分は含めません。
+func (s Segments) Slice(start, end int) Segments {
+	var result Segments
+	for _, b := range s {
+		if lo, hi := max(start, 0), min(end, len(b)); lo < hi {
+			result = append(result, b[lo:hi])
+		}
+		start -= len(b)
+		end -= len(b)
+	}
+	return result
+}
+
+// MatchSegments は、segs をつないだ入力が正規表現にマッチするかどうかを返します。
+// Segments を受け取るメソッドは、LinearFallback の設定にかかわらず常にこのエンジンで照合します。
+// 入力を正規化する設定（Flags.Normalization）では、照合の前にバイト列を連結します。
+func (re *Regexp) MatchSegments(segs Segments) bool {
+	m := re.prog.getMatcher(false)
+	defer re.prog.putMatcher(m)
+	m.resetSegments(segs)
+	return m.Match()
+}
+
+// FindSegmentsIndex は、segs をつないだ入力の最初のマッチの位置を返します。マッチしなければ nil を返します。
+func (re *Regexp) FindSegmentsIndex(segs Segments) []int {
+	m := re.prog.getMatcher(false)
+	defer re.prog.putMatcher(m)
+	m.resetSegments(segs)
+	if !m.search(0) {
+		return nil
+	}
+	return m.matchIndex()
+}
+
+// FindSegmentsSubmatchIndex は、segs をつないだ入力の最初のマッチと各グループの位置を返します（FindSubmatchIndex と同じ形式）。
+func (re *Regexp) FindSegmentsSubmatchIndex(segs Segments) []int {
+	m := re.prog.getMatcher(true)
+	defer re.prog.putMatcher(m)
+	m.resetSegments(segs)
+	if !m.search(0) {
+		return nil
+	}
+	return m.submatchIndex()
+}
+
+// FindAllSegmentsIndex は、segs をつないだ入力の重ならないマッチの位置を、先頭から順に最大 n 個（n が負なら全て）返します。
+func (re *Regexp) FindAllSegmentsIndex(segs Segments, n int) [][]int {
+	m := re.prog.getMatcher(false)
+	defer re.prog.putMatcher(m)
+	m.resetSegments(segs)
+	return m.allIndex(n)
+}
+
+// resetSegments は、segs をつないだ入力をルーンに変換してマッチャーの入力に設定し、
+// 各ルーンの開始バイト位置（つないだ入力の先頭から）を記録します。文字の解釈は resetBytes と同じです。
+func (m *Matcher) resetSegments(segs Segments) {
+	if m.prog.normalization != NoNormalization {
+		m.resetBytes(bytes.Join(segs, nil))
+		return
+	}
+	m.runes = m.runes[:0]
+	m.offsets = m.offsets[:0]
+	var buf [utf8.UTFMax]byte
+	base := 0 // seg の先頭の位置
+	skip := 0 // 前のバイト列から続く文字の、まだ読み飛ばしていないバイト数
+	for i, seg := range segs {
+		j := min(skip, len(seg))
+		skip -= j
+		for j < len(seg) {
+			r, size := rune(seg[j]), 1
+			if !m.prog.latin1 {
+				if utf8.FullRune(seg[j:]) {
+					r, size = utf8.DecodeRune(seg[j:])
+				} else {
+					// 文字が次のバイト列にまたがるため、続きのバイトを集めて解釈する
+					n := copy(buf[:], seg[j:])
+					for k := i + 1; k < len(segs) && n < len(buf); k++ {
+						n += copy(buf[n:], segs[k])
+					}
+					r, size = utf8.DecodeRune(buf[:n])
+				}
+			}
+			m.runes = append(m.runes, r)
+			m.offsets = append(m.offsets, base+j)
+			j += size
+		}
+		skip += j - len(seg)
+		base += len(seg)
+	}
+	m.offsets = append(m.offsets, base)
+	m.input = m.runes
+}
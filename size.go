@@ -0,0 +1,127 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"unsafe"
+)
+
+// mapEntryOverhead は、マップの1要素あたりのキーと値以外のおおよその大きさ（バイト数）です。
+const mapEntryOverhead = 16
+
+// Size は、コンパイルした Regexp が使うメモリのおおよその大きさ（バイト数）を返します。
+// 命令列、文字クラスやトライの表、キャプチャグループの名前などのメタデータを含みます。
+// LinearFallback や CrossCheck で使う標準ライブラリの正規表現は、その命令列から見積もります。
+// 照合のたびに使う作業領域（入力のルーン配列やバックトラックスタック）は、入力の長さで決まるため含みません。
+// FindFuzzy が初めて呼ばれたときにコンパイルする近似照合用のプログラムも含みません。
+//
+// 値は Go のバージョンやアーキテクチャによって変わり得るため、比較はおおよその目安として使ってください。
+func (re *Regexp) Size() int {
+	n := int(unsafe.Sizeof(*re)) + len(re.expr)
+	n += stringsSize(re.subexpNames)
+	n += stringsSize(re.complexity.Culprits)
+	if re.crossCheck != nil {
+		n += int(unsafe.Sizeof(*re.crossCheck)) + stdRegexpSize(re.crossCheck.std)
+	}
+	return n + re.prog.size()
+}
+
+// size は、プログラムが使うメモリのおおよその大きさを返します。
+// 名前の配列は Regexp と共有しているため、Regexp.Size で数えます。
+func (p *program) size() int {
+	n := int(unsafe.Sizeof(*p))
+	n += cap(p.instrs) * int(unsafe.Sizeof(Instr{}))
+	n += cap(p.backrefs)
+	n += cap(p.endSuffix) * int(unsafe.Sizeof(rune(0)))
+	n += len(p.rawSuffix)
+	n += cap(p.requiredLiterals) * int(unsafe.Sizeof([]rune(nil)))
+	for _, lit := range p.requiredLiterals {
+		n += cap(lit) * int(unsafe.Sizeof(rune(0)))
+	}
+	if p.startLiterals != nil {
+		n += p.startLiterals.size()
+	}
+
+	// 文字クラスとトライは、複数の命令で共有していることがある
+	classes := make(map[*charClass]bool)
+	tries := make(map[*literalTrie]bool)
+	for _, instr := range p.instrs {
+		if c := instr.CharClass; c != nil && !classes[c] {
+			classes[c] = true
+			n += c.size()
+		}
+		if t := instr.Trie; t != nil && !tries[t] {
+			tries[t] = true
+			n += int(unsafe.Sizeof(*t)) + t.root.size()
+		}
+	}
+
+	if p.linear != nil {
+		n += stdRegexpSize(p.linear)
+	}
+	if p.profile != nil {
+		n += int(unsafe.Sizeof(*p.profile))
+		n += cap(p.profile.owners) * int(unsafe.Sizeof(Node(nil)))
+		n += cap(p.profile.counts) * int(unsafe.Sizeof(p.profile.counts[0]))
+	}
+	if p.coverage != nil {
+		n += int(unsafe.Sizeof(*p.coverage))
+		n += cap(p.coverage.counts) * int(unsafe.Sizeof(p.coverage.counts[0]))
+		for _, item := range p.coverage.items {
+			n += int(unsafe.Sizeof(item)) + len(item.Pattern) + len(item.Alternation)
+		}
+	}
+	if p.slowMatch != nil {
+		// パターンの文字列は Regexp と共有している
+		n += int(unsafe.Sizeof(*p.slowMatch))
+	}
+	return n
+}
+
+// size は、文字クラスのおおよその大きさを返します。
+func (c *charClass) size() int {
+	n := int(unsafe.Sizeof(*c))
+	n += cap(c.anyOf) * int(unsafe.Sizeof(rune(0)))
+	n += cap(c.ranges) * int(unsafe.Sizeof(runeRange{}))
+	for name := range c.unicode {
+		n += int(unsafe.Sizeof(name)) + len(name) + 1 + mapEntryOverhead
+	}
+	return n
+}
+
+// size は、トライのノードとその子孫のおおよその大きさを返します。
+func (t *trieNode) size() int {
+	n := int(unsafe.Sizeof(*t))
+	for _, child := range t.children {
+		n += int(unsafe.Sizeof(rune(0))+unsafe.Sizeof(child)) + mapEntryOverhead + child.size()
+	}
+	return n
+}
+
+// stringsSize は、文字列のスライスのおおよその大きさを返します。
+func stringsSize(ss []string) int {
+	n := cap(ss) * int(unsafe.Sizeof(""))
+	for _, s := range ss {
+		n += len(s)
+	}
+	return n
+}
+
+// stdRegexpSize は、標準ライブラリの正規表現のおおよその大きさを、同じパターンの命令列から見積もります。
+func stdRegexpSize(re *regexp.Regexp) int {
+	n := int(unsafe.Sizeof(*re)) + len(re.String())
+	ast, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return n
+	}
+	prog, err := syntax.Compile(ast.Simplify())
+	if err != nil {
+		return n
+	}
+	n += len(prog.Inst) * int(unsafe.Sizeof(syntax.Inst{}))
+	for _, inst := range prog.Inst {
+		n += cap(inst.Rune) * int(unsafe.Sizeof(rune(0)))
+	}
+	return n + stringsSize(re.SubexpNames())
+}
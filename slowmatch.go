@@ -0,0 +1,71 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "time"
+
+// SlowMatchHook は、Options.SlowMatch の設定です。
+// 1回の操作（MatchString や FindAllString などの呼び出し）が Steps または Duration のしきい値を超えた場合に、Func を呼び出します。
+type SlowMatchHook struct {
+	// Steps は、1回の操作で実行した命令数のしきい値です。0以下の場合は命令数では判定しません。
+	Steps int
+
+	// Duration は、1回の操作にかかった時間のしきい値です。0以下の場合は時間では判定しません。
+	Duration time.Duration
+
+	// Func は、しきい値を超えた操作の統計を受け取る関数です。nil の場合は何もしません。
+	// 照合したゴルーチンで同期的に呼び出すため、時間のかかる処理は別のゴルーチンに任せてください。
+	Func func(SlowMatchStats)
+}
+
+// SlowMatchStats は、SlowMatchHook に渡す、しきい値を超えた操作の統計です。
+// 入力そのものは、ログに個人情報などを残さないよう含めません。
+type SlowMatchStats struct {
+	Pattern  string        // パターン
+	InputLen int           // 入力の長さ（バイト単位）
+	Steps    int           // 実行した命令数（Run 命令がまとめて消費した文字も含む）
+	Attempts int           // マッチを試行した開始位置の数
+	Duration time.Duration // 操作にかかった時間（Duration を指定しない場合は0）
+
+	// Err は、ステップ数の上限に達して照合を打ち切った場合の ErrStepLimitExceeded です。そうでなければ nil です。
+	Err error
+}
+
+// slowMatch は、SlowMatchHook を設定したプログラムで、操作の統計を判定します。
+type slowMatch struct {
+	pattern string
+	hook    SlowMatchHook
+}
+
+// newSlowMatch は、hook が有効なら slowMatch を返します。そうでなければ nil を返します。
+func newSlowMatch(pattern string, hook SlowMatchHook) *slowMatch {
+	if hook.Func == nil || (hook.Steps <= 0 && hook.Duration <= 0) {
+		return nil
+	}
+	return &slowMatch{pattern: pattern, hook: hook}
+}
+
+// begin は、マッチャーを使う操作の開始時に、開始時刻を記録します。
+func (s *slowMatch) begin(m *Matcher) {
+	if s.hook.Duration > 0 {
+		m.began = time.Now()
+	}
+}
+
+// end は、マッチャーを使う操作の終了時に、統計 stats がしきい値を超えていれば Func を呼び出します。
+func (s *slowMatch) end(m *Matcher, stats operationStats) {
+	var elapsed time.Duration
+	if s.hook.Duration > 0 {
+		elapsed = time.Since(m.began)
+	}
+	if (s.hook.Steps <= 0 || stats.steps <= s.hook.Steps) && (s.hook.Duration <= 0 || elapsed <= s.hook.Duration) {
+		return
+	}
+	s.hook.Func(SlowMatchStats{
+		Pattern:  s.pattern,
+		InputLen: stats.inputLen,
+		Steps:    stats.steps,
+		Attempts: stats.attempts,
+		Duration: elapsed,
+		Err:      stats.err,
+	})
+}
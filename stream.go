@@ -0,0 +1,366 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sort"
+	"unicode/utf8"
+)
+
+// LineMatcher は、チャンクに分けて届くストリームを行ごとに照合し、マッチした行を見つけるたびに報告します。
+//
+// チャンクの境界は行の途中にあってもかまいません。行の途中までのデータは次のチャンクが届くまで保持し、
+// 改行がそろった時点で、改行を除いた1行を入力として照合します。行の区切り方は FindLines と同じです。
+// そのため ^ と $ は行の先頭と末尾にマッチし、マッチが行をまたぐことはありません。
+// 同じ行の中の複数のマッチは、FindAllSubmatchIndex と同じく重ならないように LineMatch.Matches にまとめて報告します。
+// チャンクをまたいで保持する状態は、行の途中までのデータと、行番号、位置だけです（このエンジンは \G に対応していません）。
+//
+// LineMatcher は複数のゴルーチンから同時に使えません。
+type LineMatcher struct {
+	re    *Regexp
+	lines lineSplitter
+}
+
+// NewLineMatcher は、ストリームの先頭から照合する LineMatcher を返します。
+func (re *Regexp) NewLineMatcher() *LineMatcher {
+	return &LineMatcher{re: re, lines: lineSplitter{line: 1}}
+}
+
+// Write は、ストリームの続きのチャンクを受け取り、改行がそろった行を照合して、マッチした行ごとに f を呼び出します。
+func (lm *LineMatcher) Write(chunk []byte, f func(LineMatch)) {
+	lm.lines.write(chunk, lm.re.reportLines(f))
+}
+
+// Flush は、改行で終わっていない最後の行があれば照合し、マッチすれば f を呼び出します。
+// ストリームが終わったときに呼び出します。
+func (lm *LineMatcher) Flush(f func(LineMatch)) {
+	lm.lines.flush(lm.re.reportLines(f))
+}
+
+// reportLines は、lineSplitter が渡す行を照合し、マッチした行ごとに f を呼び出す関数を返します。
+func (re *Regexp) reportLines(f func(LineMatch)) func(line, offset int64, text []byte) bool {
+	return func(line, offset int64, text []byte) bool {
+		if m, ok := re.findLine(line, offset, text, -1); ok {
+			f(m)
+		}
+		return true
+	}
+}
+
+// MatchChannel は、チャネルから届くチャンクを LineMatcher で照合し、マッチした行を順に送るチャネルを返します。
+// chunks が閉じられると、最後の行を照合してから戻り値のチャネルを閉じます。
+// 戻り値のチャネルは最後まで受信してください。受信しないと、照合するゴルーチンが止まったままになります。
+func (re *Regexp) MatchChannel(chunks <-chan []byte) <-chan LineMatch {
+	out := make(chan LineMatch)
+	go func() {
+		defer close(out)
+		lm := re.NewLineMatcher()
+		send := func(m LineMatch) { out <- m }
+		for chunk := range chunks {
+			lm.Write(chunk, send)
+		}
+		lm.Flush(send)
+	}()
+	return out
+}
+
+// MatchLines は、r から読み取ったテキストを LineMatcher と同じく1行ずつ照合し、マッチした行を見つけるたびに f を呼び出します。
+// r が io.EOF を返すまで読み続け、読み取りのエラーがあればそれを返します（io.EOF の場合は nil）。
+// パイプやソケットのように読み取りがブロックする r なら、データが届くたびに照合して報告します。
+func (re *Regexp) MatchLines(r io.Reader, f func(LineMatch)) error {
+	return readLines(r, re.reportLines(f))
+}
+
+// Match は、AllMatchesReader や ReadMatch が見つけたマッチです。
+type Match struct {
+	// Index は、マッチ全体と各グループの開始位置と終了位置です（FindSubmatchIndex と同じ形式）。
+	// 位置はストリームの先頭からのバイト位置で、マッチしなかったグループの位置は-1です。
+	Index []int64
+
+	// Groups は、マッチした文字列（Groups[0]）と各グループの文字列です（マッチしなかったグループは空文字列）。
+	Groups []string
+}
+
+// ErrStreamHistoryExceeded は、ストリームの照合で保持する入力が Options.MaxStreamHistory を超えた場合のエラーです。
+var ErrStreamHistoryExceeded = errors.New("ストリームの照合で保持する入力が上限を超えました")
+
+// streamReadSize は、AllMatchesReader が1回に読み取るバイト数です。
+const streamReadSize = 32 << 10
+
+// AllMatchesReader は、r から読み取ったテキストの中の重ならないマッチを、先頭から順に返すイテレータを返します。
+// 結果は、読み取ったテキスト全体に FindAllSubmatchIndex を適用した場合と同じです。
+//
+// テキストは少しずつ読み取り、その時点までの入力で結果が確定したマッチから順に返します。
+// 入力が続けば結果が変わり得る位置（照合が読み取った入力の末尾に達した位置）以降だけを保持するため、
+// 保持する入力は、おおむね最も長いマッチになり得る長さまでです。
+// ただし、入力を正規化する設定（Flags.Normalization）では、入力をすべて読み取ってから走査します。
+//
+// 保持する入力が Options.MaxStreamHistory を超えた場合は、ErrStreamHistoryExceeded を返して終了します。
+//
+// 読み取りでエラーが発生した場合や、ステップ数の上限に達した場合は、そのエラーを返して終了します（io.EOF はエラーとしません）。
+// 照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+func (re *Regexp) AllMatchesReader(r io.Reader) iter.Seq2[Match, error] {
+	return func(yield func(Match, error) bool) {
+		sc := re.newStreamScanner()
+		defer sc.close()
+
+		chunk := make([]byte, streamReadSize)
+		for {
+			n, err := r.Read(chunk)
+			eof := err == io.EOF
+			if err != nil && !eof {
+				yield(Match{}, err)
+				return
+			}
+			more, err := sc.feed(chunk[:n], eof, func(m Match) bool { return yield(m, nil) })
+			if err != nil {
+				yield(Match{}, err)
+				return
+			}
+			if !more {
+				return
+			}
+		}
+	}
+}
+
+// newMatch は、b の中のマッチの位置 loc（FindSubmatchIndex と同じ形式）から、b の先頭をストリーム上の位置 base とした Match を作ります。
+func newMatch(b []byte, loc []int, base int64) Match {
+	match := Match{Index: make([]int64, len(loc)), Groups: make([]string, len(loc)/2)}
+	for i, pos := range loc {
+		match.Index[i] = -1
+		if pos >= 0 {
+			match.Index[i] = base + int64(pos)
+		}
+	}
+	for i := range match.Groups {
+		if loc[2*i] >= 0 {
+			match.Groups[i] = string(b[loc[2*i]:loc[2*i+1]])
+		}
+	}
+	return match
+}
+
+// streamScanner は、少しずつ届く入力の中の重ならないマッチを、結果が確定したものから順に探す状態です。
+// AllMatchesReader と CountingWriter が使います。
+type streamScanner struct {
+	prog    *program
+	m       *Matcher
+	buf     []byte // 保持している入力（ストリーム上の位置 base から）
+	base    int64  // buf[0] のストリーム上の位置
+	from    int64  // 次にマッチを試行する位置（ストリーム上の位置）
+	prevEnd int64  // 直前のマッチの終了位置（ストリーム上の位置、なければ-1）
+}
+
+// newStreamScanner は、ストリームの先頭から走査する streamScanner を返します。使い終わったら close を呼び出します。
+func (re *Regexp) newStreamScanner() *streamScanner {
+	return &streamScanner{prog: re.prog, m: re.prog.getMatcher(true), prevEnd: -1}
+}
+
+// close は、走査に使ったマッチャーを返却します。
+func (sc *streamScanner) close() {
+	if sc.m != nil {
+		sc.prog.putMatcher(sc.m)
+		sc.m = nil
+	}
+}
+
+// feed は、data を入力の続きに加え、結果が確定したマッチごとに f を呼び出します。
+// eof の場合は、data を入力の最後として残りをすべて走査します。
+// 走査を続けられる場合は true を返します。入力の最後まで走査したか、f が false を返した場合は false を返します。
+// ステップ数の上限に達した場合や、保持する入力が上限を超えた場合は、そのエラーを返します。
+func (sc *streamScanner) feed(data []byte, eof bool, f func(Match) bool) (bool, error) {
+	prog, m := sc.prog, sc.m
+	buf := append(sc.buf, data...)
+	sc.buf = buf
+	if !eof && (len(data) == 0 || prog.normalization != NoNormalization) {
+		return true, sc.checkHistory()
+	}
+
+	// 末尾で途切れた文字は、続きを読み取るまで照合しない
+	avail := len(buf)
+	if !eof && !prog.latin1 {
+		avail = fullRunesLen(buf)
+	}
+	m.resetBytes(buf[:avail])
+
+	// 結果が確定する開始位置から順に試行する
+	pending := -1 // 入力が続けば結果が変わり得る最初の開始位置（ルーン単位）
+	for start := sort.SearchInts(m.offsets, int(sc.from-sc.base)); start <= len(m.input); {
+		if m.tooShort(start) || (!eof && start == len(m.input)) {
+			if !eof {
+				pending = start
+			}
+			break
+		}
+		matched := m.MatchStart(start)
+		if m.err != nil {
+			return false, m.err
+		}
+		if m.hitEnd && !eof {
+			pending = start
+			break
+		}
+		if !matched {
+			start++
+			continue
+		}
+
+		// 採用するマッチと次の試行位置は scan と同じ規則で決める
+		matchStart, matchEnd := m.saved[0], m.saved[1]
+		start = matchEnd
+		if matchStart == matchEnd {
+			if matchEnd == m.startPos {
+				start++
+			}
+			if sc.base+int64(m.offsets[matchStart]) == sc.prevEnd {
+				continue
+			}
+		}
+		match := newMatch(buf, m.submatchIndex(), sc.base)
+		if !f(match) {
+			return false, nil
+		}
+		sc.prevEnd = match.Index[1]
+		sc.from = sc.base + int64(m.offsets[min(start, len(m.input))])
+	}
+	if pending < 0 {
+		return false, nil
+	}
+
+	// 保留した位置の直前の1文字（単語境界や行頭の判定に使う）より前は、もう必要ない
+	sc.from = sc.base + int64(m.offsets[pending])
+	cut := m.offsets[max(pending-1, 0)]
+	sc.buf = append(buf[:0], buf[cut:]...)
+	sc.base += int64(cut)
+	return true, sc.checkHistory()
+}
+
+// checkHistory は、保持している入力が Options.MaxStreamHistory を超えていれば ErrStreamHistoryExceeded を返します。
+func (sc *streamScanner) checkHistory() error {
+	if max := sc.prog.maxStreamHistory; max > 0 && len(sc.buf) > max {
+		return fmt.Errorf("%w: 位置 %d から %d バイト（上限 %d バイト）", ErrStreamHistoryExceeded, sc.base, len(sc.buf), max)
+	}
+	return nil
+}
+
+// fullRunesLen は、b の末尾で途切れた文字を除いた長さを返します。
+func fullRunesLen(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// SplitReader は、r から読み取ったテキストを Split(s, -1) と同じく正規表現がマッチする位置で分割し、
+// 部分文字列を先頭から順に返すイテレータを返します。結果は、読み取ったテキスト全体を Split で分割した場合と同じです。
+//
+// 区切りは AllMatchesReader と同じく少しずつ読み取りながら探すため、読み取りの境界をまたぐ区切りも正しく扱います。
+// 保持する入力は、まだ返していない部分文字列と、区切りになり得る長さまでです（Options.MaxStreamHistory は後者だけに適用します）。
+// 読み取りでエラーが発生した場合や、ステップ数の上限に達した場合は、そのエラーを返して終了します（io.EOF はエラーとしません）。
+func (re *Regexp) SplitReader(r io.Reader) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		// 区切りの間の部分を切り出すため、照合のために読み取ったテキストを field にも書き込む
+		var field bytes.Buffer
+		var beg, end int64 // field の先頭と、最後の区切りの開始位置（ストリーム上の位置）
+		for m, err := range re.AllMatchesReader(io.TeeReader(r, &field)) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			end = m.Index[0]
+			s := string(field.Next(int(end - beg)))
+			// Split と同じく、先頭の空マッチでは分割しない
+			if m.Index[1] != 0 && !yield(s, nil) {
+				return
+			}
+			field.Next(int(m.Index[1] - end))
+			beg = m.Index[1]
+		}
+
+		// 最後の区切り以降の部分を返す（テキストの末尾で終わる区切りの後には空文字列を加えない）
+		size := beg + int64(field.Len())
+		if end != size || (len(re.expr) > 0 && size == 0) {
+			yield(field.String(), nil)
+		}
+	}
+}
+
+// CountingWriter は、書き込まれたデータをそのまま別の io.Writer に書き込みながら、データの中のマッチを数える io.Writer です。
+//
+// マッチは AllMatchesReader と同じく、書き込まれたデータ全体に FindAllIndex を適用した場合と同じ重ならないマッチで、
+// 書き込みの境界をまたぐマッチも数えます。書き込みの末尾で結果が確定しないマッチは、続きのデータか Close を待って数えます。
+// 保持するデータは、おおむね最も長いマッチになり得る長さまでです。
+//
+// CountingWriter は複数のゴルーチンから同時に使えません。
+type CountingWriter struct {
+	w       io.Writer
+	sc      *streamScanner
+	count   int64
+	record  bool
+	offsets [][]int64
+	err     error // 照合を打ち切ったエラー
+}
+
+// NewCountingWriter は、w に書き込みながらマッチを数える CountingWriter を返します。
+// recordOffsets が true なら、各マッチの位置も記録します（Offsets を参照）。
+// データをすべて書き込んだら、Close を呼び出します。
+func (re *Regexp) NewCountingWriter(w io.Writer, recordOffsets bool) *CountingWriter {
+	return &CountingWriter{w: w, sc: re.newStreamScanner(), record: recordOffsets}
+}
+
+// Write は、p を元の io.Writer に書き込み、書き込めた部分を照合します。戻り値は元の io.Writer の Write の結果です。
+// 照合がステップ数や保持する入力の上限に達した場合も、データの書き込みは続けます。その場合は以降のマッチを数えず、Close がエラーを返します。
+func (cw *CountingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		cw.scan(p[:n], false)
+	}
+	return n, err
+}
+
+// Close は、書き込まれたデータの末尾までを照合し、残りのマッチを数えます。元の io.Writer は閉じません。
+// 照合がステップ数や保持する入力の上限に達していた場合は、そのエラーを返します。
+func (cw *CountingWriter) Close() error {
+	cw.scan(nil, true)
+	return cw.err
+}
+
+// scan は、データを照合してマッチを数えます。
+func (cw *CountingWriter) scan(data []byte, eof bool) {
+	if cw.sc == nil {
+		return
+	}
+	more, err := cw.sc.feed(data, eof, func(m Match) bool {
+		cw.count++
+		if cw.record {
+			cw.offsets = append(cw.offsets, m.Index[:2])
+		}
+		return true
+	})
+	if err != nil || !more {
+		cw.err = err
+		cw.sc.close()
+		cw.sc = nil
+	}
+}
+
+// Count は、これまでに数えたマッチの数を返します。
+func (cw *CountingWriter) Count() int64 {
+	return cw.count
+}
+
+// Offsets は、これまでに数えた各マッチの開始位置と終了位置（書き込まれたデータの先頭からのバイト位置）を返します。
+// NewCountingWriter で recordOffsets を指定しなかった場合は nil を返します。
+func (cw *CountingWriter) Offsets() [][]int64 {
+	return cw.offsets
+}
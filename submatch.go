@@ -0,0 +1,138 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "iter"
+
+// Submatch は、SubmatchIter と FindHandle が返すマッチです。マッチと各グループの位置だけを保持します。
+// 各グループの文字列は、Group や Named で取り出すときに初めて切り出すため、
+// FindAllStringSubmatch のように、使わないグループの文字列のスライスをマッチごとに作成しません。
+type Submatch struct {
+	re  *Regexp
+	s   string
+	loc []int
+}
+
+// Index は、マッチ全体と各グループの位置です（FindStringSubmatchIndex と同じ形式）。
+// 返すスライスは Submatch と共有しているため、変更してはいけません。
+func (m Submatch) Index() []int {
+	return m.loc
+}
+
+// Span は、番号 i のグループの開始位置と終了位置を返します（0はマッチ全体）。
+// マッチしなかったグループと、範囲外の番号では -1, -1 を返します。
+func (m Submatch) Span(i int) (start, end int) {
+	if !m.Matched(i) {
+		return -1, -1
+	}
+	return m.loc[2*i], m.loc[2*i+1]
+}
+
+// Len は、マッチ全体を含むグループの数（NumSubexp()+1）を返します。
+func (m Submatch) Len() int {
+	return len(m.loc) / 2
+}
+
+// Text は、マッチした文字列を返します。
+func (m Submatch) Text() string {
+	return m.s[m.loc[0]:m.loc[1]]
+}
+
+// Group は、番号 i のグループの文字列を返します（0はマッチ全体）。
+// マッチしなかったグループと、範囲外の番号では空文字列を返します。
+func (m Submatch) Group(i int) string {
+	if i < 0 || i >= m.Len() || m.loc[2*i] < 0 {
+		return ""
+	}
+	return m.s[m.loc[2*i]:m.loc[2*i+1]]
+}
+
+// Matched は、番号 i のグループがマッチに参加したかどうかを返します。
+func (m Submatch) Matched(i int) bool {
+	return i >= 0 && i < m.Len() && m.loc[2*i] >= 0
+}
+
+// Named は、名前付きグループ name の文字列を返します。
+// 同じ名前のグループが複数あれば、マッチに参加した最初のものを返します。なければ空文字列を返します。
+func (m Submatch) Named(name string) string {
+	for i, n := range m.re.subexpNames {
+		if i > 0 && n == name && m.Matched(i) {
+			return m.Group(i)
+		}
+	}
+	return ""
+}
+
+// FindHandle は、s の最初のマッチを、位置だけを保持する Submatch で返します。マッチしない場合は false を返します。
+// FindStringSubmatch と異なり各グループの文字列のスライスを作成しないため、大量の入力を照合して
+// グループの位置を調べ、まれに残すマッチの文字列だけを取り出す処理に向いています。
+func (re *Regexp) FindHandle(s string) (Submatch, bool) {
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return Submatch{}, false
+	}
+	return Submatch{re: re, s: s, loc: loc}, true
+}
+
+// SubmatchIter は、s の中の重ならないマッチを先頭から順に返すイテレータを返します。
+// 結果は FindAllStringSubmatchIndex(s, -1) と同じで、各グループの文字列は Submatch から必要なものだけを取り出せます。
+// マッチは利用者が次を求めたときに探すため、途中で繰り返しを終えれば残りの入力は照合しません。
+func (re *Regexp) SubmatchIter(s string) iter.Seq[Submatch] {
+	return func(yield func(Submatch) bool) {
+		prog := re.prog
+		if prog.linear != nil {
+			for _, loc := range prog.linear.FindAllStringSubmatchIndex(s, -1) {
+				if !yield(Submatch{re: re, s: s, loc: loc}) {
+					return
+				}
+			}
+			return
+		}
+
+		m := prog.getMatcher(true)
+		defer prog.putMatcher(m)
+		m.resetString(s)
+		pos, prevEnd := 0, -1
+		for {
+			var loc []int
+			pos, prevEnd = m.scan(pos, len(m.input)+1, prevEnd, 1, func() {
+				loc = m.submatchIndex()
+			})
+			if loc == nil || !yield(Submatch{re: re, s: s, loc: loc}) {
+				return
+			}
+		}
+	}
+}
+
+// namedMap は、名前付きグループの名前から文字列への対応を返します（FindStringSubmatchMap を参照）。
+func (m Submatch) namedMap() map[string]string {
+	result := make(map[string]string)
+	for i, name := range m.re.subexpNames {
+		if i > 0 && name != "" {
+			result[name] = m.Named(name)
+		}
+	}
+	return result
+}
+
+// FindStringSubmatchMap は、s の最初のマッチの名前付きグループの文字列を、グループの名前をキーとするマップで返します。
+// マップはパターンのすべての名前付きグループを含み、マッチに参加しなかったグループの値は空文字列です。
+// 名前のないグループは含みません。マッチしない場合は nil を返します。
+func (re *Regexp) FindStringSubmatchMap(s string) map[string]string {
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+	return Submatch{re: re, s: s, loc: loc}.namedMap()
+}
+
+// FindAllStringSubmatchMap は、FindStringSubmatchMap の全マッチ版です。
+// s の中の重ならないマッチを最大 n 個（n が負なら全て）探し、マッチごとのマップを返します。
+// マッチしない場合は nil を返します。
+func (re *Regexp) FindAllStringSubmatchMap(s string, n int) []map[string]string {
+	var result []map[string]string
+	for _, loc := range re.FindAllStringSubmatchIndex(s, n) {
+		result = append(result, Submatch{re: re, s: s, loc: loc}.namedMap())
+	}
+	return result
+}
@@ -0,0 +1,239 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"unicode"
+)
+
+// ToSyntax は、パターンを標準ライブラリの regexp/syntax の構文木に変換して返します。
+//
+// バックリファレンス、所有的量指定子、Unicode正規化など、RE2 の構文で表せない要素を含む場合はエラーを返します。
+func (re *Regexp) ToSyntax() (*syntax.Regexp, error) {
+	if normalizationOf(re.opts) != NoNormalization {
+		return nil, fmt.Errorf("RE2 の構文で表せません: Unicode正規化")
+	}
+	ast, err := parse(re.expr, re.opts)
+	if err != nil {
+		return nil, err
+	}
+	return toSyntax(ast)
+}
+
+// toSyntax は、ASTのノードを regexp/syntax の構文木に変換します。
+func toSyntax(node Node) (*syntax.Regexp, error) {
+	switch n := node.(type) {
+	case *CharNode:
+		return literalSyntax([]rune{n.r}, n.fold), nil
+
+	case *ConcatNode:
+		if len(n.nodes) == 0 {
+			return &syntax.Regexp{Op: syntax.OpEmptyMatch}, nil
+		}
+		re := &syntax.Regexp{Op: syntax.OpConcat}
+		for _, child := range n.nodes {
+			// 連続するリテラル文字は1つの OpLiteral にまとめる
+			if ch, ok := child.(*CharNode); ok && len(re.Sub) > 0 {
+				last := re.Sub[len(re.Sub)-1]
+				if last.Op == syntax.OpLiteral && (last.Flags&syntax.FoldCase != 0) == ch.fold {
+					last.Rune = append(last.Rune, ch.r)
+					continue
+				}
+			}
+			sub, err := toSyntax(child)
+			if err != nil {
+				return nil, err
+			}
+			// (?i) のようなフラグだけのグループが残す空の要素は、連接では意味を持たない
+			if sub.Op == syntax.OpEmptyMatch {
+				continue
+			}
+			re.Sub = append(re.Sub, sub)
+		}
+		switch len(re.Sub) {
+		case 0:
+			return &syntax.Regexp{Op: syntax.OpEmptyMatch}, nil
+		case 1:
+			return re.Sub[0], nil
+		}
+		return re, nil
+
+	case *AltNode:
+		re := &syntax.Regexp{Op: syntax.OpAlternate}
+		for _, branch := range flattenAlt(n) {
+			sub, err := toSyntax(branch)
+			if err != nil {
+				return nil, err
+			}
+			re.Sub = append(re.Sub, sub)
+		}
+		return re, nil
+
+	case *RepeatNode:
+		if n.possessive {
+			return nil, fmt.Errorf("RE2 の構文で表せません: 所有的量指定子")
+		}
+		sub, err := toSyntax(n.node)
+		if err != nil {
+			return nil, err
+		}
+		re := &syntax.Regexp{Sub: []*syntax.Regexp{sub}, Min: n.min, Max: n.max}
+		switch {
+		case n.min == 0 && n.max == -1:
+			re.Op = syntax.OpStar
+		case n.min == 1 && n.max == -1:
+			re.Op = syntax.OpPlus
+		case n.min == 0 && n.max == 1:
+			re.Op = syntax.OpQuest
+		default:
+			re.Op = syntax.OpRepeat
+		}
+		if n.repeatType == RepeatNonGreedy {
+			re.Flags |= syntax.NonGreedy
+		}
+		return re, nil
+
+	case *CaptureNode:
+		sub, err := toSyntax(n.node)
+		if err != nil {
+			return nil, err
+		}
+		return &syntax.Regexp{Op: syntax.OpCapture, Sub: []*syntax.Regexp{sub}, Cap: n.index, Name: n.name}, nil
+
+	case *GroupNode:
+		return toSyntax(n.node)
+
+	case *BackrefNode:
+		return nil, fmt.Errorf("RE2 の構文で表せません: バックリファレンス")
+
+	case *AnyCharNode:
+		if n.dotMatchesNewline {
+			return &syntax.Regexp{Op: syntax.OpAnyChar}, nil
+		}
+		// このエンジンの . は \n と \r のどちらにもマッチしない
+		return &syntax.Regexp{Op: syntax.OpCharClass, Rune: []rune{0, '\t', '\v', '\f', '\r' + 1, unicode.MaxRune}}, nil
+
+	case *CharClassNode:
+		ranges, err := classRanges(n)
+		if err != nil {
+			return nil, err
+		}
+		if n.negate {
+			ranges = complementRanges(ranges)
+		}
+		re := &syntax.Regexp{Op: syntax.OpCharClass}
+		for _, rng := range ranges {
+			re.Rune = append(re.Rune, rng.min, rng.max)
+		}
+		return re, nil
+
+	case *BoundaryNode:
+		if n.multiline && n.lineTerminators {
+			return nil, fmt.Errorf("RE2 の構文で表せません: \\n 以外の行の区切り")
+		}
+		switch n.nodeType {
+		case NodeBeginLine:
+			if n.multiline {
+				return &syntax.Regexp{Op: syntax.OpBeginLine}, nil
+			}
+			return &syntax.Regexp{Op: syntax.OpBeginText}, nil
+		case NodeEndLine:
+			if n.multiline {
+				return &syntax.Regexp{Op: syntax.OpEndLine}, nil
+			}
+			return &syntax.Regexp{Op: syntax.OpEndText, Flags: syntax.WasDollar}, nil
+		case NodeBeginText:
+			return &syntax.Regexp{Op: syntax.OpBeginText}, nil
+		case NodeEndText:
+			return &syntax.Regexp{Op: syntax.OpEndText}, nil
+		case NodeWordBoundary:
+			return &syntax.Regexp{Op: syntax.OpWordBoundary}, nil
+		case NodeNonWordBoundary:
+			return &syntax.Regexp{Op: syntax.OpNoWordBoundary}, nil
+		case NodeMatchStart, NodeMatchEnd:
+			return nil, fmt.Errorf("RE2 の構文で表せません: \\zs と \\ze")
+		case NodeNotAfterWord, NodeNotBeforeWord:
+			return nil, fmt.Errorf("RE2 の構文で表せません: 単語全体の照合（Options.WholeWord）")
+		}
+		return nil, fmt.Errorf("未知の境界タイプ: %v", n.nodeType)
+
+	case nil:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}, nil
+
+	default:
+		return nil, fmt.Errorf("未知のノードタイプ: %T", node)
+	}
+}
+
+// literalSyntax は、リテラル文字列を表す構文木を返します。
+func literalSyntax(runes []rune, fold bool) *syntax.Regexp {
+	re := &syntax.Regexp{Op: syntax.OpLiteral, Rune: runes}
+	if fold {
+		re.Flags |= syntax.FoldCase
+	}
+	return re
+}
+
+// classRanges は、文字クラス（否定は考慮しない）に含まれる文字範囲を、整列して返します。
+func classRanges(n *CharClassNode) ([]runeRange, error) {
+	switch n.classType {
+	case ClassCustom:
+		if n.fold {
+			return foldRanges(n.ranges), nil
+		}
+		return normalizeRanges(append([]runeRange(nil), n.ranges...)), nil
+	case ClassDigit:
+		return []runeRange{{'0', '9'}}, nil
+	case ClassWord:
+		return []runeRange{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}}, nil
+	case ClassSpace:
+		return spaceRanges(), nil
+	case ClassUnicode:
+		if table, ok := unicodeTable(n.unicodeKey); ok {
+			return tableRanges(table), nil
+		}
+		return nil, fmt.Errorf("未知のUnicodeプロパティ: %s", n.unicodeKey)
+	}
+	return nil, fmt.Errorf("未知の文字クラス: %v", n.classType)
+}
+
+// tableRanges は、Unicodeの範囲表を文字範囲のリストに変換します。
+func tableRanges(table *unicode.RangeTable) []runeRange {
+	var ranges []runeRange
+	for _, r := range table.R16 {
+		for c := rune(r.Lo); c <= rune(r.Hi); c += rune(r.Stride) {
+			if r.Stride == 1 {
+				ranges = append(ranges, runeRange{rune(r.Lo), rune(r.Hi)})
+				break
+			}
+			ranges = append(ranges, runeRange{c, c})
+		}
+	}
+	for _, r := range table.R32 {
+		for c := rune(r.Lo); c <= rune(r.Hi); c += rune(r.Stride) {
+			if r.Stride == 1 {
+				ranges = append(ranges, runeRange{rune(r.Lo), rune(r.Hi)})
+				break
+			}
+			ranges = append(ranges, runeRange{c, c})
+		}
+	}
+	return normalizeRanges(ranges)
+}
+
+// complementRanges は、整列済みの文字範囲のリストの補集合を返します。
+func complementRanges(ranges []runeRange) []runeRange {
+	var result []runeRange
+	next := rune(0)
+	for _, rng := range ranges {
+		if rng.min > next {
+			result = append(result, runeRange{next, rng.min - 1})
+		}
+		next = rng.max + 1
+	}
+	if next <= unicode.MaxRune {
+		result = append(result, runeRange{next, unicode.MaxRune})
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "fmt"
+
+// GroupError は、パターンにないキャプチャグループを参照した場合のエラーです。
+type GroupError struct {
+	Group     int // 参照したグループの番号
+	NumSubexp int // パターンのキャプチャグループの数
+
+	// Template と Offset は、置換テキストの中の参照の場合に、置換テキストと参照（$）のバイト位置を表します。
+	// それ以外の場合、Template は空文字列で Offset は-1です。
+	Template string
+	Offset   int
+}
+
+func (e *GroupError) Error() string {
+	if e.Offset >= 0 {
+		return fmt.Sprintf("置換テキスト %s の位置 %d で、存在しないグループ %d を参照しています（グループの数は %d）",
+			quote(e.Template), e.Offset, e.Group, e.NumSubexp)
+	}
+	return fmt.Sprintf("存在しないグループ %d を参照しています（グループの数は %d）", e.Group, e.NumSubexp)
+}
+
+// ValidateTemplate は、置換テキスト repl が、パターンにないキャプチャグループを参照していないかを検証します。
+// 参照の解釈は ReplaceAllString と同じで、2桁目の数字が有効なグループにならない場合は1桁目だけを参照とみなします。
+// 存在しないグループへの参照は ReplaceAllString では何も書き込みません。最初に見つけた誤りを *GroupError で返します。
+func (re *Regexp) ValidateTemplate(repl string) error {
+	for i := 0; i < len(repl); i++ {
+		if repl[i] != '$' || i+1 >= len(repl) {
+			continue
+		}
+		offset := i
+		i++
+		if repl[i] < '0' || '9' < repl[i] {
+			continue
+		}
+		group := int(repl[i] - '0')
+		if i+1 < len(repl) && '0' <= repl[i+1] && repl[i+1] <= '9' {
+			if two := group*10 + int(repl[i+1]-'0'); two <= re.numSubexp {
+				group = two
+				i++
+			}
+		}
+		if group > re.numSubexp {
+			return &GroupError{Group: group, NumSubexp: re.numSubexp, Template: repl, Offset: offset}
+		}
+	}
+	return nil
+}
+
+// ReplaceAllStringErr は ReplaceAllString と同様ですが、置換テキストがパターンにないグループを参照している場合は、
+// 置き換えずに空文字列と *GroupError を返します（ValidateTemplate を参照）。
+func (re *Regexp) ReplaceAllStringErr(src, repl string) (string, error) {
+	if err := re.ValidateTemplate(repl); err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(src, repl), nil
+}
+
+// FindStringGroupIndexErr は、s の最初のマッチでのキャプチャグループ group の位置（開始位置と終了位置）を返します。
+// マッチしない場合や、グループがマッチに参加しなかった場合は nil を返します。
+// group がパターンにないグループの番号の場合は、FindStringSubmatchIndex の結果の範囲外を読む代わりに *GroupError を返します。
+// ステップ数の上限に達してマッチングを打ち切った場合は nil と ErrStepLimitExceeded を返します。
+func (re *Regexp) FindStringGroupIndexErr(s string, group int) ([]int, error) {
+	if group < 0 || group > re.numSubexp {
+		return nil, &GroupError{Group: group, NumSubexp: re.numSubexp, Offset: -1}
+	}
+	loc, err := re.FindStringSubmatchIndexErr(s)
+	if loc == nil || loc[2*group] < 0 {
+		return nil, err
+	}
+	return loc[2*group : 2*group+2], nil
+}
+
+// GroupErr は Group と同様ですが、i がパターンにないグループの番号の場合は、空文字列の代わりに *GroupError を返します。
+func (m Submatch) GroupErr(i int) (string, error) {
+	if i < 0 || i >= m.Len() {
+		return "", &GroupError{Group: i, NumSubexp: m.Len() - 1, Offset: -1}
+	}
+	return m.Group(i), nil
+}
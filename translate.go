@@ -0,0 +1,33 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "fmt"
+
+// Translate は、このパッケージの構文で書かれたパターンを、target の正規表現エンジンで同じ意味になるパターンに書き換えます。
+//
+// target には DialectRE2 か DialectPCRE を指定します。
+// . や $、\s のように、エンジンによって意味の異なる要素は、同じ意味になるよう書き換えます。
+// 変換先で表せない要素（RE2 でのバックリファレンスや所有的量指定子など）を含む場合は、その要素を示すエラーを返します。
+// 空文字列にマッチし得る部分の繰り返し（(a|)* など）も、空の繰り返しの扱いが RE2 と異なりマッチの位置が変わり得るため、
+// RE2 への変換ではエラーとします。
+func Translate(pattern string, target Dialect) (string, error) {
+	ast, err := parse(pattern, Options{})
+	if err != nil {
+		return "", err
+	}
+
+	switch target {
+	case DialectRE2:
+		// 表せない要素の検出は regexp/syntax への変換に任せ、書き出しは \p{...} などの表記を保てる形で行う
+		if _, err := toSyntax(ast); err != nil {
+			return "", err
+		}
+		if hasEmptyRepeat(ast) {
+			return "", fmt.Errorf("RE2 の構文で同じ意味に表せません: 空文字列にマッチし得る部分の繰り返し")
+		}
+		return nodeStringFor(ast, DialectRE2), nil
+	case DialectPCRE:
+		return nodeStringFor(ast, DialectPCRE), nil
+	}
+	return "", fmt.Errorf("変換先に指定できない方言です: %d", target)
+}
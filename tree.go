@@ -0,0 +1,101 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// CaptureTree は、FindStringCaptureTree が返す木のノードで、キャプチャグループの1回のマッチを表します。
+// 繰り返しの中のグループは、繰り返しの回数だけノードになります。
+type CaptureTree struct {
+	Group int    // グループの番号（根はマッチ全体を表す0）
+	Name  string // グループの名前（名前のないグループは空文字列）
+	Start int    // マッチの開始位置（バイト単位）
+	End   int    // マッチの終了位置（バイト単位）
+
+	// Children は、このグループのマッチの中でマッチしたグループを、マッチした順に並べたものです。
+	Children []*CaptureTree
+}
+
+// FindStringCaptureTree は、文字列 s の最初のマッチを探し、マッチの中でどのグループがどのグループの中でマッチしたかを木で返します。
+// 根はマッチ全体で、FindStringSubmatchIndex と異なり、繰り返しの各回のマッチをすべて含みます。
+// マッチしなければ nil を返します。
+//
+// ノードは、照合が採用した経路でグループを閉じた順に作ります。後の回で同じグループの開始位置を記録し直した場合は、
+// 最後に記録した開始位置を使います。照合は、LinearFallback の設定にかかわらず常にこのエンジンで行います。
+func (re *Regexp) FindStringCaptureTree(s string) *CaptureTree {
+	m := re.prog.getMatcher(true)
+	defer re.prog.putMatcher(m)
+	m.resetString(s)
+	return re.captureTree(m)
+}
+
+// FindCaptureTree は、FindStringCaptureTree のバイト列版です。
+func (re *Regexp) FindCaptureTree(b []byte) *CaptureTree {
+	m := re.prog.getMatcher(true)
+	defer re.prog.putMatcher(m)
+	m.resetBytes(b)
+	return re.captureTree(m)
+}
+
+// captureTree は、入力を設定したマッチャーで最初のマッチを探し、キャプチャの木を組み立てます。
+// 照合の間はバックトラックポイントがなくても取り消し記録を残し、マッチした時点の記録（採用した経路でのスロットの変更の列）から、
+// 各グループの開始と終了を順にたどります。
+func (re *Regexp) captureTree(m *Matcher) *CaptureTree {
+	m.keepTrail = true
+	defer func() { m.keepTrail = false }()
+	if !m.search(0) {
+		return nil
+	}
+
+	// 各変更の後の値は、同じスロットの次の変更の前の値か、最後の値
+	slots := 2 * (re.numSubexp + 1)
+	values := make([]int, len(m.trail))
+	last := make([]int, slots)
+	for i := range last {
+		last[i] = -1
+	}
+	for i := len(m.trail) - 1; i >= 0; i-- {
+		e := m.trail[i]
+		if e.slot < 2 || e.slot >= slots {
+			continue
+		}
+		if j := last[e.slot]; j >= 0 {
+			values[i] = m.trail[j].old
+		} else {
+			values[i] = m.saved[e.slot]
+		}
+		last[e.slot] = i
+	}
+
+	root := &CaptureTree{Start: m.offsets[m.saved[0]], End: m.offsets[m.saved[1]]}
+	stack := []*CaptureTree{root} // 開いているグループ
+	for i, e := range m.trail {
+		if e.slot < 2 || e.slot >= slots || values[i] < 0 {
+			continue
+		}
+		group := e.slot / 2
+		if e.slot%2 == 0 {
+			// 閉じずに開き直したグループは、最後に開いた位置を使う
+			for j := len(stack) - 1; j > 0; j-- {
+				if stack[j].Group == group {
+					stack = append(stack[:j], stack[j+1:]...)
+					break
+				}
+			}
+			stack = append(stack, &CaptureTree{Group: group, Name: re.subexpNames[group], Start: m.offsets[values[i]]})
+			continue
+		}
+
+		// 閉じたグループを親に加える（閉じずに残った内側のグループは捨てる）
+		j := len(stack) - 1
+		for j > 0 && stack[j].Group != group {
+			j--
+		}
+		if j == 0 {
+			continue
+		}
+		node := stack[j]
+		node.End = m.offsets[values[i]]
+		stack = stack[:j]
+		parent := stack[j-1]
+		parent.Children = append(parent.Children, node)
+	}
+	return root
+}
@@ -0,0 +1,186 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+// literalTrie は、リテラルのみからなる選択（foo|foobar|fizz|...）を表すトライです。
+// 選択肢の数に関わらず、入力の長さに比例する時間で候補を列挙できます。
+type literalTrie struct {
+	root *trieNode // 根ノード
+}
+
+// trieNode は、トライの1ノードを表します。
+type trieNode struct {
+	children map[rune]*trieNode // 次の文字ごとの子ノード
+	accept   int                // このノードで終わる選択肢の番号（-1はなし）
+}
+
+// trieAccept は、トライの探索で見つかった選択肢を表します。
+type trieAccept struct {
+	branch int // 選択肢の番号（小さいほど優先）
+	length int // マッチしたルーン数
+}
+
+// newTrieNode は、空のトライノードを作成します。
+func newTrieNode() *trieNode {
+	return &trieNode{accept: -1}
+}
+
+// newLiteralTrie は、リテラルのリストからトライを構築します。
+// 同じリテラルが複数ある場合は、先に現れた選択肢が優先されます。
+func newLiteralTrie(literals [][]rune) *literalTrie {
+	root := newTrieNode()
+	for branch, lit := range literals {
+		node := root
+		for _, r := range lit {
+			if node.children == nil {
+				node.children = make(map[rune]*trieNode)
+			}
+			child, ok := node.children[r]
+			if !ok {
+				child = newTrieNode()
+				node.children[r] = child
+			}
+			node = child
+		}
+		if node.accept < 0 {
+			node.accept = branch
+		}
+	}
+	return &literalTrie{root: root}
+}
+
+// lookup は、input[pos:] の先頭にマッチする選択肢をすべて列挙し、
+// 選択肢の番号順（優先順）に並べて buf に追加して返します。
+// 入力が続けばさらに長い選択肢にマッチし得る（入力の末尾まで照合した）場合は、atEnd が true になります。
+func (t *literalTrie) lookup(input []rune, pos int, buf []trieAccept) (accepts []trieAccept, atEnd bool) {
+	buf = buf[:0]
+	node := t.root
+	for i := pos; ; i++ {
+		if node.accept >= 0 {
+			buf = append(buf, trieAccept{branch: node.accept, length: i - pos})
+		}
+		if node.children == nil {
+			break
+		}
+		if i >= len(input) {
+			atEnd = true
+			break
+		}
+		next, ok := node.children[input[i]]
+		if !ok {
+			break
+		}
+		node = next
+	}
+
+	sortAccepts(buf)
+	return buf, atEnd
+}
+
+// lookupFold は、lookup と同様に input[pos:] の先頭にマッチする選択肢を列挙しますが、大小文字を区別せずに照合します。
+func (t *literalTrie) lookupFold(input []rune, pos int, buf []trieAccept) (accepts []trieAccept, atEnd bool) {
+	buf = buf[:0]
+	var walk func(node *trieNode, i int)
+	walk = func(node *trieNode, i int) {
+		if node.accept >= 0 {
+			buf = append(buf, trieAccept{branch: node.accept, length: i - pos})
+		}
+		if node.children == nil {
+			return
+		}
+		if i >= len(input) {
+			atEnd = true
+			return
+		}
+		// 同一視される文字ごとに、別の子ノードに進み得る
+		r := input[i]
+		for f := r; ; {
+			if next, ok := node.children[f]; ok {
+				walk(next, i+1)
+			}
+			if f = simpleFold(f); f == r {
+				break
+			}
+		}
+	}
+	walk(t.root, pos)
+	sortAccepts(buf)
+	return buf, atEnd
+}
+
+// sortAccepts は、見つかった選択肢を番号順（優先順）に並べ替えます（件数は少ないので挿入ソート）。
+func sortAccepts(buf []trieAccept) {
+	for i := 1; i < len(buf); i++ {
+		for j := i; j > 0 && buf[j].branch < buf[j-1].branch; j-- {
+			buf[j], buf[j-1] = buf[j-1], buf[j]
+		}
+	}
+}
+
+// maxTrieLiterals は、1つのトライにまとめるリテラルの最大数です。
+// 入れ子の選択を展開すると組み合わせが爆発し得るため、上限を超えたらトライを使いません。
+const maxTrieLiterals = 4096
+
+// literalAlternatives は、選択ノードが（入れ子の選択を含めて）リテラルの集合であれば、
+// それらを優先順に返します。
+func literalAlternatives(node *AltNode) ([][]rune, bool) {
+	return literalSet(node)
+}
+
+// flattenAlt は、入れ子になった選択ノードを選択肢のリストに展開します。
+func flattenAlt(node Node) []Node {
+	alt, ok := node.(*AltNode)
+	if !ok {
+		return []Node{node}
+	}
+	return append(flattenAlt(alt.left), flattenAlt(alt.right)...)
+}
+
+// literalSet は、ノードがマッチし得るリテラル文字列を優先順にすべて返します。
+// リテラル以外の要素を含む場合や、文字列の数が maxTrieLiterals を超える場合は false を返します。
+func literalSet(node Node) ([][]rune, bool) {
+	switch n := node.(type) {
+	case *CharNode:
+		if n.fold {
+			// 大小文字を区別しない文字はトライで扱わない
+			return nil, false
+		}
+		return [][]rune{{n.r}}, true
+
+	case *ConcatNode:
+		// 各要素の集合の直積（前の要素の選択が優先）
+		set := [][]rune{{}}
+		for _, child := range n.nodes {
+			sub, ok := literalSet(child)
+			if !ok || len(set)*len(sub) > maxTrieLiterals {
+				return nil, false
+			}
+			product := make([][]rune, 0, len(set)*len(sub))
+			for _, head := range set {
+				for _, tail := range sub {
+					lit := make([]rune, 0, len(head)+len(tail))
+					product = append(product, append(append(lit, head...), tail...))
+				}
+			}
+			set = product
+		}
+		return set, true
+
+	case *AltNode:
+		// 左の選択肢から順に連結
+		var set [][]rune
+		for _, branch := range flattenAlt(n) {
+			sub, ok := literalSet(branch)
+			if !ok || len(set)+len(sub) > maxTrieLiterals {
+				return nil, false
+			}
+			set = append(set, sub...)
+		}
+		return set, true
+
+	case *GroupNode:
+		return literalSet(n.node)
+
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,55 @@
+//go:build btregexp_ascii
+
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "unicode"
+
+// unicodeTables は、Unicode の表（プロパティ、大小文字の同一視、正規化）を使うビルドかどうかです。
+// このビルドでは、TinyGo や WASM 向けにバイナリを小さくするため、これらの表を使いません。
+// \p{...} と Unicode 正規化は使えず、\s と大小文字の同一視は ASCII の文字だけを対象にします。
+const unicodeTables = false
+
+// unicodeTable は、このビルドでは常に false を返します。
+func unicodeTable(name string) (*unicode.RangeTable, bool) {
+	return nil, false
+}
+
+// hasUnicodeProperty は、このビルドでは常に false を返します。
+func hasUnicodeProperty(prop string, r rune) bool {
+	return false
+}
+
+// asciiSpaces は、このビルドで \s がマッチする文字範囲です。
+var asciiSpaces = []runeRange{{'\t', '\r'}, {' ', ' '}}
+
+// spaceRanges は、\s がマッチする文字範囲（ASCII の空白類）を返します。
+func spaceRanges() []runeRange {
+	return asciiSpaces
+}
+
+// isSpace は、文字 r が ASCII の空白類かどうかを判定します。
+func isSpace(r rune) bool {
+	return r == ' ' || ('\t' <= r && r <= '\r')
+}
+
+// markRanges は、このビルドでは常に nil を返します（Unicode 正規化に対応しないため使いません）。
+func markRanges() []runeRange {
+	return nil
+}
+
+// isMark は、このビルドでは常に false を返します。
+func isMark(r rune) bool {
+	return false
+}
+
+// simpleFold は、ASCII の英字について大文字と小文字を入れ替えた文字を返します。それ以外の文字はそのまま返します。
+func simpleFold(r rune) rune {
+	switch {
+	case 'A' <= r && r <= 'Z':
+		return r + 'a' - 'A'
+	case 'a' <= r && r <= 'z':
+		return r - 'a' + 'A'
+	}
+	return r
+}
@@ -0,0 +1,51 @@
+//go:build !btregexp_ascii
+
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import "unicode"
+
+// unicodeTables は、Unicode の表（プロパティ、大小文字の同一視、正規化）を使うビルドかどうかです。
+// btregexp_ascii のビルドタグを指定すると false になり、バイナリの大きな表を含めずにビルドできます。
+const unicodeTables = true
+
+// unicodeTable は、\p{...} のプロパティ名（一般カテゴリまたは文字体系）の文字の表を返します。
+func unicodeTable(name string) (*unicode.RangeTable, bool) {
+	if table, ok := unicode.Categories[name]; ok {
+		return table, true
+	}
+	table, ok := unicode.Scripts[name]
+	return table, ok
+}
+
+// hasUnicodeProperty は、文字 r が Unicode プロパティ prop を持つかどうかを判定します。
+func hasUnicodeProperty(prop string, r rune) bool {
+	// TODO: Unicodeプロパティの実装
+	// （現在はダミー実装です）
+	return prop == "L" && unicode.IsLetter(r)
+}
+
+// spaceRanges は、\s がマッチする文字範囲を返します。
+func spaceRanges() []runeRange {
+	return tableRanges(unicode.White_Space)
+}
+
+// isSpace は、文字 r が \s にマッチするかどうかを判定します。
+func isSpace(r rune) bool {
+	return unicode.IsSpace(r)
+}
+
+// markRanges は、結合文字（一般カテゴリ M）の文字範囲を返します。
+func markRanges() []runeRange {
+	return tableRanges(unicode.M)
+}
+
+// isMark は、文字 r が結合文字（一般カテゴリ M）かどうかを判定します。
+func isMark(r rune) bool {
+	return unicode.Is(unicode.M, r)
+}
+
+// simpleFold は、大小文字を区別しない場合に r と同一視される次の文字を返します（unicode.SimpleFold と同じ）。
+func simpleFold(r rune) rune {
+	return unicode.SimpleFold(r)
+}
@@ -0,0 +1,204 @@
+//go:build !btregexp_ascii
+
+package btregexp
+
+import (
+	"fmt"
+	stdregexp "regexp"
+	"strings"
+	"testing"
+)
+
+// Unicode の表に依存するテストは、btregexp_ascii タグを付けたビルドでは実行しません。
+
+func TestUnicodeCaseFolding(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		{"(?i)k", "K", true},
+		{"(?i)σ", "ς", true},
+		{"(?i)[ς]", "Σ", true},
+	}
+
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Errorf("Compile(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+
+		if got := re.MatchString(tt.input); got != tt.want {
+			t.Errorf("Compile(%q).MatchString(%q) = %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestUnicodeProperty(t *testing.T) {
+	// \p{...} に量指定子を付けてもエラーにならない
+	re, err := Compile(`\p{L}+`)
+	if err != nil {
+		t.Fatalf("Compile(%q) failed: %v", `\p{L}+`, err)
+	}
+
+	// 標準ライブラリの構文木に変換しても、同じ位置にマッチする
+	s, err := re.ToSyntax()
+	if err != nil {
+		t.Fatalf("Compile(%q).ToSyntax() failed: %v", `\p{L}+`, err)
+	}
+	std := stdregexp.MustCompile(s.String())
+	for _, input := range []string{"123 αβγ", "123"} {
+		got, want := std.FindStringIndex(input), re.FindStringIndex(input)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Compile(%q).ToSyntax() = %q matches %q at %v, want %v", `\p{L}+`, s, input, got, want)
+		}
+	}
+}
+
+func TestUnicodeCaseFoldingAPIs(t *testing.T) {
+	// ケルビン記号（U+212A）は k と同一視する
+	re := MustCompile(`(?i)k`)
+	seq, err := re.Enumerate(1)
+	if err != nil {
+		t.Fatalf("Enumerate() error: %v", err)
+	}
+	var got []string
+	for s := range seq {
+		got = append(got, s)
+	}
+	if want := []string{"K", "k", "\u212a"}; fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("Enumerate(1) for %q = %q, want %q", `(?i)k`, got, want)
+	}
+	if eq, ce := Equivalent(re, MustCompile("[kK\u212a]"), 2); !eq {
+		t.Errorf("Equivalent(%q, %q, 2) = false, %q, want true", `(?i)k`, "[kK\u212a]", ce)
+	}
+	if got := MustCompile(`k`).FindStringFold("\u212a"); got != "\u212a" {
+		t.Errorf("FindStringFold(%q, %q) = %q, want %q", `k`, "\u212a", got, "\u212a")
+	}
+	if got, want := MustCompile(`(?i)é`).Highlight("Été", "[", "]"), "[É]t[é]"; got != want {
+		t.Errorf("Compile(%q).Highlight(%q) = %q, want %q", `(?i)é`, "Été", got, want)
+	}
+}
+
+func TestUnicodePropertyAPIs(t *testing.T) {
+	const pattern = `\p{Greek}\p{Latin}[\p{Han}\p{Hiragana}x]`
+	if small, size := MustCompile(`a`).Size(), MustCompile(pattern).Size(); size <= small {
+		t.Errorf("Size(%q) = %d, want > %d", pattern, size, small)
+	}
+
+	// 別々にコンパイルしても、文字クラスのマップの順序などによらず同じ値になる
+	for range 10 {
+		if a, b := MustCompile(pattern), MustCompile(pattern); a.Hash() != b.Hash() {
+			t.Fatalf("Hash differs between compilations: %x, %x", a.Hash(), b.Hash())
+		}
+	}
+
+	// 構文木から書き戻したパターンも同じようにマッチする
+	ast, err := Parse(`\p{Greek}\P{L}`, Options{})
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", `\p{Greek}\P{L}`, err)
+	}
+	rendered, err := Render(ast)
+	if err != nil {
+		t.Fatalf("Render(%q): %v", `\p{Greek}\P{L}`, err)
+	}
+	for _, input := range []string{"aα1", "αa"} {
+		got, want := MustCompile(rendered).FindStringIndex(input), MustCompile(`\p{Greek}\P{L}`).FindStringIndex(input)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%q rendered as %q on %q = %v, want %v", `\p{Greek}\P{L}`, rendered, input, got, want)
+		}
+	}
+}
+
+func TestUnicodeTranslate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		target  Dialect
+		want    string
+	}{
+		{`\p{L}+\P{Greek}`, DialectRE2, `\p{L}+\P{Greek}`},
+		{`\S`, DialectPCRE, "[^\\t-\\r \u0085\u00a0\u1680\u2000-\u200a\u2028-\u2029\u202f\u205f\u3000]"},
+	}
+	for _, tt := range tests {
+		if got, err := Translate(tt.pattern, tt.target); err != nil || got != tt.want {
+			t.Errorf("Translate(%q, %d) = %q, %v, want %q", tt.pattern, tt.target, got, err, tt.want)
+		}
+	}
+
+	// RE2 向けの変換結果は、標準ライブラリで同じようにマッチする
+	translated, err := Translate(`a.c|\s+$|(?i)é\p{L}`, DialectRE2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std := stdregexp.MustCompile(translated)
+	re := MustCompile(`a.c|\s+$|(?i)é\p{L}`)
+	for _, input := range []string{"abc", "a\rc", "x \u3000", "x \n", "xÉß"} {
+		if got, want := std.FindStringIndex(input), re.FindStringIndex(input); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("translated %q on %q = %v, want %v", translated, input, got, want)
+		}
+	}
+}
+
+func TestNormalization(t *testing.T) {
+	const (
+		composed   = "caf\u00e9"  // é を合成済みの1文字で表したもの
+		decomposed = "cafe\u0301" // e と結合用アクセントの並び
+	)
+
+	tests := []struct {
+		form    NormalizationForm
+		pattern string
+		input   string
+		want    []int
+	}{
+		{NoNormalization, composed, decomposed, nil},
+		{NoNormalization, decomposed, composed, nil},
+		{NFC, composed, decomposed, []int{0, 6}},
+		{NFC, decomposed, composed, []int{0, 5}},
+		{NFD, composed, decomposed, []int{0, 6}},
+		{NFD, decomposed, composed, []int{0, 5}},
+		{NFC, "f.$", "x" + decomposed, []int{3, 7}},
+		// マッチはセグメントの途中で始まったり終わったりしない
+		{NFD, "e", decomposed, nil},
+		{NFC, "e", decomposed, nil},
+		{NFD, `[^a]x`, "e\u0301x", []int{0, 4}},
+		{NFD, `[é]`, "e\u0301", []int{0, 3}},
+		{NFD, `[a-z]`, "e\u0301", nil},
+		{NFC, `^.$`, "q\u0301", []int{0, 3}},
+		{NFC, `\w.`, "ae\u0301", []int{0, 4}},
+		// 量指定子はセグメント全体に掛かる
+		{NFD, `^é+$`, "\u00e9e\u0301\u00e9", []int{0, 7}},
+		{NFC, `é{2}`, "e\u0301e\u0301", []int{0, 6}},
+	}
+
+	for _, tt := range tests {
+		re, err := CompileWithFlags(tt.pattern, Flags{Normalization: tt.form})
+		if err != nil {
+			t.Errorf("CompileWithFlags(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+
+		got := re.FindStringIndex(tt.input)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("CompileWithFlags(%q, %v).FindStringIndex(%q) = %v, want %v", tt.pattern, tt.form, tt.input, got, tt.want)
+		}
+		if gotBytes := re.FindIndex([]byte(tt.input)); fmt.Sprint(gotBytes) != fmt.Sprint(tt.want) {
+			t.Errorf("CompileWithFlags(%q, %v).FindIndex(%q) = %v, want %v", tt.pattern, tt.form, tt.input, gotBytes, tt.want)
+		}
+		if gotReader := re.MatchReader(strings.NewReader(tt.input)); gotReader != (tt.want != nil) {
+			t.Errorf("CompileWithFlags(%q, %v).MatchReader(%q) = %v, want %v", tt.pattern, tt.form, tt.input, gotReader, tt.want != nil)
+		}
+	}
+
+	// . や文字クラスは、結合文字の並びを1文字として置き換える
+	for _, pattern := range []string{`.`, `[é]`, `[^a]`} {
+		re, err := CompileWithFlags(pattern, Flags{Normalization: NFD})
+		if err != nil {
+			t.Fatalf("CompileWithFlags(%q) failed: %v", pattern, err)
+		}
+		if got, want := re.ReplaceAllString("e\u0301", "x"), "x"; got != want {
+			t.Errorf("CompileWithFlags(%q, NFD).ReplaceAllString(%q) = %q, want %q", pattern, "e\u0301", got, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SyntaxError は、パターンの構文エラーを、問題のある位置とともに表します。
+type SyntaxError struct {
+	Pattern string // エラーのあったパターン
+	Offset  int    // 問題のある箇所のバイト位置
+	Msg     string // エラーの内容
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s（位置 %d）: %s", e.Msg, e.Offset, e.Pattern)
+}
+
+// quantState は、量指定子の検証中に、直前の要素が何だったかを表します。
+type quantState int
+
+const (
+	quantNone  quantState = iota // 直前に繰り返せる要素がない（パターンの先頭、| や ( の直後）
+	quantAtom                    // 直前が繰り返せる要素
+	quantAfter                   // 直前が量指定子
+)
+
+// validateQuantifiers は、パースの前にパターン中の量指定子を検証します。
+// 対象のない量指定子（*a、(+)）、連続した量指定子（a**、a+*）、
+// 範囲が逆の量指定子（a{2,1}）、最小値のない量指定子（a{,3}）を、その位置とともにエラーにします。
+//
+// JavaScript の方言では、所有的量指定子がないため量指定子に続く + も連続した量指定子とみなし、
+// 量指定子の形をしていない { は文字として扱います。
+func validateQuantifiers(expr string, dialect Dialect) error {
+	errorAt := func(offset int, msg string) error {
+		return &SyntaxError{Pattern: expr, Offset: offset, Msg: msg}
+	}
+
+	state := quantNone
+	for i := 0; i < len(expr); {
+		start := i
+		switch c := expr[i]; c {
+		case '\\':
+			// エスケープ（\p{...} の中身は量指定子として扱わない）
+			i += 2
+			if i < len(expr) && (expr[i-1] == 'p' || expr[i-1] == 'P') && expr[i] == '{' {
+				i = skipPast(expr, i, '}')
+			}
+			state = quantAtom
+
+		case '[':
+			// 文字クラスは1つの要素
+			i = skipCharClass(expr, i)
+			state = quantAtom
+
+		case '(':
+			i++
+			if i < len(expr) && expr[i] == '?' {
+				i++
+				switch {
+				case i < len(expr) && (expr[i] == 'P' || expr[i] == '<'):
+					// 名前付きキャプチャグループ (?P<name> または (?<name>
+					i = skipPast(expr, i, '>')
+				default:
+					// フラグ指定 (?i) または (?i:
+					for i < len(expr) && expr[i] != ':' && expr[i] != ')' {
+						i++
+					}
+					if i < len(expr) && expr[i] == ')' {
+						// (?i) 自体は繰り返せる要素ではないので、直前の状態を引き継がない
+						i++
+						state = quantNone
+						continue
+					}
+					i++
+				}
+			}
+			state = quantNone
+
+		case ')':
+			i++
+			state = quantAtom
+
+		case '|':
+			i++
+			state = quantNone
+
+		case '*', '+', '?':
+			i++
+			if err := checkQuantifier(state, start, errorAt); err != nil {
+				return err
+			}
+			i = skipQuantifierSuffix(expr, i, dialect)
+			state = quantAfter
+
+		case '{':
+			if dialect == DialectJavaScript && !isQuantifierAt(expr, i) {
+				i++
+				state = quantAtom
+				continue
+			}
+			if i+1 < len(expr) && expr[i+1] == ',' {
+				return errorAt(start, "繰り返し回数の最小値がありません")
+			}
+			if i+1 >= len(expr) || !isDigit(rune(expr[i+1])) {
+				// 量指定子でない '{' は文字として扱われる
+				i++
+				state = quantAtom
+				continue
+			}
+			if err := checkQuantifier(state, start, errorAt); err != nil {
+				return err
+			}
+
+			// {n}, {n,}, {n,m} の範囲を確認
+			i++
+			min, next := scanNumber(expr, i)
+			i = next
+			max := min
+			if i < len(expr) && expr[i] == ',' {
+				i++
+				max = -1
+				if i < len(expr) && isDigit(rune(expr[i])) {
+					max, i = scanNumber(expr, i)
+				}
+			}
+			if i >= len(expr) || expr[i] != '}' {
+				// 閉じ括弧がない場合のエラーはパーサーに任せる
+				return nil
+			}
+			i++
+			if max >= 0 && min > max {
+				return errorAt(start, "繰り返し回数の範囲が逆です: "+expr[start:i])
+			}
+			i = skipQuantifierSuffix(expr, i, dialect)
+			state = quantAfter
+
+		default:
+			i++
+			state = quantAtom
+		}
+	}
+	return nil
+}
+
+// checkQuantifier は、位置 offset にある量指定子が、直前の状態に対して有効かどうかを確認します。
+func checkQuantifier(state quantState, offset int, errorAt func(int, string) error) error {
+	switch state {
+	case quantNone:
+		return errorAt(offset, "繰り返しの対象がありません")
+	case quantAfter:
+		return errorAt(offset, "繰り返し演算子が連続しています")
+	}
+	return nil
+}
+
+// skipQuantifierSuffix は、量指定子に続く非貪欲（?）または所有的（+）の指定を読み飛ばします。
+func skipQuantifierSuffix(expr string, i int, dialect Dialect) int {
+	if i < len(expr) && (expr[i] == '?' || (expr[i] == '+' && dialect != DialectJavaScript)) {
+		i++
+	}
+	return i
+}
+
+// skipCharClass は、位置 i から始まる文字クラスを読み飛ばし、その直後の位置を返します。
+func skipCharClass(expr string, i int) int {
+	i++ // '[' を読み飛ばす
+	for i < len(expr) && expr[i] != ']' {
+		if expr[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	return i + 1
+}
+
+// skipPast は、位置 i 以降で最初に現れる end の直後の位置を返します。
+func skipPast(expr string, i int, end byte) int {
+	for i < len(expr) && expr[i] != end {
+		i++
+	}
+	return i + 1
+}
+
+// scanNumber は、位置 i から始まる10進数を読み取り、その値と直後の位置を返します。
+// 値が大きすぎる場合は上限なしとして -1 を返します。
+func scanNumber(expr string, i int) (int, int) {
+	start := i
+	for i < len(expr) && isDigit(rune(expr[i])) {
+		i++
+	}
+	n, err := strconv.Atoi(expr[start:i])
+	if err != nil {
+		return -1, i
+	}
+	return n, i
+}
@@ -0,0 +1,556 @@
+// Package btregexp は、バックトラック型の正規表現エンジンを実装したパッケージです。
+package btregexp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// vimMagic は、Vim のパターンの「魔法」の度合い（記号がバックスラッシュなしで特別な意味を持つ範囲）です。
+type vimMagic int
+
+const (
+	vimVeryNomagic  vimMagic = iota // \V: バックスラッシュ付きの記号だけが特別
+	vimNomagic                      // \M: ^ と $ だけがそのままで特別
+	vimMagicDefault                 // \m: ^ $ . * [ ~ がそのままで特別（既定）
+	vimVeryMagic                    // \v: 英数字と _ 以外の記号がそのままで特別
+)
+
+// vimOperators は、バックスラッシュの有無によって特別な意味を持ち得る記号です。
+// これ以外の記号は、バックスラッシュの有無にかかわらず文字そのものを表します。
+const vimOperators = `()|+?={@%<>*.[~^$&`
+
+// vimPlain は、各度合いでバックスラッシュなしに特別な意味を持つ記号です。
+// これらの記号はバックスラッシュを付けると文字そのものを表し、それ以外の vimOperators は逆になります。
+var vimPlain = map[vimMagic]string{
+	vimVeryNomagic:  ``,
+	vimNomagic:      `^$`,
+	vimMagicDefault: `^$.*[~`,
+	vimVeryMagic:    vimOperators,
+}
+
+// vimClasses は、Vim の文字クラスのエスケープ（\a、\l など）が表す文字範囲です。大文字は否定を表します。
+var vimClasses = map[rune][]runeRange{
+	'a': {{'A', 'Z'}, {'a', 'z'}},
+	'l': {{'a', 'z'}},
+	'u': {{'A', 'Z'}},
+	'x': {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+	'o': {{'0', '7'}},
+	'h': {{'A', 'Z'}, {'_', '_'}, {'a', 'z'}},
+}
+
+// vimBracketClasses は、角括弧の中で使える [:name:] 形式の文字クラスが表す文字範囲です。
+var vimBracketClasses = map[string][]runeRange{
+	"alnum":  {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"alpha":  {{'A', 'Z'}, {'a', 'z'}},
+	"blank":  {{'\t', '\t'}, {' ', ' '}},
+	"digit":  {{'0', '9'}},
+	"lower":  {{'a', 'z'}},
+	"upper":  {{'A', 'Z'}},
+	"space":  {{'\t', '\r'}, {' ', ' '}},
+	"xdigit": {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+	"punct":  {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+}
+
+// vimToken は、Vim のパターンの字句（1文字、またはバックスラッシュとそれに続く1文字）です。
+type vimToken struct {
+	r       rune // 文字
+	op      bool // 記号が特別な意味を持つかどうか
+	escaped bool // バックスラッシュに続く英数字や _ かどうか（\s、\zs など）
+}
+
+// vimTranslator は、Vim のパターンをこのパッケージの構文に書き換えます。
+type vimTranslator struct {
+	expr  string
+	pos   int
+	magic vimMagic
+	sb    strings.Builder
+
+	branchStart bool // 選択肢の先頭にいるかどうか（^ が行頭を表す位置）
+	atom        bool // 直前に繰り返しの対象になる要素があるかどうか
+}
+
+// translateVim は、Vim のパターンをこのパッケージの構文で書き表した文字列に書き換えます。
+// \zs と \ze はそのまま残し、Vim の方言のパーサーが解釈します。
+func translateVim(expr string) (string, error) {
+	t := &vimTranslator{expr: expr, magic: vimMagicDefault, branchStart: true}
+	var caseFlag string
+	for t.pos < len(t.expr) {
+		tok, err := t.next()
+		if err != nil {
+			return "", err
+		}
+		// \c と \C は、パターン中のどこにあってもパターン全体に適用される
+		if tok.escaped && (tok.r == 'c' || tok.r == 'C') {
+			caseFlag = map[rune]string{'c': "(?i)", 'C': "(?-i)"}[tok.r]
+			continue
+		}
+		if err := t.translate(tok); err != nil {
+			return "", err
+		}
+	}
+	return caseFlag + t.sb.String(), nil
+}
+
+// next は、次の字句を読み取ります。
+func (t *vimTranslator) next() (vimToken, error) {
+	r, size := utf8.DecodeRuneInString(t.expr[t.pos:])
+	t.pos += size
+	if r != '\\' {
+		return vimToken{r: r, op: strings.ContainsRune(vimPlain[t.magic], r)}, nil
+	}
+
+	if t.pos >= len(t.expr) {
+		return vimToken{}, fmt.Errorf("エスケープシーケンスが終了していません")
+	}
+	r, size = utf8.DecodeRuneInString(t.expr[t.pos:])
+	t.pos += size
+	switch {
+	case isWordChar(r):
+		return vimToken{r: r, escaped: true}, nil
+	case strings.ContainsRune(vimOperators, r):
+		// バックスラッシュは、記号がそのままで持つ意味を反転させる
+		return vimToken{r: r, op: !strings.ContainsRune(vimPlain[t.magic], r)}, nil
+	default:
+		return vimToken{r: r}, nil
+	}
+}
+
+// peekToken は、位置を進めずに次の字句を返します。パターンの終わりでは false を返します。
+func (t *vimTranslator) peekToken() (vimToken, bool) {
+	if t.pos >= len(t.expr) {
+		return vimToken{}, false
+	}
+	pos := t.pos
+	tok, err := t.next()
+	t.pos = pos
+	return tok, err == nil
+}
+
+// atBranchEnd は、現在の位置が選択肢の終わり（パターンの終わり、\| または \) の直前）かどうかを返します。
+func (t *vimTranslator) atBranchEnd() bool {
+	tok, ok := t.peekToken()
+	return !ok || (tok.op && (tok.r == '|' || tok.r == ')'))
+}
+
+// translate は、1つの字句を書き換えて出力します。
+func (t *vimTranslator) translate(tok vimToken) error {
+	switch {
+	case tok.escaped:
+		return t.translateEscape(tok.r)
+	case !tok.op:
+		t.literal(tok.r)
+		return nil
+	}
+
+	switch tok.r {
+	case '(':
+		t.sb.WriteString("(")
+		t.branchStart, t.atom = true, false
+	case ')':
+		t.sb.WriteString(")")
+		t.branchStart, t.atom = false, true
+	case '|':
+		t.sb.WriteString("|")
+		t.branchStart, t.atom = true, false
+	case '*':
+		// 繰り返しの対象がない * は文字そのもの
+		if !t.atom {
+			t.literal('*')
+			return nil
+		}
+		t.quantifier("*")
+	case '+':
+		t.quantifier("+")
+	case '=', '?':
+		t.quantifier("?")
+	case '{':
+		return t.translateBrace()
+	case '.':
+		t.element("[^\\n]")
+	case '[':
+		return t.translateBracket("")
+	case '^':
+		// 選択肢の先頭以外の ^ は文字そのもの
+		if !t.branchStart {
+			t.literal('^')
+			return nil
+		}
+		t.sb.WriteString("(?m:^)")
+		t.atom = false
+	case '$':
+		// 選択肢の終わり以外の $ は文字そのもの
+		if !t.atBranchEnd() {
+			t.literal('$')
+			return nil
+		}
+		t.sb.WriteString("(?m:$)")
+		t.branchStart, t.atom = false, false
+	case '<', '>':
+		// 単語の先頭・末尾は、単語境界で近似する（隣のパターンの要素が単語構成文字であれば同じ意味になる）
+		t.sb.WriteString(`\b`)
+		t.branchStart, t.atom = false, false
+	case '%':
+		return t.translatePercent()
+	case '@':
+		return fmt.Errorf("Vim の先読み・後読み（\\@）には対応していません")
+	case '&':
+		return fmt.Errorf("Vim の \\& には対応していません")
+	case '~':
+		return fmt.Errorf("Vim の ~（直前の置換文字列）には対応していません")
+	}
+	return nil
+}
+
+// translateEscape は、バックスラッシュに続く英数字や _ の字句を書き換えます。
+func (t *vimTranslator) translateEscape(r rune) error {
+	switch r {
+	case 'v':
+		t.magic = vimVeryMagic
+	case 'm':
+		t.magic = vimMagicDefault
+	case 'M':
+		t.magic = vimNomagic
+	case 'V':
+		t.magic = vimVeryNomagic
+
+	case 'z':
+		// \zs と \ze は文字を消費しないため、繰り返しの対象にならない
+		if t.pos < len(t.expr) && (t.expr[t.pos] == 's' || t.expr[t.pos] == 'e') {
+			t.sb.WriteString(`\z` + t.expr[t.pos:t.pos+1])
+			t.pos++
+			t.branchStart, t.atom = false, false
+			return nil
+		}
+		return fmt.Errorf("Vim の \\z%s には対応していません", t.expr[t.pos:min(t.pos+1, len(t.expr))])
+
+	case '_':
+		return t.translateNewlineVariant()
+
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		t.element(`\` + string(r))
+
+	case 's', 'S', 'd', 'D', 'w', 'W':
+		t.element(`\` + string(r))
+
+	case 'e':
+		t.literal('\x1b')
+	case 't':
+		t.literal('\t')
+	case 'r':
+		t.literal('\r')
+	case 'n':
+		t.literal('\n')
+	case 'b':
+		t.literal('\b')
+
+	default:
+		class, err := vimClass(r)
+		if err != nil {
+			return err
+		}
+		t.element(class)
+	}
+	return nil
+}
+
+// vimClass は、Vim の文字クラスのエスケープ（\a、\L など）を、このパッケージの文字クラスの構文で返します。
+func vimClass(r rune) (string, error) {
+	lower := r | 0x20 // 英字の小文字
+	ranges, ok := vimClasses[lower]
+	if !ok {
+		return "", fmt.Errorf("Vim の \\%c には対応していません", r)
+	}
+	var sb strings.Builder
+	sb.WriteString("[")
+	if r != lower {
+		sb.WriteString("^")
+	}
+	for _, rng := range ranges {
+		writeClassRune(&sb, rng.min)
+		if rng.max != rng.min {
+			sb.WriteString("-")
+			writeClassRune(&sb, rng.max)
+		}
+	}
+	sb.WriteString("]")
+	return sb.String(), nil
+}
+
+// translateNewlineVariant は、\_ に続く、改行も含めるバリエーション（\_.、\_s、\_[...] など）を書き換えます。
+func (t *vimTranslator) translateNewlineVariant() error {
+	if t.pos >= len(t.expr) {
+		return fmt.Errorf("エスケープシーケンスが終了していません")
+	}
+	r, size := utf8.DecodeRuneInString(t.expr[t.pos:])
+	t.pos += size
+	switch r {
+	case '.':
+		t.element("(?s:.)")
+	case '^':
+		t.sb.WriteString("(?m:^)")
+		t.atom = false
+	case '$':
+		t.sb.WriteString("(?m:$)")
+		t.branchStart, t.atom = false, false
+	case '[':
+		return t.translateBracket(`\n`)
+	case 's', 'S', 'd', 'D', 'w', 'W':
+		t.element(`(?:\` + string(r) + `|\n)`)
+	default:
+		class, err := vimClass(r)
+		if err != nil {
+			return err
+		}
+		t.element("(?:" + class + `|\n)`)
+	}
+	return nil
+}
+
+// translatePercent は、\% に続く要素（\%(、\%[、\%^、\%$、\%d123 など）を書き換えます。
+func (t *vimTranslator) translatePercent() error {
+	if t.pos >= len(t.expr) {
+		return fmt.Errorf("Vim の \\%% が終了していません")
+	}
+	r := t.expr[t.pos]
+	t.pos++
+	switch r {
+	case '(':
+		t.sb.WriteString("(?:")
+		t.branchStart, t.atom = true, false
+	case '[':
+		return t.translateOptionalSequence()
+	case '^':
+		t.sb.WriteString(`\A`)
+		t.atom = false
+	case '$':
+		// 直後の文字と合わせて \zs などと読まれないよう、グループで囲む
+		t.sb.WriteString(`(?:\z)`)
+		t.branchStart, t.atom = false, false
+	case 'd', 'x', 'u', 'U', 'o':
+		c, err := t.codePoint(r)
+		if err != nil {
+			return err
+		}
+		t.literal(c)
+	default:
+		return fmt.Errorf("Vim の \\%%%c には対応していません", r)
+	}
+	return nil
+}
+
+// codePoint は、\%d123、\%x2a、\%u20AC、\%U1F600、\%o40 の数字を読み取り、その文字を返します。
+func (t *vimTranslator) codePoint(kind byte) (rune, error) {
+	base, maxDigits := 16, map[byte]int{'x': 2, 'u': 4, 'U': 8}[kind]
+	switch kind {
+	case 'd':
+		base, maxDigits = 10, 10
+	case 'o':
+		base, maxDigits = 8, 11
+	}
+	start := t.pos
+	for t.pos < len(t.expr) && t.pos-start < maxDigits && isDigitIn(t.expr[t.pos], base) {
+		t.pos++
+	}
+	v, err := strconv.ParseUint(t.expr[start:t.pos], base, 32)
+	if err != nil || v > utf8.MaxRune {
+		return 0, fmt.Errorf("Vim の \\%%%c の文字コードが無効です: %q", kind, t.expr[start:t.pos])
+	}
+	return rune(v), nil
+}
+
+// isDigitIn は、c が base 進数の数字かどうかを返します。
+func isDigitIn(c byte, base int) bool {
+	switch base {
+	case 8:
+		return '0' <= c && c <= '7'
+	case 10:
+		return '0' <= c && c <= '9'
+	}
+	return isHexDigit(c)
+}
+
+// translateOptionalSequence は、\%[...]（先頭から順に省略可能な要素の並び）を、入れ子の省略可能なグループに書き換えます。
+// 例えば fu\%[nction] の \%[nction] は (?:n(?:c(?:t(?:i(?:o(?:n)?)?)?)?)?)? になります。
+func (t *vimTranslator) translateOptionalSequence() error {
+	depth := 0
+	for {
+		if t.pos >= len(t.expr) {
+			return fmt.Errorf("Vim の \\%%[ が閉じられていません")
+		}
+		if t.expr[t.pos] == ']' {
+			t.pos++
+			break
+		}
+		tok, err := t.next()
+		if err != nil {
+			return err
+		}
+
+		t.sb.WriteString("(?:")
+		depth++
+		switch {
+		case tok.escaped, !tok.op, tok.r == '.', tok.r == '[':
+			err = t.translate(tok)
+		default:
+			err = fmt.Errorf("Vim の \\%%[ の中には1文字にマッチする要素しか書けません: %q", tok.r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if depth == 0 {
+		return fmt.Errorf("Vim の \\%%[] が空です")
+	}
+	t.sb.WriteString(strings.Repeat(")?", depth))
+	t.branchStart, t.atom = false, false
+	return nil
+}
+
+// translateBrace は、\{n,m} 形式の繰り返し（\{-n,m} は非貪欲）を書き換えます。閉じ括弧は } と \} のどちらでもかまいません。
+func (t *vimTranslator) translateBrace() error {
+	end := strings.IndexByte(t.expr[t.pos:], '}')
+	if end < 0 {
+		return fmt.Errorf("Vim の \\{ が閉じられていません")
+	}
+	body := strings.TrimSuffix(t.expr[t.pos:t.pos+end], `\`)
+	t.pos += end + 1
+
+	suffix := ""
+	if strings.HasPrefix(body, "-") {
+		body = body[1:]
+		suffix = "?"
+	}
+	lo, hi, comma := strings.Cut(body, ",")
+	if strings.Trim(lo+hi, "0123456789") != "" {
+		return fmt.Errorf("Vim の繰り返し回数が無効です: \\{%s}", body)
+	}
+
+	switch {
+	case !comma && lo == "":
+		// \{} は * と同じ
+		t.quantifier("*" + suffix)
+	case !comma:
+		t.quantifier("{" + lo + "}" + suffix)
+	default:
+		if lo == "" {
+			lo = "0"
+		}
+		t.quantifier("{" + lo + "," + hi + "}" + suffix)
+	}
+	return nil
+}
+
+// translateBracket は、[...] の文字クラスを書き換えます。extra は文字クラスに加える要素（\_[ の改行）です。
+// 閉じられていない [ は、Vim と同様に文字そのものとして扱います。
+func (t *vimTranslator) translateBracket(extra string) error {
+	start := t.pos
+	var ranges []runeRange
+	negate := false
+	if t.pos < len(t.expr) && t.expr[t.pos] == '^' {
+		negate = true
+		t.pos++
+	}
+	for first := true; ; first = false {
+		if t.pos >= len(t.expr) {
+			t.pos = start
+			t.literal('[')
+			return nil
+		}
+		if t.expr[t.pos] == ']' && !first {
+			t.pos++
+			break
+		}
+		if strings.HasPrefix(t.expr[t.pos:], "[:") {
+			if end := strings.Index(t.expr[t.pos:], ":]"); end >= 0 {
+				name := t.expr[t.pos+2 : t.pos+end]
+				class, ok := vimBracketClasses[name]
+				if !ok {
+					return fmt.Errorf("未知の文字クラス: [:%s:]", name)
+				}
+				ranges = append(ranges, class...)
+				t.pos += end + 2
+				continue
+			}
+		}
+
+		lo, err := t.bracketRune()
+		if err != nil {
+			return err
+		}
+		hi := lo
+		if t.pos+1 < len(t.expr) && t.expr[t.pos] == '-' && t.expr[t.pos+1] != ']' {
+			t.pos++
+			if hi, err = t.bracketRune(); err != nil {
+				return err
+			}
+			if hi < lo {
+				return fmt.Errorf("文字クラスの範囲が逆です: %c-%c", lo, hi)
+			}
+		}
+		ranges = append(ranges, runeRange{lo, hi})
+	}
+
+	var sb strings.Builder
+	sb.WriteString("[")
+	if negate {
+		sb.WriteString("^")
+	}
+	for _, rng := range ranges {
+		writeClassRune(&sb, rng.min)
+		if rng.max != rng.min {
+			sb.WriteString("-")
+			writeClassRune(&sb, rng.max)
+		}
+	}
+	sb.WriteString("]")
+	if extra != "" {
+		t.element("(?:" + sb.String() + "|" + extra + ")")
+		return nil
+	}
+	t.element(sb.String())
+	return nil
+}
+
+// bracketRune は、文字クラスの中の1文字を読み取ります。
+// \e、\t、\r、\b、\n、\\、\]、\^、\-、\d123 などのエスケープを解釈し、それ以外の \ は文字そのものとして扱います。
+func (t *vimTranslator) bracketRune() (rune, error) {
+	r, size := utf8.DecodeRuneInString(t.expr[t.pos:])
+	t.pos += size
+	if r != '\\' || t.pos >= len(t.expr) {
+		return r, nil
+	}
+	switch c := t.expr[t.pos]; c {
+	case 'e', 't', 'r', 'b', 'n':
+		t.pos++
+		return map[byte]rune{'e': '\x1b', 't': '\t', 'r': '\r', 'b': '\b', 'n': '\n'}[c], nil
+	case '\\', ']', '^', '-':
+		t.pos++
+		return rune(c), nil
+	case 'd', 'x', 'u', 'U', 'o':
+		t.pos++
+		return t.codePoint(c)
+	}
+	return '\\', nil
+}
+
+// literal は、文字そのものを出力します。
+func (t *vimTranslator) literal(r rune) {
+	var sb strings.Builder
+	writeLiteral(&sb, r)
+	t.element(sb.String())
+}
+
+// element は、繰り返しの対象になる要素を出力します。
+func (t *vimTranslator) element(s string) {
+	t.sb.WriteString(s)
+	t.branchStart, t.atom = false, true
+}
+
+// quantifier は、量指定子を出力します。
+func (t *vimTranslator) quantifier(s string) {
+	t.sb.WriteString(s)
+	t.branchStart, t.atom = false, false
+}